@@ -0,0 +1,110 @@
+// Command archiver writes the joined request/response events of llm.joined
+// to a local directory or S3-compatible object storage, partitioned by
+// tenant, date and hour, with a manifest the reprocessing tooling reads.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"streamlens/internal/archive"
+	"streamlens/internal/config"
+	"streamlens/internal/dlq"
+	"streamlens/internal/kafka"
+	"streamlens/internal/store"
+	"streamlens/internal/telemetry"
+	"syscall"
+)
+
+func main() {
+	cfg := config.Load()
+
+	log.Println("Starting Archiver...")
+
+	format, err := archive.ParseFormat(cfg.ArchiveFormat)
+	if err != nil {
+		log.Fatalf("Invalid archive format: %v", err)
+	}
+	bucket, err := archive.OpenBucket(cfg.ArchiveTarget, archiveS3Settings(cfg))
+	if err != nil {
+		log.Fatalf("Failed to open archive: %v", err)
+	}
+
+	consumer, err := kafka.NewConsumer(cfg.KafkaBrokers, cfg.ConsumerGroup+"-archive", []string{kafka.TopicLLMJoined})
+	if err != nil {
+		log.Fatalf("Failed to create Kafka consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	producer, err := kafka.NewProducer(cfg.KafkaBrokers)
+	if err != nil {
+		log.Fatalf("Failed to create Kafka producer: %v", err)
+	}
+	defer producer.Close()
+
+	// Postgres holds dead-letter entries and events handed off on rebalance
+	metricsStore, err := store.NewMetricsStore(cfg.PostgresDSN)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer metricsStore.Close()
+	if cfg.MigrateOnStartup {
+		if _, err := metricsStore.Migrate(context.Background()); err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+	}
+
+	archiveDLQ := dlq.NewPublisher(producer, metricsStore, consumer.Group())
+	archiver := archive.NewArchiver(consumer, bucket, metricsStore, archiveDLQ, format, cfg.ArchiveFlushInterval, cfg.ArchiveMaxBufferedEvents)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go telemetry.Serve(ctx, ":"+cfg.MetricsPort)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+
+	errChan := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := archiver.Run(ctx); err != nil && err != context.Canceled {
+			errChan <- err
+		}
+	}()
+
+	select {
+	case <-quit:
+		log.Println("Received shutdown signal...")
+	case err := <-errChan:
+		log.Printf("Archiver error: %v", err)
+	}
+	cancel()
+
+	// Archive what is buffered before the consumer closes; whatever fails is
+	// handed off when the partitions are revoked
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+	defer flushCancel()
+	select {
+	case <-done:
+		if err := archiver.Flush(flushCtx); err != nil {
+			log.Printf("Failed to archive buffered events: %v", err)
+		}
+	case <-flushCtx.Done():
+		log.Printf("Archiver did not stop within %s", cfg.DrainTimeout)
+	}
+
+	log.Println("Archiver exited")
+}
+
+// archiveS3Settings returns the S3 settings of the archive target
+func archiveS3Settings(cfg *config.Config) archive.S3Settings {
+	return archive.S3Settings{
+		Endpoint:        cfg.ArchiveS3Endpoint,
+		Region:          cfg.ArchiveS3Region,
+		AccessKeyID:     cfg.ArchiveS3AccessKeyID,
+		SecretAccessKey: cfg.ArchiveS3SecretAccessKey,
+	}
+}
@@ -0,0 +1,173 @@
+// Command dlq inspects and re-drives records from the dead-letter queue.
+//
+// Usage:
+//
+//	dlq list [-source-topic llm.requests] [-limit 100] [-all]
+//	dlq show ID
+//	dlq redrive ID [ID...]
+//	dlq redrive -source-topic llm.requests [-limit 100]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"streamlens/internal/config"
+	"streamlens/internal/dlq"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/store"
+	"text/tabwriter"
+	"time"
+)
+
+func main() {
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cfg := config.Load()
+
+	metricsStore, err := store.NewMetricsStore(cfg.PostgresDSN)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer metricsStore.Close()
+
+	ctx := context.Background()
+
+	switch os.Args[1] {
+	case "list":
+		list(ctx, metricsStore, os.Args[2:])
+	case "show":
+		show(ctx, metricsStore, os.Args[2:])
+	case "redrive":
+		redrive(ctx, cfg, metricsStore, os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dlq list [-source-topic TOPIC] [-limit N] [-all]")
+	fmt.Fprintln(os.Stderr, "       dlq show ID")
+	fmt.Fprintln(os.Stderr, "       dlq redrive ID [ID...]")
+	fmt.Fprintln(os.Stderr, "       dlq redrive -source-topic TOPIC [-limit N]")
+	os.Exit(2)
+}
+
+// list prints dead-letter entries, newest first
+func list(ctx context.Context, metricsStore *store.MetricsStore, args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	sourceTopic := fs.String("source-topic", "", "only entries from this topic")
+	limit := fs.Int("limit", 100, "maximum number of entries")
+	all := fs.Bool("all", false, "include re-driven entries")
+	_ = fs.Parse(args)
+
+	query := store.DLQQuery{Limit: *limit, IncludeRedriven: *all}
+	if *sourceTopic != "" {
+		query.SourceTopic = sourceTopic
+	}
+
+	entries, err := metricsStore.ListDLQEntries(ctx, query)
+	if err != nil {
+		log.Fatalf("Failed to list dead-letter entries: %v", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tFAILED AT\tSOURCE\tREDRIVEN\tERROR")
+	for _, e := range entries {
+		redriven := "-"
+		if e.RedrivenAt != nil {
+			redriven = e.RedrivenAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s[%d]@%d\t%s\t%s\n",
+			e.ID, e.FailedAt.Format(time.RFC3339), e.SourceTopic, e.SourcePartition, e.SourceOffset, redriven, e.ErrorReason)
+	}
+	_ = tw.Flush()
+}
+
+// show prints one entry, including its payload, as JSON
+func show(ctx context.Context, metricsStore *store.MetricsStore, args []string) {
+	if len(args) != 1 {
+		usage()
+	}
+	entry, err := metricsStore.GetDLQEntry(ctx, parseID(args[0]))
+	if err != nil {
+		log.Fatalf("Failed to get dead-letter entry: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(entry); err != nil {
+		log.Fatalf("Failed to encode entry: %v", err)
+	}
+}
+
+// redrive produces the selected entries back to their source topics
+func redrive(ctx context.Context, cfg *config.Config, metricsStore *store.MetricsStore, args []string) {
+	fs := flag.NewFlagSet("redrive", flag.ExitOnError)
+	sourceTopic := fs.String("source-topic", "", "re-drive every pending entry from this topic")
+	limit := fs.Int("limit", 100, "maximum number of entries with -source-topic")
+	_ = fs.Parse(args)
+
+	var entries []*models.DLQEntry
+	switch {
+	case *sourceTopic != "" && fs.NArg() == 0:
+		pending, err := metricsStore.ListDLQEntries(ctx, store.DLQQuery{SourceTopic: sourceTopic, Limit: *limit})
+		if err != nil {
+			log.Fatalf("Failed to list dead-letter entries: %v", err)
+		}
+		for i := range pending {
+			entries = append(entries, &pending[i])
+		}
+	case *sourceTopic == "" && fs.NArg() > 0:
+		for _, arg := range fs.Args() {
+			entry, err := metricsStore.GetDLQEntry(ctx, parseID(arg))
+			if err != nil {
+				log.Fatalf("Failed to get dead-letter entry %s: %v", arg, err)
+			}
+			if entry.RedrivenAt != nil {
+				log.Printf("Entry %d was already re-driven at %s, re-driving again", entry.ID, entry.RedrivenAt.Format(time.RFC3339))
+			}
+			entries = append(entries, entry)
+		}
+	default:
+		usage()
+	}
+
+	producer, err := kafka.NewProducer(cfg.KafkaBrokers)
+	if err != nil {
+		log.Fatalf("Failed to create Kafka producer: %v", err)
+	}
+	defer producer.Close()
+
+	failed := 0
+	for _, entry := range entries {
+		if err := dlq.Redrive(ctx, producer, metricsStore, entry); err != nil {
+			log.Printf("Failed to re-drive entry %d: %v", entry.ID, err)
+			failed++
+			continue
+		}
+		log.Printf("Re-drove entry %d to %s", entry.ID, entry.SourceTopic)
+	}
+
+	log.Printf("Re-drove %d of %d entries", len(entries)-failed, len(entries))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func parseID(s string) int64 {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid entry id %q", s)
+	}
+	return id
+}
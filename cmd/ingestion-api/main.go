@@ -9,6 +9,7 @@ import (
 	"streamlens/internal/config"
 	"streamlens/internal/handlers"
 	"streamlens/internal/kafka"
+	"streamlens/internal/telemetry"
 	"syscall"
 	"time"
 
@@ -38,11 +39,13 @@ func main() {
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
 	r.Use(middleware.Timeout(60 * time.Second))
+	r.Use(telemetry.Middleware)
 
 	// Register routes
 	r.Post("/v1/llm/request", ingestionHandler.HandleLLMRequest)
 	r.Post("/v1/llm/response", ingestionHandler.HandleLLMResponse)
 	r.Get("/health", ingestionHandler.HandleHealth)
+	r.Method(http.MethodGet, "/metrics", telemetry.Handler())
 
 	// Create HTTP server
 	srv := &http.Server{
@@ -9,6 +9,7 @@ import (
 	"streamlens/internal/config"
 	"streamlens/internal/handlers"
 	"streamlens/internal/store"
+	"streamlens/internal/telemetry"
 	"syscall"
 	"time"
 
@@ -28,9 +29,21 @@ func main() {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer metricsStore.Close()
+	if cfg.MigrateOnStartup {
+		if _, err := metricsStore.Migrate(context.Background()); err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+	}
 
 	// Create handlers
 	metricsHandler := handlers.NewMetricsHandler(metricsStore)
+	dlqHandler := handlers.NewDLQHandler(metricsStore)
+	anomalyHandler := handlers.NewAnomalyHandler(metricsStore)
+	alertHandler := handlers.NewAlertHandler(metricsStore)
+	sloHandler := handlers.NewSLOHandler(metricsStore)
+	budgetHandler := handlers.NewBudgetHandler(metricsStore)
+	dimensionHandler := handlers.NewDimensionHandler(metricsStore, cfg.DimensionMaxValues)
+	retentionHandler := handlers.NewRetentionHandler(metricsStore, cfg.RetentionDays())
 
 	// Setup router
 	r := chi.NewRouter()
@@ -38,10 +51,35 @@ func main() {
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
 	r.Use(middleware.Timeout(60 * time.Second))
+	r.Use(telemetry.Middleware)
 
 	// Register routes
 	r.Get("/v1/metrics", metricsHandler.HandleGetMetrics)
+	r.Get("/v1/metrics/users", metricsHandler.HandleGetUsers)
+	r.Get("/v1/anomalies", anomalyHandler.HandleGetAnomalies)
+	r.Get("/v1/alerts", alertHandler.HandleListAlerts)
+	r.Get("/v1/alerts/rules", alertHandler.HandleListRules)
+	r.Post("/v1/alerts/rules", alertHandler.HandleCreateRule)
+	r.Get("/v1/alerts/rules/{id}", alertHandler.HandleGetRule)
+	r.Put("/v1/alerts/rules/{id}", alertHandler.HandleUpdateRule)
+	r.Delete("/v1/alerts/rules/{id}", alertHandler.HandleDeleteRule)
+	r.Get("/v1/slos", sloHandler.HandleList)
+	r.Post("/v1/slos", sloHandler.HandleCreate)
+	r.Get("/v1/slos/{id}", sloHandler.HandleGet)
+	r.Put("/v1/slos/{id}", sloHandler.HandleUpdate)
+	r.Delete("/v1/slos/{id}", sloHandler.HandleDelete)
+	r.Get("/v1/budgets", budgetHandler.HandleList)
+	r.Post("/v1/budgets", budgetHandler.HandleCreate)
+	r.Put("/v1/budgets/{id}", budgetHandler.HandleUpdate)
+	r.Delete("/v1/budgets/{id}", budgetHandler.HandleDelete)
+	r.Get("/v1/dimensions", dimensionHandler.HandleList)
+	r.Put("/v1/dimensions/{tenant_id}", dimensionHandler.HandleReplace)
+	r.Get("/v1/retention", retentionHandler.HandleList)
+	r.Put("/v1/retention/{tenant_id}", retentionHandler.HandleReplace)
+	r.Get("/v1/dlq", dlqHandler.HandleListDLQ)
+	r.Get("/v1/dlq/{id}", dlqHandler.HandleGetDLQEntry)
 	r.Get("/health", metricsHandler.HandleHealth)
+	r.Method(http.MethodGet, "/metrics", telemetry.Handler())
 
 	// Create HTTP server
 	srv := &http.Server{
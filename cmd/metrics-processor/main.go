@@ -5,26 +5,76 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"streamlens/internal/alert"
+	"streamlens/internal/anomaly"
+	"streamlens/internal/budget"
 	"streamlens/internal/config"
+	"streamlens/internal/dlq"
 	"streamlens/internal/kafka"
+	"streamlens/internal/pricing"
 	"streamlens/internal/processor"
+	"streamlens/internal/retention"
+	"streamlens/internal/rollup"
+	"streamlens/internal/sink"
+	"streamlens/internal/slo"
+	"streamlens/internal/spill"
 	"streamlens/internal/store"
+	"streamlens/internal/telemetry"
 	"syscall"
 )
 
 func main() {
-	log.Println("Starting Metrics Processor...")
-
 	// Load configuration
 	cfg := config.Load()
 
-	// Create Kafka consumer
+	if len(os.Args) > 1 && os.Args[1] == "reprocess" {
+		reprocess(cfg, os.Args[2:])
+		return
+	}
+
+	log.Println("Starting Metrics Processor...")
+
+	// Open the store state beyond the memory budgets is spilled to. It
+	// is opened first so that it outlives the rebalance callbacks consumers
+	// run when they close.
+	spillStore, err := spill.Open(cfg.SpillPath)
+	if err != nil {
+		log.Fatalf("Failed to open spill store: %v", err)
+	}
+	defer func() {
+		if err := spillStore.Close(); err != nil {
+			log.Printf("Failed to close spill store: %v", err)
+		}
+	}()
+
+	// Create Kafka consumers for the four stages: the join stage reads the
+	// co-partitioned request/response topics, the window stage reads joined
+	// events repartitioned by tenant|route|model, and the anomaly and alert
+	// stages read the resulting one-minute windows
 	topics := []string{kafka.TopicLLMRequests, kafka.TopicLLMResponses}
-	consumer, err := kafka.NewConsumer(cfg.KafkaBrokers, cfg.ConsumerGroup, topics)
+	joinConsumer, err := kafka.NewConsumer(cfg.KafkaBrokers, cfg.ConsumerGroup, topics)
+	if err != nil {
+		log.Fatalf("Failed to create Kafka consumer: %v", err)
+	}
+	defer joinConsumer.Close()
+
+	windowConsumer, err := kafka.NewConsumer(cfg.KafkaBrokers, cfg.ConsumerGroup+"-windows", []string{kafka.TopicLLMJoined})
+	if err != nil {
+		log.Fatalf("Failed to create Kafka consumer: %v", err)
+	}
+	defer windowConsumer.Close()
+
+	anomalyConsumer, err := kafka.NewConsumer(cfg.KafkaBrokers, cfg.ConsumerGroup+"-anomalies", []string{kafka.TopicLLMMetrics})
 	if err != nil {
 		log.Fatalf("Failed to create Kafka consumer: %v", err)
 	}
-	defer consumer.Close()
+	defer anomalyConsumer.Close()
+
+	alertConsumer, err := kafka.NewConsumer(cfg.KafkaBrokers, cfg.ConsumerGroup+"-alerts", []string{kafka.TopicLLMMetrics})
+	if err != nil {
+		log.Fatalf("Failed to create Kafka consumer: %v", err)
+	}
+	defer alertConsumer.Close()
 
 	// Create Kafka producer for metrics output
 	producer, err := kafka.NewProducer(cfg.KafkaBrokers)
@@ -39,26 +89,139 @@ func main() {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer metricsStore.Close()
+	if cfg.MigrateOnStartup {
+		if _, err := metricsStore.Migrate(context.Background()); err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+	}
+
+	// Load pricing catalog
+	catalog, err := pricing.NewCatalog(context.Background(), cfg.PricingFile, metricsStore, cfg.PricingReloadInterval)
+	if err != nil {
+		log.Fatalf("Failed to load pricing catalog: %v", err)
+	}
+
+	// Parse window definitions
+	windows, err := processor.ParseWindowDefinitions(cfg.WindowDefinitions)
+	if err != nil {
+		log.Fatalf("Invalid window definitions: %v", err)
+	}
+
+	// Parse error classification rules
+	classifier, err := processor.NewErrorClassifier(cfg.ErrorClassRules)
+	if err != nil {
+		log.Fatalf("Invalid error class rules: %v", err)
+	}
 
-	// Create processor
-	proc := processor.NewMetricsProcessor(consumer, producer, metricsStore)
+	// Load spend budgets
+	budgets := budget.NewTracker(metricsStore, producer, cfg.BudgetReloadInterval)
+	if err := budgets.Reload(context.Background()); err != nil {
+		log.Fatalf("Failed to load budgets: %v", err)
+	}
+
+	// Load promoted metadata dimensions
+	dimensions := processor.NewDimensionResolver(metricsStore, cfg.DimensionReloadInterval)
+	if err := dimensions.Reload(context.Background()); err != nil {
+		log.Fatalf("Failed to load dimensions: %v", err)
+	}
+
+	// Open the sinks flushed windows are written to
+	sinkNames, err := sink.ParseNames(cfg.Sinks)
+	if err != nil {
+		log.Fatalf("Invalid sinks: %v", err)
+	}
+	sinks, err := sink.Open(sinkNames, producer, metricsStore, sink.Settings{
+		FilePath:          cfg.SinkFilePath,
+		ParquetDir:        cfg.SinkParquetDir,
+		ParquetCloseDelay: cfg.SinkParquetCloseDelay,
+	})
+	if err != nil {
+		log.Fatalf("Failed to open sinks: %v", err)
+	}
+	fanout := sink.NewFanout(sinks, cfg.SinkRetryAttempts, cfg.SinkRetryBackoff)
+	defer func() {
+		if err := fanout.Close(); err != nil {
+			log.Printf("Failed to close sinks: %v", err)
+		}
+	}()
+
+	// Create processor stages
+	joinDLQ := dlq.NewPublisher(producer, metricsStore, joinConsumer.Group())
+	windowDLQ := dlq.NewPublisher(producer, metricsStore, windowConsumer.Group())
+	anomalyDLQ := dlq.NewPublisher(producer, metricsStore, anomalyConsumer.Group())
+	alertDLQ := dlq.NewPublisher(producer, metricsStore, alertConsumer.Group())
+	joiner := processor.NewJoiner(joinConsumer, producer, metricsStore, joinDLQ, spillStore, cfg.MaxJoinState, cfg.ResponseTimeout, cfg.PublishAbandoned)
+	proc := processor.NewMetricsProcessor(windowConsumer, fanout, metricsStore, catalog, classifier, windowDLQ, budgets, dimensions, windows, spillStore, cfg.MaxOpenWindows)
 	defer proc.Close()
+	detector := anomaly.NewDetector(anomalyConsumer, producer, metricsStore, anomalyDLQ, anomaly.Settings{
+		ZThreshold:  cfg.AnomalyZThreshold,
+		Alpha:       cfg.AnomalyAlpha,
+		Warmup:      int64(cfg.AnomalyWarmup),
+		MinRequests: cfg.AnomalyMinRequests,
+	})
+	notifier := alert.NewNotifier(cfg.AlertWebhookTimeout)
+	alerts := alert.NewEngine(alertConsumer, metricsStore, alertDLQ, notifier, alert.Settings{
+		RulesReloadInterval: cfg.AlertRulesReloadInterval,
+		RepeatInterval:      cfg.AlertRepeatInterval,
+	})
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Create tables for additional window definitions
+	if err := proc.EnsureTables(ctx); err != nil {
+		log.Fatalf("Failed to create window tables: %v", err)
+	}
+
+	// Hot-reload prices
+	go catalog.Run(ctx)
+	go budgets.Run(ctx)
+	go dimensions.Run(ctx)
+
+	// Expose pipeline metrics
+	go telemetry.Serve(ctx, ":"+cfg.MetricsPort)
+
+	// Start rollups of minute windows into coarser resolutions
+	roller := rollup.NewRoller(metricsStore, cfg.RollupInterval, cfg.RollupLookback)
+	go roller.Run(ctx)
+
+	// Create upcoming partitions and enforce retention
+	maintainer := retention.NewMaintainer(metricsStore, cfg.RetentionDays(), cfg.PartitionPremakeDays, cfg.RetentionInterval)
+	go maintainer.Run(ctx)
+
+	// Evaluate SLO error budgets and burn-rate alerts
+	evaluator := slo.NewEvaluator(metricsStore, notifier, cfg.SLOEvalInterval, cfg.AlertRepeatInterval)
+	go evaluator.Run(ctx)
+
 	// Handle shutdown signals
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
-	// Run processor in a goroutine
-	errChan := make(chan error, 1)
+	// Run all stages in goroutines
+	errChan := make(chan error, 4)
+	go func() {
+		if err := joiner.Run(ctx); err != nil && err != context.Canceled {
+			errChan <- err
+		}
+	}()
+	procDone := make(chan struct{})
 	go func() {
+		defer close(procDone)
 		if err := proc.Run(ctx); err != nil && err != context.Canceled {
 			errChan <- err
 		}
 	}()
+	go func() {
+		if err := detector.Run(ctx); err != nil && err != context.Canceled {
+			errChan <- err
+		}
+	}()
+	go func() {
+		if err := alerts.Run(ctx); err != nil && err != context.Canceled {
+			errChan <- err
+		}
+	}()
 
 	// Wait for shutdown signal or error
 	select {
@@ -70,5 +233,18 @@ func main() {
 		cancel()
 	}
 
+	// Flush open windows as partial before the consumers close, within the
+	// drain deadline; whatever is left is handed off on close
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+	defer drainCancel()
+	select {
+	case <-procDone:
+		if err := proc.Drain(drainCtx); err != nil {
+			log.Printf("Drain incomplete: %v", err)
+		}
+	case <-drainCtx.Done():
+		log.Printf("Window stage did not stop within %s, handing off open windows", cfg.DrainTimeout)
+	}
+
 	log.Println("Metrics processor exited")
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"streamlens/internal/archive"
+	"streamlens/internal/config"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/pricing"
+	"streamlens/internal/processor"
+	"streamlens/internal/retention"
+	"streamlens/internal/rollup"
+	"streamlens/internal/store"
+	"syscall"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// reprocessLockKey is the Postgres advisory lock held for a whole reprocess
+// run, since concurrent runs would share the shadow table
+const reprocessLockKey int64 = 0x726570726f63
+
+// reprocess rebuilds the one-minute windows of a past range, and the rollups
+// over it, from the request and response topics, or from the event archive
+// once the topics no longer hold the range:
+//
+//	metrics-processor reprocess -from 2025-11-20T10:00:00Z -to 2025-11-20T12:00:00Z
+//	metrics-processor reprocess -from 2025-11-20T10:00:00Z -to 2025-11-20T12:00:00Z -archive s3://streamlens-archive/
+func reprocess(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("reprocess", flag.ExitOnError)
+	fromFlag := fs.String("from", "", "start of the range (RFC 3339), truncated to the minute")
+	toFlag := fs.String("to", "", "end of the range (RFC 3339), truncated to the minute")
+	archiveFlag := fs.String("archive", "", "read joined events from this archive (directory or s3://bucket/prefix) instead of Kafka")
+	_ = fs.Parse(args)
+
+	from, err := time.Parse(time.RFC3339, *fromFlag)
+	if err != nil {
+		reprocessUsage("invalid -from: %v", err)
+	}
+	to, err := time.Parse(time.RFC3339, *toFlag)
+	if err != nil {
+		reprocessUsage("invalid -to: %v", err)
+	}
+	from, to = from.UTC().Truncate(processor.WindowDuration), to.UTC().Truncate(processor.WindowDuration)
+	if !from.Before(to) {
+		reprocessUsage("-from must be before -to")
+	}
+	// The live pipeline may still flush windows that end later
+	if settled := time.Now().Add(-processor.StateRetentionDuration - processor.FlushGracePeriod); to.After(settled) {
+		reprocessUsage("-to must be before %s", settled.UTC().Format(time.RFC3339))
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	metricsStore, err := store.NewMetricsStore(cfg.PostgresDSN)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer metricsStore.Close()
+
+	unlock, ok, err := metricsStore.TryAdvisoryLock(ctx, reprocessLockKey)
+	if err != nil {
+		log.Fatalf("Failed to take reprocess lock: %v", err)
+	}
+	if !ok {
+		log.Fatalf("Another reprocess run is in progress")
+	}
+	defer unlock()
+
+	// Rollups over the range are rebuilt from the finer windows, which must
+	// not have expired for any tenant
+	policy, err := retention.LoadPolicy(ctx, metricsStore, cfg.RetentionDays())
+	if err != nil {
+		log.Fatalf("Failed to load retention: %v", err)
+	}
+	for _, res := range []models.Resolution{models.Resolution1m, models.Resolution5m, models.Resolution1h} {
+		if since := policy.KeptSince(res, time.Now()); from.Before(since) {
+			log.Fatalf("-from must not be before %s: %s windows before it have expired", since.Format(time.RFC3339), res)
+		}
+	}
+
+	catalog, err := pricing.NewCatalog(ctx, cfg.PricingFile, metricsStore, cfg.PricingReloadInterval)
+	if err != nil {
+		log.Fatalf("Failed to load pricing catalog: %v", err)
+	}
+	classifier, err := processor.NewErrorClassifier(cfg.ErrorClassRules)
+	if err != nil {
+		log.Fatalf("Invalid error class rules: %v", err)
+	}
+	dimensions := processor.NewDimensionResolver(metricsStore, cfg.DimensionReloadInterval)
+	if err := dimensions.Reload(ctx); err != nil {
+		log.Fatalf("Failed to load dimensions: %v", err)
+	}
+
+	log.Printf("Reprocessing windows from %s to %s...", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	reprocessor := processor.NewReprocessor(catalog, classifier, dimensions, from, to, cfg.ResponseTimeout)
+	var malformed int
+	if *archiveFlag != "" {
+		readArchive(ctx, cfg, *archiveFlag, reprocessor, from, to)
+	} else {
+		// Read past both bounds so that pairs straddling them are joined, as
+		// the live joiner would have within its state retention
+		topics := []string{kafka.TopicLLMRequests, kafka.TopicLLMResponses}
+		err = kafka.ReadRange(ctx, cfg.KafkaBrokers, cfg.ConsumerGroup+"-reprocess", topics,
+			from.Add(-processor.StateRetentionDuration), to.Add(processor.StateRetentionDuration),
+			func(record *kgo.Record) error {
+				if err := reprocessor.Add(ctx, record); err != nil {
+					log.Printf("Skipping %s[%d]@%d: %v", record.Topic, record.Partition, record.Offset, err)
+					malformed++
+				}
+				return nil
+			})
+		if err != nil {
+			log.Fatalf("Failed to read topics: %v", err)
+		}
+		reprocessor.Abandon(ctx)
+	}
+
+	windows := reprocessor.Windows()
+	log.Printf("Joined %d events into %d windows (%d abandoned, %d outside the range, %d unmatched responses, %d duplicates, %d malformed)",
+		reprocessor.Joined, len(windows), reprocessor.Abandoned, reprocessor.OutOfRange, reprocessor.Unmatched(), reprocessor.Duplicates, malformed)
+
+	// Build the new windows aside, then swap them in at once
+	if err := metricsStore.CreateShadowTable(ctx, store.ShadowMetricsTable); err != nil {
+		log.Fatalf("Failed to create shadow table: %v", err)
+	}
+	for _, m := range windows {
+		if err := metricsStore.UpsertMetricsTable(ctx, store.ShadowMetricsTable, m); err != nil {
+			log.Fatalf("Failed to write shadow window: %v", err)
+		}
+	}
+	if err := metricsStore.SwapMetricsRange(ctx, store.ShadowMetricsTable, from, to); err != nil {
+		log.Fatalf("Failed to swap windows: %v", err)
+	}
+	log.Printf("Replaced windows from %s to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+
+	roller := rollup.NewRoller(metricsStore, cfg.RollupInterval, cfg.RollupLookback)
+	if err := roller.RollupRange(ctx, from, to); err != nil {
+		log.Fatalf("Failed to rebuild rollups: %v", err)
+	}
+	log.Println("Rebuilt rollups")
+}
+
+// readArchive adds the archived joined events of [from, to) to the
+// reprocessor. The archiver already joined them, so no read goes past the
+// bounds. A file that cannot be read stops the run, since swapping in the
+// windows without its events would lose them.
+func readArchive(ctx context.Context, cfg *config.Config, target string, reprocessor *processor.Reprocessor, from, to time.Time) {
+	bucket, err := archive.OpenBucket(target, archive.S3Settings{
+		Endpoint:        cfg.ArchiveS3Endpoint,
+		Region:          cfg.ArchiveS3Region,
+		AccessKeyID:     cfg.ArchiveS3AccessKeyID,
+		SecretAccessKey: cfg.ArchiveS3SecretAccessKey,
+	})
+	if err != nil {
+		log.Fatalf("Failed to open archive: %v", err)
+	}
+	entries, err := archive.ReadManifest(ctx, bucket, from, to)
+	if err != nil {
+		log.Fatalf("Failed to read archive manifest: %v", err)
+	}
+	log.Printf("Reading %d archived files...", len(entries))
+
+	for _, entry := range entries {
+		events, err := archive.ReadEvents(ctx, bucket, entry)
+		if err != nil {
+			log.Fatalf("Failed to read archive: %v", err)
+		}
+		for i := range events {
+			reprocessor.AddJoined(ctx, &events[i])
+		}
+	}
+}
+
+func reprocessUsage(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	fmt.Fprintln(os.Stderr, "usage: metrics-processor reprocess -from RFC3339 -to RFC3339 [-archive DIR|s3://BUCKET/PREFIX]")
+	os.Exit(2)
+}
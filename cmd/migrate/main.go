@@ -0,0 +1,105 @@
+// Command migrate applies, reverts and lists the Postgres schema migrations
+// embedded in the services.
+//
+// Usage:
+//
+//	migrate up
+//	migrate down [-steps 1]
+//	migrate status
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"streamlens/internal/config"
+	"streamlens/internal/store"
+	"text/tabwriter"
+	"time"
+)
+
+func main() {
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cfg := config.Load()
+
+	metricsStore, err := store.NewMetricsStore(cfg.PostgresDSN)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer metricsStore.Close()
+
+	ctx := context.Background()
+
+	switch os.Args[1] {
+	case "up":
+		up(ctx, metricsStore)
+	case "down":
+		down(ctx, metricsStore, os.Args[2:])
+	case "status":
+		status(ctx, metricsStore)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate up")
+	fmt.Fprintln(os.Stderr, "       migrate down [-steps N]")
+	fmt.Fprintln(os.Stderr, "       migrate status")
+	os.Exit(2)
+}
+
+// up applies every pending migration
+func up(ctx context.Context, metricsStore *store.MetricsStore) {
+	applied, err := metricsStore.Migrate(ctx)
+	if err != nil {
+		log.Fatalf("Failed to migrate: %v", err)
+	}
+	if applied == 0 {
+		log.Println("Schema is up to date")
+	}
+}
+
+// down reverts the latest applied migrations
+func down(ctx context.Context, metricsStore *store.MetricsStore, args []string) {
+	fs := flag.NewFlagSet("down", flag.ExitOnError)
+	steps := fs.Int("steps", 1, "number of migrations to revert")
+	_ = fs.Parse(args)
+	if *steps < 1 {
+		usage()
+	}
+
+	reverted, err := metricsStore.MigrateDown(ctx, *steps)
+	if err != nil {
+		log.Fatalf("Failed to revert migrations: %v", err)
+	}
+	if len(reverted) == 0 {
+		log.Println("No migration to revert")
+	}
+}
+
+// status lists every migration and when it was applied
+func status(ctx context.Context, metricsStore *store.MetricsStore) {
+	statuses, err := metricsStore.MigrationStatuses(ctx)
+	if err != nil {
+		log.Fatalf("Failed to read migrations: %v", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range statuses {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	_ = tw.Flush()
+}
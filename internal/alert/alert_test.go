@@ -0,0 +1,334 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"streamlens/internal/clock"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/processor/processortest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestEvaluate(t *testing.T) {
+	rule := &models.AlertRule{ID: 7, Metric: "p95_latency_ms", Operator: ">", Threshold: 4000, ForWindows: 3}
+	start := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+
+	window := func(i int, p95 float64) *models.LLMMetrics {
+		ws := start.Add(time.Duration(i) * time.Minute)
+		return &models.LLMMetrics{TenantID: "tenant-1", Route: "chat", Model: "gpt-4", WindowStart: ws, WindowEnd: ws.Add(time.Minute), P95LatencyMs: p95}
+	}
+
+	var a *models.Alert
+	steps := []struct {
+		p95  float64
+		want models.AlertStatus // empty: no alert
+	}{
+		{p95: 3000, want: ""},
+		{p95: 5000, want: models.AlertPending},
+		{p95: 3000, want: ""}, // pending alerts reset
+		{p95: 5000, want: models.AlertPending},
+		{p95: 5000, want: models.AlertPending},
+		{p95: 5000, want: models.AlertFiring},
+		{p95: 6000, want: models.AlertFiring},
+		{p95: 1000, want: models.AlertResolved},
+		{p95: 1000, want: models.AlertResolved},
+		{p95: 5000, want: models.AlertPending}, // a new breach starts over
+	}
+	for i, step := range steps {
+		a = evaluate(rule, a, window(i, step.p95))
+		var got models.AlertStatus
+		if a != nil {
+			got = a.Status
+		}
+		if got != step.want {
+			t.Fatalf("window %d: status = %q, want %q", i, got, step.want)
+		}
+		if i == 6 && !a.StartsAt.Equal(start.Add(3*time.Minute)) {
+			t.Errorf("StartsAt = %s, want the first breaching window", a.StartsAt)
+		}
+		if i == 7 && (a.EndsAt == nil || !a.EndsAt.Equal(start.Add(8*time.Minute))) {
+			t.Errorf("EndsAt = %v, want the end of the first normal window", a.EndsAt)
+		}
+	}
+}
+
+func TestEvaluate_Gap(t *testing.T) {
+	rule := &models.AlertRule{ID: 7, Metric: "p95_latency_ms", Operator: ">", Threshold: 4000, ForWindows: 3}
+	start := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+
+	window := func(i int) *models.LLMMetrics {
+		ws := start.Add(time.Duration(i) * time.Minute)
+		return &models.LLMMetrics{TenantID: "tenant-1", Route: "chat", Model: "gpt-4", WindowStart: ws, WindowEnd: ws.Add(time.Minute), P95LatencyMs: 5000}
+	}
+
+	var a *models.Alert
+	steps := []struct {
+		minute      int
+		want        models.AlertStatus
+		consecutive int
+	}{
+		{minute: 0, want: models.AlertPending, consecutive: 1},
+		{minute: 1, want: models.AlertPending, consecutive: 2},
+		{minute: 3, want: models.AlertPending, consecutive: 1}, // no window at minute 2
+		{minute: 4, want: models.AlertPending, consecutive: 2},
+		{minute: 5, want: models.AlertFiring, consecutive: 3},
+		{minute: 7, want: models.AlertFiring, consecutive: 1}, // firing alerts keep firing
+	}
+	for _, step := range steps {
+		a = evaluate(rule, a, window(step.minute))
+		if a == nil || a.Status != step.want || a.Consecutive != step.consecutive {
+			t.Fatalf("minute %d: alert = %+v, want %s after %d consecutive windows", step.minute, a, step.want, step.consecutive)
+		}
+	}
+	if !a.StartsAt.Equal(start.Add(3 * time.Minute)) {
+		t.Errorf("StartsAt = %s, want the first window after the gap", a.StartsAt)
+	}
+}
+
+func TestNeedsNotification(t *testing.T) {
+	now := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+	recent := now.Add(-time.Hour)
+	old := now.Add(-5 * time.Hour)
+
+	tests := []struct {
+		name  string
+		alert models.Alert
+		want  notification
+	}{
+		{name: "pending", alert: models.Alert{Status: models.AlertPending}, want: notifyNone},
+		{name: "new firing", alert: models.Alert{Status: models.AlertFiring}, want: notifySend},
+		{name: "notified firing", alert: models.Alert{Status: models.AlertFiring, NotifiedStatus: models.AlertFiring, LastNotifiedAt: &recent}, want: notifyNone},
+		{name: "repeat firing", alert: models.Alert{Status: models.AlertFiring, NotifiedStatus: models.AlertFiring, LastNotifiedAt: &old}, want: notifySend},
+		{name: "resolved", alert: models.Alert{Status: models.AlertResolved, NotifiedStatus: models.AlertFiring, LastNotifiedAt: &recent}, want: notifySend},
+		{name: "resolved unnotified", alert: models.Alert{Status: models.AlertResolved}, want: notifyDrop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := needsNotification(&tt.alert, now, 4*time.Hour); got != tt.want {
+				t.Errorf("needsNotification() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotifier_Alertmanager(t *testing.T) {
+	var got AlertmanagerWebhook
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode payload: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	rule := &models.AlertRule{
+		ID: 7, Name: "slow chat", TenantID: "tenant-1", Metric: "p95_latency_ms", Operator: ">", Threshold: 4000,
+		ForWindows: 3, Severity: "critical", WebhookURL: srv.URL, WebhookFormat: models.WebhookAlertmanager,
+	}
+	alerts := []*models.Alert{
+		{RuleID: 7, TenantID: "tenant-1", Route: "chat", Model: "gpt-4", Status: models.AlertFiring, Value: 5000},
+		{RuleID: 7, TenantID: "tenant-1", Route: "chat", Model: "gpt-4o", Status: models.AlertResolved, Value: 900},
+	}
+
+	if err := NewNotifier(time.Second).Notify(context.Background(), rule, alerts); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if got.Version != "4" || got.Status != models.AlertFiring || got.GroupKey != "rule/7" {
+		t.Errorf("payload = %+v", got)
+	}
+	if len(got.Alerts) != 2 || got.Alerts[1].Labels["model"] != "gpt-4o" || got.Alerts[0].Labels["alertname"] != "slow chat" {
+		t.Errorf("alerts = %+v", got.Alerts)
+	}
+}
+
+func TestAlertRule_Validate(t *testing.T) {
+	valid := models.AlertRule{Name: "slow", TenantID: "t", Metric: "p95_latency_ms", Operator: ">", Threshold: 4000, WebhookURL: "https://hooks.example.com/x"}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if valid.ForWindows != 1 || valid.WebhookFormat != models.WebhookGeneric || valid.Severity != "warning" {
+		t.Errorf("defaults not applied: %+v", valid)
+	}
+
+	invalid := []func(r *models.AlertRule){
+		func(r *models.AlertRule) { r.Metric = "latency" },
+		func(r *models.AlertRule) { r.Operator = "!=" },
+		func(r *models.AlertRule) { r.WebhookURL = "ftp://example.com" },
+		func(r *models.AlertRule) { r.WebhookFormat = "teams" },
+		func(r *models.AlertRule) { r.ForWindows = -1 },
+		func(r *models.AlertRule) { r.TenantID = "" },
+	}
+	for i, mutate := range invalid {
+		r := valid
+		mutate(&r)
+		if err := r.Validate(); err == nil {
+			t.Errorf("case %d: expected validation error", i)
+		}
+	}
+}
+
+// memStore keeps rules, alert states and handed-off windows in memory
+type memStore struct {
+	*processortest.Store
+	rules  []models.AlertRule
+	alerts map[string]models.Alert
+	mu     sync.Mutex
+}
+
+func alertKey(ruleID int64, tenantID, route, model string) string {
+	return fmt.Sprintf("%d|%s|%s|%s", ruleID, tenantID, route, model)
+}
+
+func (s *memStore) ListAlertRules(ctx context.Context, tenantID *string) ([]models.AlertRule, error) {
+	return s.rules, nil
+}
+
+func (s *memStore) LoadAlerts(ctx context.Context, tenantID, route, model string) ([]models.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var alerts []models.Alert
+	for _, a := range s.alerts {
+		if a.TenantID == tenantID && a.Route == route && a.Model == model {
+			alerts = append(alerts, a)
+		}
+	}
+	return alerts, nil
+}
+
+func (s *memStore) SaveAlert(ctx context.Context, a *models.Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.alerts == nil {
+		s.alerts = make(map[string]models.Alert)
+	}
+	s.alerts[alertKey(a.RuleID, a.TenantID, a.Route, a.Model)] = *a
+	return nil
+}
+
+func (s *memStore) DeleteAlert(ctx context.Context, ruleID int64, tenantID, route, model string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.alerts, alertKey(ruleID, tenantID, route, model))
+	return nil
+}
+
+// testEngine is an alerting stage wired to in-memory fakes
+type testEngine struct {
+	*Engine
+	consumer *processortest.Consumer
+	store    *memStore
+	dlq      *processortest.DLQ
+	clock    *clock.Fake
+}
+
+func newTestEngine(rules ...models.AlertRule) *testEngine {
+	te := &testEngine{
+		consumer: processortest.NewConsumer("test-alerts"),
+		store:    &memStore{Store: processortest.NewStore(), rules: rules},
+		dlq:      &processortest.DLQ{},
+		clock:    clock.NewFake(time.Date(2025, 11, 20, 12, 0, 0, 0, time.UTC)),
+	}
+	te.Engine = NewEngine(te.consumer, te.store, te.dlq, NewNotifier(time.Second),
+		Settings{RulesReloadInterval: time.Hour, RepeatInterval: time.Hour})
+	te.SetClock(te.clock)
+	return te
+}
+
+// run runs the stage until every queued record is handled
+func (te *testEngine) run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- te.Run(ctx) }()
+	te.consumer.IdleAdvancing(te.clock, kafka.RewindBackoff)
+	cancel()
+	<-done
+}
+
+func metricsRecord(t *testing.T, m *models.LLMMetrics, offset int64) *kgo.Record {
+	t.Helper()
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &kgo.Record{Topic: kafka.TopicLLMMetrics, Offset: offset, Value: data}
+}
+
+func TestEngine_DeadLetterFailure(t *testing.T) {
+	te := newTestEngine(models.AlertRule{ID: 7, TenantID: "tenant-1", Metric: "p95_latency_ms",
+		Operator: ">", Threshold: 4000, ForWindows: 10, Enabled: true})
+	te.dlq.SetError(errors.New("dlq unavailable"))
+
+	start := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+	window := func(i int, route string) *models.LLMMetrics {
+		ws := start.Add(time.Duration(i) * time.Minute)
+		return &models.LLMMetrics{TenantID: "tenant-1", Route: route, Model: "gpt-4",
+			WindowStart: ws, WindowEnd: ws.Add(time.Minute), P95LatencyMs: 5000}
+	}
+	first := metricsRecord(t, window(0, "chat"), 0)
+	malformed := &kgo.Record{Topic: kafka.TopicLLMMetrics, Offset: 1, Value: []byte("not json")}
+	after := metricsRecord(t, window(2, "chat"), 2)
+	other := metricsRecord(t, window(3, "search"), 0)
+	other.Partition = 1
+	te.consumer.Add(first, malformed, after, other)
+	te.run()
+
+	// Nothing past the record that could not be dead-lettered is evaluated
+	// or committed; its partition is polled again from it
+	if rewound := te.consumer.Rewound(); len(rewound) != 1 || rewound[0] != malformed {
+		t.Errorf("rewound to %v, want the malformed record", rewound)
+	}
+	committed := te.consumer.Committed()
+	if len(committed) != 2 || committed[0] != first || committed[1] != other {
+		t.Errorf("committed %d records, want the first one and the other partition's", len(committed))
+	}
+	if a, ok := te.store.alerts[alertKey(7, "tenant-1", "chat", "gpt-4")]; !ok || a.Consecutive != 1 {
+		t.Errorf("saved alert = %+v, want one breaching window", a)
+	}
+	if _, ok := te.store.alerts[alertKey(7, "tenant-1", "search", "gpt-4")]; !ok {
+		t.Error("alert of the other partition not saved")
+	}
+}
+
+func TestEngine_PartialWindow(t *testing.T) {
+	te := newTestEngine(models.AlertRule{ID: 7, TenantID: "tenant-1", Metric: "error_rate",
+		Operator: ">", Threshold: 0.5, ForWindows: 1, Enabled: true})
+
+	start := time.Date(2025, 11, 20, 11, 58, 0, 0, time.UTC)
+	window := func(requests, errors int, partial bool) *models.LLMMetrics {
+		return &models.LLMMetrics{TenantID: "tenant-1", Route: "chat", Model: "gpt-4",
+			WindowStart: start, WindowEnd: start.Add(time.Minute), Requests: requests, Errors: errors, Partial: partial}
+	}
+	te.consumer.Add(metricsRecord(t, window(10, 8, true), 0))
+	te.run()
+
+	partitions := map[string][]int32{kafka.TopicLLMMetrics: {0}}
+	te.consumer.Revoke(context.Background(), partitions)
+	te.consumer.Assign(context.Background(), partitions)
+
+	te.consumer.Add(metricsRecord(t, window(90, 2, false), 1))
+	te.run()
+
+	// The drained part alone breaches, the merged window does not
+	if a, ok := te.store.alerts[alertKey(7, "tenant-1", "chat", "gpt-4")]; ok {
+		t.Errorf("saved alert = %+v, want none for the merged window", a)
+	}
+
+	next := window(10, 8, false)
+	next.WindowStart, next.WindowEnd = start.Add(time.Minute), start.Add(2*time.Minute)
+	te.consumer.Add(metricsRecord(t, window(90, 2, false), 1), metricsRecord(t, next, 2))
+	te.run()
+
+	// The redelivered remainder is not evaluated again
+	if a, ok := te.store.alerts[alertKey(7, "tenant-1", "chat", "gpt-4")]; !ok || a.Consecutive != 1 {
+		t.Errorf("saved alert = %+v, want one breaching window", a)
+	}
+}
@@ -0,0 +1,504 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"streamlens/internal/clock"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/partial"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Settings tune the alert engine
+type Settings struct {
+	// RulesReloadInterval is how often rules are re-read from Postgres
+	RulesReloadInterval time.Duration
+	// RepeatInterval is how often a still-firing alert is notified again
+	RepeatInterval time.Duration
+}
+
+// Consumer reads llm.metrics; see kafka.Consumer
+type Consumer interface {
+	Group() string
+	SetRebalanceListener(l kafka.RebalanceListener)
+	Poll(ctx context.Context) kgo.Fetches
+	AllowRebalance()
+	CommitRecords(ctx context.Context, records ...*kgo.Record) error
+	Rewind(record *kgo.Record)
+}
+
+// Store keeps rules and alert states; see store.MetricsStore
+type Store interface {
+	ListAlertRules(ctx context.Context, tenantID *string) ([]models.AlertRule, error)
+	LoadAlerts(ctx context.Context, tenantID, route, model string) ([]models.Alert, error)
+	SaveAlert(ctx context.Context, a *models.Alert) error
+	DeleteAlert(ctx context.Context, ruleID int64, tenantID, route, model string) error
+	SaveProcessorState(ctx context.Context, group, topic string, partition int32, entries map[string][]byte) error
+	TakeProcessorState(ctx context.Context, group, topic string, partition int32) (map[string][]byte, error)
+}
+
+// DeadLetterer takes windows that cannot be evaluated; see dlq.Publisher
+type DeadLetterer interface {
+	Publish(ctx context.Context, record *kgo.Record, cause error) error
+}
+
+// seriesAlerts holds the alerts of one tenant/route/model series
+type seriesAlerts struct {
+	tenantID  string
+	route     string
+	model     string
+	partition int32
+	alerts    map[int64]*models.Alert // by rule ID
+	changed   map[int64]bool          // rule IDs whose alert must be saved or deleted
+}
+
+// Engine is the alerting stage of the processor topology. It consumes the
+// default one-minute windows from llm.metrics, evaluates the alert rules of
+// each window's tenant and notifies rule webhooks of firing and resolved
+// alerts, grouped per rule. Alert states are saved to Postgres after every
+// batch, before offsets are committed.
+type Engine struct {
+	consumer Consumer
+	store    Store
+	dlq      DeadLetterer
+	notifier *Notifier
+	settings Settings
+	clock    clock.Clock
+
+	rules   map[string][]models.AlertRule // enabled rules by tenant
+	ruleIDs map[int64]*models.AlertRule
+	rulesMu sync.RWMutex
+
+	series   map[string]*seriesAlerts
+	seriesMu sync.Mutex
+
+	// Windows flushed partially by a window stage shutting down, waiting
+	// for their remainder
+	partials *partial.Windows
+}
+
+// NewEngine creates the alerting stage and registers it for rebalance callbacks
+func NewEngine(consumer Consumer, store Store, dlq DeadLetterer, notifier *Notifier, settings Settings) *Engine {
+	e := &Engine{
+		consumer: consumer,
+		store:    store,
+		dlq:      dlq,
+		notifier: notifier,
+		settings: settings,
+		clock:    clock.Real,
+		rules:    make(map[string][]models.AlertRule),
+		ruleIDs:  make(map[int64]*models.AlertRule),
+		series:   make(map[string]*seriesAlerts),
+		partials: partial.NewWindows(),
+	}
+	consumer.SetRebalanceListener(e)
+	return e
+}
+
+// SetClock replaces the system clock, which paces retries of records that
+// could not be dead-lettered. It must be called before Run.
+func (e *Engine) SetClock(c clock.Clock) {
+	e.clock = c
+}
+
+// Run loads the rules and starts the alert engine
+func (e *Engine) Run(ctx context.Context) error {
+	log.Println("Starting alert engine...")
+
+	if err := e.ReloadRules(ctx); err != nil {
+		return err
+	}
+	go e.reloadRules(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Context cancelled, stopping alert engine...")
+			return ctx.Err()
+		default:
+			fetches := e.consumer.Poll(ctx)
+
+			if errs := fetches.Errors(); len(errs) > 0 {
+				for _, err := range errs {
+					log.Printf("Fetch error: %v", err.Err)
+				}
+				e.consumer.AllowRebalance()
+				continue
+			}
+
+			// Process records
+			var recordsToCommit []*kgo.Record
+			rewound := make(map[int32]bool)
+			fetches.EachRecord(func(record *kgo.Record) {
+				if rewound[record.Partition] {
+					return
+				}
+				if err := e.processRecord(ctx, record); err != nil {
+					// Only commit past unprocessable windows once they are
+					// safely dead-lettered
+					if dlqErr := e.dlq.Publish(ctx, record, err); dlqErr != nil {
+						log.Printf("Error processing record: %v (dead-letter failed, retrying: %v)", err, dlqErr)
+						e.consumer.Rewind(record)
+						rewound[record.Partition] = true
+						return
+					}
+				}
+				recordsToCommit = append(recordsToCommit, record)
+			})
+
+			for _, w := range e.partials.Expire(e.clock.Now().Add(-partial.HoldTimeout)) {
+				if err := e.evaluateWindow(ctx, w.Metrics, w.Partition); err != nil {
+					log.Printf("Failed to evaluate partial window: %v", err)
+				}
+			}
+
+			e.notify(ctx, time.Now().UTC())
+
+			// Alert states and held windows must be saved before offsets
+			// move past the windows evaluated into them
+			if err := e.persist(ctx, nil); err != nil {
+				log.Printf("Failed to save alert states, not committing: %v", err)
+				e.consumer.AllowRebalance()
+				continue
+			}
+			if err := e.savePartials(ctx, nil); err != nil {
+				log.Printf("Failed to save partial windows, not committing: %v", err)
+				e.consumer.AllowRebalance()
+				continue
+			}
+
+			// Commit offsets
+			if len(recordsToCommit) > 0 {
+				if err := e.consumer.CommitRecords(ctx, recordsToCommit...); err != nil {
+					log.Printf("Failed to commit offsets: %v", err)
+				}
+			}
+
+			e.consumer.AllowRebalance()
+			if len(rewound) > 0 {
+				waitRewind(ctx, e.clock)
+			}
+		}
+	}
+}
+
+// waitRewind pauses polling after a partition was rewound to a record that
+// could not be dead-lettered, until it is retried or ctx is done
+func waitRewind(ctx context.Context, c clock.Clock) {
+	ticker := c.NewTicker(kafka.RewindBackoff)
+	defer ticker.Stop()
+	select {
+	case <-ctx.Done():
+	case <-ticker.C():
+	}
+}
+
+// ReloadRules re-reads every enabled rule from Postgres
+func (e *Engine) ReloadRules(ctx context.Context) error {
+	all, err := e.store.ListAlertRules(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to list alert rules: %w", err)
+	}
+
+	rules := make(map[string][]models.AlertRule)
+	ruleIDs := make(map[int64]*models.AlertRule)
+	for i := range all {
+		r := &all[i]
+		if !r.Enabled {
+			continue
+		}
+		rules[r.TenantID] = append(rules[r.TenantID], *r)
+		ruleIDs[r.ID] = r
+	}
+
+	e.rulesMu.Lock()
+	e.rules = rules
+	e.ruleIDs = ruleIDs
+	e.rulesMu.Unlock()
+	return nil
+}
+
+// reloadRules periodically reloads the rules until ctx is cancelled
+func (e *Engine) reloadRules(ctx context.Context) {
+	ticker := time.NewTicker(e.settings.RulesReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.ReloadRules(ctx); err != nil {
+				log.Printf("Failed to reload alert rules, keeping previous rules: %v", err)
+			}
+		}
+	}
+}
+
+// processRecord evaluates the rules of a window's tenant. Partial windows
+// are held until the rest of them arrives.
+func (e *Engine) processRecord(ctx context.Context, record *kgo.Record) error {
+	var m models.LLMMetrics
+	if err := json.Unmarshal(record.Value, &m); err != nil {
+		return fmt.Errorf("failed to unmarshal metrics: %w", err)
+	}
+
+	windows, err := e.partials.Add(&m, record.Partition, record.Offset)
+	if err != nil {
+		return err
+	}
+	for _, w := range windows {
+		if err := e.evaluateWindow(ctx, w.Metrics, w.Partition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evaluateWindow evaluates the rules of a whole window's tenant
+func (e *Engine) evaluateWindow(ctx context.Context, m *models.LLMMetrics, partition int32) error {
+	e.rulesMu.RLock()
+	var rules []models.AlertRule
+	for _, r := range e.rules[m.TenantID] {
+		if r.Matches(m) {
+			rules = append(rules, r)
+		}
+	}
+	e.rulesMu.RUnlock()
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	s, err := e.loadSeries(ctx, m, partition)
+	if err != nil {
+		return err
+	}
+
+	e.seriesMu.Lock()
+	defer e.seriesMu.Unlock()
+
+	for i := range rules {
+		rule := &rules[i]
+		current := s.alerts[rule.ID]
+		// Windows at or before the last evaluated one were already seen
+		if current != nil && !m.WindowStart.After(current.LastWindowStart) {
+			continue
+		}
+
+		next := evaluate(rule, current, m)
+		if next == nil {
+			if current != nil {
+				delete(s.alerts, rule.ID)
+				s.changed[rule.ID] = true
+			}
+			continue
+		}
+		s.alerts[rule.ID] = next
+		s.changed[rule.ID] = true
+	}
+	return nil
+}
+
+// loadSeries returns the in-memory alerts of a window's series, loading them
+// on first use
+func (e *Engine) loadSeries(ctx context.Context, m *models.LLMMetrics, partition int32) (*seriesAlerts, error) {
+	key := fmt.Sprintf("%s|%s|%s", m.TenantID, m.Route, m.Model)
+
+	e.seriesMu.Lock()
+	s, ok := e.series[key]
+	e.seriesMu.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	saved, err := e.store.LoadAlerts(ctx, m.TenantID, m.Route, m.Model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load alerts: %w", err)
+	}
+
+	s = &seriesAlerts{
+		tenantID:  m.TenantID,
+		route:     m.Route,
+		model:     m.Model,
+		partition: partition,
+		alerts:    make(map[int64]*models.Alert, len(saved)),
+		changed:   make(map[int64]bool),
+	}
+	for i := range saved {
+		s.alerts[saved[i].RuleID] = &saved[i]
+	}
+
+	e.seriesMu.Lock()
+	e.series[key] = s
+	e.seriesMu.Unlock()
+	return s, nil
+}
+
+// alertRef locates an alert within its series
+type alertRef struct {
+	series *seriesAlerts
+	alert  *models.Alert
+}
+
+// notify sends due notifications, one webhook call per rule. Failed
+// deliveries are retried after the next batch.
+func (e *Engine) notify(ctx context.Context, now time.Time) {
+	e.seriesMu.Lock()
+	defer e.seriesMu.Unlock()
+
+	e.rulesMu.RLock()
+	groups := make(map[int64][]alertRef)
+	for _, s := range e.series {
+		for ruleID, a := range s.alerts {
+			if _, ok := e.ruleIDs[ruleID]; !ok {
+				// Rule deleted or disabled
+				delete(s.alerts, ruleID)
+				s.changed[ruleID] = true
+				continue
+			}
+			switch needsNotification(a, now, e.settings.RepeatInterval) {
+			case notifySend:
+				groups[ruleID] = append(groups[ruleID], alertRef{series: s, alert: a})
+			case notifyDrop:
+				delete(s.alerts, ruleID)
+				s.changed[ruleID] = true
+			}
+		}
+	}
+	rules := make(map[int64]models.AlertRule, len(groups))
+	for ruleID := range groups {
+		rules[ruleID] = *e.ruleIDs[ruleID]
+	}
+	e.rulesMu.RUnlock()
+
+	for ruleID, refs := range groups {
+		rule := rules[ruleID]
+		alerts := make([]*models.Alert, len(refs))
+		for i, ref := range refs {
+			alerts[i] = ref.alert
+		}
+
+		if err := e.notifier.Notify(ctx, &rule, alerts); err != nil {
+			log.Printf("Failed to notify alert rule %d (%s), will retry: %v", rule.ID, rule.Name, err)
+			continue
+		}
+		log.Printf("Notified %d alerts of rule %d (%s)", len(alerts), rule.ID, rule.Name)
+
+		for _, ref := range refs {
+			notifiedAt := now
+			ref.alert.NotifiedStatus = ref.alert.Status
+			ref.alert.LastNotifiedAt = &notifiedAt
+			if ref.alert.Status == models.AlertResolved {
+				delete(ref.series.alerts, ruleID)
+			}
+			ref.series.changed[ruleID] = true
+		}
+	}
+}
+
+// persist saves changed alerts, limited to the given partitions when
+// partitions is non-nil
+func (e *Engine) persist(ctx context.Context, partitions map[int32]bool) error {
+	e.seriesMu.Lock()
+	defer e.seriesMu.Unlock()
+
+	for _, s := range e.series {
+		if partitions != nil && !partitions[s.partition] {
+			continue
+		}
+		for ruleID := range s.changed {
+			var err error
+			if a, ok := s.alerts[ruleID]; ok {
+				err = e.store.SaveAlert(ctx, a)
+			} else {
+				err = e.store.DeleteAlert(ctx, ruleID, s.tenantID, s.route, s.model)
+			}
+			if err != nil {
+				return err
+			}
+			delete(s.changed, ruleID)
+		}
+	}
+	return nil
+}
+
+// savePartials saves the held partial windows of changed partitions,
+// limited to the given partitions when partitions is non-nil
+func (e *Engine) savePartials(ctx context.Context, partitions map[int32]bool) error {
+	for _, p := range e.partials.Dirty() {
+		if partitions != nil && !partitions[p] {
+			continue
+		}
+		entries, err := e.partials.Entries(p)
+		if err != nil {
+			return err
+		}
+		if err := e.store.SaveProcessorState(ctx, e.consumer.Group(), kafka.TopicLLMMetrics, p, entries); err != nil {
+			return err
+		}
+		e.partials.Saved(p)
+	}
+	return nil
+}
+
+// dropPartitions forgets the series and held windows of the given partitions
+func (e *Engine) dropPartitions(partitions map[int32]bool) {
+	e.partials.Drop(partitions)
+
+	e.seriesMu.Lock()
+	defer e.seriesMu.Unlock()
+
+	for key, s := range e.series {
+		if partitions[s.partition] {
+			delete(e.series, key)
+		}
+	}
+}
+
+// OnPartitionsRevoked saves and drops the alerts and held windows of revoked
+// partitions so the next owner loads them from Postgres
+func (e *Engine) OnPartitionsRevoked(ctx context.Context, revoked map[string][]int32) {
+	partitions := partitionSet(revoked[kafka.TopicLLMMetrics])
+	if err := e.persist(ctx, partitions); err != nil {
+		log.Printf("Failed to save alert states on revoke: %v", err)
+	}
+	if err := e.savePartials(ctx, partitions); err != nil {
+		log.Printf("Failed to save partial windows on revoke: %v", err)
+	}
+	e.dropPartitions(partitions)
+}
+
+// OnPartitionsAssigned restores the partial windows held by the previous
+// owner; alerts are loaded on first use
+func (e *Engine) OnPartitionsAssigned(ctx context.Context, assigned map[string][]int32) {
+	for _, p := range assigned[kafka.TopicLLMMetrics] {
+		entries, err := e.store.TakeProcessorState(ctx, e.consumer.Group(), kafka.TopicLLMMetrics, p)
+		if err == nil {
+			err = e.partials.Restore(p, entries)
+		}
+		if err != nil {
+			log.Printf("Failed to restore partial windows of partition %d: %v", p, err)
+		}
+	}
+}
+
+// OnPartitionsLost drops the alerts and held windows of lost partitions
+// without saving them
+func (e *Engine) OnPartitionsLost(ctx context.Context, lost map[string][]int32) {
+	e.dropPartitions(partitionSet(lost[kafka.TopicLLMMetrics]))
+}
+
+func partitionSet(partitions []int32) map[int32]bool {
+	set := make(map[int32]bool, len(partitions))
+	for _, p := range partitions {
+		set[p] = true
+	}
+	return set
+}
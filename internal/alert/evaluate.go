@@ -0,0 +1,90 @@
+package alert
+
+import (
+	"streamlens/internal/models"
+	"time"
+)
+
+// evaluate advances the alert of rule for a series with a new window. a is the
+// series' current alert, or nil if it has none; the returned alert is nil
+// once the series is back to normal.
+func evaluate(rule *models.AlertRule, a *models.Alert, m *models.LLMMetrics) *models.Alert {
+	value := models.MetricFields[rule.Metric](m)
+	breached := rule.Breached(value)
+
+	// Breaches count as consecutive only if no window is missing between
+	// them. A breach after a resolution, or after a gap in a pending alert's
+	// breaches, starts a new alert.
+	adjacent := a != nil && m.WindowStart.Equal(a.LastWindowEnd)
+	if a != nil && breached && (a.Status == models.AlertResolved || a.Status == models.AlertPending && !adjacent) {
+		a = nil
+	}
+
+	if a == nil {
+		if !breached {
+			return nil
+		}
+		a = &models.Alert{
+			RuleID:   rule.ID,
+			TenantID: m.TenantID,
+			Route:    m.Route,
+			Model:    m.Model,
+			StartsAt: m.WindowStart,
+		}
+	}
+
+	a.LastWindowStart = m.WindowStart
+	a.Value = value
+
+	switch {
+	case breached:
+		if adjacent {
+			a.Consecutive++
+		} else {
+			a.Consecutive = 1
+		}
+		a.LastWindowEnd = m.WindowEnd
+		if a.Status == models.AlertFiring || a.Consecutive >= rule.ForWindows {
+			a.Status = models.AlertFiring
+		} else {
+			a.Status = models.AlertPending
+		}
+	case a.Status == models.AlertPending:
+		return nil
+	case a.Status == models.AlertFiring:
+		a.Status = models.AlertResolved
+		a.Consecutive = 0
+		endsAt := m.WindowEnd
+		a.EndsAt = &endsAt
+	}
+	return a
+}
+
+// notification is what should be sent for an alert, if anything
+type notification int
+
+const (
+	notifyNone notification = iota
+	notifySend
+	// notifyDrop means the alert resolved before its firing was delivered;
+	// it is dropped without a notification
+	notifyDrop
+)
+
+// needsNotification decides whether an alert's state must be delivered.
+// Firing alerts are sent once, then again every repeat interval; resolutions
+// are sent only for alerts whose firing was delivered.
+func needsNotification(a *models.Alert, now time.Time, repeat time.Duration) notification {
+	switch a.Status {
+	case models.AlertFiring:
+		if a.NotifiedStatus != models.AlertFiring || a.LastNotifiedAt == nil || now.Sub(*a.LastNotifiedAt) >= repeat {
+			return notifySend
+		}
+	case models.AlertResolved:
+		if a.NotifiedStatus == models.AlertFiring {
+			return notifySend
+		}
+		return notifyDrop
+	}
+	return notifyNone
+}
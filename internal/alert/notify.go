@@ -0,0 +1,189 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"streamlens/internal/models"
+	"strings"
+	"time"
+)
+
+// Notifier delivers grouped alerts to a rule's webhook
+type Notifier struct {
+	client *http.Client
+}
+
+// NewNotifier creates a Notifier whose requests time out after timeout
+func NewNotifier(timeout time.Duration) *Notifier {
+	return &Notifier{client: &http.Client{Timeout: timeout}}
+}
+
+// Notify sends the alerts of one rule in a single webhook call, in the
+// rule's webhook format
+func (n *Notifier) Notify(ctx context.Context, rule *models.AlertRule, alerts []*models.Alert) error {
+	var payload interface{}
+	switch rule.WebhookFormat {
+	case models.WebhookSlack:
+		payload = slackPayload(rule, alerts)
+	case models.WebhookAlertmanager:
+		payload = alertmanagerPayload(rule, alerts)
+	default:
+		payload = genericPayload(rule, alerts)
+	}
+
+	return n.Post(ctx, rule.WebhookURL, payload)
+}
+
+// Post sends payload as JSON to a webhook URL, failing on non-2xx responses
+func (n *Notifier) Post(ctx context.Context, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// groupKey identifies the notification group of a rule
+func groupKey(rule *models.AlertRule) string {
+	return "rule/" + strconv.FormatInt(rule.ID, 10)
+}
+
+// groupStatus is firing if any alert of the group is firing
+func groupStatus(alerts []*models.Alert) models.AlertStatus {
+	for _, a := range alerts {
+		if a.Status == models.AlertFiring {
+			return models.AlertFiring
+		}
+	}
+	return models.AlertResolved
+}
+
+// summary describes one alert in a sentence
+func summary(rule *models.AlertRule, a *models.Alert) string {
+	return fmt.Sprintf("%s %s/%s: %s = %.4g (%s %g for %d windows)",
+		a.TenantID, a.Route, a.Model, rule.Metric, a.Value, rule.Operator, rule.Threshold, rule.ForWindows)
+}
+
+// genericWebhook is the default payload
+type genericWebhook struct {
+	GroupKey string             `json:"group_key"`
+	Status   models.AlertStatus `json:"status"`
+	Rule     *models.AlertRule  `json:"rule"`
+	Alerts   []*models.Alert    `json:"alerts"`
+}
+
+func genericPayload(rule *models.AlertRule, alerts []*models.Alert) genericWebhook {
+	// The webhook URL may carry credentials; receivers do not need it
+	redacted := *rule
+	redacted.WebhookURL = ""
+	return genericWebhook{
+		GroupKey: groupKey(rule),
+		Status:   groupStatus(alerts),
+		Rule:     &redacted,
+		Alerts:   alerts,
+	}
+}
+
+// SlackWebhook is the payload accepted by Slack incoming webhooks
+type SlackWebhook struct {
+	Text string `json:"text"`
+}
+
+func slackPayload(rule *models.AlertRule, alerts []*models.Alert) SlackWebhook {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*[%s] %s* (%s)", strings.ToUpper(string(groupStatus(alerts))), rule.Name, rule.Severity)
+	for _, a := range alerts {
+		icon := ":red_circle:"
+		if a.Status == models.AlertResolved {
+			icon = ":large_green_circle:"
+		}
+		fmt.Fprintf(&b, "\n%s %s", icon, summary(rule, a))
+	}
+	return SlackWebhook{Text: b.String()}
+}
+
+// AlertmanagerWebhook follows the Alertmanager webhook payload (version 4)
+type AlertmanagerWebhook struct {
+	Version           string              `json:"version"`
+	GroupKey          string              `json:"groupKey"`
+	Status            models.AlertStatus  `json:"status"`
+	Receiver          string              `json:"receiver"`
+	GroupLabels       map[string]string   `json:"groupLabels"`
+	CommonLabels      map[string]string   `json:"commonLabels"`
+	CommonAnnotations map[string]string   `json:"commonAnnotations"`
+	ExternalURL       string              `json:"externalURL"`
+	Alerts            []AlertmanagerAlert `json:"alerts"`
+}
+
+// AlertmanagerAlert is one alert of an AlertmanagerWebhook
+type AlertmanagerAlert struct {
+	Status       models.AlertStatus `json:"status"`
+	Labels       map[string]string  `json:"labels"`
+	Annotations  map[string]string  `json:"annotations"`
+	StartsAt     time.Time          `json:"startsAt"`
+	EndsAt       time.Time          `json:"endsAt"`
+	GeneratorURL string             `json:"generatorURL"`
+	Fingerprint  string             `json:"fingerprint"`
+}
+
+func alertmanagerPayload(rule *models.AlertRule, alerts []*models.Alert) AlertmanagerWebhook {
+	common := map[string]string{
+		"alertname": rule.Name,
+		"severity":  rule.Severity,
+		"tenant_id": rule.TenantID,
+		"metric":    rule.Metric,
+	}
+
+	payload := AlertmanagerWebhook{
+		Version:           "4",
+		GroupKey:          groupKey(rule),
+		Status:            groupStatus(alerts),
+		Receiver:          "streamlens",
+		GroupLabels:       map[string]string{"alertname": rule.Name},
+		CommonLabels:      common,
+		CommonAnnotations: map[string]string{},
+	}
+
+	for _, a := range alerts {
+		labels := make(map[string]string, len(common)+2)
+		for k, v := range common {
+			labels[k] = v
+		}
+		labels["route"] = a.Route
+		labels["model"] = a.Model
+
+		var endsAt time.Time
+		if a.EndsAt != nil {
+			endsAt = *a.EndsAt
+		}
+		payload.Alerts = append(payload.Alerts, AlertmanagerAlert{
+			Status:      a.Status,
+			Labels:      labels,
+			Annotations: map[string]string{"summary": summary(rule, a), "value": strconv.FormatFloat(a.Value, 'g', -1, 64)},
+			StartsAt:    a.StartsAt,
+			EndsAt:      endsAt,
+			Fingerprint: fmt.Sprintf("%d|%s|%s|%s", rule.ID, a.TenantID, a.Route, a.Model),
+		})
+	}
+	return payload
+}
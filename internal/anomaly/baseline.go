@@ -0,0 +1,49 @@
+package anomaly
+
+import (
+	"math"
+	"streamlens/internal/models"
+)
+
+// GlobalSlot is the slot of the all-day baseline
+const GlobalSlot = -1
+
+// minRelativeStdDev keeps near-constant series from flagging tiny changes:
+// the standard deviation used for scoring is at least this fraction of the mean
+const minRelativeStdDev = 0.05
+
+// Metric is a scored field of LLMMetrics
+type Metric struct {
+	Name string
+	// MinStdDev is the absolute floor of the standard deviation used for scoring
+	MinStdDev float64
+	Value     func(m *models.LLMMetrics) float64
+}
+
+// Metrics are the fields every series is scored on
+var Metrics = []Metric{
+	{Name: "avg_latency_ms", MinStdDev: 1, Value: func(m *models.LLMMetrics) float64 { return m.AvgLatencyMs }},
+	{Name: "p99_latency_ms", MinStdDev: 1, Value: func(m *models.LLMMetrics) float64 { return m.P99LatencyMs }},
+	{Name: "error_rate", MinStdDev: 0.01, Value: (*models.LLMMetrics).ErrorRate},
+	{Name: "estimated_cost_usd", MinStdDev: 0.0001, Value: func(m *models.LLMMetrics) float64 { return m.EstimatedCostUSD }},
+}
+
+// update folds x into the baseline with smoothing factor alpha
+func update(b *models.AnomalyBaseline, x, alpha float64) {
+	if b.Count == 0 {
+		b.Mean = x
+		b.Variance = 0
+		b.Count = 1
+		return
+	}
+	diff := x - b.Mean
+	incr := alpha * diff
+	b.Mean += incr
+	b.Variance = (1 - alpha) * (b.Variance + diff*incr)
+	b.Count++
+}
+
+// stdDev is the standard deviation used to score against the baseline
+func stdDev(b *models.AnomalyBaseline, minStdDev float64) float64 {
+	return math.Max(math.Sqrt(b.Variance), math.Max(minStdDev, minRelativeStdDev*math.Abs(b.Mean)))
+}
@@ -0,0 +1,497 @@
+package anomaly
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"streamlens/internal/clock"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/partial"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Settings tune the detector
+type Settings struct {
+	// ZThreshold is the absolute z-score at which a window is anomalous
+	ZThreshold float64
+	// Alpha is the EWMA smoothing factor of every baseline
+	Alpha float64
+	// Warmup is the number of windows a baseline needs before it is used
+	Warmup int64
+	// MinRequests skips windows with too little traffic to be meaningful
+	MinRequests int
+}
+
+// Consumer reads llm.metrics; see kafka.Consumer
+type Consumer interface {
+	Group() string
+	SetRebalanceListener(l kafka.RebalanceListener)
+	Poll(ctx context.Context) kgo.Fetches
+	AllowRebalance()
+	CommitRecords(ctx context.Context, records ...*kgo.Record) error
+	Rewind(record *kgo.Record)
+}
+
+// Producer emits anomalies; see kafka.Producer
+type Producer interface {
+	ProduceJSON(ctx context.Context, topic, key string, value interface{}) error
+}
+
+// Store keeps baselines and anomalies; see store.MetricsStore
+type Store interface {
+	LoadAnomalySeries(ctx context.Context, tenantID, route, model string) (*models.AnomalySeries, error)
+	SaveAnomalySeries(ctx context.Context, series *models.AnomalySeries) error
+	InsertAnomaly(ctx context.Context, a *models.Anomaly) error
+	SaveProcessorState(ctx context.Context, group, topic string, partition int32, entries map[string][]byte) error
+	TakeProcessorState(ctx context.Context, group, topic string, partition int32) (map[string][]byte, error)
+}
+
+// DeadLetterer takes windows that cannot be scored; see dlq.Publisher
+type DeadLetterer interface {
+	Publish(ctx context.Context, record *kgo.Record, cause error) error
+}
+
+// baselineKey identifies one baseline of a series
+type baselineKey struct {
+	metric string
+	slot   int
+}
+
+// series holds the baselines of one tenant/route/model
+type series struct {
+	tenantID        string
+	route           string
+	model           string
+	partition       int32
+	lastWindowStart time.Time
+	baselines       map[baselineKey]*models.AnomalyBaseline
+	dirty           bool
+}
+
+// newSeries creates a series, restoring saved baselines when there are any
+func newSeries(m *models.LLMMetrics, partition int32, saved *models.AnomalySeries) *series {
+	s := &series{
+		tenantID:  m.TenantID,
+		route:     m.Route,
+		model:     m.Model,
+		partition: partition,
+		baselines: make(map[baselineKey]*models.AnomalyBaseline),
+	}
+	if saved != nil {
+		s.lastWindowStart = saved.LastWindowStart
+		for i := range saved.Baselines {
+			b := saved.Baselines[i]
+			s.baselines[baselineKey{metric: b.Metric, slot: b.Slot}] = &b
+		}
+	}
+	return s
+}
+
+// baseline returns the baseline for a metric and slot, creating it if needed
+func (s *series) baseline(metric string, slot int) *models.AnomalyBaseline {
+	key := baselineKey{metric: metric, slot: slot}
+	b, ok := s.baselines[key]
+	if !ok {
+		b = &models.AnomalyBaseline{Metric: metric, Slot: slot}
+		s.baselines[key] = b
+	}
+	return b
+}
+
+// score compares a window with the series baselines. The hour-of-day baseline
+// is preferred once warmed up, so daily traffic patterns are not flagged.
+func (s *series) score(m *models.LLMMetrics, settings Settings, now time.Time) []models.Anomaly {
+	if m.Requests < settings.MinRequests {
+		return nil
+	}
+
+	var anomalies []models.Anomaly
+	for _, metric := range Metrics {
+		b, seasonal := s.baselines[baselineKey{metric: metric.Name, slot: m.WindowStart.UTC().Hour()}], true
+		if b == nil || b.Count < settings.Warmup {
+			b, seasonal = s.baselines[baselineKey{metric: metric.Name, slot: GlobalSlot}], false
+		}
+		if b == nil || b.Count < settings.Warmup {
+			continue
+		}
+
+		observed := metric.Value(m)
+		sd := stdDev(b, metric.MinStdDev)
+		z := (observed - b.Mean) / sd
+		if math.Abs(z) < settings.ZThreshold {
+			continue
+		}
+
+		anomalies = append(anomalies, models.Anomaly{
+			TenantID:    m.TenantID,
+			Route:       m.Route,
+			Model:       m.Model,
+			Metric:      metric.Name,
+			WindowStart: m.WindowStart,
+			WindowEnd:   m.WindowEnd,
+			Observed:    observed,
+			Expected:    b.Mean,
+			StdDev:      sd,
+			ZScore:      z,
+			Seasonal:    seasonal,
+			DetectedAt:  now,
+		})
+	}
+	return anomalies
+}
+
+// fold updates the all-day and hour-of-day baselines with a window
+func (s *series) fold(m *models.LLMMetrics, settings Settings) {
+	s.lastWindowStart = m.WindowStart
+	s.dirty = true
+
+	if m.Requests < settings.MinRequests {
+		return
+	}
+	for _, metric := range Metrics {
+		x := metric.Value(m)
+		update(s.baseline(metric.Name, GlobalSlot), x, settings.Alpha)
+		update(s.baseline(metric.Name, m.WindowStart.UTC().Hour()), x, settings.Alpha)
+	}
+}
+
+// saved returns the persistent form of the series
+func (s *series) saved() *models.AnomalySeries {
+	out := &models.AnomalySeries{
+		TenantID:        s.tenantID,
+		Route:           s.route,
+		Model:           s.model,
+		LastWindowStart: s.lastWindowStart,
+		Baselines:       make([]models.AnomalyBaseline, 0, len(s.baselines)),
+	}
+	for _, b := range s.baselines {
+		out.Baselines = append(out.Baselines, *b)
+	}
+	return out
+}
+
+// Detector is the anomaly stage of the processor topology. It consumes the
+// default one-minute windows from llm.metrics, which is keyed by
+// tenant|route|model, scores each window against its series baselines and
+// emits deviations to llm.anomalies. Baselines are saved to Postgres after
+// every batch and loaded on first use, so they survive restarts and move with
+// their partition on rebalance.
+type Detector struct {
+	consumer Consumer
+	producer Producer
+	store    Store
+	dlq      DeadLetterer
+	settings Settings
+	clock    clock.Clock
+
+	series   map[string]*series
+	seriesMu sync.Mutex
+
+	// Windows flushed partially by a window stage shutting down, waiting
+	// for their remainder
+	partials *partial.Windows
+}
+
+// NewDetector creates the anomaly stage and registers it for rebalance callbacks
+func NewDetector(consumer Consumer, producer Producer, store Store, dlq DeadLetterer, settings Settings) *Detector {
+	d := &Detector{
+		consumer: consumer,
+		producer: producer,
+		store:    store,
+		dlq:      dlq,
+		settings: settings,
+		clock:    clock.Real,
+		series:   make(map[string]*series),
+		partials: partial.NewWindows(),
+	}
+	consumer.SetRebalanceListener(d)
+	return d
+}
+
+// SetClock replaces the system clock, which paces retries of records that
+// could not be dead-lettered. It must be called before Run.
+func (d *Detector) SetClock(c clock.Clock) {
+	d.clock = c
+}
+
+// Run starts the anomaly detector
+func (d *Detector) Run(ctx context.Context) error {
+	log.Printf("Starting anomaly detector (z >= %.1f, alpha %.2f, warmup %d windows)",
+		d.settings.ZThreshold, d.settings.Alpha, d.settings.Warmup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Context cancelled, stopping anomaly detector...")
+			return ctx.Err()
+		default:
+			fetches := d.consumer.Poll(ctx)
+
+			if errs := fetches.Errors(); len(errs) > 0 {
+				for _, err := range errs {
+					log.Printf("Fetch error: %v", err.Err)
+				}
+				d.consumer.AllowRebalance()
+				continue
+			}
+
+			// Process records
+			var recordsToCommit []*kgo.Record
+			rewound := make(map[int32]bool)
+			fetches.EachRecord(func(record *kgo.Record) {
+				if rewound[record.Partition] {
+					return
+				}
+				if err := d.processRecord(ctx, record); err != nil {
+					// Only commit past unprocessable windows once they are
+					// safely dead-lettered
+					if dlqErr := d.dlq.Publish(ctx, record, err); dlqErr != nil {
+						log.Printf("Error processing record: %v (dead-letter failed, retrying: %v)", err, dlqErr)
+						d.consumer.Rewind(record)
+						rewound[record.Partition] = true
+						return
+					}
+				}
+				recordsToCommit = append(recordsToCommit, record)
+			})
+
+			for _, w := range d.partials.Expire(d.clock.Now().Add(-partial.HoldTimeout)) {
+				if err := d.evaluate(ctx, w.Metrics, w.Partition); err != nil {
+					log.Printf("Failed to score partial window: %v", err)
+				}
+			}
+
+			// Baselines and held windows must be saved before offsets move
+			// past the windows folded into them
+			if err := d.saveDirty(ctx, nil); err != nil {
+				log.Printf("Failed to save anomaly baselines, not committing: %v", err)
+				d.consumer.AllowRebalance()
+				continue
+			}
+			if err := d.savePartials(ctx, nil); err != nil {
+				log.Printf("Failed to save partial windows, not committing: %v", err)
+				d.consumer.AllowRebalance()
+				continue
+			}
+
+			// Commit offsets
+			if len(recordsToCommit) > 0 {
+				if err := d.consumer.CommitRecords(ctx, recordsToCommit...); err != nil {
+					log.Printf("Failed to commit offsets: %v", err)
+				}
+			}
+
+			d.consumer.AllowRebalance()
+			if len(rewound) > 0 {
+				waitRewind(ctx, d.clock)
+			}
+		}
+	}
+}
+
+// waitRewind pauses polling after a partition was rewound to a record that
+// could not be dead-lettered, until it is retried or ctx is done
+func waitRewind(ctx context.Context, c clock.Clock) {
+	ticker := c.NewTicker(kafka.RewindBackoff)
+	defer ticker.Stop()
+	select {
+	case <-ctx.Done():
+	case <-ticker.C():
+	}
+}
+
+// processRecord scores one metrics window and folds it into its baselines.
+// Partial windows are held until the rest of them arrives.
+func (d *Detector) processRecord(ctx context.Context, record *kgo.Record) error {
+	var m models.LLMMetrics
+	if err := json.Unmarshal(record.Value, &m); err != nil {
+		return fmt.Errorf("failed to unmarshal metrics: %w", err)
+	}
+
+	windows, err := d.partials.Add(&m, record.Partition, record.Offset)
+	if err != nil {
+		return err
+	}
+	for _, w := range windows {
+		if err := d.evaluate(ctx, w.Metrics, w.Partition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evaluate scores a whole window and folds it into its baselines
+func (d *Detector) evaluate(ctx context.Context, m *models.LLMMetrics, partition int32) error {
+	s, err := d.loadSeries(ctx, m, partition)
+	if err != nil {
+		return err
+	}
+
+	// Windows at or before the last folded one were already scored, e.g.
+	// redelivered after a restart
+	if !m.WindowStart.After(s.lastWindowStart) {
+		return nil
+	}
+
+	for _, a := range s.score(m, d.settings, time.Now().UTC()) {
+		if err := d.emit(ctx, &a); err != nil {
+			return err
+		}
+	}
+
+	d.seriesMu.Lock()
+	s.fold(m, d.settings)
+	d.seriesMu.Unlock()
+	return nil
+}
+
+// loadSeries returns the in-memory series of a window, loading its saved
+// baselines on first use
+func (d *Detector) loadSeries(ctx context.Context, m *models.LLMMetrics, partition int32) (*series, error) {
+	key := seriesKey(m.TenantID, m.Route, m.Model)
+
+	d.seriesMu.Lock()
+	s, ok := d.series[key]
+	d.seriesMu.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	saved, err := d.store.LoadAnomalySeries(ctx, m.TenantID, m.Route, m.Model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load anomaly baselines: %w", err)
+	}
+	s = newSeries(m, partition, saved)
+
+	d.seriesMu.Lock()
+	d.series[key] = s
+	d.seriesMu.Unlock()
+	return s, nil
+}
+
+// emit produces and stores an anomaly
+func (d *Detector) emit(ctx context.Context, a *models.Anomaly) error {
+	key := seriesKey(a.TenantID, a.Route, a.Model)
+	if err := d.producer.ProduceJSON(ctx, kafka.TopicLLMAnomalies, key, a); err != nil {
+		return fmt.Errorf("failed to produce anomaly: %w", err)
+	}
+	if err := d.store.InsertAnomaly(ctx, a); err != nil {
+		return fmt.Errorf("failed to store anomaly: %w", err)
+	}
+
+	log.Printf("Anomaly: %s %s=%.4g (expected %.4g, z=%.1f) [%s]",
+		key, a.Metric, a.Observed, a.Expected, a.ZScore, a.WindowStart.Format(time.RFC3339))
+	return nil
+}
+
+// saveDirty saves changed series, limited to the given partitions when
+// partitions is non-nil
+func (d *Detector) saveDirty(ctx context.Context, partitions map[int32]bool) error {
+	d.seriesMu.Lock()
+	var dirty []*series
+	for _, s := range d.series {
+		if s.dirty && (partitions == nil || partitions[s.partition]) {
+			dirty = append(dirty, s)
+		}
+	}
+	d.seriesMu.Unlock()
+
+	for _, s := range dirty {
+		d.seriesMu.Lock()
+		saved := s.saved()
+		d.seriesMu.Unlock()
+
+		if err := d.store.SaveAnomalySeries(ctx, saved); err != nil {
+			return err
+		}
+
+		d.seriesMu.Lock()
+		s.dirty = false
+		d.seriesMu.Unlock()
+	}
+	return nil
+}
+
+// savePartials saves the held partial windows of changed partitions,
+// limited to the given partitions when partitions is non-nil
+func (d *Detector) savePartials(ctx context.Context, partitions map[int32]bool) error {
+	for _, p := range d.partials.Dirty() {
+		if partitions != nil && !partitions[p] {
+			continue
+		}
+		entries, err := d.partials.Entries(p)
+		if err != nil {
+			return err
+		}
+		if err := d.store.SaveProcessorState(ctx, d.consumer.Group(), kafka.TopicLLMMetrics, p, entries); err != nil {
+			return err
+		}
+		d.partials.Saved(p)
+	}
+	return nil
+}
+
+// dropPartitions forgets the series and held windows of the given partitions
+func (d *Detector) dropPartitions(partitions map[int32]bool) {
+	d.partials.Drop(partitions)
+
+	d.seriesMu.Lock()
+	defer d.seriesMu.Unlock()
+
+	for key, s := range d.series {
+		if partitions[s.partition] {
+			delete(d.series, key)
+		}
+	}
+}
+
+// OnPartitionsRevoked saves and drops the baselines and held windows of
+// revoked partitions so the next owner loads them from Postgres
+func (d *Detector) OnPartitionsRevoked(ctx context.Context, revoked map[string][]int32) {
+	partitions := partitionSet(revoked[kafka.TopicLLMMetrics])
+	if err := d.saveDirty(ctx, partitions); err != nil {
+		log.Printf("Failed to save anomaly baselines on revoke: %v", err)
+	}
+	if err := d.savePartials(ctx, partitions); err != nil {
+		log.Printf("Failed to save partial windows on revoke: %v", err)
+	}
+	d.dropPartitions(partitions)
+}
+
+// OnPartitionsAssigned restores the partial windows held by the previous
+// owner; baselines are loaded on first use
+func (d *Detector) OnPartitionsAssigned(ctx context.Context, assigned map[string][]int32) {
+	for _, p := range assigned[kafka.TopicLLMMetrics] {
+		entries, err := d.store.TakeProcessorState(ctx, d.consumer.Group(), kafka.TopicLLMMetrics, p)
+		if err == nil {
+			err = d.partials.Restore(p, entries)
+		}
+		if err != nil {
+			log.Printf("Failed to restore partial windows of partition %d: %v", p, err)
+		}
+	}
+}
+
+// OnPartitionsLost drops the baselines and held windows of lost partitions
+// without saving them
+func (d *Detector) OnPartitionsLost(ctx context.Context, lost map[string][]int32) {
+	d.dropPartitions(partitionSet(lost[kafka.TopicLLMMetrics]))
+}
+
+func partitionSet(partitions []int32) map[int32]bool {
+	set := make(map[int32]bool, len(partitions))
+	for _, p := range partitions {
+		set[p] = true
+	}
+	return set
+}
+
+func seriesKey(tenantID, route, model string) string {
+	return fmt.Sprintf("%s|%s|%s", tenantID, route, model)
+}
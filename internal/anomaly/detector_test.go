@@ -0,0 +1,248 @@
+package anomaly
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"streamlens/internal/clock"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/processor/processortest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestUpdate(t *testing.T) {
+	var b models.AnomalyBaseline
+	for i := 0; i < 1000; i++ {
+		// Alternate 90 and 110: mean 100, standard deviation 10
+		update(&b, float64(90+20*(i%2)), 0.05)
+	}
+	if math.Abs(b.Mean-100) > 1 {
+		t.Errorf("Mean = %v, want ~100", b.Mean)
+	}
+	if sd := math.Sqrt(b.Variance); math.Abs(sd-10) > 1 {
+		t.Errorf("stddev = %v, want ~10", sd)
+	}
+	if b.Count != 1000 {
+		t.Errorf("Count = %d, want 1000", b.Count)
+	}
+}
+
+func TestSeries_Score(t *testing.T) {
+	settings := Settings{ZThreshold: 4, Alpha: 0.1, Warmup: 30, MinRequests: 10}
+	start := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+
+	window := func(i int, latency float64, errors int) *models.LLMMetrics {
+		ws := start.Add(time.Duration(i) * time.Minute)
+		return &models.LLMMetrics{
+			TenantID:         "tenant-1",
+			Route:            "chat",
+			Model:            "gpt-4",
+			WindowStart:      ws,
+			WindowEnd:        ws.Add(time.Minute),
+			Requests:         100,
+			Errors:           errors,
+			AvgLatencyMs:     latency,
+			P99LatencyMs:     latency * 2,
+			EstimatedCostUSD: 1,
+		}
+	}
+
+	s := newSeries(window(0, 0, 0), 0, nil)
+	for i := 0; i < 40; i++ {
+		m := window(i, 500+float64(i%5), 1)
+		if got := s.score(m, settings, start); len(got) != 0 {
+			t.Fatalf("window %d: unexpected anomalies %+v", i, got)
+		}
+		s.fold(m, settings)
+	}
+
+	// Low-traffic windows are neither scored nor folded
+	quiet := window(40, 5000, 0)
+	quiet.Requests = 3
+	if got := s.score(quiet, settings, start); len(got) != 0 {
+		t.Errorf("low-traffic window scored: %+v", got)
+	}
+
+	got := s.score(window(41, 2000, 30), settings, start)
+	metrics := make(map[string]models.Anomaly)
+	for _, a := range got {
+		metrics[a.Metric] = a
+	}
+	for _, name := range []string{"avg_latency_ms", "p99_latency_ms", "error_rate"} {
+		a, ok := metrics[name]
+		if !ok {
+			t.Errorf("expected %s anomaly, got %+v", name, got)
+			continue
+		}
+		if a.ZScore < settings.ZThreshold || a.Observed <= a.Expected {
+			t.Errorf("%s anomaly = %+v", name, a)
+		}
+		// All windows fall within the same hour, so the seasonal baseline is used
+		if !a.Seasonal {
+			t.Errorf("%s anomaly used the all-day baseline", name)
+		}
+	}
+	if _, ok := metrics["estimated_cost_usd"]; ok {
+		t.Errorf("unexpected cost anomaly")
+	}
+
+	// Baselines round-trip through their saved form
+	restored := newSeries(window(0, 0, 0), 0, s.saved())
+	if !restored.lastWindowStart.Equal(s.lastWindowStart) || len(restored.baselines) != len(s.baselines) {
+		t.Errorf("restored series = %+v", restored)
+	}
+}
+
+// memStore keeps baselines, anomalies and handed-off windows in memory
+type memStore struct {
+	*processortest.Store
+	series    map[string]*models.AnomalySeries
+	anomalies []models.Anomaly
+	mu        sync.Mutex
+}
+
+func (s *memStore) LoadAnomalySeries(ctx context.Context, tenantID, route, model string) (*models.AnomalySeries, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.series[seriesKey(tenantID, route, model)], nil
+}
+
+func (s *memStore) SaveAnomalySeries(ctx context.Context, series *models.AnomalySeries) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.series == nil {
+		s.series = make(map[string]*models.AnomalySeries)
+	}
+	s.series[seriesKey(series.TenantID, series.Route, series.Model)] = series
+	return nil
+}
+
+func (s *memStore) InsertAnomaly(ctx context.Context, a *models.Anomaly) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.anomalies = append(s.anomalies, *a)
+	return nil
+}
+
+// testDetector is an anomaly stage wired to in-memory fakes
+type testDetector struct {
+	*Detector
+	consumer *processortest.Consumer
+	producer *processortest.Producer
+	store    *memStore
+	dlq      *processortest.DLQ
+	clock    *clock.Fake
+}
+
+func newTestDetector(settings Settings) *testDetector {
+	td := &testDetector{
+		consumer: processortest.NewConsumer("test-anomalies"),
+		producer: &processortest.Producer{},
+		store:    &memStore{Store: processortest.NewStore()},
+		dlq:      &processortest.DLQ{},
+		clock:    clock.NewFake(time.Date(2025, 11, 20, 12, 0, 0, 0, time.UTC)),
+	}
+	td.Detector = NewDetector(td.consumer, td.producer, td.store, td.dlq, settings)
+	td.SetClock(td.clock)
+	return td
+}
+
+// run runs the stage until every queued record is handled
+func (td *testDetector) run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- td.Run(ctx) }()
+	td.consumer.IdleAdvancing(td.clock, kafka.RewindBackoff)
+	cancel()
+	<-done
+}
+
+func metricsRecord(t *testing.T, m *models.LLMMetrics, offset int64) *kgo.Record {
+	t.Helper()
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &kgo.Record{Topic: kafka.TopicLLMMetrics, Offset: offset, Value: data}
+}
+
+func TestDetector_DeadLetterFailure(t *testing.T) {
+	td := newTestDetector(Settings{ZThreshold: 4, Alpha: 0.1, Warmup: 30, MinRequests: 10})
+	td.dlq.SetError(errors.New("dlq unavailable"))
+
+	start := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+	window := func(i int) *models.LLMMetrics {
+		ws := start.Add(time.Duration(i) * time.Minute)
+		return &models.LLMMetrics{TenantID: "tenant-1", Route: "chat", Model: "gpt-4",
+			WindowStart: ws, WindowEnd: ws.Add(time.Minute), Requests: 100}
+	}
+	first := metricsRecord(t, window(0), 0)
+	malformed := &kgo.Record{Topic: kafka.TopicLLMMetrics, Offset: 1, Value: []byte("not json")}
+	after := metricsRecord(t, window(2), 2)
+	otherWindow := window(3)
+	otherWindow.Route = "search"
+	other := metricsRecord(t, otherWindow, 0)
+	other.Partition = 1
+	td.consumer.Add(first, malformed, after, other)
+	td.run()
+
+	// Nothing past the record that could not be dead-lettered is scored or
+	// committed; its partition is polled again from it
+	if rewound := td.consumer.Rewound(); len(rewound) != 1 || rewound[0] != malformed {
+		t.Errorf("rewound to %v, want the malformed record", rewound)
+	}
+	committed := td.consumer.Committed()
+	if len(committed) != 2 || committed[0] != first || committed[1] != other {
+		t.Errorf("committed %d records, want the first one and the other partition's", len(committed))
+	}
+	if saved := td.store.series[seriesKey("tenant-1", "chat", "gpt-4")]; saved == nil || !saved.LastWindowStart.Equal(start) {
+		t.Errorf("saved series = %+v, want it up to the first window", saved)
+	}
+	if saved := td.store.series[seriesKey("tenant-1", "search", "gpt-4")]; saved == nil {
+		t.Error("series of the other partition not saved")
+	}
+}
+
+func TestDetector_PartialWindow(t *testing.T) {
+	td := newTestDetector(Settings{ZThreshold: 4, Alpha: 0.1, Warmup: 30, MinRequests: 50})
+
+	start := time.Date(2025, 11, 20, 11, 58, 0, 0, time.UTC)
+	window := func(requests, errors int, partial bool) *models.LLMMetrics {
+		return &models.LLMMetrics{TenantID: "tenant-1", Route: "chat", Model: "gpt-4",
+			WindowStart: start, WindowEnd: start.Add(time.Minute), Requests: requests, Errors: errors, Partial: partial}
+	}
+	drained := metricsRecord(t, window(40, 8, true), 0)
+	td.consumer.Add(drained)
+	td.run()
+
+	// The drained part is held, not scored, and handed off with its partition
+	if saved := td.store.series[seriesKey("tenant-1", "chat", "gpt-4")]; saved != nil {
+		t.Errorf("saved series = %+v, want the partial window held", saved)
+	}
+	if committed := td.consumer.Committed(); len(committed) != 1 || committed[0] != drained {
+		t.Errorf("committed %d records, want the drained one", len(committed))
+	}
+	partitions := map[string][]int32{kafka.TopicLLMMetrics: {0}}
+	td.consumer.Revoke(context.Background(), partitions)
+	td.consumer.Assign(context.Background(), partitions)
+
+	td.consumer.Add(metricsRecord(t, window(60, 2, false), 1))
+	td.run()
+
+	// The remainder releases the window merged, scored and folded once
+	saved := td.store.series[seriesKey("tenant-1", "chat", "gpt-4")]
+	if saved == nil || !saved.LastWindowStart.Equal(start) {
+		t.Fatalf("saved series = %+v, want it up to the window", saved)
+	}
+	for _, b := range saved.Baselines {
+		if b.Metric == "error_rate" && b.Slot == GlobalSlot && (b.Count != 1 || b.Mean != 0.1) {
+			t.Errorf("error_rate baseline = %+v, want the merged window folded once", b)
+		}
+	}
+}
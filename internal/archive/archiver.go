@@ -0,0 +1,417 @@
+// Package archive keeps joined request/response events in object storage,
+// beyond the retention of the Kafka topics, for audits and reprocessing.
+package archive
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"streamlens/internal/clock"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// FlushRetryBackoff is how long the archiver stops polling after a full
+// buffer failed to flush
+const FlushRetryBackoff = 10 * time.Second
+
+// Consumer reads llm.joined; see kafka.Consumer
+type Consumer interface {
+	Group() string
+	SetRebalanceListener(l kafka.RebalanceListener)
+	Poll(ctx context.Context) kgo.Fetches
+	AllowRebalance()
+	CommitRecords(ctx context.Context, records ...*kgo.Record) error
+	Rewind(record *kgo.Record)
+}
+
+// StateStore hands buffered events over to the next owner of a partition
+// when they cannot be archived on rebalance; see store.MetricsStore
+type StateStore interface {
+	SaveProcessorState(ctx context.Context, group, topic string, partition int32, entries map[string][]byte) error
+	TakeProcessorState(ctx context.Context, group, topic string, partition int32) (map[string][]byte, error)
+}
+
+// DeadLetterer takes records that are not joined events; see dlq.Publisher
+type DeadLetterer interface {
+	Publish(ctx context.Context, record *kgo.Record, cause error) error
+}
+
+// partitionKey identifies the archive partition of an event
+type partitionKey struct {
+	tenantID string
+	hour     time.Time
+}
+
+// buffered is an event waiting to be archived, with the input partition
+// it was read from
+type buffered struct {
+	event     models.JoinedEvent
+	partition int32
+}
+
+// Archiver buffers joined events and writes them, every flush interval or
+// once maxEvents are buffered (0 for no limit), as one file per tenant and
+// hour. Offsets are committed only once every event read before them is
+// archived, so an event is archived at least once: a crash between writing a
+// file and committing may archive its events again on restart.
+type Archiver struct {
+	consumer      Consumer
+	bucket        Bucket
+	store         StateStore
+	dlq           DeadLetterer
+	format        Format
+	flushInterval time.Duration
+	maxEvents     int
+	clock         clock.Clock
+
+	buffers  map[partitionKey][]buffered
+	buffered int
+	// toCommit is the last record handled of each partition since the
+	// last flush
+	toCommit map[int32]*kgo.Record
+	mu       sync.Mutex
+}
+
+// NewArchiver creates an archiver and registers it for rebalance callbacks
+func NewArchiver(consumer Consumer, bucket Bucket, store StateStore, dlq DeadLetterer, format Format, flushInterval time.Duration, maxEvents int) *Archiver {
+	a := &Archiver{
+		consumer:      consumer,
+		bucket:        bucket,
+		store:         store,
+		dlq:           dlq,
+		format:        format,
+		flushInterval: flushInterval,
+		maxEvents:     maxEvents,
+		clock:         clock.Real,
+		buffers:       make(map[partitionKey][]buffered),
+		toCommit:      make(map[int32]*kgo.Record),
+	}
+	consumer.SetRebalanceListener(a)
+	return a
+}
+
+// SetClock replaces the clock driving flushes, for tests
+func (a *Archiver) SetClock(c clock.Clock) {
+	a.clock = c
+}
+
+// Run archives events until ctx is done. Events still buffered then are
+// written by Flush.
+func (a *Archiver) Run(ctx context.Context) error {
+	log.Printf("Starting archiver (%s, every %s or %d events)", a.format, a.flushInterval, a.maxEvents)
+
+	go a.flushPeriodically(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Context cancelled, stopping archiver...")
+			return ctx.Err()
+		default:
+			fetches := a.consumer.Poll(ctx)
+
+			if errs := fetches.Errors(); len(errs) > 0 {
+				for _, err := range errs {
+					log.Printf("Fetch error: %v", err.Err)
+				}
+				a.consumer.AllowRebalance()
+				continue
+			}
+
+			batchCtx := context.WithoutCancel(ctx)
+			rewound := make(map[int32]bool)
+			fetches.EachRecord(func(record *kgo.Record) {
+				if rewound[record.Partition] {
+					return
+				}
+				if err := a.processRecord(record); err != nil {
+					// Nothing past a record is committed before it is
+					// dead-lettered; its partition is polled again from it
+					if dlqErr := a.dlq.Publish(batchCtx, record, err); dlqErr != nil {
+						log.Printf("Error processing record: %v (dead-letter failed, retrying: %v)", err, dlqErr)
+						a.consumer.Rewind(record)
+						rewound[record.Partition] = true
+						return
+					}
+				}
+				a.mu.Lock()
+				a.toCommit[record.Partition] = record
+				a.mu.Unlock()
+			})
+
+			if a.maxEvents > 0 && a.bufferedEvents() >= a.maxEvents {
+				if err := a.Flush(batchCtx); err != nil {
+					log.Printf("Failed to archive full buffer, pausing for %s: %v", FlushRetryBackoff, err)
+					a.wait(ctx, FlushRetryBackoff)
+				}
+			}
+
+			a.consumer.AllowRebalance()
+			if len(rewound) > 0 {
+				a.wait(ctx, kafka.RewindBackoff)
+			}
+		}
+	}
+}
+
+// processRecord buffers the joined event of a record
+func (a *Archiver) processRecord(record *kgo.Record) error {
+	if record.Topic != kafka.TopicLLMJoined {
+		return fmt.Errorf("unknown topic: %s", record.Topic)
+	}
+	var event models.JoinedEvent
+	if err := json.Unmarshal(record.Value, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal joined event: %w", err)
+	}
+
+	a.mu.Lock()
+	a.addLocked(event, record.Partition)
+	a.mu.Unlock()
+	return nil
+}
+
+// addLocked buffers an event. Callers must hold mu.
+func (a *Archiver) addLocked(event models.JoinedEvent, partition int32) {
+	key := partitionKey{tenantID: event.Request.TenantID, hour: event.Request.Timestamp.UTC().Truncate(time.Hour)}
+	a.buffers[key] = append(a.buffers[key], buffered{event: event, partition: partition})
+	a.buffered++
+	bufferedEvents.Set(float64(a.buffered))
+}
+
+func (a *Archiver) bufferedEvents() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.buffered
+}
+
+// flushPeriodically flushes the buffer every flush interval
+func (a *Archiver) flushPeriodically(ctx context.Context) {
+	ticker := a.clock.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if err := a.Flush(ctx); err != nil {
+				log.Printf("Failed to archive events: %v", err)
+			}
+		}
+	}
+}
+
+// wait blocks for d or until ctx is done
+func (a *Archiver) wait(ctx context.Context, d time.Duration) {
+	ticker := a.clock.NewTicker(d)
+	defer ticker.Stop()
+	select {
+	case <-ctx.Done():
+	case <-ticker.C():
+	}
+}
+
+// Flush archives every buffered event and commits the records read so far.
+// It is also called after Run returned, before the consumer is closed.
+func (a *Archiver) Flush(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.flushLocked(ctx); err != nil {
+		return err
+	}
+	if len(a.toCommit) == 0 {
+		return nil
+	}
+	records := make([]*kgo.Record, 0, len(a.toCommit))
+	for _, r := range a.toCommit {
+		records = append(records, r)
+	}
+	if err := a.consumer.CommitRecords(ctx, records...); err != nil {
+		return fmt.Errorf("failed to commit offsets: %w", err)
+	}
+	a.toCommit = make(map[int32]*kgo.Record)
+	return nil
+}
+
+// flushLocked writes a file per buffered tenant and hour. Partitions that
+// fail stay buffered for the next flush. Callers must hold mu.
+func (a *Archiver) flushLocked(ctx context.Context) error {
+	var failed int
+	var lastErr error
+	for key, events := range a.buffers {
+		if err := a.writeFile(ctx, key, events); err != nil {
+			archiveFailures.Inc()
+			failed++
+			lastErr = err
+			continue
+		}
+		delete(a.buffers, key)
+		a.buffered -= len(events)
+	}
+	bufferedEvents.Set(float64(a.buffered))
+	if failed > 0 {
+		return fmt.Errorf("%d files not written: %w", failed, lastErr)
+	}
+	return nil
+}
+
+// writeFile writes the events of one partition as a new file, then its
+// manifest entry
+func (a *Archiver) writeFile(ctx context.Context, key partitionKey, batch []buffered) error {
+	events := make([]models.JoinedEvent, len(batch))
+	for i := range batch {
+		events[i] = batch[i].event
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Request.Timestamp.Before(events[j].Request.Timestamp)
+	})
+
+	data, err := a.format.encode(events)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s events: %w", key.tenantID, err)
+	}
+
+	now := a.clock.Now().UTC()
+	id := fmt.Sprintf("%d-%s", now.UnixNano(), randomHex(4))
+	entry := ManifestEntry{
+		Path:         eventsKey(key.tenantID, key.hour, id, a.format),
+		Format:       a.format,
+		TenantID:     key.tenantID,
+		Hour:         key.hour,
+		Events:       len(events),
+		Bytes:        len(data),
+		MinTimestamp: events[0].Request.Timestamp,
+		MaxTimestamp: events[len(events)-1].Request.Timestamp,
+		CreatedAt:    now,
+	}
+	if err := a.bucket.Put(ctx, entry.Path, data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", entry.Path, err)
+	}
+	manifest, err := json.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+	if err := a.bucket.Put(ctx, manifestKey(key.hour, id), manifest); err != nil {
+		return fmt.Errorf("failed to write manifest entry of %s: %w", entry.Path, err)
+	}
+
+	archivedEvents.Add(float64(len(events)))
+	archivedBytes.Add(float64(len(data)))
+	log.Printf("Archived %d events of %s [%s] to %s", len(events), key.tenantID, key.hour.Format(time.RFC3339), entry.Path)
+	return nil
+}
+
+// OnPartitionsRevoked archives every buffered event before the offsets of
+// revoked partitions are committed. When that fails, the events of revoked
+// partitions are handed over to their next owner instead.
+func (a *Archiver) OnPartitionsRevoked(ctx context.Context, revoked map[string][]int32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	flushErr := a.flushLocked(ctx)
+	for _, partition := range revoked[kafka.TopicLLMJoined] {
+		delete(a.toCommit, partition)
+		owned := a.takePartitionLocked(partition)
+		if flushErr == nil || len(owned) == 0 {
+			continue
+		}
+
+		entries := make(map[string][]byte, len(owned))
+		for i := range owned {
+			data, err := json.Marshal(&owned[i])
+			if err != nil {
+				log.Printf("Failed to encode event %s: %v", owned[i].Request.RequestID, err)
+				continue
+			}
+			entries[owned[i].Request.RequestID] = data
+		}
+		if err := a.store.SaveProcessorState(ctx, a.consumer.Group(), kafka.TopicLLMJoined, partition, entries); err != nil {
+			log.Printf("Failed to hand off %d events of partition %d, they are not archived: %v", len(owned), partition, err)
+			continue
+		}
+		log.Printf("Archive unavailable (%v), handed off %d events of partition %d", flushErr, len(owned), partition)
+	}
+}
+
+// OnPartitionsAssigned buffers the events handed off by the previous owner
+func (a *Archiver) OnPartitionsAssigned(ctx context.Context, assigned map[string][]int32) {
+	for _, partition := range assigned[kafka.TopicLLMJoined] {
+		entries, err := a.store.TakeProcessorState(ctx, a.consumer.Group(), kafka.TopicLLMJoined, partition)
+		if err != nil {
+			log.Printf("Failed to restore events of partition %d: %v", partition, err)
+			continue
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		a.mu.Lock()
+		for id, data := range entries {
+			var event models.JoinedEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				log.Printf("Failed to restore event %s: %v", id, err)
+				continue
+			}
+			a.addLocked(event, partition)
+		}
+		a.mu.Unlock()
+		log.Printf("Restored %d events of partition %d", len(entries), partition)
+	}
+}
+
+// OnPartitionsLost drops the buffered events of lost partitions; their
+// offsets were not committed, so the next owner reads them again
+func (a *Archiver) OnPartitionsLost(ctx context.Context, lost map[string][]int32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, partition := range lost[kafka.TopicLLMJoined] {
+		delete(a.toCommit, partition)
+		owned := a.takePartitionLocked(partition)
+		log.Printf("Lost partition %d, dropped %d buffered events", partition, len(owned))
+	}
+}
+
+// takePartitionLocked removes and returns the buffered events read from a
+// partition. Callers must hold mu.
+func (a *Archiver) takePartitionLocked(partition int32) []models.JoinedEvent {
+	var owned []models.JoinedEvent
+	for key, events := range a.buffers {
+		var kept []buffered
+		for _, b := range events {
+			if b.partition == partition {
+				owned = append(owned, b.event)
+			} else {
+				kept = append(kept, b)
+			}
+		}
+		if len(kept) == 0 {
+			delete(a.buffers, key)
+		} else {
+			a.buffers[key] = kept
+		}
+	}
+	a.buffered -= len(owned)
+	bufferedEvents.Set(float64(a.buffered))
+	return owned
+}
+
+// randomHex returns n random bytes, hex-encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
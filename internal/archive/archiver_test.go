@@ -0,0 +1,298 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"streamlens/internal/clock"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/processor/processortest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+var testStart = time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+
+// failingBucket fails every Put while failing is set
+type failingBucket struct {
+	Bucket
+	failing bool
+	mu      sync.Mutex
+}
+
+func (b *failingBucket) Put(ctx context.Context, key string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failing {
+		return errors.New("bucket unavailable")
+	}
+	return b.Bucket.Put(ctx, key, data)
+}
+
+func (b *failingBucket) setFailing(failing bool) {
+	b.mu.Lock()
+	b.failing = failing
+	b.mu.Unlock()
+}
+
+// testArchiver is an archiver writing to a temporary directory
+type testArchiver struct {
+	*Archiver
+	consumer *processortest.Consumer
+	dlq      *processortest.DLQ
+	bucket   *failingBucket
+}
+
+func newTestArchiver(t *testing.T, store *processortest.Store, format Format) *testArchiver {
+	t.Helper()
+	local, err := NewLocalBucket(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalBucket() error = %v", err)
+	}
+	ta := &testArchiver{
+		consumer: processortest.NewConsumer("test"),
+		dlq:      &processortest.DLQ{},
+		bucket:   &failingBucket{Bucket: local},
+	}
+	ta.Archiver = NewArchiver(ta.consumer, ta.bucket, store, ta.dlq, format, time.Hour, 0)
+	ta.SetClock(clock.NewFake(testStart.Add(2 * time.Hour)))
+	return ta
+}
+
+// joined returns a joined event of tenant at testStart+at
+func joined(id, tenant string, at time.Duration) models.JoinedEvent {
+	ts := testStart.Add(at)
+	errMsg := "rate limited"
+	ev := models.JoinedEvent{
+		Request: models.LLMRequest{
+			RequestID:    id,
+			TenantID:     tenant,
+			Route:        "/chat",
+			Model:        "gpt-4o",
+			Provider:     "openai",
+			Timestamp:    ts,
+			PromptTokens: 120,
+			Metadata:     map[string]interface{}{"team": "search"},
+		},
+		Response: models.LLMResponse{
+			RequestID:        id,
+			Timestamp:        ts.Add(800 * time.Millisecond),
+			LatencyMs:        800,
+			CompletionTokens: 40,
+			FinishReason:     "stop",
+		},
+	}
+	if strings.HasSuffix(id, "-err") {
+		ev.Response.Error = &errMsg
+	}
+	return ev
+}
+
+func record(t *testing.T, ev models.JoinedEvent, partition int32, offset int64) *kgo.Record {
+	t.Helper()
+	data, err := json.Marshal(&ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &kgo.Record{Topic: kafka.TopicLLMJoined, Partition: partition, Offset: offset, Value: data}
+}
+
+// readAll returns the archived events of the test day by request ID
+func readAll(t *testing.T, bucket Bucket) (map[string]models.JoinedEvent, []ManifestEntry) {
+	t.Helper()
+	ctx := context.Background()
+	entries, err := ReadManifest(ctx, bucket, testStart, testStart.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	events := make(map[string]models.JoinedEvent)
+	for _, entry := range entries {
+		read, err := ReadEvents(ctx, bucket, entry)
+		if err != nil {
+			t.Fatalf("ReadEvents() error = %v", err)
+		}
+		if len(read) != entry.Events {
+			t.Errorf("%s holds %d events, manifest says %d", entry.Path, len(read), entry.Events)
+		}
+		for _, ev := range read {
+			events[ev.Request.RequestID] = ev
+		}
+	}
+	return events, entries
+}
+
+func TestArchiver_Run(t *testing.T) {
+	for _, format := range []Format{FormatParquet, FormatJSONLZstd} {
+		t.Run(string(format), func(t *testing.T) {
+			ta := newTestArchiver(t, processortest.NewStore(), format)
+			events := []models.JoinedEvent{
+				joined("a", "t1", 10*time.Second),
+				joined("b-err", "t1", 20*time.Minute),
+				joined("c", "t1", 70*time.Minute), // next hour
+				joined("d", "acme/eu", 30*time.Second),
+			}
+			abandoned := joined("e", "t1", time.Minute)
+			abandoned.Abandoned = true
+			abandoned.Response = models.LLMResponse{}
+			events = append(events, abandoned)
+
+			for i, ev := range events {
+				ta.consumer.Add(record(t, ev, int32(i%2), int64(i)))
+			}
+			ta.consumer.Add(&kgo.Record{Topic: kafka.TopicLLMJoined, Partition: 0, Offset: 10, Value: []byte("{")})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- ta.Run(ctx) }()
+			<-ta.consumer.Idle()
+			cancel()
+			<-done
+
+			if committed := ta.consumer.Committed(); len(committed) != 0 {
+				t.Fatalf("committed %d records before archiving", len(committed))
+			}
+			if err := ta.Flush(context.Background()); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+
+			got, entries := readAll(t, ta.bucket)
+			if len(entries) != 3 {
+				t.Errorf("got %d files, want 3", len(entries))
+			}
+			for _, entry := range entries {
+				if !strings.HasPrefix(entry.Path, "events/tenant="+escapedTenant(entry.TenantID)+"/"+hourPartition(entry.Hour)+"/") ||
+					!strings.HasSuffix(entry.Path, "."+string(format)) {
+					t.Errorf("file %s of %s at %s", entry.Path, entry.TenantID, entry.Hour)
+				}
+			}
+			for _, want := range events {
+				ev, ok := got[want.Request.RequestID]
+				if !ok {
+					t.Errorf("event %s not archived", want.Request.RequestID)
+					continue
+				}
+				wantJSON, _ := json.Marshal(&want)
+				gotJSON, _ := json.Marshal(&ev)
+				if string(gotJSON) != string(wantJSON) {
+					t.Errorf("archived %s, want %s", gotJSON, wantJSON)
+				}
+			}
+
+			if letters := ta.dlq.Letters(); len(letters) != 1 {
+				t.Errorf("dead-lettered %d records, want 1", len(letters))
+			}
+			offsets := make(map[int32]int64)
+			for _, r := range ta.consumer.Committed() {
+				offsets[r.Partition] = r.Offset
+			}
+			if want := map[int32]int64{0: 10, 1: 3}; !reflect.DeepEqual(offsets, want) {
+				t.Errorf("committed offsets %v, want %v", offsets, want)
+			}
+		})
+	}
+}
+
+func escapedTenant(tenant string) string {
+	return strings.ReplaceAll(tenant, "/", "%2F")
+}
+
+func TestArchiver_DeadLetterFailure(t *testing.T) {
+	ta := newTestArchiver(t, processortest.NewStore(), FormatJSONLZstd)
+	clk := clock.NewFake(testStart.Add(2 * time.Hour))
+	ta.SetClock(clk)
+	ta.dlq.SetError(errors.New("dlq unavailable"))
+	malformed := &kgo.Record{Topic: kafka.TopicLLMJoined, Partition: 0, Offset: 1, Value: []byte("{")}
+	ta.consumer.Add(
+		record(t, joined("a", "t1", 0), 0, 0),
+		malformed,
+		record(t, joined("b", "t1", time.Second), 0, 2),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- ta.Run(ctx) }()
+	ta.consumer.IdleAdvancing(clk, kafka.RewindBackoff)
+	cancel()
+	<-done
+	if err := ta.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	if rewound := ta.consumer.Rewound(); len(rewound) != 1 || rewound[0] != malformed {
+		t.Errorf("rewound to %v, want the malformed record", rewound)
+	}
+	if committed := ta.consumer.Committed(); len(committed) != 1 || committed[0].Offset != 0 {
+		t.Errorf("committed %v, want offset 0 only", committed)
+	}
+	if got, _ := readAll(t, ta.bucket); len(got) != 1 {
+		t.Errorf("archived %d events, want 1", len(got))
+	}
+}
+
+func TestArchiver_FlushFailure(t *testing.T) {
+	ctx := context.Background()
+	ta := newTestArchiver(t, processortest.NewStore(), FormatParquet)
+	if err := ta.processRecord(record(t, joined("a", "t1", 0), 0, 0)); err != nil {
+		t.Fatalf("processRecord() error = %v", err)
+	}
+
+	// Nothing is committed until the events are archived
+	ta.bucket.setFailing(true)
+	if err := ta.Flush(ctx); err == nil {
+		t.Fatal("Flush() expected error")
+	}
+	if got := ta.bufferedEvents(); got != 1 {
+		t.Errorf("buffered %d events after a failed flush, want 1", got)
+	}
+
+	ta.bucket.setFailing(false)
+	if err := ta.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if got, _ := readAll(t, ta.bucket); len(got) != 1 {
+		t.Errorf("archived %d events, want 1", len(got))
+	}
+}
+
+func TestArchiver_Handoff(t *testing.T) {
+	ctx := context.Background()
+	store := processortest.NewStore()
+	partitions := map[string][]int32{kafka.TopicLLMJoined: {0}}
+
+	first := newTestArchiver(t, store, FormatJSONLZstd)
+	for i, ev := range []models.JoinedEvent{joined("a", "t1", 0), joined("b", "t1", time.Second)} {
+		if err := first.processRecord(record(t, ev, int32(i), int64(i))); err != nil {
+			t.Fatalf("processRecord() error = %v", err)
+		}
+	}
+
+	// The archive is down: events of the revoked partition are handed off,
+	// the others stay buffered
+	first.bucket.setFailing(true)
+	first.consumer.Revoke(ctx, partitions)
+	if got := first.bufferedEvents(); got != 1 {
+		t.Errorf("revoked archiver kept %d events, want 1", got)
+	}
+
+	next := newTestArchiver(t, store, FormatJSONLZstd)
+	next.consumer.Assign(ctx, partitions)
+	if err := next.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if got, _ := readAll(t, next.bucket); len(got) != 1 || got["a"].Request.RequestID != "a" {
+		t.Errorf("next owner archived %v, want [a]", got)
+	}
+
+	// With the archive up, revoking archives the remaining events first
+	first.bucket.setFailing(false)
+	first.consumer.Revoke(ctx, map[string][]int32{kafka.TopicLLMJoined: {1}})
+	if got, _ := readAll(t, first.bucket); len(got) != 1 || got["b"].Request.RequestID != "b" {
+		t.Errorf("revoked archiver archived %v, want [b]", got)
+	}
+}
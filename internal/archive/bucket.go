@@ -0,0 +1,126 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned by Get for a key that does not exist
+var ErrNotFound = errors.New("object not found")
+
+// Bucket stores archive files and manifest entries under slash-separated keys
+type Bucket interface {
+	// Put stores data under key, replacing any object there. A reader never
+	// sees a partly written object.
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	// List returns the keys starting with prefix, in lexical order
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// S3Settings are the endpoint and credentials of S3-compatible storage
+type S3Settings struct {
+	// Endpoint is the base URL of the service, e.g. http://minio:9000. When
+	// empty, the AWS endpoint of Region is used.
+	Endpoint        string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// OpenBucket opens the archive target: s3://bucket/prefix for S3-compatible
+// storage, any other value for a local directory
+func OpenBucket(target string, s3 S3Settings) (Bucket, error) {
+	if rest, ok := strings.CutPrefix(target, "s3://"); ok {
+		name, prefix, _ := strings.Cut(rest, "/")
+		if name == "" {
+			return nil, fmt.Errorf("invalid archive target %q: missing bucket", target)
+		}
+		return NewS3Bucket(s3, name, prefix)
+	}
+	return NewLocalBucket(target)
+}
+
+// localBucket keeps objects as files under a directory
+type localBucket struct {
+	dir string
+}
+
+// NewLocalBucket creates a bucket storing objects as files under dir
+func NewLocalBucket(dir string) (Bucket, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &localBucket{dir: dir}, nil
+}
+
+func (b *localBucket) path(key string) string {
+	return filepath.Join(b.dir, filepath.FromSlash(key))
+}
+
+// Put writes the file under a hidden name and renames it into place
+func (b *localBucket) Put(ctx context.Context, key string, data []byte) error {
+	target := b.path(key)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	dir, name := filepath.Split(target)
+	tmp := filepath.Join(dir, "."+name+".tmp")
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+func (b *localBucket) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(b.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// List walks the directory of prefix, skipping hidden files being written
+func (b *localBucket) List(ctx context.Context, prefix string) ([]string, error) {
+	root := b.path(path.Dir(prefix + "x"))
+	var keys []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(b.dir, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
@@ -0,0 +1,190 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"streamlens/internal/models"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Format is the file format of archived events
+type Format string
+
+const (
+	// FormatParquet writes zstd-compressed Parquet, one row per event
+	FormatParquet Format = "parquet"
+	// FormatJSONLZstd writes zstd-compressed JSON lines of joined events,
+	// as read from llm.joined
+	FormatJSONLZstd Format = "jsonl.zst"
+)
+
+// ParseFormat validates a format name
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatParquet, FormatJSONLZstd:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown archive format %q (want %s or %s)", s, FormatParquet, FormatJSONLZstd)
+}
+
+// eventRow is the Parquet schema of a joined event. Metadata is kept as a
+// JSON object, since its values are of any type.
+type eventRow struct {
+	RequestID          string    `parquet:"request_id"`
+	TenantID           string    `parquet:"tenant_id"`
+	Route              string    `parquet:"route"`
+	Model              string    `parquet:"model"`
+	Provider           string    `parquet:"provider"`
+	RequestTimestamp   time.Time `parquet:"request_timestamp,timestamp(nanosecond)"`
+	PromptTokens       int64     `parquet:"prompt_tokens"`
+	UserIDHash         *string   `parquet:"user_id_hash,optional"`
+	Metadata           string    `parquet:"metadata"`
+	ResponseTimestamp  time.Time `parquet:"response_timestamp,timestamp(nanosecond)"`
+	LatencyMs          int64     `parquet:"latency_ms"`
+	CompletionTokens   int64     `parquet:"completion_tokens"`
+	CachedPromptTokens int64     `parquet:"cached_prompt_tokens"`
+	FinishReason       string    `parquet:"finish_reason"`
+	Error              *string   `parquet:"error,optional"`
+	Abandoned          bool      `parquet:"abandoned"`
+}
+
+func newEventRow(ev *models.JoinedEvent) (eventRow, error) {
+	var metadata string
+	if len(ev.Request.Metadata) > 0 {
+		data, err := json.Marshal(ev.Request.Metadata)
+		if err != nil {
+			return eventRow{}, fmt.Errorf("metadata of %s: %w", ev.Request.RequestID, err)
+		}
+		metadata = string(data)
+	}
+	return eventRow{
+		RequestID:          ev.Request.RequestID,
+		TenantID:           ev.Request.TenantID,
+		Route:              ev.Request.Route,
+		Model:              ev.Request.Model,
+		Provider:           ev.Request.Provider,
+		RequestTimestamp:   ev.Request.Timestamp,
+		PromptTokens:       int64(ev.Request.PromptTokens),
+		UserIDHash:         ev.Request.UserIDHash,
+		Metadata:           metadata,
+		ResponseTimestamp:  ev.Response.Timestamp,
+		LatencyMs:          int64(ev.Response.LatencyMs),
+		CompletionTokens:   int64(ev.Response.CompletionTokens),
+		CachedPromptTokens: int64(ev.Response.CachedPromptTokens),
+		FinishReason:       ev.Response.FinishReason,
+		Error:              ev.Response.Error,
+		Abandoned:          ev.Abandoned,
+	}, nil
+}
+
+func (r *eventRow) event() (models.JoinedEvent, error) {
+	ev := models.JoinedEvent{
+		Request: models.LLMRequest{
+			RequestID:    r.RequestID,
+			TenantID:     r.TenantID,
+			Route:        r.Route,
+			Model:        r.Model,
+			Provider:     r.Provider,
+			Timestamp:    r.RequestTimestamp.UTC(),
+			PromptTokens: int(r.PromptTokens),
+			UserIDHash:   r.UserIDHash,
+		},
+		Abandoned: r.Abandoned,
+	}
+	if r.Metadata != "" {
+		if err := json.Unmarshal([]byte(r.Metadata), &ev.Request.Metadata); err != nil {
+			return ev, fmt.Errorf("metadata of %s: %w", r.RequestID, err)
+		}
+	}
+	if !r.Abandoned {
+		ev.Response = models.LLMResponse{
+			RequestID:          r.RequestID,
+			Timestamp:          r.ResponseTimestamp.UTC(),
+			LatencyMs:          int(r.LatencyMs),
+			CompletionTokens:   int(r.CompletionTokens),
+			CachedPromptTokens: int(r.CachedPromptTokens),
+			FinishReason:       r.FinishReason,
+			Error:              r.Error,
+		}
+	}
+	return ev, nil
+}
+
+// encode writes events in format f
+func (f Format) encode(events []models.JoinedEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	switch f {
+	case FormatParquet:
+		rows := make([]eventRow, len(events))
+		for i := range events {
+			row, err := newEventRow(&events[i])
+			if err != nil {
+				return nil, err
+			}
+			rows[i] = row
+		}
+		if err := parquet.Write(&buf, rows, parquet.Compression(&parquet.Zstd)); err != nil {
+			return nil, err
+		}
+	case FormatJSONLZstd:
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		enc := json.NewEncoder(zw)
+		for i := range events {
+			if err := enc.Encode(&events[i]); err != nil {
+				zw.Close()
+				return nil, err
+			}
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown archive format %q", f)
+	}
+	return buf.Bytes(), nil
+}
+
+// decode reads events written in format f
+func (f Format) decode(data []byte) ([]models.JoinedEvent, error) {
+	switch f {
+	case FormatParquet:
+		rows, err := parquet.Read[eventRow](bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		events := make([]models.JoinedEvent, len(rows))
+		for i := range rows {
+			if events[i], err = rows[i].event(); err != nil {
+				return nil, err
+			}
+		}
+		return events, nil
+	case FormatJSONLZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+
+		var events []models.JoinedEvent
+		scanner := bufio.NewScanner(zr)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var ev models.JoinedEvent
+			if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+				return nil, err
+			}
+			events = append(events, ev)
+		}
+		return events, scanner.Err()
+	}
+	return nil, fmt.Errorf("unknown archive format %q", f)
+}
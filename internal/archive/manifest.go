@@ -0,0 +1,92 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"streamlens/internal/models"
+	"strings"
+	"time"
+)
+
+// Layout of the archive. Event files are partitioned by tenant and by the
+// UTC hour of their requests; every file has a manifest entry of its own in
+// the partition of its hour, written once the file is complete, so that
+// concurrent archivers never update a shared object:
+//
+//	events/tenant=<tenant>/date=YYYY-MM-DD/hour=HH/<id>.parquet
+//	manifest/date=YYYY-MM-DD/hour=HH/<id>.json
+const (
+	eventsPrefix   = "events/"
+	manifestPrefix = "manifest/"
+)
+
+// ManifestEntry describes one archived file
+type ManifestEntry struct {
+	Path     string    `json:"path"`
+	Format   Format    `json:"format"`
+	TenantID string    `json:"tenant_id"`
+	Hour     time.Time `json:"hour"`
+	Events   int       `json:"events"`
+	Bytes    int       `json:"bytes"`
+	// Request timestamps of the first and last event of the file
+	MinTimestamp time.Time `json:"min_timestamp"`
+	MaxTimestamp time.Time `json:"max_timestamp"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// hourPartition is the date=/hour= path of the hour starting at hour
+func hourPartition(hour time.Time) string {
+	return fmt.Sprintf("date=%s/hour=%02d", hour.Format("2006-01-02"), hour.Hour())
+}
+
+// eventsKey is the key of an event file
+func eventsKey(tenantID string, hour time.Time, id string, format Format) string {
+	return fmt.Sprintf("%stenant=%s/%s/%s.%s", eventsPrefix, url.PathEscape(tenantID), hourPartition(hour), id, format)
+}
+
+// manifestKey is the key of the manifest entry of an event file
+func manifestKey(hour time.Time, id string) string {
+	return fmt.Sprintf("%s%s/%s.json", manifestPrefix, hourPartition(hour), id)
+}
+
+// ReadManifest returns the entries of the files holding events of the hours
+// overlapping [from, to), ordered by hour
+func ReadManifest(ctx context.Context, bucket Bucket, from, to time.Time) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+	for hour := from.UTC().Truncate(time.Hour); hour.Before(to); hour = hour.Add(time.Hour) {
+		keys, err := bucket.List(ctx, manifestPrefix+hourPartition(hour)+"/")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list manifest of %s: %w", hour.Format(time.RFC3339), err)
+		}
+		for _, key := range keys {
+			if !strings.HasSuffix(key, ".json") {
+				continue
+			}
+			data, err := bucket.Get(ctx, key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read manifest entry %s: %w", key, err)
+			}
+			var entry ManifestEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return nil, fmt.Errorf("invalid manifest entry %s: %w", key, err)
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// ReadEvents returns the events of an archived file
+func ReadEvents(ctx context.Context, bucket Bucket, entry ManifestEntry) ([]models.JoinedEvent, error) {
+	data, err := bucket.Get(ctx, entry.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", entry.Path, err)
+	}
+	events, err := entry.Format.decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", entry.Path, err)
+	}
+	return events, nil
+}
@@ -0,0 +1,38 @@
+package archive
+
+import (
+	"streamlens/internal/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	archivedEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "archive",
+		Name:      "events_total",
+		Help:      "Joined events written to the archive.",
+	})
+
+	archivedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "archive",
+		Name:      "bytes_total",
+		Help:      "Bytes of archive files written, manifest entries excluded.",
+	})
+
+	archiveFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "archive",
+		Name:      "write_failures_total",
+		Help:      "Archive files that failed to be written and stayed buffered.",
+	})
+
+	bufferedEvents = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "archive",
+		Name:      "buffered_events",
+		Help:      "Joined events read but not archived yet.",
+	})
+)
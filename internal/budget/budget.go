@@ -0,0 +1,191 @@
+// Package budget accumulates tenant spend against budgets and notifies
+// threshold crossings.
+package budget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/store"
+	"sync"
+	"time"
+)
+
+// Store keeps budgets and their spend; see store.MetricsStore
+type Store interface {
+	ListBudgets(ctx context.Context, tenantID *string) ([]models.Budget, error)
+	AddBudgetSpend(ctx context.Context, budgetID int64, periodStart time.Time, flushID string, amountUSD float64) (float64, int, error)
+	SetBudgetNotified(ctx context.Context, budgetID int64, periodStart time.Time, from, to int) (bool, error)
+	PruneBudgetFlushes(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Producer publishes budget events; see kafka.Producer
+type Producer interface {
+	ProduceJSON(ctx context.Context, topic, key string, value interface{}) error
+}
+
+// Tracker adds the cost of flushed windows to every matching budget and
+// produces a budget event to llm.budgets the first time a period's spend
+// crosses each threshold
+type Tracker struct {
+	store    Store
+	producer Producer
+	interval time.Duration
+
+	budgets map[string][]models.Budget // by tenant
+	mu      sync.RWMutex
+}
+
+// NewTracker creates a Tracker that re-reads budgets every interval
+func NewTracker(store Store, producer Producer, interval time.Duration) *Tracker {
+	return &Tracker{
+		store:    store,
+		producer: producer,
+		interval: interval,
+		budgets:  make(map[string][]models.Budget),
+	}
+}
+
+// Reload re-reads every budget from Postgres
+func (t *Tracker) Reload(ctx context.Context) error {
+	all, err := t.store.ListBudgets(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to list budgets: %w", err)
+	}
+
+	budgets := make(map[string][]models.Budget)
+	for _, b := range all {
+		budgets[b.TenantID] = append(budgets[b.TenantID], b)
+	}
+
+	t.mu.Lock()
+	t.budgets = budgets
+	t.mu.Unlock()
+	return nil
+}
+
+// FlushRetention is how long the flushes added to budgets are remembered,
+// so that a flush retried within it is not counted twice
+const FlushRetention = 7 * 24 * time.Hour
+
+// Run periodically reloads budgets, and forgets flushes past FlushRetention,
+// until ctx is cancelled
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.Reload(ctx); err != nil {
+				log.Printf("Failed to reload budgets, keeping previous budgets: %v", err)
+			}
+			if _, err := t.store.PruneBudgetFlushes(ctx, time.Now().UTC().Add(-FlushRetention)); err != nil {
+				log.Printf("Failed to prune budget flushes: %v", err)
+			}
+		}
+	}
+}
+
+// Record adds the cost of a window to the budgets it counts against. Spend is
+// attributed to the period containing the window's start. flushID identifies
+// the flush of the window: recording it again after a failure adds it only to
+// the budgets that missed it.
+func (t *Tracker) Record(ctx context.Context, flushID string, m *models.LLMMetrics) error {
+	if m.EstimatedCostUSD <= 0 {
+		return nil
+	}
+
+	t.mu.RLock()
+	var budgets []models.Budget
+	for _, b := range t.budgets[m.TenantID] {
+		if b.Matches(m) {
+			budgets = append(budgets, b)
+		}
+	}
+	t.mu.RUnlock()
+
+	var errs []error
+	for i := range budgets {
+		if err := t.record(ctx, &budgets[i], flushID, m); err != nil {
+			errs = append(errs, fmt.Errorf("budget %d: %w", budgets[i].ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// record adds spend to one budget and notifies the newly crossed thresholds,
+// lowest first
+func (t *Tracker) record(ctx context.Context, b *models.Budget, flushID string, m *models.LLMMetrics) error {
+	periodStart := b.PeriodStart(m.WindowStart)
+	spend, notified, err := t.store.AddBudgetSpend(ctx, b.ID, periodStart, flushID, m.EstimatedCostUSD)
+	if errors.Is(err, store.ErrBudgetNotFound) {
+		// Deleted since the last reload
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	crossed := b.CrossedThreshold(spend)
+	for _, pct := range models.BudgetThresholds {
+		if pct <= notified || pct > crossed {
+			continue
+		}
+
+		// Claim the threshold first so that concurrent windows notify it once
+		claimed, err := t.store.SetBudgetNotified(ctx, b.ID, periodStart, notified, pct)
+		if err != nil || !claimed {
+			return err
+		}
+
+		event := models.BudgetEvent{
+			BudgetID:         b.ID,
+			TenantID:         b.TenantID,
+			Route:            b.Route,
+			Period:           b.Period,
+			PeriodStart:      periodStart,
+			ThresholdPercent: pct,
+			AmountUSD:        b.AmountUSD,
+			SpendUSD:         spend,
+			Timestamp:        time.Now().UTC(),
+		}
+		if err := t.producer.ProduceJSON(ctx, kafka.TopicLLMBudgets, b.TenantID, event); err != nil {
+			// Release the claim so that the next window retries the notification
+			if _, releaseErr := t.store.SetBudgetNotified(ctx, b.ID, periodStart, pct, notified); releaseErr != nil {
+				log.Printf("Failed to release budget %d threshold %d%%: %v", b.ID, pct, releaseErr)
+			}
+			return fmt.Errorf("failed to produce budget event: %w", err)
+		}
+
+		log.Printf("Budget %d of %s crossed %d%% (%.2f of %.2f USD)", b.ID, b.TenantID, pct, spend, b.AmountUSD)
+		notified = pct
+	}
+	return nil
+}
+
+// Status returns the spend of a budget in the period containing now, with
+// the spend so far extrapolated linearly to the end of the period
+func Status(b *models.Budget, spendUSD float64, notifiedPercent int, now time.Time) models.BudgetStatus {
+	start := b.PeriodStart(now)
+	end := b.PeriodEnd(start)
+
+	status := models.BudgetStatus{
+		Budget:          *b,
+		PeriodStart:     start,
+		PeriodEnd:       end,
+		SpendUSD:        spendUSD,
+		RemainingUSD:    b.AmountUSD - spendUSD,
+		ProjectedUSD:    spendUSD,
+		NotifiedPercent: notifiedPercent,
+	}
+	if elapsed := now.Sub(start); elapsed > 0 {
+		status.ProjectedUSD = spendUSD * float64(end.Sub(start)) / float64(elapsed)
+	}
+	return status
+}
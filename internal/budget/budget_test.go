@@ -0,0 +1,135 @@
+package budget
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/processor/processortest"
+	"testing"
+	"time"
+)
+
+func TestPeriod(t *testing.T) {
+	ts := time.Date(2025, 11, 20, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		period    models.BudgetPeriod
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{models.BudgetDaily, time.Date(2025, 11, 20, 0, 0, 0, 0, time.UTC), time.Date(2025, 11, 21, 0, 0, 0, 0, time.UTC)},
+		{models.BudgetMonthly, time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.period), func(t *testing.T) {
+			b := models.Budget{Period: tt.period}
+			start := b.PeriodStart(ts)
+			if !start.Equal(tt.wantStart) {
+				t.Errorf("PeriodStart = %v, want %v", start, tt.wantStart)
+			}
+			if end := b.PeriodEnd(start); !end.Equal(tt.wantEnd) {
+				t.Errorf("PeriodEnd = %v, want %v", end, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestCrossedThreshold(t *testing.T) {
+	b := models.Budget{AmountUSD: 200}
+	tests := []struct {
+		spend float64
+		want  int
+	}{
+		{0, 0},
+		{99.99, 0},
+		{100, 50},
+		{170, 80},
+		{200, 100},
+		{450, 100},
+	}
+	for _, tt := range tests {
+		if got := b.CrossedThreshold(tt.spend); got != tt.want {
+			t.Errorf("CrossedThreshold(%v) = %d, want %d", tt.spend, got, tt.want)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	b := &models.Budget{ID: 1, TenantID: "tenant-1", Period: models.BudgetMonthly, AmountUSD: 3000}
+	// A third of November gone
+	now := time.Date(2025, 11, 11, 0, 0, 0, 0, time.UTC)
+
+	s := Status(b, 1200, 50, now)
+	if s.RemainingUSD != 1800 {
+		t.Errorf("RemainingUSD = %v, want 1800", s.RemainingUSD)
+	}
+	if math.Abs(s.ProjectedUSD-3600) > 1e-9 {
+		t.Errorf("ProjectedUSD = %v, want 3600", s.ProjectedUSD)
+	}
+	if s.NotifiedPercent != 50 || !s.PeriodEnd.Equal(time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Status = %+v", s)
+	}
+}
+
+// memStore keeps the spend and notified threshold of one budget period
+type memStore struct {
+	budgets  []models.Budget
+	spend    float64
+	notified int
+}
+
+func (s *memStore) ListBudgets(ctx context.Context, tenantID *string) ([]models.Budget, error) {
+	return s.budgets, nil
+}
+
+func (s *memStore) AddBudgetSpend(ctx context.Context, budgetID int64, periodStart time.Time, flushID string, amountUSD float64) (float64, int, error) {
+	s.spend += amountUSD
+	return s.spend, s.notified, nil
+}
+
+func (s *memStore) SetBudgetNotified(ctx context.Context, budgetID int64, periodStart time.Time, from, to int) (bool, error) {
+	if s.notified != from {
+		return false, nil
+	}
+	s.notified = to
+	return true, nil
+}
+
+func (s *memStore) PruneBudgetFlushes(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestTracker_ThresholdJump(t *testing.T) {
+	store := &memStore{budgets: []models.Budget{{ID: 1, TenantID: "tenant-1", Period: models.BudgetDaily, AmountUSD: 100}}}
+	producer := &processortest.Producer{}
+	tracker := NewTracker(store, producer, time.Minute)
+	if err := tracker.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ws := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+	for i, cost := range []float64{40, 70} {
+		m := &models.LLMMetrics{TenantID: "tenant-1", Route: "chat", WindowStart: ws, EstimatedCostUSD: cost}
+		if err := tracker.Record(context.Background(), string(rune('a'+i)), m); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	// A window taking spend from 40% to 110% notifies every threshold it crossed
+	var got []int
+	for _, msg := range producer.Messages(kafka.TopicLLMBudgets) {
+		var event models.BudgetEvent
+		if err := json.Unmarshal(msg.Value, &event); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, event.ThresholdPercent)
+	}
+	if len(got) != 3 || got[0] != 50 || got[1] != 80 || got[2] != 100 {
+		t.Errorf("notified thresholds %v, want [50 80 100]", got)
+	}
+	if store.notified != 100 {
+		t.Errorf("notified percent = %d, want 100", store.notified)
+	}
+}
@@ -0,0 +1,103 @@
+// Package clock abstracts the passage of time so that time-driven logic can
+// be tested deterministically.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and schedules periodic work
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks on C until stopped
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the system clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// Fake is a clock that only moves when advanced. Like time.Ticker, its
+// tickers drop ticks a reader is not ready for.
+type Fake struct {
+	now     time.Time
+	tickers []*fakeTicker
+	mu      sync.Mutex
+}
+
+// NewFake creates a fake clock set to now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the fake time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the clock forward by d, firing every ticker that became due
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+	for _, t := range f.tickers {
+		if t.stopped || t.next.After(f.now) {
+			continue
+		}
+		for !t.next.After(f.now) {
+			t.next = t.next.Add(t.period)
+		}
+		select {
+		case t.c <- f.now:
+		default:
+		}
+	}
+}
+
+// NewTicker creates a ticker firing every d of fake time
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := &fakeTicker{clock: f, c: make(chan time.Time, 1), period: d, next: f.now.Add(d)}
+	f.tickers = append(f.tickers, t)
+	return t
+}
+
+type fakeTicker struct {
+	clock   *Fake
+	c       chan time.Time
+	period  time.Duration
+	next    time.Time
+	stopped bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	t.stopped = true
+	t.clock.mu.Unlock()
+}
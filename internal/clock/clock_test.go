@@ -0,0 +1,45 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	ticker := c.NewTicker(time.Minute)
+
+	ticked := func() bool {
+		select {
+		case <-ticker.C():
+			return true
+		default:
+			return false
+		}
+	}
+
+	c.Advance(30 * time.Second)
+	if ticked() {
+		t.Error("ticker fired before its period")
+	}
+	c.Advance(30 * time.Second)
+	if !ticked() {
+		t.Error("ticker did not fire after its period")
+	}
+	if got := c.Now(); !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("Now() = %s, want %s", got, start.Add(time.Minute))
+	}
+
+	// Ticks that are not read are dropped
+	c.Advance(3 * time.Minute)
+	if !ticked() || ticked() {
+		t.Error("ticker did not deliver exactly one tick")
+	}
+
+	ticker.Stop()
+	c.Advance(time.Minute)
+	if ticked() {
+		t.Error("stopped ticker fired")
+	}
+}
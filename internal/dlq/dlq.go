@@ -0,0 +1,144 @@
+package dlq
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/store"
+	"streamlens/internal/telemetry"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+var deadLettered = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: telemetry.Namespace,
+	Subsystem: "dlq",
+	Name:      "records_total",
+	Help:      "Records produced to llm.dlq, by consumer group and source topic.",
+}, []string{"group", "topic"})
+
+// Headers added to every record produced to llm.dlq
+const (
+	HeaderError           = "dlq.error"
+	HeaderSourceTopic     = "dlq.source.topic"
+	HeaderSourcePartition = "dlq.source.partition"
+	HeaderSourceOffset    = "dlq.source.offset"
+	HeaderConsumerGroup   = "dlq.consumer.group"
+	HeaderFailedAt        = "dlq.failed_at"
+)
+
+// headerPrefix marks headers added by the dead-letter queue; they are stripped
+// when a record is re-driven
+const headerPrefix = "dlq."
+
+// Publisher sends records that could not be processed to llm.dlq and indexes
+// them in Postgres so they can be listed and re-driven
+type Publisher struct {
+	producer *kafka.Producer
+	store    *store.MetricsStore
+	group    string
+}
+
+// NewPublisher creates a Publisher for records consumed by the given group
+func NewPublisher(producer *kafka.Producer, store *store.MetricsStore, group string) *Publisher {
+	return &Publisher{
+		producer: producer,
+		store:    store,
+		group:    group,
+	}
+}
+
+// Publish dead-letters record with cause as the error reason. The record may be
+// committed once Publish returns nil; the Postgres index is best effort since
+// llm.dlq itself holds every dead-lettered record.
+func (p *Publisher) Publish(ctx context.Context, record *kgo.Record, cause error) error {
+	failedAt := time.Now().UTC()
+
+	headers := deadLetterHeaders(record, cause, p.group, failedAt)
+
+	if err := p.producer.ProduceRecord(ctx, &kgo.Record{
+		Topic:   kafka.TopicLLMDLQ,
+		Key:     record.Key,
+		Value:   record.Value,
+		Headers: headers,
+	}); err != nil {
+		return fmt.Errorf("failed to produce to %s: %w", kafka.TopicLLMDLQ, err)
+	}
+
+	entry := &models.DLQEntry{
+		ConsumerGroup:   p.group,
+		SourceTopic:     record.Topic,
+		SourcePartition: record.Partition,
+		SourceOffset:    record.Offset,
+		Key:             string(record.Key),
+		Value:           string(record.Value),
+		Headers:         headerMap(record.Headers),
+		ErrorReason:     cause.Error(),
+		FailedAt:        failedAt,
+	}
+	if err := p.store.InsertDLQEntry(ctx, entry); err != nil {
+		log.Printf("Failed to index dead-lettered record %s[%d]@%d: %v", record.Topic, record.Partition, record.Offset, err)
+	}
+
+	deadLettered.WithLabelValues(p.group, record.Topic).Inc()
+	log.Printf("Dead-lettered record %s[%d]@%d: %v", record.Topic, record.Partition, record.Offset, cause)
+	return nil
+}
+
+// Redrive produces an entry back to its source topic with its original key,
+// value and headers, and marks it as re-driven
+func Redrive(ctx context.Context, producer *kafka.Producer, store *store.MetricsStore, entry *models.DLQEntry) error {
+	record := &kgo.Record{
+		Topic: entry.SourceTopic,
+		Key:   []byte(entry.Key),
+		Value: []byte(entry.Value),
+	}
+	for k, v := range entry.Headers {
+		if !strings.HasPrefix(k, headerPrefix) {
+			record.Headers = append(record.Headers, kgo.RecordHeader{Key: k, Value: []byte(v)})
+		}
+	}
+
+	if err := producer.ProduceRecord(ctx, record); err != nil {
+		return fmt.Errorf("failed to produce to %s: %w", entry.SourceTopic, err)
+	}
+	return store.MarkDLQRedriven(ctx, entry.ID, time.Now().UTC())
+}
+
+// deadLetterHeaders returns the record's own headers, minus any left over from
+// an earlier dead-lettering, followed by the DLQ metadata headers
+func deadLetterHeaders(record *kgo.Record, cause error, group string, failedAt time.Time) []kgo.RecordHeader {
+	headers := make([]kgo.RecordHeader, 0, len(record.Headers)+6)
+	for _, h := range record.Headers {
+		if !strings.HasPrefix(h.Key, headerPrefix) {
+			headers = append(headers, h)
+		}
+	}
+	return append(headers,
+		kgo.RecordHeader{Key: HeaderError, Value: []byte(cause.Error())},
+		kgo.RecordHeader{Key: HeaderSourceTopic, Value: []byte(record.Topic)},
+		kgo.RecordHeader{Key: HeaderSourcePartition, Value: []byte(strconv.Itoa(int(record.Partition)))},
+		kgo.RecordHeader{Key: HeaderSourceOffset, Value: []byte(strconv.FormatInt(record.Offset, 10))},
+		kgo.RecordHeader{Key: HeaderConsumerGroup, Value: []byte(group)},
+		kgo.RecordHeader{Key: HeaderFailedAt, Value: []byte(failedAt.Format(time.RFC3339Nano))},
+	)
+}
+
+// headerMap flattens record headers; later values win for repeated keys
+func headerMap(headers []kgo.RecordHeader) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(headers))
+	for _, h := range headers {
+		m[h.Key] = string(h.Value)
+	}
+	return m
+}
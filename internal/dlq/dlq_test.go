@@ -0,0 +1,42 @@
+package dlq
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestDeadLetterHeaders(t *testing.T) {
+	failedAt := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+	record := &kgo.Record{
+		Topic:     "llm.requests",
+		Partition: 3,
+		Offset:    42,
+		Headers: []kgo.RecordHeader{
+			{Key: "trace_id", Value: []byte("abc")},
+			{Key: HeaderError, Value: []byte("stale error from an earlier failure")},
+		},
+	}
+
+	got := headerMap(deadLetterHeaders(record, errors.New("failed to unmarshal request"), "metrics-processor-group", failedAt))
+
+	want := map[string]string{
+		"trace_id":            "abc",
+		HeaderError:           "failed to unmarshal request",
+		HeaderSourceTopic:     "llm.requests",
+		HeaderSourcePartition: "3",
+		HeaderSourceOffset:    "42",
+		HeaderConsumerGroup:   "metrics-processor-group",
+		HeaderFailedAt:        "2025-11-20T10:00:00Z",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d headers, want %d: %v", len(got), len(want), got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("header %s = %q, want %q", k, got[k], v)
+		}
+	}
+}
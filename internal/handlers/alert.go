@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"streamlens/internal/models"
+	"streamlens/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// AlertHandler manages alert rules and lists alerts
+type AlertHandler struct {
+	store *store.MetricsStore
+}
+
+// NewAlertHandler creates a new AlertHandler
+func NewAlertHandler(store *store.MetricsStore) *AlertHandler {
+	return &AlertHandler{store: store}
+}
+
+// HandleListRules handles GET /v1/alerts/rules
+func (h *AlertHandler) HandleListRules(w http.ResponseWriter, r *http.Request) {
+	tenantID := r.URL.Query().Get("tenant_id")
+	if tenantID == "" {
+		http.Error(w, "tenant_id is required", http.StatusBadRequest)
+		return
+	}
+
+	rules, err := h.store.ListAlertRules(r.Context(), &tenantID)
+	if err != nil {
+		log.Printf("Failed to list alert rules: %v", err)
+		http.Error(w, "Failed to fetch alert rules", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"rules": rules,
+		"count": len(rules),
+	})
+}
+
+// HandleCreateRule handles POST /v1/alerts/rules
+func (h *AlertHandler) HandleCreateRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := decodeRule(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.CreateAlertRule(r.Context(), rule); err != nil {
+		log.Printf("Failed to create alert rule: %v", err)
+		http.Error(w, "Failed to create alert rule", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+// HandleGetRule handles GET /v1/alerts/rules/{id}
+func (h *AlertHandler) HandleGetRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := ruleID(w, r)
+	if !ok {
+		return
+	}
+
+	rule, err := h.store.GetAlertRule(r.Context(), id)
+	if errors.Is(err, store.ErrAlertRuleNotFound) {
+		http.Error(w, "Alert rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get alert rule %d: %v", id, err)
+		http.Error(w, "Failed to fetch alert rule", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, rule)
+}
+
+// HandleUpdateRule handles PUT /v1/alerts/rules/{id}
+func (h *AlertHandler) HandleUpdateRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := ruleID(w, r)
+	if !ok {
+		return
+	}
+	rule, ok := decodeRule(w, r)
+	if !ok {
+		return
+	}
+	rule.ID = id
+
+	err := h.store.UpdateAlertRule(r.Context(), rule)
+	if errors.Is(err, store.ErrAlertRuleNotFound) {
+		http.Error(w, "Alert rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update alert rule %d: %v", id, err)
+		http.Error(w, "Failed to update alert rule", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, rule)
+}
+
+// HandleDeleteRule handles DELETE /v1/alerts/rules/{id}
+func (h *AlertHandler) HandleDeleteRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := ruleID(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.store.DeleteAlertRule(r.Context(), id)
+	if errors.Is(err, store.ErrAlertRuleNotFound) {
+		http.Error(w, "Alert rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete alert rule %d: %v", id, err)
+		http.Error(w, "Failed to delete alert rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListAlerts handles GET /v1/alerts
+func (h *AlertHandler) HandleListAlerts(w http.ResponseWriter, r *http.Request) {
+	tenantID := r.URL.Query().Get("tenant_id")
+	if tenantID == "" {
+		http.Error(w, "tenant_id is required", http.StatusBadRequest)
+		return
+	}
+
+	var status *models.AlertStatus
+	if s := models.AlertStatus(r.URL.Query().Get("status")); s != "" {
+		switch s {
+		case models.AlertPending, models.AlertFiring, models.AlertResolved:
+			status = &s
+		default:
+			http.Error(w, "Invalid status parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	alerts, err := h.store.ListAlerts(r.Context(), tenantID, status)
+	if err != nil {
+		log.Printf("Failed to list alerts: %v", err)
+		http.Error(w, "Failed to fetch alerts", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"alerts": alerts,
+		"count":  len(alerts),
+	})
+}
+
+// decodeRule reads and validates a rule from the request body. Rules are
+// enabled unless the body says otherwise.
+func decodeRule(w http.ResponseWriter, r *http.Request) (*models.AlertRule, bool) {
+	rule := &models.AlertRule{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(rule); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := rule.Validate(); err != nil {
+		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return rule, true
+}
+
+// ruleID parses the {id} URL parameter
+func ruleID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"streamlens/internal/store"
+)
+
+// AnomalyHandler serves detected anomalies
+type AnomalyHandler struct {
+	store *store.MetricsStore
+}
+
+// NewAnomalyHandler creates a new AnomalyHandler
+func NewAnomalyHandler(store *store.MetricsStore) *AnomalyHandler {
+	return &AnomalyHandler{store: store}
+}
+
+// HandleGetAnomalies handles GET /v1/anomalies
+func (h *AnomalyHandler) HandleGetAnomalies(w http.ResponseWriter, r *http.Request) {
+	query := store.AnomalyQuery{TenantID: r.URL.Query().Get("tenant_id"), Limit: 100}
+	if query.TenantID == "" {
+		http.Error(w, "tenant_id is required", http.StatusBadRequest)
+		return
+	}
+
+	if route := r.URL.Query().Get("route"); route != "" {
+		query.Route = &route
+	}
+	if model := r.URL.Query().Get("model"); model != "" {
+		query.Model = &model
+	}
+	if metric := r.URL.Query().Get("metric"); metric != "" {
+		query.Metric = &metric
+	}
+
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		query.Limit = limit
+	}
+
+	from, to, err := parseTimeRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.From = from
+	query.To = to
+
+	anomalies, err := h.store.QueryAnomalies(r.Context(), query)
+	if err != nil {
+		log.Printf("Failed to query anomalies: %v", err)
+		http.Error(w, "Failed to fetch anomalies", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"anomalies": anomalies,
+		"count":     len(anomalies),
+	}); err != nil {
+		log.Printf("Failed to encode anomalies response: %v", err)
+	}
+}
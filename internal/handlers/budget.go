@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"streamlens/internal/budget"
+	"streamlens/internal/models"
+	"streamlens/internal/store"
+	"time"
+)
+
+// BudgetHandler manages spend budgets and reports their current spend
+type BudgetHandler struct {
+	store *store.MetricsStore
+}
+
+// NewBudgetHandler creates a new BudgetHandler
+func NewBudgetHandler(store *store.MetricsStore) *BudgetHandler {
+	return &BudgetHandler{store: store}
+}
+
+// HandleList handles GET /v1/budgets, returning each budget of a tenant with
+// its current spend, remaining budget and projected end-of-period spend
+func (h *BudgetHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	tenantID := r.URL.Query().Get("tenant_id")
+	if tenantID == "" {
+		http.Error(w, "tenant_id is required", http.StatusBadRequest)
+		return
+	}
+
+	budgets, err := h.store.ListBudgets(r.Context(), &tenantID)
+	if err != nil {
+		log.Printf("Failed to list budgets: %v", err)
+		http.Error(w, "Failed to fetch budgets", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	statuses := make([]models.BudgetStatus, 0, len(budgets))
+	for i := range budgets {
+		b := &budgets[i]
+		spend, notified, err := h.store.GetBudgetSpend(r.Context(), b.ID, b.PeriodStart(now))
+		if err != nil {
+			log.Printf("Failed to get spend of budget %d: %v", b.ID, err)
+			http.Error(w, "Failed to fetch budgets", http.StatusInternalServerError)
+			return
+		}
+		statuses = append(statuses, budget.Status(b, spend, notified, now))
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"budgets": statuses,
+		"count":   len(statuses),
+	})
+}
+
+// HandleCreate handles POST /v1/budgets
+func (h *BudgetHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	b, ok := decodeBudget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.CreateBudget(r.Context(), b); err != nil {
+		log.Printf("Failed to create budget: %v", err)
+		http.Error(w, "Failed to create budget", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, b)
+}
+
+// HandleUpdate handles PUT /v1/budgets/{id}
+func (h *BudgetHandler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := ruleID(w, r)
+	if !ok {
+		return
+	}
+	b, ok := decodeBudget(w, r)
+	if !ok {
+		return
+	}
+	b.ID = id
+
+	err := h.store.UpdateBudget(r.Context(), b)
+	if errors.Is(err, store.ErrBudgetNotFound) {
+		http.Error(w, "Budget not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update budget %d: %v", id, err)
+		http.Error(w, "Failed to update budget", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, b)
+}
+
+// HandleDelete handles DELETE /v1/budgets/{id}
+func (h *BudgetHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	id, ok := ruleID(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.store.DeleteBudget(r.Context(), id)
+	if errors.Is(err, store.ErrBudgetNotFound) {
+		http.Error(w, "Budget not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete budget %d: %v", id, err)
+		http.Error(w, "Failed to delete budget", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeBudget reads and validates a budget from the request body
+func decodeBudget(w http.ResponseWriter, r *http.Request) (*models.Budget, bool) {
+	b := &models.Budget{}
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := b.Validate(); err != nil {
+		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return b, true
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"streamlens/internal/models"
+	"streamlens/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// DimensionHandler manages the metadata keys tenants promote to dimensions
+type DimensionHandler struct {
+	store            *store.MetricsStore
+	defaultMaxValues int
+}
+
+// NewDimensionHandler creates a new DimensionHandler. Keys promoted without
+// max_values track up to defaultMaxValues distinct values.
+func NewDimensionHandler(store *store.MetricsStore, defaultMaxValues int) *DimensionHandler {
+	return &DimensionHandler{store: store, defaultMaxValues: defaultMaxValues}
+}
+
+// HandleList handles GET /v1/dimensions, optionally for one tenant_id
+func (h *DimensionHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	var tenantID *string
+	if t := r.URL.Query().Get("tenant_id"); t != "" {
+		tenantID = &t
+	}
+
+	dims, err := h.store.ListTenantDimensions(r.Context(), tenantID)
+	if err != nil {
+		log.Printf("Failed to list dimensions: %v", err)
+		http.Error(w, "Failed to fetch dimensions", http.StatusInternalServerError)
+		return
+	}
+	if dims == nil {
+		dims = []models.TenantDimension{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"dimensions": dims,
+		"count":      len(dims),
+	})
+}
+
+// HandleReplace handles PUT /v1/dimensions/{tenant_id}, replacing the
+// tenant's promoted keys. Values already admitted for keys that stay
+// promoted are kept.
+func (h *DimensionHandler) HandleReplace(w http.ResponseWriter, r *http.Request) {
+	tenantID := chi.URLParam(r, "tenant_id")
+
+	var body struct {
+		Dimensions []models.TenantDimension `json:"dimensions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := models.ValidateTenantDimensions(body.Dimensions, h.defaultMaxValues); err != nil {
+		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.ReplaceTenantDimensions(r.Context(), tenantID, body.Dimensions); err != nil {
+		log.Printf("Failed to replace dimensions of tenant %s: %v", tenantID, err)
+		http.Error(w, "Failed to update dimensions", http.StatusInternalServerError)
+		return
+	}
+	if body.Dimensions == nil {
+		body.Dimensions = []models.TenantDimension{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"dimensions": body.Dimensions,
+		"count":      len(body.Dimensions),
+	})
+}
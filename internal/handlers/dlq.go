@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"streamlens/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// DLQHandler serves dead-lettered records for inspection
+type DLQHandler struct {
+	store *store.MetricsStore
+}
+
+// NewDLQHandler creates a new DLQHandler
+func NewDLQHandler(store *store.MetricsStore) *DLQHandler {
+	return &DLQHandler{store: store}
+}
+
+// HandleListDLQ handles GET /v1/dlq
+func (h *DLQHandler) HandleListDLQ(w http.ResponseWriter, r *http.Request) {
+	query := store.DLQQuery{Limit: 100}
+
+	if topic := r.URL.Query().Get("source_topic"); topic != "" {
+		query.SourceTopic = &topic
+	}
+
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		query.Limit = limit
+	}
+
+	if redrivenStr := r.URL.Query().Get("include_redriven"); redrivenStr != "" {
+		includeRedriven, err := strconv.ParseBool(redrivenStr)
+		if err != nil {
+			http.Error(w, "Invalid include_redriven parameter", http.StatusBadRequest)
+			return
+		}
+		query.IncludeRedriven = includeRedriven
+	}
+
+	from, to, err := parseTimeRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.From = from
+	query.To = to
+
+	entries, err := h.store.ListDLQEntries(r.Context(), query)
+	if err != nil {
+		log.Printf("Failed to list dead-letter entries: %v", err)
+		http.Error(w, "Failed to fetch dead-letter entries", http.StatusInternalServerError)
+		return
+	}
+
+	// Payloads can be large; the list only carries metadata
+	for i := range entries {
+		entries[i].Value = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+		"count":   len(entries),
+	}); err != nil {
+		log.Printf("Failed to encode dead-letter response: %v", err)
+	}
+}
+
+// HandleGetDLQEntry handles GET /v1/dlq/{id}
+func (h *DLQHandler) HandleGetDLQEntry(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	entry, err := h.store.GetDLQEntry(r.Context(), id)
+	if errors.Is(err, store.ErrDLQEntryNotFound) {
+		http.Error(w, "Dead-letter entry not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get dead-letter entry %d: %v", id, err)
+		http.Error(w, "Failed to fetch dead-letter entry", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(entry); err != nil {
+		log.Printf("Failed to encode dead-letter response: %v", err)
+	}
+}
@@ -3,10 +3,17 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"streamlens/internal/models"
+	"streamlens/internal/processor"
+	"streamlens/internal/rollup"
 	"streamlens/internal/store"
+	"strings"
 	"time"
 )
 
@@ -20,7 +27,14 @@ func NewMetricsHandler(store *store.MetricsStore) *MetricsHandler {
 	return &MetricsHandler{store: store}
 }
 
-// HandleGetMetrics handles GET /v1/metrics
+// maxDimensionRows bounds the dimensioned windows read for one query before
+// they are grouped
+const maxDimensionRows = 10000
+
+// HandleGetMetrics handles GET /v1/metrics. Omitting route or model reads
+// the windows across all routes or models. dim.<key>=<value> parameters and
+// group_by=<key>,... read the dimensioned windows of promoted metadata keys
+// instead of tenant totals, grouped by the group_by keys.
 func (h *MetricsHandler) HandleGetMetrics(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -38,8 +52,8 @@ func (h *MetricsHandler) HandleGetMetrics(w http.ResponseWriter, r *http.Request
 	model := r.URL.Query().Get("model")
 	limitStr := r.URL.Query().Get("limit")
 
-	// Parse optional limit
-	limit := 60 // default
+	// Parse optional limit; see below for the default
+	limit := 0
 	if limitStr != "" {
 		parsedLimit, err := strconv.Atoi(limitStr)
 		if err != nil {
@@ -58,11 +72,79 @@ func (h *MetricsHandler) HandleGetMetrics(w http.ResponseWriter, r *http.Request
 		modelPtr = &model
 	}
 
+	// Parse optional time range
+	from, to, err := parseTimeRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Pick the resolution: explicit override, else derived from the time range
+	resolution := models.Resolution1m
+	if resStr := r.URL.Query().Get("resolution"); resStr != "" {
+		resolution, err = models.ParseResolution(resStr)
+		if err != nil {
+			http.Error(w, "Invalid resolution parameter", http.StatusBadRequest)
+			return
+		}
+	} else if from != nil {
+		resolution = rollup.SelectResolution(*from, *to)
+	}
+
+	// By default a time range is returned whole, up to MaxPointsPerSeries
+	// windows; without one the latest 60 windows are
+	if limit == 0 {
+		limit = 60
+		if from != nil {
+			limit = rollup.Points(*from, *to, resolution)
+		}
+	}
+
+	// Non-default window definitions are read from their own table
+	var table string
+	if window := r.URL.Query().Get("window"); window != "" && window != processor.DefaultWindowName {
+		if !processor.ValidWindowName(window) {
+			http.Error(w, "Invalid window parameter", http.StatusBadRequest)
+			return
+		}
+		table = processor.WindowTable(window)
+	}
+
+	filter, groupBy, err := parseDimensions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dimensional := filter != nil || groupBy != nil
+	if dimensional && table != "" {
+		http.Error(w, "Dimensions are only kept for the default window", http.StatusBadRequest)
+		return
+	}
+
 	// Query database with timeout
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	metrics, err := h.store.QueryMetrics(ctx, tenantID, routePtr, modelPtr, limit)
+	q := store.MetricsQuery{
+		TenantID:   tenantID,
+		Route:      routePtr,
+		Model:      modelPtr,
+		From:       from,
+		To:         to,
+		Resolution: resolution,
+		Table:      table,
+		Limit:      limit,
+	}
+	var metrics []models.LLMMetrics
+	if dimensional {
+		metrics, err = h.queryDimensions(ctx, q, filter, groupBy)
+	} else {
+		metrics, err = h.store.QueryMetrics(ctx, q)
+	}
+	if errors.Is(err, store.ErrUnknownTable) {
+		http.Error(w, "Unknown window", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to query metrics: %v", err)
 		http.Error(w, "Failed to fetch metrics", http.StatusInternalServerError)
@@ -73,13 +155,154 @@ func (h *MetricsHandler) HandleGetMetrics(w http.ResponseWriter, r *http.Request
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"metrics": metrics,
-		"count":   len(metrics),
+		"metrics":    metrics,
+		"count":      len(metrics),
+		"resolution": resolution,
 	}); err != nil {
 		log.Printf("Failed to encode metrics response: %v", err)
 	}
 }
 
+// queryDimensions reads the dimensioned windows matching filter and groups
+// them by groupBy, returning at most q.Limit groups
+func (h *MetricsHandler) queryDimensions(ctx context.Context, q store.MetricsQuery, filter models.Dimensions, groupBy []string) ([]models.LLMMetrics, error) {
+	limit := q.Limit
+	q.Dimensional = true
+	q.Dimensions = filter
+	q.Limit = maxDimensionRows
+	if q.From == nil {
+		// Every dimension combination is a row, so bound the range rather
+		// than the rows
+		to := time.Now().UTC()
+		from := to.Add(-time.Hour)
+		q.From, q.To = &from, &to
+	}
+
+	windows, err := h.store.QueryMetrics(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if len(windows) == maxDimensionRows {
+		// Rows are newest first, so only the oldest window may be cut short
+		oldest := windows[len(windows)-1].WindowStart
+		for len(windows) > 0 && windows[len(windows)-1].WindowStart.Equal(oldest) {
+			windows = windows[:len(windows)-1]
+		}
+	}
+
+	grouped := rollup.Group(windows, groupBy)
+	if limit > 0 && len(grouped) > limit {
+		grouped = grouped[:limit]
+	}
+	metrics := make([]models.LLMMetrics, len(grouped))
+	for i, m := range grouped {
+		metrics[i] = *m
+	}
+	return metrics, nil
+}
+
+// parseDimensions parses dim.<key>=<value> filters and the comma-separated
+// group_by keys, returning nil for each when absent
+func parseDimensions(params url.Values) (models.Dimensions, []string, error) {
+	var filter models.Dimensions
+	for param, values := range params {
+		key, ok := strings.CutPrefix(param, "dim.")
+		if !ok {
+			continue
+		}
+		if !models.ValidDimensionKey(key) {
+			return nil, nil, fmt.Errorf("invalid dimension %q", key)
+		}
+		if filter == nil {
+			filter = make(models.Dimensions)
+		}
+		filter[key] = values[0]
+	}
+
+	var groupBy []string
+	if s := params.Get("group_by"); s != "" {
+		for _, key := range strings.Split(s, ",") {
+			key = strings.TrimSpace(key)
+			if !models.ValidDimensionKey(key) {
+				return nil, nil, fmt.Errorf("invalid group_by dimension %q", key)
+			}
+			groupBy = append(groupBy, key)
+		}
+	}
+	return filter, groupBy, nil
+}
+
+// parseTimeRange parses optional RFC3339 from/to parameters. When only from is
+// given, the range extends to now.
+func parseTimeRange(fromStr, toStr string) (*time.Time, *time.Time, error) {
+	if fromStr == "" {
+		if toStr != "" {
+			return nil, nil, errors.New("to requires from")
+		}
+		return nil, nil, nil
+	}
+
+	from, err := time.Parse(time.RFC3339, fromStr)
+	if err != nil {
+		return nil, nil, errors.New("invalid from parameter")
+	}
+
+	to := time.Now().UTC()
+	if toStr != "" {
+		to, err = time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			return nil, nil, errors.New("invalid to parameter")
+		}
+	}
+
+	if !to.After(from) {
+		return nil, nil, errors.New("to must be after from")
+	}
+
+	return &from, &to, nil
+}
+
+// HandleGetUsers handles GET /v1/metrics/users, counting distinct users over
+// a time range by merging the user sketches of the stored windows. The range
+// defaults to the last hour.
+func (h *MetricsHandler) HandleGetUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	tenantID := q.Get("tenant_id")
+	if tenantID == "" {
+		http.Error(w, "tenant_id is required", http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := parseTimeRange(q.Get("from"), q.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if from == nil {
+		now := time.Now().UTC()
+		start := now.Add(-time.Hour)
+		from, to = &start, &now
+	}
+	resolution := rollup.SelectResolution(*from, *to)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	windows, err := h.store.ListSeriesWindows(ctx, resolution, tenantID, q.Get("route"), q.Get("model"), *from, *to)
+	if err != nil {
+		log.Printf("Failed to query user windows: %v", err)
+		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"users":      rollup.Users(windows),
+		"from":       from,
+		"to":         to,
+		"resolution": resolution,
+	})
+}
+
 // HandleHealth handles GET /health
 func (h *MetricsHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
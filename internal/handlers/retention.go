@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"streamlens/internal/models"
+	"streamlens/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// RetentionHandler manages how long tenants' windows are kept
+type RetentionHandler struct {
+	store    *store.MetricsStore
+	defaults map[models.Resolution]int
+}
+
+// NewRetentionHandler creates a new RetentionHandler. defaults are the days
+// each resolution is kept for tenants without an override.
+func NewRetentionHandler(store *store.MetricsStore, defaults map[models.Resolution]int) *RetentionHandler {
+	return &RetentionHandler{store: store, defaults: defaults}
+}
+
+// HandleList handles GET /v1/retention, returning the default retention and
+// the overrides, optionally of one tenant_id
+func (h *RetentionHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	var tenantID *string
+	if t := r.URL.Query().Get("tenant_id"); t != "" {
+		tenantID = &t
+	}
+
+	overrides, err := h.store.ListTenantRetention(r.Context(), tenantID)
+	if err != nil {
+		log.Printf("Failed to list retention: %v", err)
+		http.Error(w, "Failed to fetch retention", http.StatusInternalServerError)
+		return
+	}
+	if overrides == nil {
+		overrides = []models.TenantRetention{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"defaults":  h.defaults,
+		"retention": overrides,
+		"count":     len(overrides),
+	})
+}
+
+// HandleReplace handles PUT /v1/retention/{tenant_id}, replacing the tenant's
+// retention overrides. Resolutions left out fall back to the defaults.
+func (h *RetentionHandler) HandleReplace(w http.ResponseWriter, r *http.Request) {
+	tenantID := chi.URLParam(r, "tenant_id")
+
+	var body struct {
+		Retention []models.TenantRetention `json:"retention"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := models.ValidateTenantRetention(body.Retention); err != nil {
+		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.ReplaceTenantRetention(r.Context(), tenantID, body.Retention); err != nil {
+		log.Printf("Failed to replace retention of tenant %s: %v", tenantID, err)
+		http.Error(w, "Failed to update retention", http.StatusInternalServerError)
+		return
+	}
+	if body.Retention == nil {
+		body.Retention = []models.TenantRetention{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"retention": body.Retention,
+		"count":     len(body.Retention),
+	})
+}
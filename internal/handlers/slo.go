@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"streamlens/internal/models"
+	"streamlens/internal/slo"
+	"streamlens/internal/store"
+	"time"
+)
+
+// SLOHandler manages SLOs and reports their error budgets
+type SLOHandler struct {
+	store *store.MetricsStore
+}
+
+// NewSLOHandler creates a new SLOHandler
+func NewSLOHandler(store *store.MetricsStore) *SLOHandler {
+	return &SLOHandler{store: store}
+}
+
+// sloResponse is an SLO with its current status and burn-rate alerts
+type sloResponse struct {
+	*models.SLO
+	Status *slo.Status       `json:"status"`
+	Alerts []models.SLOAlert `json:"alerts"`
+}
+
+// HandleList handles GET /v1/slos
+func (h *SLOHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	tenantID := r.URL.Query().Get("tenant_id")
+	if tenantID == "" {
+		http.Error(w, "tenant_id is required", http.StatusBadRequest)
+		return
+	}
+
+	slos, err := h.store.ListSLOs(r.Context(), &tenantID)
+	if err != nil {
+		log.Printf("Failed to list SLOs: %v", err)
+		http.Error(w, "Failed to fetch SLOs", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"slos":  slos,
+		"count": len(slos),
+	})
+}
+
+// HandleCreate handles POST /v1/slos
+func (h *SLOHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	o, ok := decodeSLO(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.CreateSLO(r.Context(), o); err != nil {
+		log.Printf("Failed to create SLO: %v", err)
+		http.Error(w, "Failed to create SLO", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, o)
+}
+
+// HandleGet handles GET /v1/slos/{id}, returning the SLO with its remaining
+// error budget, burn rates and hourly burn history
+func (h *SLOHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
+	id, ok := ruleID(w, r)
+	if !ok {
+		return
+	}
+
+	o, err := h.store.GetSLO(r.Context(), id)
+	if errors.Is(err, store.ErrSLONotFound) {
+		http.Error(w, "SLO not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get SLO %d: %v", id, err)
+		http.Error(w, "Failed to fetch SLO", http.StatusInternalServerError)
+		return
+	}
+
+	status, err := slo.Compute(r.Context(), h.store, o, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to compute SLO %d: %v", id, err)
+		http.Error(w, "Failed to compute SLO", http.StatusInternalServerError)
+		return
+	}
+
+	alerts, err := h.store.ListSLOAlerts(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to list alerts of SLO %d: %v", id, err)
+		http.Error(w, "Failed to fetch SLO alerts", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sloResponse{SLO: o, Status: status, Alerts: alerts})
+}
+
+// HandleUpdate handles PUT /v1/slos/{id}
+func (h *SLOHandler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := ruleID(w, r)
+	if !ok {
+		return
+	}
+	o, ok := decodeSLO(w, r)
+	if !ok {
+		return
+	}
+	o.ID = id
+
+	err := h.store.UpdateSLO(r.Context(), o)
+	if errors.Is(err, store.ErrSLONotFound) {
+		http.Error(w, "SLO not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update SLO %d: %v", id, err)
+		http.Error(w, "Failed to update SLO", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, o)
+}
+
+// HandleDelete handles DELETE /v1/slos/{id}
+func (h *SLOHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	id, ok := ruleID(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.store.DeleteSLO(r.Context(), id)
+	if errors.Is(err, store.ErrSLONotFound) {
+		http.Error(w, "SLO not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete SLO %d: %v", id, err)
+		http.Error(w, "Failed to delete SLO", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeSLO reads and validates an SLO from the request body. SLOs are
+// enabled unless the body says otherwise.
+func decodeSLO(w http.ResponseWriter, r *http.Request) (*models.SLO, bool) {
+	o := &models.SLO{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(o); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := o.Validate(); err != nil {
+		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return o, true
+}
@@ -3,33 +3,118 @@ package kafka
 import (
 	"context"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/twmb/franz-go/pkg/kgo"
 )
 
+// RebalanceListener is notified when the consumer group moves partitions.
+// Callbacks run while no records are being processed (see AllowRebalance).
+type RebalanceListener interface {
+	// OnPartitionsAssigned is called with newly assigned partitions, per topic
+	OnPartitionsAssigned(ctx context.Context, assigned map[string][]int32)
+	// OnPartitionsRevoked is called before partitions move to another member.
+	// Offsets of all polled records are committed once it returns.
+	OnPartitionsRevoked(ctx context.Context, revoked map[string][]int32)
+	// OnPartitionsLost is called when partitions were lost without a clean
+	// handoff, e.g. after a session timeout
+	OnPartitionsLost(ctx context.Context, lost map[string][]int32)
+}
+
+// RewindBackoff is how long a stage waits before polling again after it
+// rewound a partition
+const RewindBackoff = 5 * time.Second
+
 // Consumer wraps a franz-go client for consuming messages
 type Consumer struct {
 	client *kgo.Client
+	group  string
+
+	listenerMu sync.RWMutex
+	listener   RebalanceListener
 }
 
-// NewConsumer creates a new Kafka consumer with a consumer group
+// NewConsumer creates a new Kafka consumer with a consumer group. Offsets are
+// only committed explicitly, and rebalances wait for AllowRebalance so a
+// partition never moves while its records are being processed.
+//
+// The range balancer assigns the same partition numbers of every subscribed
+// topic to the same member, which keeps co-partitioned topics (such as
+// llm.requests and llm.responses, both keyed by request_id) joinable.
 func NewConsumer(brokers []string, group string, topics []string) (*Consumer, error) {
-	client, err := kgo.NewClient(
+	c := &Consumer{group: group}
+
+	opts := []kgo.Opt{
 		kgo.SeedBrokers(brokers...),
 		kgo.ConsumerGroup(group),
 		kgo.ConsumeTopics(topics...),
 		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()), // Start from beginning for new consumers
-	)
+		kgo.Balancers(kgo.RangeBalancer()),
+		kgo.DisableAutoCommit(),
+		kgo.BlockRebalanceOnPoll(),
+		kgo.OnPartitionsAssigned(func(ctx context.Context, _ *kgo.Client, assigned map[string][]int32) {
+			if l := c.rebalanceListener(); l != nil {
+				l.OnPartitionsAssigned(ctx, assigned)
+			}
+		}),
+		kgo.OnPartitionsRevoked(func(ctx context.Context, cl *kgo.Client, revoked map[string][]int32) {
+			c.forgetLag(revoked)
+			if l := c.rebalanceListener(); l != nil {
+				l.OnPartitionsRevoked(ctx, revoked)
+			}
+			if err := cl.CommitUncommittedOffsets(ctx); err != nil {
+				log.Printf("Failed to commit offsets on revoke: %v", err)
+			}
+		}),
+		kgo.OnPartitionsLost(func(ctx context.Context, _ *kgo.Client, lost map[string][]int32) {
+			c.forgetLag(lost)
+			if l := c.rebalanceListener(); l != nil {
+				l.OnPartitionsLost(ctx, lost)
+			}
+		}),
+	}
+	// Every stage has its own group, so the group also identifies the client
+	client, err := kgo.NewClient(append(opts, clientMetrics(group)...)...)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Consumer{client: client}, nil
+	c.client = client
+	return c, nil
+}
+
+// Group returns the consumer group name
+func (c *Consumer) Group() string {
+	return c.group
 }
 
-// Poll fetches records from Kafka
+// SetRebalanceListener registers the listener for partition changes. It must
+// be called before the first Poll.
+func (c *Consumer) SetRebalanceListener(l RebalanceListener) {
+	c.listenerMu.Lock()
+	c.listener = l
+	c.listenerMu.Unlock()
+}
+
+func (c *Consumer) rebalanceListener() RebalanceListener {
+	c.listenerMu.RLock()
+	defer c.listenerMu.RUnlock()
+	return c.listener
+}
+
+// Poll fetches records from Kafka. Rebalances are held back until
+// AllowRebalance is called.
 func (c *Consumer) Poll(ctx context.Context) kgo.Fetches {
-	return c.client.PollFetches(ctx)
+	fetches := c.client.PollFetches(ctx)
+	c.observeLag(fetches)
+	return fetches
+}
+
+// AllowRebalance lets a pending rebalance proceed once the records returned
+// by the last Poll have been processed
+func (c *Consumer) AllowRebalance() {
+	c.client.AllowRebalance()
 }
 
 // CommitRecords commits the offsets for consumed records
@@ -37,6 +122,16 @@ func (c *Consumer) CommitRecords(ctx context.Context, records ...*kgo.Record) er
 	return c.client.CommitRecords(ctx, records...)
 }
 
+// Rewind makes Poll return the records of record's partition again starting
+// at record, for a record that could not be handled. Offsets past it are not
+// committed, not even on revoke, until it is polled and committed again. It
+// must be called before AllowRebalance.
+func (c *Consumer) Rewind(record *kgo.Record) {
+	c.client.SetOffsets(map[string]map[int32]kgo.EpochOffset{
+		record.Topic: {record.Partition: {Epoch: record.LeaderEpoch, Offset: record.Offset}},
+	})
+}
+
 // Close shuts down the consumer gracefully
 func (c *Consumer) Close() {
 	c.client.Close()
@@ -12,6 +12,14 @@ const (
 	TopicLLMRequests  = "llm.requests"
 	TopicLLMResponses = "llm.responses"
 	TopicLLMMetrics   = "llm.metrics"
+	TopicLLMJoined    = "llm.joined"
+	TopicLLMDLQ       = "llm.dlq"
+	TopicLLMAnomalies = "llm.anomalies"
+	TopicLLMBudgets   = "llm.budgets"
+	TopicLLMAbandoned = "llm.abandoned"
+
+	TopicLLMMetricsDimensions = "llm.metrics.dimensions"
+	TopicLLMMetricsGrouped    = "llm.metrics.grouped"
 )
 
 // Producer wraps a franz-go client for producing messages
@@ -19,12 +27,14 @@ type Producer struct {
 	client *kgo.Client
 }
 
-// NewProducer creates a new Kafka producer
+// NewProducer creates a new Kafka producer. A process should create at
+// most one, since its client metrics are registered under a fixed client ID.
 func NewProducer(brokers []string) (*Producer, error) {
-	client, err := kgo.NewClient(
+	opts := []kgo.Opt{
 		kgo.SeedBrokers(brokers...),
 		kgo.AllowAutoTopicCreation(),
-	)
+	}
+	client, err := kgo.NewClient(append(opts, clientMetrics("streamlens-producer")...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -54,6 +64,11 @@ func (p *Producer) ProduceJSON(ctx context.Context, topic, key string, value int
 	return nil
 }
 
+// ProduceRecord produces a prepared record synchronously
+func (p *Producer) ProduceRecord(ctx context.Context, record *kgo.Record) error {
+	return p.client.ProduceSync(ctx, record).FirstErr()
+}
+
 // Close shuts down the producer gracefully
 func (p *Producer) Close() {
 	p.client.Close()
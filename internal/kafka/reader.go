@@ -0,0 +1,96 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// ReadRange reads the records of topics between the first offsets at or
+// after from and the first offsets at or after to, as found by timestamp
+// lookups, and calls fn for each in partition order. It stops once every
+// partition reached its end offset, fn fails or ctx is cancelled.
+//
+// Record timestamps are set by producers, so a few records around the bounds
+// may fall on the other side; callers filter by event time themselves.
+//
+// The reader joins group, which must not be used by the live pipeline. It
+// never commits offsets, so every call starts from the lookup again.
+func ReadRange(ctx context.Context, brokers []string, group string, topics []string, from, to time.Time, fn func(*kgo.Record) error) error {
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(brokers...),
+		kgo.ClientID(group),
+		kgo.ConsumerGroup(group),
+		kgo.ConsumeTopics(topics...),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AfterMilli(from.UnixMilli())),
+		kgo.DisableAutoCommit(),
+	)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	admin := kadm.NewClient(client)
+	start, err := admin.ListOffsetsAfterMilli(ctx, from.UnixMilli(), topics...)
+	if err == nil {
+		err = start.Error()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up start offsets: %w", err)
+	}
+	end, err := admin.ListOffsetsAfterMilli(ctx, to.UnixMilli(), topics...)
+	if err == nil {
+		err = end.Error()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up end offsets: %w", err)
+	}
+
+	// Offsets still to be read up to, per partition
+	remaining := make(map[string]map[int32]int64)
+	end.Each(func(o kadm.ListedOffset) {
+		if s, ok := start.Lookup(o.Topic, o.Partition); ok && s.Offset >= o.Offset {
+			return
+		}
+		if remaining[o.Topic] == nil {
+			remaining[o.Topic] = make(map[int32]int64)
+		}
+		remaining[o.Topic][o.Partition] = o.Offset
+	})
+
+	for len(remaining) > 0 {
+		fetches := client.PollFetches(ctx)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if errs := fetches.Errors(); len(errs) > 0 {
+			for _, e := range errs {
+				log.Printf("Fetch error: %v", e.Err)
+			}
+			continue
+		}
+
+		var fnErr error
+		fetches.EachRecord(func(record *kgo.Record) {
+			stop, ok := remaining[record.Topic][record.Partition]
+			if fnErr != nil || !ok || record.Offset >= stop {
+				return
+			}
+			fnErr = fn(record)
+			if record.Offset+1 >= stop {
+				delete(remaining[record.Topic], record.Partition)
+				if len(remaining[record.Topic]) == 0 {
+					delete(remaining, record.Topic)
+				}
+			}
+		})
+		if fnErr != nil {
+			return fnErr
+		}
+	}
+	return nil
+}
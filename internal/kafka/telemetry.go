@@ -0,0 +1,51 @@
+package kafka
+
+import (
+	"strconv"
+	"streamlens/internal/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/plugin/kprom"
+)
+
+var consumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: telemetry.Namespace,
+	Subsystem: "kafka",
+	Name:      "consumer_lag",
+	Help:      "Records between the last polled offset and the high watermark, per consumed partition.",
+}, []string{"group", "topic", "partition"})
+
+// clientMetrics registers franz-go client metrics (connections, bytes,
+// batches, records, errors) under the client's ID. IDs must be unique within
+// a process.
+func clientMetrics(clientID string) []kgo.Opt {
+	return []kgo.Opt{
+		kgo.ClientID(clientID),
+		kgo.WithHooks(kprom.NewMetrics(telemetry.Namespace,
+			kprom.Registerer(prometheus.DefaultRegisterer),
+			kprom.WithClientLabel(),
+		)),
+	}
+}
+
+// observeLag records the lag of every partition in fetches
+func (c *Consumer) observeLag(fetches kgo.Fetches) {
+	fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+		if len(p.Records) == 0 {
+			return
+		}
+		last := p.Records[len(p.Records)-1].Offset
+		consumerLag.WithLabelValues(c.group, p.Topic, strconv.Itoa(int(p.Partition))).Set(float64(p.HighWatermark - last - 1))
+	})
+}
+
+// forgetLag drops the lag of partitions this member no longer consumes
+func (c *Consumer) forgetLag(partitions map[string][]int32) {
+	for topic, ps := range partitions {
+		for _, p := range ps {
+			consumerLag.DeleteLabelValues(c.group, topic, strconv.Itoa(int(p)))
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AlertStatus is the state of an alert
+type AlertStatus string
+
+const (
+	// AlertPending alerts breach their rule but not yet for enough windows
+	AlertPending AlertStatus = "pending"
+	// AlertFiring alerts breached their rule for the required windows
+	AlertFiring AlertStatus = "firing"
+	// AlertResolved alerts were firing and stopped breaching their rule
+	AlertResolved AlertStatus = "resolved"
+)
+
+// WebhookFormat is the payload layout sent to an alert rule's webhook
+type WebhookFormat string
+
+const (
+	WebhookGeneric      WebhookFormat = "generic"
+	WebhookSlack        WebhookFormat = "slack"
+	WebhookAlertmanager WebhookFormat = "alertmanager"
+)
+
+// AlertRule fires when a metric of a tenant's windows crosses a threshold for
+// ForWindows consecutive windows. Empty Route and Model match any route or
+// model; each matching series is alerted on separately.
+type AlertRule struct {
+	ID            int64         `json:"id"`
+	Name          string        `json:"name"`
+	TenantID      string        `json:"tenant_id"`
+	Route         string        `json:"route,omitempty"`
+	Model         string        `json:"model,omitempty"`
+	Metric        string        `json:"metric"`
+	Operator      string        `json:"operator"`
+	Threshold     float64       `json:"threshold"`
+	ForWindows    int           `json:"for_windows"`
+	Severity      string        `json:"severity"`
+	WebhookURL    string        `json:"webhook_url"`
+	WebhookFormat WebhookFormat `json:"webhook_format"`
+	Enabled       bool          `json:"enabled"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+}
+
+// AlertOperators are the supported threshold comparisons
+var AlertOperators = []string{">", ">=", "<", "<="}
+
+// Validate checks a rule and fills in defaults
+func (r *AlertRule) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name is required")
+	}
+	if r.TenantID == "" {
+		return ErrMissingTenantID
+	}
+	if _, ok := MetricFields[r.Metric]; !ok {
+		return fmt.Errorf("unknown metric %q, expected one of %s", r.Metric, strings.Join(MetricFieldNames(), ", "))
+	}
+	if !containsString(AlertOperators, r.Operator) {
+		return fmt.Errorf("unknown operator %q, expected one of %s", r.Operator, strings.Join(AlertOperators, " "))
+	}
+	if r.ForWindows == 0 {
+		r.ForWindows = 1
+	}
+	if r.ForWindows < 1 {
+		return errors.New("for_windows must be positive")
+	}
+	if r.Severity == "" {
+		r.Severity = "warning"
+	}
+
+	u, err := url.Parse(r.WebhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("webhook_url must be an http(s) URL")
+	}
+	switch r.WebhookFormat {
+	case "":
+		r.WebhookFormat = WebhookGeneric
+	case WebhookGeneric, WebhookSlack, WebhookAlertmanager:
+	default:
+		return fmt.Errorf("unknown webhook_format %q", r.WebhookFormat)
+	}
+	return nil
+}
+
+// Matches reports whether the rule applies to a window's series
+func (r *AlertRule) Matches(m *LLMMetrics) bool {
+	return r.Enabled && m.SessionID == "" && r.TenantID == m.TenantID &&
+		(r.Route == "" || r.Route == m.Route) &&
+		(r.Model == "" || r.Model == m.Model)
+}
+
+// Breached reports whether value crosses the rule's threshold
+func (r *AlertRule) Breached(value float64) bool {
+	switch r.Operator {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	default:
+		return false
+	}
+}
+
+// Alert is the state of one rule for one tenant/route/model series
+type Alert struct {
+	RuleID          int64       `json:"rule_id"`
+	TenantID        string      `json:"tenant_id"`
+	Route           string      `json:"route"`
+	Model           string      `json:"model"`
+	Status          AlertStatus `json:"status"`
+	Value           float64     `json:"value"`
+	Consecutive     int         `json:"consecutive_windows"`
+	StartsAt        time.Time   `json:"starts_at"`
+	EndsAt          *time.Time  `json:"ends_at,omitempty"`
+	LastWindowStart time.Time   `json:"last_window_start"`
+	LastWindowEnd   time.Time   `json:"last_window_end"` // end of the last breaching window
+
+	// Last status delivered to the webhook, and when
+	NotifiedStatus AlertStatus `json:"notified_status,omitempty"`
+	LastNotifiedAt *time.Time  `json:"last_notified_at,omitempty"`
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// Anomaly is a metrics window whose value deviates from its series baseline
+type Anomaly struct {
+	TenantID    string    `json:"tenant_id"`
+	Route       string    `json:"route"`
+	Model       string    `json:"model"`
+	Metric      string    `json:"metric"`
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	Observed    float64   `json:"observed"`
+	Expected    float64   `json:"expected"`
+	StdDev      float64   `json:"stddev"`
+	ZScore      float64   `json:"z_score"`
+	Seasonal    bool      `json:"seasonal"` // baseline was the hour-of-day one
+	DetectedAt  time.Time `json:"detected_at"`
+}
+
+// AnomalyBaseline is the exponentially weighted mean and variance of one
+// metric of a tenant/route/model series. Slot is the UTC hour of day for
+// seasonal baselines and -1 for the all-day baseline.
+type AnomalyBaseline struct {
+	Metric   string  `json:"metric"`
+	Slot     int     `json:"slot"`
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+	Count    int64   `json:"count"`
+}
+
+// AnomalySeries holds the baselines of one tenant/route/model series and the
+// start of the last window folded into them
+type AnomalySeries struct {
+	TenantID        string
+	Route           string
+	Model           string
+	LastWindowStart time.Time
+	Baselines       []AnomalyBaseline
+}
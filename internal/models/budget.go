@@ -0,0 +1,104 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// BudgetPeriod is the calendar period a budget resets on, in UTC
+type BudgetPeriod string
+
+const (
+	BudgetDaily   BudgetPeriod = "daily"
+	BudgetMonthly BudgetPeriod = "monthly"
+)
+
+// BudgetThresholds are the percentages of a budget whose crossing is notified
+var BudgetThresholds = []int{50, 80, 100}
+
+// Budget caps the spend of a tenant, or of one of its routes, per period
+type Budget struct {
+	ID        int64        `json:"id"`
+	TenantID  string       `json:"tenant_id"`
+	Route     string       `json:"route,omitempty"`
+	Period    BudgetPeriod `json:"period"`
+	AmountUSD float64      `json:"amount_usd"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// Validate checks a budget
+func (b *Budget) Validate() error {
+	if b.TenantID == "" {
+		return ErrMissingTenantID
+	}
+	switch b.Period {
+	case BudgetDaily, BudgetMonthly:
+	default:
+		return fmt.Errorf("unknown period %q, expected %s or %s", b.Period, BudgetDaily, BudgetMonthly)
+	}
+	if b.AmountUSD <= 0 {
+		return errors.New("amount_usd must be positive")
+	}
+	return nil
+}
+
+// Matches reports whether spend of a window counts against the budget
+func (b *Budget) Matches(m *LLMMetrics) bool {
+	return b.TenantID == m.TenantID && (b.Route == "" || b.Route == m.Route)
+}
+
+// PeriodStart returns the start of the budget period containing t
+func (b *Budget) PeriodStart(t time.Time) time.Time {
+	t = t.UTC()
+	if b.Period == BudgetMonthly {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// PeriodEnd returns the end of the budget period starting at start
+func (b *Budget) PeriodEnd(start time.Time) time.Time {
+	if b.Period == BudgetMonthly {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// CrossedThreshold returns the highest threshold reached by spend, or 0
+func (b *Budget) CrossedThreshold(spendUSD float64) int {
+	crossed := 0
+	for _, pct := range BudgetThresholds {
+		if spendUSD >= b.AmountUSD*float64(pct)/100 {
+			crossed = pct
+		}
+	}
+	return crossed
+}
+
+// BudgetStatus is the spend of a budget in its current period
+type BudgetStatus struct {
+	Budget
+	PeriodStart  time.Time `json:"period_start"`
+	PeriodEnd    time.Time `json:"period_end"`
+	SpendUSD     float64   `json:"spend_usd"`
+	RemainingUSD float64   `json:"remaining_usd"`
+	// ProjectedUSD extrapolates the spend so far linearly to the end of the period
+	ProjectedUSD float64 `json:"projected_usd"`
+	// NotifiedPercent is the highest threshold notified this period
+	NotifiedPercent int `json:"notified_percent"`
+}
+
+// BudgetEvent is produced to llm.budgets when spend crosses a threshold
+type BudgetEvent struct {
+	BudgetID         int64        `json:"budget_id"`
+	TenantID         string       `json:"tenant_id"`
+	Route            string       `json:"route,omitempty"`
+	Period           BudgetPeriod `json:"period"`
+	PeriodStart      time.Time    `json:"period_start"`
+	ThresholdPercent int          `json:"threshold_percent"`
+	AmountUSD        float64      `json:"amount_usd"`
+	SpendUSD         float64      `json:"spend_usd"`
+	Timestamp        time.Time    `json:"timestamp"`
+}
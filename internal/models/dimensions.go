@@ -0,0 +1,128 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DimensionOther replaces values of a dimension beyond its cardinality cap
+	DimensionOther = "__other__"
+	// MaxDimensionsPerTenant bounds the metadata keys a tenant can promote,
+	// since every combination of their values is a separate series
+	MaxDimensionsPerTenant = 8
+	// MaxDimensionValueLength truncates long metadata values
+	MaxDimensionValueLength = 128
+)
+
+var dimensionKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// ValidDimensionKey reports whether key can be promoted to a dimension
+func ValidDimensionKey(key string) bool {
+	return dimensionKeyPattern.MatchString(key)
+}
+
+// TenantDimension is a metadata key a tenant promoted to an aggregation
+// dimension. At most MaxValues distinct values are tracked; later values are
+// grouped into DimensionOther.
+type TenantDimension struct {
+	TenantID  string    `json:"tenant_id"`
+	Key       string    `json:"key"`
+	MaxValues int       `json:"max_values"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ValidateTenantDimensions checks a tenant's allow-list, filling in the
+// default cap where none is set
+func ValidateTenantDimensions(dims []TenantDimension, defaultMaxValues int) error {
+	if len(dims) > MaxDimensionsPerTenant {
+		return fmt.Errorf("at most %d dimensions per tenant", MaxDimensionsPerTenant)
+	}
+	seen := make(map[string]bool, len(dims))
+	for i := range dims {
+		d := &dims[i]
+		if !ValidDimensionKey(d.Key) {
+			return fmt.Errorf("dimension %q: key must match %s", d.Key, dimensionKeyPattern)
+		}
+		if seen[d.Key] {
+			return fmt.Errorf("dimension %q: listed more than once", d.Key)
+		}
+		seen[d.Key] = true
+		if d.MaxValues == 0 {
+			d.MaxValues = defaultMaxValues
+		}
+		if d.MaxValues < 1 {
+			return errors.New("max_values must be positive")
+		}
+	}
+	return nil
+}
+
+// Dimensions are the promoted metadata values of a window, by key
+type Dimensions map[string]string
+
+// Key is a canonical encoding of the dimensions, empty when there are none
+func (d Dimensions) Key() string {
+	if len(d) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(d))
+	for k := range d {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(url.QueryEscape(d[k]))
+	}
+	return b.String()
+}
+
+// Project returns the dimensions restricted to keys. Keys without a value are
+// left out.
+func (d Dimensions) Project(keys []string) Dimensions {
+	if len(keys) == 0 {
+		return nil
+	}
+	out := make(Dimensions, len(keys))
+	for _, k := range keys {
+		if v, ok := d[k]; ok {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// DimensionValue converts a metadata value to a dimension value. Only strings,
+// numbers and booleans are used; other values are treated as missing.
+func DimensionValue(v interface{}) (string, bool) {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case float64:
+		s = fmt.Sprintf("%g", v)
+	case bool:
+		s = fmt.Sprintf("%t", v)
+	default:
+		return "", false
+	}
+	if s == "" {
+		return "", false
+	}
+	if len(s) > MaxDimensionValueLength {
+		s = s[:MaxDimensionValueLength]
+	}
+	return s, true
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDimensions_Key(t *testing.T) {
+	tests := []struct {
+		name string
+		dims Dimensions
+		want string
+	}{
+		{name: "none", dims: nil, want: ""},
+		{name: "sorted by key", dims: Dimensions{"feature": "search", "environment": "prod"}, want: "environment=prod&feature=search"},
+		{name: "escaped values", dims: Dimensions{"feature": "a&b=c"}, want: "feature=a%26b%3Dc"},
+		{name: "missing value", dims: Dimensions{"environment": ""}, want: "environment="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dims.Key(); got != tt.want {
+				t.Errorf("Key() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDimensions_Project(t *testing.T) {
+	dims := Dimensions{"environment": "prod", "feature": "search", "customer_tier": "gold"}
+
+	got := dims.Project([]string{"feature", "region"})
+	if got.Key() != "feature=search" {
+		t.Errorf("Project() = %v, want only feature", got)
+	}
+	if got := dims.Project(nil); got != nil {
+		t.Errorf("Project(nil) = %v, want nil", got)
+	}
+}
+
+func TestDimensionValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  interface{}
+		want   string
+		wantOK bool
+	}{
+		{name: "string", value: "prod", want: "prod", wantOK: true},
+		{name: "number", value: float64(42), want: "42", wantOK: true},
+		{name: "bool", value: true, want: "true", wantOK: true},
+		{name: "empty string", value: "", wantOK: false},
+		{name: "missing", value: nil, wantOK: false},
+		{name: "object", value: map[string]interface{}{"a": 1}, wantOK: false},
+		{name: "truncated", value: strings.Repeat("x", 200), want: strings.Repeat("x", MaxDimensionValueLength), wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := DimensionValue(tt.value)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("DimensionValue(%v) = %q, %v, want %q, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidateTenantDimensions(t *testing.T) {
+	tests := []struct {
+		name    string
+		dims    []TenantDimension
+		wantErr bool
+	}{
+		{name: "valid", dims: []TenantDimension{{Key: "environment"}, {Key: "feature", MaxValues: 20}}},
+		{name: "invalid key", dims: []TenantDimension{{Key: "Feature-Name"}}, wantErr: true},
+		{name: "duplicate key", dims: []TenantDimension{{Key: "feature"}, {Key: "feature"}}, wantErr: true},
+		{name: "negative cap", dims: []TenantDimension{{Key: "feature", MaxValues: -1}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTenantDimensions(tt.dims, 100)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateTenantDimensions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && tt.dims[0].MaxValues != 100 {
+				t.Errorf("MaxValues = %d, want default 100", tt.dims[0].MaxValues)
+			}
+		})
+	}
+}
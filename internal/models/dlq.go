@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// DLQEntry is a record that could not be processed, as captured in llm.dlq
+type DLQEntry struct {
+	ID              int64             `json:"id"`
+	ConsumerGroup   string            `json:"consumer_group"`
+	SourceTopic     string            `json:"source_topic"`
+	SourcePartition int32             `json:"source_partition"`
+	SourceOffset    int64             `json:"source_offset"`
+	Key             string            `json:"key"`
+	Value           string            `json:"value,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	ErrorReason     string            `json:"error_reason"`
+	FailedAt        time.Time         `json:"failed_at"`
+	RedrivenAt      *time.Time        `json:"redriven_at,omitempty"`
+}
@@ -0,0 +1,39 @@
+package models
+
+// ErrorClass is the normalized category of a failed LLM call
+type ErrorClass string
+
+const (
+	ErrorClassRateLimited    ErrorClass = "rate_limited"
+	ErrorClassTimeout        ErrorClass = "timeout"
+	ErrorClassServerError    ErrorClass = "server_error"
+	ErrorClassInvalidRequest ErrorClass = "invalid_request"
+	ErrorClassContentFilter  ErrorClass = "content_filter"
+	ErrorClassOther          ErrorClass = "other"
+)
+
+// ErrorClasses lists every error class
+var ErrorClasses = []ErrorClass{
+	ErrorClassRateLimited,
+	ErrorClassTimeout,
+	ErrorClassServerError,
+	ErrorClassInvalidRequest,
+	ErrorClassContentFilter,
+	ErrorClassOther,
+}
+
+// ParseErrorClass validates an error class name
+func ParseErrorClass(s string) (ErrorClass, bool) {
+	for _, c := range ErrorClasses {
+		if string(c) == s {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// Breakdown dimensions stored per window in llm_metrics_breakdown
+const (
+	BreakdownErrorClass   = "error_class"
+	BreakdownFinishReason = "finish_reason"
+)
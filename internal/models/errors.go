@@ -8,4 +8,5 @@ var (
 	ErrMissingRoute     = errors.New("missing route")
 	ErrMissingModel     = errors.New("missing model")
 	ErrMissingTimestamp = errors.New("missing timestamp")
+	ErrWildcard         = errors.New("route and model cannot be \"*\"")
 )
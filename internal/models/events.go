@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"streamlens/internal/sketch"
+	"time"
+)
 
 // LLMRequest represents a request event from an LLM-powered application
 type LLMRequest struct {
@@ -8,6 +12,7 @@ type LLMRequest struct {
 	TenantID     string                 `json:"tenant_id"`
 	Route        string                 `json:"route"`
 	Model        string                 `json:"model"`
+	Provider     string                 `json:"provider,omitempty"`
 	Timestamp    time.Time              `json:"timestamp"`
 	PromptTokens int                    `json:"prompt_tokens"`
 	UserIDHash   *string                `json:"user_id_hash,omitempty"`
@@ -16,28 +21,242 @@ type LLMRequest struct {
 
 // LLMResponse represents a response event from an LLM service
 type LLMResponse struct {
-	RequestID        string    `json:"request_id"`
-	Timestamp        time.Time `json:"timestamp"`
-	LatencyMs        int       `json:"latency_ms"`
-	CompletionTokens int       `json:"completion_tokens"`
-	FinishReason     string    `json:"finish_reason"`
-	Error            *string   `json:"error,omitempty"`
+	RequestID          string    `json:"request_id"`
+	Timestamp          time.Time `json:"timestamp"`
+	LatencyMs          int       `json:"latency_ms"`
+	CompletionTokens   int       `json:"completion_tokens"`
+	CachedPromptTokens int       `json:"cached_prompt_tokens,omitempty"`
+	FinishReason       string    `json:"finish_reason"`
+	Error              *string   `json:"error,omitempty"`
 }
 
+// JoinedEvent is a request matched with its response. Joined events are
+// repartitioned by WindowKey so every window is owned by a single processor.
+// An abandoned request, which got no response within the response timeout,
+// is joined with an empty response.
+type JoinedEvent struct {
+	Request   LLMRequest  `json:"request"`
+	Response  LLMResponse `json:"response"`
+	Abandoned bool        `json:"abandoned,omitempty"`
+}
+
+// AbandonedRequest is a request that got no response within the response
+// timeout, as published to llm.abandoned
+type AbandonedRequest struct {
+	Request    LLMRequest `json:"request"`
+	DetectedAt time.Time  `json:"detected_at"`
+}
+
+// WindowKey is the partitioning key of the event's windows
+func (e *JoinedEvent) WindowKey() string {
+	return e.Request.TenantID + "|" + e.Request.Route + "|" + e.Request.Model
+}
+
+// Wildcard is the route or model of windows aggregated across all routes or
+// models of a tenant
+const Wildcard = "*"
+
 // LLMMetrics represents aggregated metrics for a time window
 type LLMMetrics struct {
 	TenantID            string    `json:"tenant_id"`
 	Route               string    `json:"route"`
 	Model               string    `json:"model"`
+	SessionID           string    `json:"session_id,omitempty"`
 	WindowStart         time.Time `json:"window_start"`
 	WindowEnd           time.Time `json:"window_end"`
 	Requests            int       `json:"requests"`
 	Errors              int       `json:"errors"`
+	Abandoned           int       `json:"abandoned"` // requests without a response, not in Requests
 	AvgLatencyMs        float64   `json:"avg_latency_ms"`
+	P50LatencyMs        float64   `json:"p50_latency_ms"`
+	P90LatencyMs        float64   `json:"p90_latency_ms"`
 	P95LatencyMs        float64   `json:"p95_latency_ms"`
+	P99LatencyMs        float64   `json:"p99_latency_ms"`
+	P999LatencyMs       float64   `json:"p999_latency_ms"`
 	AvgPromptTokens     float64   `json:"avg_prompt_tokens"`
 	AvgCompletionTokens float64   `json:"avg_completion_tokens"`
 	EstimatedCostUSD    float64   `json:"estimated_cost_usd"`
+
+	// Additive components, kept so windows can be rolled up into coarser
+	// resolutions without averaging averages. LatencySketch is a serialized
+	// DDSketch of the window's latencies.
+	LatencySumMs        int64  `json:"latency_sum_ms"`
+	PromptTokensSum     int64  `json:"prompt_tokens_sum"`
+	CompletionTokensSum int64  `json:"completion_tokens_sum"`
+	LatencySketch       []byte `json:"latency_sketch,omitempty"`
+
+	// Errors by ErrorClass and calls by finish_reason
+	ErrorClasses  map[string]int `json:"error_classes,omitempty"`
+	FinishReasons map[string]int `json:"finish_reasons,omitempty"`
+
+	// Distinct users by user_id_hash, and calls per user. UsersSketch is a
+	// serialized HyperLogLog of the users; CallsPerUserSketch is a serialized
+	// DDSketch of each user's calls in the window. Rollups merge the sketches
+	// of their minute windows, so they keep calls per user per minute.
+	UniqueUsers        int64   `json:"unique_users"`
+	P99CallsPerUser    float64 `json:"p99_calls_per_user"`
+	MaxCallsPerUser    int64   `json:"max_calls_per_user"`
+	UsersSketch        []byte  `json:"users_sketch,omitempty"`
+	CallsPerUserSketch []byte  `json:"calls_per_user_sketch,omitempty"`
+
+	// Dimensions are set on windows of one combination of a tenant's
+	// promoted metadata values; tenant totals have none
+	Dimensions Dimensions `json:"dimensions,omitempty"`
+
+	// Partial is set on windows flushed early by an instance shutting down.
+	// The rest of the window's events are flushed later by the next owner of
+	// its partition and merged into the stored window.
+	Partial bool `json:"partial,omitempty"`
+}
+
+// ApplyLatencySketch stores the serialized sketch and derives the latency
+// percentiles from it
+func (m *LLMMetrics) ApplyLatencySketch(s *sketch.DDSketch) error {
+	data, err := s.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	m.LatencySketch = data
+	m.P50LatencyMs = s.Quantile(0.50)
+	m.P90LatencyMs = s.Quantile(0.90)
+	m.P95LatencyMs = s.Quantile(0.95)
+	m.P99LatencyMs = s.Quantile(0.99)
+	m.P999LatencyMs = s.Quantile(0.999)
+	return nil
+}
+
+// ApplyUserSketches stores the serialized user sketches and derives the
+// distinct-user count and calls-per-user percentile from them
+func (m *LLMMetrics) ApplyUserSketches(users *sketch.HLL, calls *sketch.DDSketch) error {
+	usersData, err := users.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	callsData, err := calls.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	m.UsersSketch = usersData
+	m.CallsPerUserSketch = callsData
+	m.UniqueUsers = int64(users.Estimate())
+	m.P99CallsPerUser = calls.Quantile(0.99)
+	return nil
+}
+
+// Merge adds the additive components and sketches of other, a part of the
+// same window, and derives the averages and percentiles of the sum
+func (m *LLMMetrics) Merge(other *LLMMetrics) error {
+	latencies, err := mergeSketches(m.LatencySketch, other.LatencySketch)
+	if err != nil {
+		return fmt.Errorf("latency sketch: %w", err)
+	}
+	calls, err := mergeSketches(m.CallsPerUserSketch, other.CallsPerUserSketch)
+	if err != nil {
+		return fmt.Errorf("calls per user sketch: %w", err)
+	}
+	users, err := sketch.DecodeHLL(m.UsersSketch)
+	if err != nil {
+		return fmt.Errorf("users sketch: %w", err)
+	}
+	otherUsers, err := sketch.DecodeHLL(other.UsersSketch)
+	if err == nil {
+		err = users.Merge(otherUsers)
+	}
+	if err != nil {
+		return fmt.Errorf("users sketch: %w", err)
+	}
+
+	if other.WindowEnd.After(m.WindowEnd) {
+		m.WindowEnd = other.WindowEnd
+	}
+	m.Requests += other.Requests
+	m.Errors += other.Errors
+	m.Abandoned += other.Abandoned
+	m.LatencySumMs += other.LatencySumMs
+	m.PromptTokensSum += other.PromptTokensSum
+	m.CompletionTokensSum += other.CompletionTokensSum
+	m.EstimatedCostUSD += other.EstimatedCostUSD
+	m.ErrorClasses = addCounts(m.ErrorClasses, other.ErrorClasses)
+	m.FinishReasons = addCounts(m.FinishReasons, other.FinishReasons)
+	if other.MaxCallsPerUser > m.MaxCallsPerUser {
+		m.MaxCallsPerUser = other.MaxCallsPerUser
+	}
+
+	m.deriveAverages()
+	if err := m.ApplyLatencySketch(latencies); err != nil {
+		return err
+	}
+	return m.ApplyUserSketches(users, calls)
+}
+
+// Derive computes the averages from the additive sums, and the percentiles
+// and distinct users from the sketches. Windows read from storage are
+// derived again, so that they agree with their components however often
+// they were merged.
+func (m *LLMMetrics) Derive() error {
+	latencies, err := sketch.Decode(m.LatencySketch)
+	if err != nil {
+		return fmt.Errorf("latency sketch: %w", err)
+	}
+	users, err := sketch.DecodeHLL(m.UsersSketch)
+	if err != nil {
+		return fmt.Errorf("users sketch: %w", err)
+	}
+	calls, err := sketch.Decode(m.CallsPerUserSketch)
+	if err != nil {
+		return fmt.Errorf("calls per user sketch: %w", err)
+	}
+
+	m.deriveAverages()
+	m.P50LatencyMs = latencies.Quantile(0.50)
+	m.P90LatencyMs = latencies.Quantile(0.90)
+	m.P95LatencyMs = latencies.Quantile(0.95)
+	m.P99LatencyMs = latencies.Quantile(0.99)
+	m.P999LatencyMs = latencies.Quantile(0.999)
+	m.UniqueUsers = int64(users.Estimate())
+	m.P99CallsPerUser = calls.Quantile(0.99)
+	return nil
+}
+
+// deriveAverages computes the per-request averages from the additive sums
+func (m *LLMMetrics) deriveAverages() {
+	m.AvgLatencyMs, m.AvgPromptTokens, m.AvgCompletionTokens = 0, 0, 0
+	if m.Requests > 0 {
+		n := float64(m.Requests)
+		m.AvgLatencyMs = float64(m.LatencySumMs) / n
+		m.AvgPromptTokens = float64(m.PromptTokensSum) / n
+		m.AvgCompletionTokens = float64(m.CompletionTokensSum) / n
+	}
+}
+
+// mergeSketches decodes and merges two serialized DDSketches
+func mergeSketches(a, b []byte) (*sketch.DDSketch, error) {
+	merged, err := sketch.Decode(a)
+	if err != nil {
+		return nil, err
+	}
+	other, err := sketch.Decode(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := merged.Merge(other); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// addCounts adds src into dst, allocating dst on first use
+func addCounts(dst, src map[string]int) map[string]int {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]int, len(src))
+	}
+	for k, n := range src {
+		dst[k] += n
+	}
+	return dst
 }
 
 // Validate checks if LLMRequest has all required fields
@@ -54,6 +273,9 @@ func (r *LLMRequest) Validate() error {
 	if r.Model == "" {
 		return ErrMissingModel
 	}
+	if r.Route == Wildcard || r.Model == Wildcard {
+		return ErrWildcard
+	}
 	if r.Timestamp.IsZero() {
 		return ErrMissingTimestamp
 	}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"streamlens/internal/sketch"
 	"testing"
 	"time"
 )
@@ -69,6 +70,18 @@ func TestLLMRequest_Validate(t *testing.T) {
 			},
 			wantErr: ErrMissingModel,
 		},
+		{
+			name: "wildcard model",
+			request: LLMRequest{
+				RequestID:    "req-123",
+				TenantID:     "tenant-1",
+				Route:        "chat_support",
+				Model:        Wildcard,
+				Timestamp:    now,
+				PromptTokens: 100,
+			},
+			wantErr: ErrWildcard,
+		},
 		{
 			name: "missing timestamp",
 			request: LLMRequest{
@@ -158,3 +171,65 @@ func TestLLMResponse_Validate(t *testing.T) {
 func stringPtr(s string) *string {
 	return &s
 }
+
+func TestLLMMetrics_Merge(t *testing.T) {
+	start := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+	part := func(latencies []float64, users []string, classes map[string]int) *LLMMetrics {
+		m := &LLMMetrics{WindowStart: start, WindowEnd: start.Add(time.Minute), ErrorClasses: classes}
+		lat, hll := sketch.NewDefault(), sketch.NewDefaultHLL()
+		for _, v := range latencies {
+			lat.Add(v)
+			m.Requests++
+			m.LatencySumMs += int64(v)
+			m.PromptTokensSum += 10
+		}
+		for _, u := range users {
+			hll.AddString(u)
+		}
+		if err := m.ApplyLatencySketch(lat); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.ApplyUserSketches(hll, sketch.NewDefault()); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	m := part([]float64{100, 200}, []string{"a", "b"}, map[string]int{"timeout": 1})
+	m.Partial = true
+	if err := m.Merge(part([]float64{300, 400}, []string{"b", "c"}, map[string]int{"timeout": 2, "rate_limited": 1})); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+
+	if m.Requests != 4 || m.LatencySumMs != 1000 || m.AvgLatencyMs != 250 || m.AvgPromptTokens != 10 {
+		t.Errorf("merged = %d requests, %d ms, %.1f ms avg, %.1f prompt avg; want 4, 1000, 250, 10",
+			m.Requests, m.LatencySumMs, m.AvgLatencyMs, m.AvgPromptTokens)
+	}
+	lat, err := sketch.Decode(m.LatencySketch)
+	if err != nil {
+		t.Fatalf("merged latency sketch: %v", err)
+	}
+	if lat.Count() != 4 {
+		t.Errorf("merged latency sketch holds %d values, want 4", lat.Count())
+	}
+	if m.UniqueUsers != 3 {
+		t.Errorf("merged unique users = %d, want 3", m.UniqueUsers)
+	}
+	if m.ErrorClasses["timeout"] != 3 || m.ErrorClasses["rate_limited"] != 1 {
+		t.Errorf("merged error classes = %v", m.ErrorClasses)
+	}
+	if !m.Partial {
+		t.Error("Merge() changed the partial flag")
+	}
+
+	// A window read from storage is derived from its components again
+	stored := *m
+	stored.AvgLatencyMs, stored.P99LatencyMs, stored.UniqueUsers = 0, 0, 0
+	if err := stored.Derive(); err != nil {
+		t.Fatalf("Derive() error = %v", err)
+	}
+	if stored.AvgLatencyMs != m.AvgLatencyMs || stored.P99LatencyMs != m.P99LatencyMs || stored.UniqueUsers != m.UniqueUsers {
+		t.Errorf("derived = %.1f ms avg, %.1f ms p99, %d users; want %.1f, %.1f, %d",
+			stored.AvgLatencyMs, stored.P99LatencyMs, stored.UniqueUsers, m.AvgLatencyMs, m.P99LatencyMs, m.UniqueUsers)
+	}
+}
@@ -0,0 +1,50 @@
+package models
+
+import "sort"
+
+// MetricFields maps the names of numeric LLMMetrics fields, as they appear in
+// JSON, to accessors. error_rate is derived from errors and requests.
+var MetricFields = map[string]func(m *LLMMetrics) float64{
+	"requests":              func(m *LLMMetrics) float64 { return float64(m.Requests) },
+	"errors":                func(m *LLMMetrics) float64 { return float64(m.Errors) },
+	"error_rate":            func(m *LLMMetrics) float64 { return m.ErrorRate() },
+	"abandoned":             func(m *LLMMetrics) float64 { return float64(m.Abandoned) },
+	"abandon_rate":          func(m *LLMMetrics) float64 { return m.AbandonRate() },
+	"avg_latency_ms":        func(m *LLMMetrics) float64 { return m.AvgLatencyMs },
+	"p50_latency_ms":        func(m *LLMMetrics) float64 { return m.P50LatencyMs },
+	"p90_latency_ms":        func(m *LLMMetrics) float64 { return m.P90LatencyMs },
+	"p95_latency_ms":        func(m *LLMMetrics) float64 { return m.P95LatencyMs },
+	"p99_latency_ms":        func(m *LLMMetrics) float64 { return m.P99LatencyMs },
+	"p999_latency_ms":       func(m *LLMMetrics) float64 { return m.P999LatencyMs },
+	"avg_prompt_tokens":     func(m *LLMMetrics) float64 { return m.AvgPromptTokens },
+	"avg_completion_tokens": func(m *LLMMetrics) float64 { return m.AvgCompletionTokens },
+	"estimated_cost_usd":    func(m *LLMMetrics) float64 { return m.EstimatedCostUSD },
+}
+
+// MetricFieldNames returns the names of MetricFields in sorted order
+func MetricFieldNames() []string {
+	names := make([]string, 0, len(MetricFields))
+	for name := range MetricFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ErrorRate returns the fraction of failed requests in the window
+func (m *LLMMetrics) ErrorRate() float64 {
+	if m.Requests == 0 {
+		return 0
+	}
+	return float64(m.Errors) / float64(m.Requests)
+}
+
+// AbandonRate returns the fraction of calls in the window that got no
+// response
+func (m *LLMMetrics) AbandonRate() float64 {
+	calls := m.Requests + m.Abandoned
+	if calls == 0 {
+		return 0
+	}
+	return float64(m.Abandoned) / float64(calls)
+}
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// WildcardModel matches any model in a price entry; it is used as the
+// catalog-wide or tenant-wide fallback price
+const WildcardModel = "*"
+
+// ModelPrice is the price of a provider/model from EffectiveFrom onwards.
+// Prices are in USD per million tokens. An empty TenantID makes the price the
+// list price; a set TenantID makes it a negotiated override for that tenant.
+type ModelPrice struct {
+	Provider              string    `json:"provider"`
+	Model                 string    `json:"model"`
+	TenantID              string    `json:"tenant_id,omitempty"`
+	InputPerMillion       float64   `json:"input_per_million"`
+	OutputPerMillion      float64   `json:"output_per_million"`
+	CachedInputPerMillion float64   `json:"cached_input_per_million"`
+	EffectiveFrom         time.Time `json:"effective_from"`
+}
+
+// TokenUsage holds the token counts of one call
+type TokenUsage struct {
+	PromptTokens       int
+	CachedPromptTokens int
+	CompletionTokens   int
+}
+
+// Cost returns the USD cost of usage at this price. Cached prompt tokens are
+// part of PromptTokens and are billed at the cached rate instead of the input rate.
+func (p *ModelPrice) Cost(u TokenUsage) float64 {
+	cached := u.CachedPromptTokens
+	if cached > u.PromptTokens {
+		cached = u.PromptTokens
+	}
+	uncached := u.PromptTokens - cached
+
+	return (float64(uncached)*p.InputPerMillion +
+		float64(cached)*p.CachedInputPerMillion +
+		float64(u.CompletionTokens)*p.OutputPerMillion) / 1e6
+}
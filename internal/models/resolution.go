@@ -0,0 +1,43 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Resolution identifies the window size of a stored metrics series
+type Resolution string
+
+const (
+	Resolution1m Resolution = "1m"
+	Resolution5m Resolution = "5m"
+	Resolution1h Resolution = "1h"
+	Resolution1d Resolution = "1d"
+)
+
+// Resolutions lists all supported resolutions from finest to coarsest
+var Resolutions = []Resolution{Resolution1m, Resolution5m, Resolution1h, Resolution1d}
+
+// Duration returns the window size of the resolution
+func (r Resolution) Duration() time.Duration {
+	switch r {
+	case Resolution5m:
+		return 5 * time.Minute
+	case Resolution1h:
+		return time.Hour
+	case Resolution1d:
+		return 24 * time.Hour
+	default:
+		return time.Minute
+	}
+}
+
+// ParseResolution validates a resolution string
+func ParseResolution(s string) (Resolution, error) {
+	for _, r := range Resolutions {
+		if string(r) == s {
+			return r, nil
+		}
+	}
+	return "", fmt.Errorf("unknown resolution: %q", s)
+}
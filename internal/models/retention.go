@@ -0,0 +1,34 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TenantRetention overrides how many days windows of one resolution are kept
+// for a tenant. Days of 0 keeps them forever.
+type TenantRetention struct {
+	TenantID   string     `json:"tenant_id"`
+	Resolution Resolution `json:"resolution"`
+	Days       int        `json:"days"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ValidateTenantRetention checks a tenant's retention overrides
+func ValidateTenantRetention(overrides []TenantRetention) error {
+	seen := make(map[Resolution]bool, len(overrides))
+	for _, o := range overrides {
+		if _, err := ParseResolution(string(o.Resolution)); err != nil {
+			return err
+		}
+		if seen[o.Resolution] {
+			return fmt.Errorf("resolution %s: listed more than once", o.Resolution)
+		}
+		seen[o.Resolution] = true
+		if o.Days < 0 {
+			return errors.New("days must not be negative")
+		}
+	}
+	return nil
+}
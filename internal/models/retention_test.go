@@ -0,0 +1,24 @@
+package models
+
+import "testing"
+
+func TestValidateTenantRetention(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides []TenantRetention
+		wantErr   bool
+	}{
+		{name: "valid", overrides: []TenantRetention{{Resolution: Resolution1m, Days: 30}, {Resolution: Resolution1d}}},
+		{name: "unknown resolution", overrides: []TenantRetention{{Resolution: "2m", Days: 30}}, wantErr: true},
+		{name: "duplicate resolution", overrides: []TenantRetention{{Resolution: Resolution1h, Days: 30}, {Resolution: Resolution1h, Days: 60}}, wantErr: true},
+		{name: "negative days", overrides: []TenantRetention{{Resolution: Resolution5m, Days: -1}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateTenantRetention(tt.overrides); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateTenantRetention() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SLIType is the kind of indicator an SLO is measured with
+type SLIType string
+
+const (
+	// SLIAvailability counts requests without an error as good
+	SLIAvailability SLIType = "availability"
+	// SLILatency counts requests at or under LatencyThresholdMs as good
+	SLILatency SLIType = "latency"
+)
+
+// DefaultSLOPeriodDays is the rolling period of SLOs that do not set one
+const DefaultSLOPeriodDays = 28
+
+// SLO is a service level objective for a tenant's route and model. Empty
+// Route and Model cover every route or model of the tenant.
+type SLO struct {
+	ID                 int64         `json:"id"`
+	Name               string        `json:"name"`
+	TenantID           string        `json:"tenant_id"`
+	Route              string        `json:"route,omitempty"`
+	Model              string        `json:"model,omitempty"`
+	SLI                SLIType       `json:"sli"`
+	Objective          float64       `json:"objective"`
+	LatencyThresholdMs float64       `json:"latency_threshold_ms,omitempty"`
+	PeriodDays         int           `json:"period_days"`
+	WebhookURL         string        `json:"webhook_url,omitempty"`
+	WebhookFormat      WebhookFormat `json:"webhook_format,omitempty"`
+	Enabled            bool          `json:"enabled"`
+	CreatedAt          time.Time     `json:"created_at"`
+	UpdatedAt          time.Time     `json:"updated_at"`
+}
+
+// Period returns the rolling period the error budget is tracked over
+func (s *SLO) Period() time.Duration {
+	return time.Duration(s.PeriodDays) * 24 * time.Hour
+}
+
+// Validate checks an SLO and fills in defaults
+func (s *SLO) Validate() error {
+	if strings.TrimSpace(s.Name) == "" {
+		return errors.New("name is required")
+	}
+	if s.TenantID == "" {
+		return ErrMissingTenantID
+	}
+	switch s.SLI {
+	case SLIAvailability:
+	case SLILatency:
+		if s.LatencyThresholdMs <= 0 {
+			return errors.New("latency SLOs require a positive latency_threshold_ms")
+		}
+	default:
+		return fmt.Errorf("unknown sli %q, expected %s or %s", s.SLI, SLIAvailability, SLILatency)
+	}
+	if s.Objective <= 0 || s.Objective >= 1 {
+		return errors.New("objective must be between 0 and 1, e.g. 0.995")
+	}
+	if s.PeriodDays == 0 {
+		s.PeriodDays = DefaultSLOPeriodDays
+	}
+	if s.PeriodDays < 1 || s.PeriodDays > 90 {
+		return errors.New("period_days must be between 1 and 90")
+	}
+
+	if s.WebhookURL != "" {
+		u, err := url.Parse(s.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("webhook_url must be an http(s) URL")
+		}
+	}
+	switch s.WebhookFormat {
+	case "":
+		s.WebhookFormat = WebhookGeneric
+	case WebhookGeneric, WebhookSlack, WebhookAlertmanager:
+	default:
+		return fmt.Errorf("unknown webhook_format %q", s.WebhookFormat)
+	}
+	return nil
+}
+
+// SLOAlert is the state of one burn-rate alert window of an SLO
+type SLOAlert struct {
+	SLOID         int64       `json:"slo_id"`
+	Window        string      `json:"window"`
+	Severity      string      `json:"severity"`
+	Status        AlertStatus `json:"status"`
+	Threshold     float64     `json:"threshold"`
+	LongBurnRate  float64     `json:"long_burn_rate"`
+	ShortBurnRate float64     `json:"short_burn_rate"`
+	StartsAt      time.Time   `json:"starts_at"`
+	EndsAt        *time.Time  `json:"ends_at,omitempty"`
+
+	// Last status delivered to the webhook, and when
+	NotifiedStatus AlertStatus `json:"notified_status,omitempty"`
+	LastNotifiedAt *time.Time  `json:"last_notified_at,omitempty"`
+}
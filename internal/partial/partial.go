@@ -0,0 +1,225 @@
+// Package partial holds the windows of llm.metrics flushed before they
+// completed, by a window stage shutting down, until the rest of them arrives
+// from the next owner, so that consumers evaluate whole windows.
+package partial
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"streamlens/internal/models"
+	"sync"
+	"time"
+)
+
+// HoldTimeout is how long after its end a partial window waits for its
+// remainder; a window without events after the restart has none
+const HoldTimeout = 10 * time.Minute
+
+// Window is a window to evaluate, with the partition it was read from
+type Window struct {
+	Metrics   *models.LLMMetrics
+	Partition int32
+}
+
+// held is a partial window with the last record offset merged into it
+type held struct {
+	Metrics   *models.LLMMetrics `json:"metrics"`
+	Partition int32              `json:"partition"`
+	Offset    int64              `json:"offset"`
+}
+
+// Windows holds partial windows by series until their remainder arrives. It
+// is safe for concurrent use.
+type Windows struct {
+	held  map[string][]*held // by series, oldest first
+	dirty map[int32]bool     // partitions changed since they were saved
+	mu    sync.Mutex
+}
+
+// NewWindows creates an empty set of held windows
+func NewWindows() *Windows {
+	return &Windows{
+		held:  make(map[string][]*held),
+		dirty: make(map[int32]bool),
+	}
+}
+
+func seriesKey(m *models.LLMMetrics) string {
+	return fmt.Sprintf("%s|%s|%s", m.TenantID, m.Route, m.Model)
+}
+
+// Add takes a window read from partition at offset and returns the windows
+// to evaluate, oldest first. A partial window is held, merged with any later
+// part of the same window; a complete one releases the held part of its
+// window merged into it, and the older held windows of its series as they
+// are. Records at or before the offset of a held window of their series were
+// merged already and are dropped.
+func (w *Windows) Add(m *models.LLMMetrics, partition int32, offset int64) ([]Window, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := seriesKey(m)
+	windows := w.held[key]
+	for _, h := range windows {
+		if h.Partition == partition && offset <= h.Offset {
+			return nil, nil
+		}
+	}
+
+	var ready []Window
+	var kept []*held
+	var same *held
+	for _, h := range windows {
+		switch {
+		case h.Metrics.WindowStart.Equal(m.WindowStart):
+			same = h
+		case h.Metrics.WindowStart.Before(m.WindowStart) && !m.Partial:
+			ready = append(ready, Window{Metrics: h.Metrics, Partition: h.Partition})
+		default:
+			kept = append(kept, h)
+		}
+	}
+
+	if same != nil {
+		if err := same.Metrics.Merge(m); err != nil {
+			return nil, fmt.Errorf("failed to merge partial window: %w", err)
+		}
+		same.Metrics.Partial = m.Partial
+		same.Offset = offset
+		m = same.Metrics
+	}
+	if m.Partial {
+		if same == nil {
+			same = &held{Metrics: m, Partition: partition, Offset: offset}
+		}
+		kept = append(kept, same)
+		sort.Slice(kept, func(i, j int) bool { return kept[i].Metrics.WindowStart.Before(kept[j].Metrics.WindowStart) })
+	} else {
+		ready = append(ready, Window{Metrics: m, Partition: partition})
+	}
+
+	if len(windows) > 0 || m.Partial {
+		w.dirty[partition] = true
+	}
+	if len(kept) == 0 {
+		delete(w.held, key)
+	} else {
+		w.held[key] = kept
+	}
+	return ready, nil
+}
+
+// Expire releases the held windows that ended before cutoff, to evaluate as
+// they are
+func (w *Windows) Expire(cutoff time.Time) []Window {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var ready []Window
+	for key, windows := range w.held {
+		var kept []*held
+		for _, h := range windows {
+			if h.Metrics.WindowEnd.Before(cutoff) {
+				ready = append(ready, Window{Metrics: h.Metrics, Partition: h.Partition})
+				w.dirty[h.Partition] = true
+			} else {
+				kept = append(kept, h)
+			}
+		}
+		if len(kept) == 0 {
+			delete(w.held, key)
+		} else {
+			w.held[key] = kept
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool { return ready[i].Metrics.WindowStart.Before(ready[j].Metrics.WindowStart) })
+	return ready
+}
+
+// Dirty returns the partitions whose held windows changed since they were
+// last saved
+func (w *Windows) Dirty() []int32 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	partitions := make([]int32, 0, len(w.dirty))
+	for p := range w.dirty {
+		partitions = append(partitions, p)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	return partitions
+}
+
+// Entries returns the held windows of a partition encoded as processor
+// state, to be saved before offsets move past them
+func (w *Windows) Entries(partition int32) (map[string][]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	entries := make(map[string][]byte)
+	for key, windows := range w.held {
+		for _, h := range windows {
+			if h.Partition != partition {
+				continue
+			}
+			data, err := json.Marshal(h)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode partial window %s: %w", key, err)
+			}
+			entries[fmt.Sprintf("%s|%d", key, h.Metrics.WindowStart.Unix())] = data
+		}
+	}
+	return entries, nil
+}
+
+// Saved marks the held windows of a partition as saved
+func (w *Windows) Saved(partition int32) {
+	w.mu.Lock()
+	delete(w.dirty, partition)
+	w.mu.Unlock()
+}
+
+// Restore adds held windows saved by the previous owner of a partition. They
+// are marked changed, to be saved again by their new owner.
+func (w *Windows) Restore(partition int32, entries map[string][]byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for key, data := range entries {
+		var h held
+		if err := json.Unmarshal(data, &h); err != nil {
+			return fmt.Errorf("failed to decode partial window %s: %w", key, err)
+		}
+		series := seriesKey(h.Metrics)
+		w.held[series] = append(w.held[series], &h)
+		sort.Slice(w.held[series], func(i, j int) bool {
+			return w.held[series][i].Metrics.WindowStart.Before(w.held[series][j].Metrics.WindowStart)
+		})
+		w.dirty[partition] = true
+	}
+	return nil
+}
+
+// Drop forgets the held windows of the given partitions
+func (w *Windows) Drop(partitions map[int32]bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for key, windows := range w.held {
+		var kept []*held
+		for _, h := range windows {
+			if !partitions[h.Partition] {
+				kept = append(kept, h)
+			}
+		}
+		if len(kept) == 0 {
+			delete(w.held, key)
+		} else {
+			w.held[key] = kept
+		}
+	}
+	for p := range partitions {
+		delete(w.dirty, p)
+	}
+}
@@ -0,0 +1,155 @@
+package partial
+
+import (
+	"streamlens/internal/models"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+
+func window(minute, requests int, partial bool) *models.LLMMetrics {
+	ws := start.Add(time.Duration(minute) * time.Minute)
+	return &models.LLMMetrics{TenantID: "tenant-1", Route: "chat", Model: "gpt-4",
+		WindowStart: ws, WindowEnd: ws.Add(time.Minute), Requests: requests, Partial: partial}
+}
+
+// requests returns the start minute and requests of each window
+func requests(windows []Window) [][2]int {
+	var out [][2]int
+	for _, w := range windows {
+		out = append(out, [2]int{int(w.Metrics.WindowStart.Sub(start).Minutes()), w.Metrics.Requests})
+	}
+	return out
+}
+
+func equal(a, b [][2]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWindows_Add(t *testing.T) {
+	type add struct {
+		m      *models.LLMMetrics
+		offset int64
+		want   [][2]int
+	}
+	tests := []struct {
+		name string
+		adds []add
+	}{
+		{
+			name: "complete windows pass through",
+			adds: []add{
+				{window(0, 10, false), 0, [][2]int{{0, 10}}},
+				{window(1, 20, false), 1, [][2]int{{1, 20}}},
+			},
+		},
+		{
+			name: "partial window is merged with its remainder",
+			adds: []add{
+				{window(0, 10, true), 0, nil},
+				{window(0, 30, false), 1, [][2]int{{0, 40}}},
+			},
+		},
+		{
+			name: "partial parts are merged until the window completes",
+			adds: []add{
+				{window(0, 10, true), 0, nil},
+				{window(0, 5, true), 1, nil},
+				{window(0, 30, false), 2, [][2]int{{0, 45}}},
+			},
+		},
+		{
+			name: "later window releases an older partial one",
+			adds: []add{
+				{window(0, 10, true), 0, nil},
+				{window(1, 20, false), 1, [][2]int{{0, 10}, {1, 20}}},
+			},
+		},
+		{
+			name: "redelivered records are dropped",
+			adds: []add{
+				{window(0, 10, true), 3, nil},
+				{window(0, 10, true), 3, nil},
+				{window(0, 30, false), 4, [][2]int{{0, 40}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWindows()
+			for i, a := range tt.adds {
+				got, err := w.Add(a.m, 0, a.offset)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !equal(requests(got), a.want) {
+					t.Errorf("add %d released %v, want %v", i, requests(got), a.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWindows_Expire(t *testing.T) {
+	w := NewWindows()
+	for i, m := range []*models.LLMMetrics{window(0, 10, true), window(5, 20, true)} {
+		if _, err := w.Add(m, 0, int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := requests(w.Expire(start.Add(3 * time.Minute))); !equal(got, [][2]int{{0, 10}}) {
+		t.Errorf("expired %v, want the first window", got)
+	}
+	if got := requests(w.Expire(start.Add(3 * time.Minute))); got != nil {
+		t.Errorf("expired %v again", got)
+	}
+}
+
+func TestWindows_Handoff(t *testing.T) {
+	w := NewWindows()
+	if _, err := w.Add(window(0, 10, true), 2, 7); err != nil {
+		t.Fatal(err)
+	}
+	if dirty := w.Dirty(); len(dirty) != 1 || dirty[0] != 2 {
+		t.Fatalf("dirty = %v, want partition 2", dirty)
+	}
+	entries, err := w.Entries(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Saved(2)
+	w.Drop(map[int32]bool{2: true})
+	if dirty := w.Dirty(); len(dirty) != 0 {
+		t.Errorf("dirty = %v after save and drop", dirty)
+	}
+
+	next := NewWindows()
+	if err := next.Restore(2, entries); err != nil {
+		t.Fatal(err)
+	}
+	if dirty := next.Dirty(); len(dirty) != 1 || dirty[0] != 2 {
+		t.Errorf("dirty = %v after restore, want partition 2", dirty)
+	}
+	// The restored part keeps its offset, so its record is not merged twice
+	if got, err := next.Add(window(0, 10, true), 2, 7); err != nil || got != nil {
+		t.Errorf("redelivered part released %v, %v", requests(got), err)
+	}
+	got, err := next.Add(window(0, 30, false), 2, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(requests(got), [][2]int{{0, 40}}) || got[0].Partition != 2 {
+		t.Errorf("released %v, want the merged window of partition 2", requests(got))
+	}
+}
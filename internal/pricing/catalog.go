@@ -0,0 +1,219 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"streamlens/internal/models"
+	"sync"
+	"time"
+)
+
+// DefaultPrice applies when no catalog entry matches an event. It keeps the
+// historical flat rate of $0.01/1K prompt and $0.03/1K completion tokens.
+var DefaultPrice = models.ModelPrice{
+	Model:                 models.WildcardModel,
+	InputPerMillion:       10,
+	OutputPerMillion:      30,
+	CachedInputPerMillion: 10,
+}
+
+// PriceLister loads price entries from a persistent source
+type PriceLister interface {
+	ListModelPrices(ctx context.Context) ([]models.ModelPrice, error)
+}
+
+// priceFile is the layout of the pricing file
+type priceFile struct {
+	Prices []models.ModelPrice `json:"prices"`
+}
+
+// priceKey identifies a price series; entries of a series differ only by
+// their effective date
+type priceKey struct {
+	tenantID string
+	provider string
+	model    string
+}
+
+// Catalog resolves the price of a call from a JSON file and the model_prices
+// table. Both sources are reloaded periodically; a failed reload keeps the
+// previous prices.
+type Catalog struct {
+	path     string
+	lister   PriceLister
+	interval time.Duration
+
+	mu      sync.RWMutex
+	index   map[priceKey][]models.ModelPrice // sorted by EffectiveFrom
+	entries int
+
+	// Last successfully parsed file, reused while the file is unchanged
+	fileModTime time.Time
+	filePrices  []models.ModelPrice
+}
+
+// NewCatalog creates a catalog and performs the initial load. path and lister
+// are both optional; without either every call is priced at DefaultPrice.
+func NewCatalog(ctx context.Context, path string, lister PriceLister, interval time.Duration) (*Catalog, error) {
+	c := &Catalog{
+		path:     path,
+		lister:   lister,
+		interval: interval,
+		index:    make(map[priceKey][]models.ModelPrice),
+	}
+	if err := c.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Run reloads the catalog every interval until ctx is cancelled
+func (c *Catalog) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Reload(ctx); err != nil {
+				log.Printf("Failed to reload pricing catalog, keeping previous prices: %v", err)
+			}
+		}
+	}
+}
+
+// Reload re-reads the pricing file (when it changed) and the price table. It
+// is called from a single goroutine; lookups may run concurrently.
+func (c *Catalog) Reload(ctx context.Context) error {
+	filePrices, fileChanged, err := c.loadFile()
+	if err != nil {
+		return err
+	}
+
+	var tablePrices []models.ModelPrice
+	if c.lister != nil {
+		tablePrices, err = c.lister.ListModelPrices(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list model prices: %w", err)
+		}
+	}
+
+	// Table entries come last so they win ties on effective date
+	index := buildIndex(append(append([]models.ModelPrice{}, filePrices...), tablePrices...))
+
+	entries := len(filePrices) + len(tablePrices)
+
+	c.mu.Lock()
+	changed := fileChanged || entries != c.entries
+	c.index = index
+	c.entries = entries
+	c.mu.Unlock()
+
+	if changed {
+		log.Printf("Loaded pricing catalog: %d file entries, %d table entries", len(filePrices), len(tablePrices))
+	}
+	return nil
+}
+
+// loadFile parses the pricing file if it changed since the last load
+func (c *Catalog) loadFile() ([]models.ModelPrice, bool, error) {
+	if c.path == "" {
+		return nil, false, nil
+	}
+
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to stat pricing file: %w", err)
+	}
+	if info.ModTime().Equal(c.fileModTime) {
+		return c.filePrices, false, nil
+	}
+
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read pricing file: %w", err)
+	}
+
+	var file priceFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, false, fmt.Errorf("failed to parse pricing file: %w", err)
+	}
+	for i, p := range file.Prices {
+		if p.Model == "" {
+			return nil, false, fmt.Errorf("pricing file entry %d: missing model", i)
+		}
+	}
+
+	c.fileModTime = info.ModTime()
+	c.filePrices = file.Prices
+	return file.Prices, true, nil
+}
+
+// buildIndex groups prices into series. Provider-specific prices are also
+// indexed without the provider so events that omit it still match by model.
+func buildIndex(prices []models.ModelPrice) map[priceKey][]models.ModelPrice {
+	index := make(map[priceKey][]models.ModelPrice)
+	for _, p := range prices {
+		key := priceKey{tenantID: p.TenantID, provider: p.Provider, model: p.Model}
+		index[key] = append(index[key], p)
+		if p.Provider != "" {
+			anyProvider := priceKey{tenantID: p.TenantID, model: p.Model}
+			index[anyProvider] = append(index[anyProvider], p)
+		}
+	}
+
+	for key := range index {
+		series := index[key]
+		sort.SliceStable(series, func(i, j int) bool {
+			return series[i].EffectiveFrom.Before(series[j].EffectiveFrom)
+		})
+	}
+	return index
+}
+
+// Lookup returns the price effective at ts. Tenant overrides take precedence
+// over list prices, exact provider matches over model-only matches, and
+// model-specific prices over wildcard ones.
+func (c *Catalog) Lookup(tenantID, provider, model string, ts time.Time) models.ModelPrice {
+	candidates := []priceKey{
+		{tenantID: tenantID, provider: provider, model: model},
+		{tenantID: tenantID, model: model},
+		{provider: provider, model: model},
+		{model: model},
+		{tenantID: tenantID, model: models.WildcardModel},
+		{model: models.WildcardModel},
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, key := range candidates {
+		if p, ok := effectiveAt(c.index[key], ts); ok {
+			return p
+		}
+	}
+	return DefaultPrice
+}
+
+// Cost returns the USD cost of a call at the price effective at ts
+func (c *Catalog) Cost(tenantID, provider, model string, ts time.Time, usage models.TokenUsage) float64 {
+	price := c.Lookup(tenantID, provider, model, ts)
+	return price.Cost(usage)
+}
+
+// effectiveAt returns the latest entry of series effective at or before ts
+func effectiveAt(series []models.ModelPrice, ts time.Time) (models.ModelPrice, bool) {
+	i := sort.Search(len(series), func(i int) bool {
+		return series[i].EffectiveFrom.After(ts)
+	})
+	if i == 0 {
+		return models.ModelPrice{}, false
+	}
+	return series[i-1], true
+}
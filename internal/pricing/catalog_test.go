@@ -0,0 +1,75 @@
+package pricing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"streamlens/internal/models"
+	"testing"
+	"time"
+)
+
+type staticLister []models.ModelPrice
+
+func (l staticLister) ListModelPrices(ctx context.Context) ([]models.ModelPrice, error) {
+	return l, nil
+}
+
+func TestCatalog_Lookup(t *testing.T) {
+	jan := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	jun := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	path := filepath.Join(t.TempDir(), "pricing.json")
+	file := `{"prices": [
+		{"provider": "openai", "model": "gpt-4.1", "input_per_million": 2, "output_per_million": 8, "effective_from": "2025-01-01T00:00:00Z"},
+		{"provider": "openai", "model": "gpt-4.1", "input_per_million": 1, "output_per_million": 4, "effective_from": "2025-06-01T00:00:00Z"},
+		{"model": "*", "input_per_million": 5, "output_per_million": 15, "effective_from": "2024-01-01T00:00:00Z"}
+	]}`
+	if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	table := staticLister{
+		{Provider: "openai", Model: "gpt-4.1", TenantID: "acme", InputPerMillion: 0.5, OutputPerMillion: 2, EffectiveFrom: jun},
+	}
+
+	c, err := NewCatalog(context.Background(), path, table, time.Minute)
+	if err != nil {
+		t.Fatalf("NewCatalog() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		tenant    string
+		provider  string
+		model     string
+		ts        time.Time
+		wantInput float64
+	}{
+		{name: "list price before change", tenant: "t1", provider: "openai", model: "gpt-4.1", ts: jan.Add(time.Hour), wantInput: 2},
+		{name: "list price after change", tenant: "t1", provider: "openai", model: "gpt-4.1", ts: jun.Add(time.Hour), wantInput: 1},
+		{name: "model match without provider", tenant: "t1", model: "gpt-4.1", ts: jun.Add(time.Hour), wantInput: 1},
+		{name: "tenant override", tenant: "acme", provider: "openai", model: "gpt-4.1", ts: jun.Add(time.Hour), wantInput: 0.5},
+		{name: "tenant override not yet effective", tenant: "acme", provider: "openai", model: "gpt-4.1", ts: jan.Add(time.Hour), wantInput: 2},
+		{name: "wildcard", tenant: "t1", model: "unknown", ts: jan, wantInput: 5},
+		{name: "before any price", tenant: "t1", model: "gpt-4.1", ts: jan.AddDate(-5, 0, 0), wantInput: DefaultPrice.InputPerMillion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := c.Lookup(tt.tenant, tt.provider, tt.model, tt.ts)
+			if got.InputPerMillion != tt.wantInput {
+				t.Errorf("Lookup() input price = %v, want %v", got.InputPerMillion, tt.wantInput)
+			}
+		})
+	}
+}
+
+func TestModelPrice_Cost(t *testing.T) {
+	price := models.ModelPrice{InputPerMillion: 2, OutputPerMillion: 8, CachedInputPerMillion: 0.5}
+	got := price.Cost(models.TokenUsage{PromptTokens: 1_000_000, CachedPromptTokens: 400_000, CompletionTokens: 500_000})
+	// 600K uncached * $2 + 400K cached * $0.5 + 500K output * $8
+	if want := 1.2 + 0.2 + 4.0; got != want {
+		t.Errorf("Cost() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,81 @@
+package processor
+
+import (
+	"context"
+	"streamlens/internal/clock"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/sink"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// The stages depend on the small interfaces below rather than on concrete
+// clients, so they can be driven by the in-memory fakes of processortest.
+
+// Consumer reads a stage's input topics; see kafka.Consumer
+type Consumer interface {
+	Group() string
+	SetRebalanceListener(l kafka.RebalanceListener)
+	Poll(ctx context.Context) kgo.Fetches
+	AllowRebalance()
+	CommitRecords(ctx context.Context, records ...*kgo.Record) error
+	Rewind(record *kgo.Record)
+}
+
+// Producer produces joined events; see kafka.Producer
+type Producer interface {
+	ProduceJSON(ctx context.Context, topic, key string, value interface{}) error
+}
+
+// StateStore hands open state over to the next owner of a partition on
+// rebalance; see store.MetricsStore
+type StateStore interface {
+	SaveProcessorState(ctx context.Context, group, topic string, partition int32, entries map[string][]byte) error
+	TakeProcessorState(ctx context.Context, group, topic string, partition int32) (map[string][]byte, error)
+}
+
+// WindowStore is the StateStore of the window stage, which also creates the
+// tables of window definitions
+type WindowStore interface {
+	StateStore
+	EnsureWindowTable(ctx context.Context, table string) error
+}
+
+// DeadLetterer takes records a stage cannot process; see dlq.Publisher
+type DeadLetterer interface {
+	Publish(ctx context.Context, record *kgo.Record, cause error) error
+}
+
+// WindowWriter writes flushed windows to the sinks; see sink.Fanout
+type WindowWriter interface {
+	Write(ctx context.Context, windows []*sink.Window, delivered []map[string]bool) []error
+}
+
+// SpendRecorder counts the spend of flushed windows against budgets, once
+// per flush ID; see budget.Tracker
+type SpendRecorder interface {
+	Record(ctx context.Context, flushID string, m *models.LLMMetrics) error
+}
+
+// topicPartition identifies an input partition
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// partitionOf returns the input partition of a record
+func partitionOf(record *kgo.Record) topicPartition {
+	return topicPartition{topic: record.Topic, partition: record.Partition}
+}
+
+// waitRewind pauses polling after a partition was rewound to a record that
+// could not be dead-lettered, until it is retried or ctx is done
+func waitRewind(ctx context.Context, c clock.Clock) {
+	ticker := c.NewTicker(kafka.RewindBackoff)
+	defer ticker.Stop()
+	select {
+	case <-ctx.Done():
+	case <-ticker.C():
+	}
+}
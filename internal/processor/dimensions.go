@@ -0,0 +1,150 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"streamlens/internal/models"
+	"streamlens/internal/store"
+	"sync"
+	"time"
+)
+
+// DimensionResolver maps request metadata to the dimensions a tenant
+// promoted. Each promoted key tracks at most its MaxValues distinct values,
+// shared across instances through the dimension_values table; values seen
+// after the cap is reached are reported as models.DimensionOther.
+type DimensionResolver struct {
+	store    *store.MetricsStore
+	interval time.Duration
+
+	dimensions map[string][]models.TenantDimension // by tenant
+	admitted   map[string]map[string]bool          // by tenant|key, then value
+	full       map[string]bool                     // tenant|key pairs at their cap
+	mu         sync.RWMutex
+}
+
+// NewDimensionResolver creates a DimensionResolver that re-reads promoted
+// keys and admitted values every interval
+func NewDimensionResolver(store *store.MetricsStore, interval time.Duration) *DimensionResolver {
+	return &DimensionResolver{
+		store:      store,
+		interval:   interval,
+		dimensions: make(map[string][]models.TenantDimension),
+		admitted:   make(map[string]map[string]bool),
+		full:       make(map[string]bool),
+	}
+}
+
+// Reload re-reads promoted keys and admitted values from Postgres
+func (r *DimensionResolver) Reload(ctx context.Context) error {
+	all, err := r.store.ListTenantDimensions(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to list tenant dimensions: %w", err)
+	}
+	values, err := r.store.ListDimensionValues(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list dimension values: %w", err)
+	}
+
+	dimensions := make(map[string][]models.TenantDimension)
+	admitted := make(map[string]map[string]bool)
+	full := make(map[string]bool)
+	for _, d := range all {
+		dimensions[d.TenantID] = append(dimensions[d.TenantID], d)
+
+		key := dimensionKey(d.TenantID, d.Key)
+		admitted[key] = make(map[string]bool)
+		for _, v := range values[d.TenantID][d.Key] {
+			admitted[key][v] = true
+		}
+		full[key] = len(admitted[key]) >= d.MaxValues
+	}
+
+	r.mu.Lock()
+	r.dimensions = dimensions
+	r.admitted = admitted
+	r.full = full
+	r.mu.Unlock()
+	return nil
+}
+
+// Run periodically reloads until ctx is cancelled
+func (r *DimensionResolver) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Reload(ctx); err != nil {
+				log.Printf("Failed to reload dimensions, keeping previous dimensions: %v", err)
+			}
+		}
+	}
+}
+
+// Resolve returns the dimensions of a request, nil when its tenant promoted
+// no keys. Keys missing from the metadata have an empty value, so every
+// request of such a tenant lands in a dimensioned window.
+func (r *DimensionResolver) Resolve(ctx context.Context, req *models.LLMRequest) models.Dimensions {
+	r.mu.RLock()
+	promoted := r.dimensions[req.TenantID]
+	r.mu.RUnlock()
+	if len(promoted) == 0 {
+		return nil
+	}
+
+	dims := make(models.Dimensions, len(promoted))
+	for _, d := range promoted {
+		value, ok := models.DimensionValue(req.Metadata[d.Key])
+		if !ok {
+			dims[d.Key] = ""
+			continue
+		}
+		dims[d.Key] = r.admit(ctx, d, value)
+	}
+	return dims
+}
+
+// admit returns value if it is, or can be, admitted for the key and
+// models.DimensionOther otherwise
+func (r *DimensionResolver) admit(ctx context.Context, d models.TenantDimension, value string) string {
+	key := dimensionKey(d.TenantID, d.Key)
+
+	r.mu.RLock()
+	known, full := r.admitted[key][value], r.full[key]
+	r.mu.RUnlock()
+	if known {
+		return value
+	}
+	if full {
+		return models.DimensionOther
+	}
+
+	ok, err := r.store.AdmitDimensionValue(ctx, d.TenantID, d.Key, value, d.MaxValues)
+	if err != nil {
+		log.Printf("Failed to admit %s value for tenant %s: %v", d.Key, d.TenantID, err)
+		return models.DimensionOther
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.admitted[key] == nil {
+		// Reloaded without the key in the meantime
+		return models.DimensionOther
+	}
+	if !ok {
+		r.full[key] = true
+		return models.DimensionOther
+	}
+	r.admitted[key][value] = true
+	return value
+}
+
+// dimensionKey identifies a promoted key of a tenant
+func dimensionKey(tenantID, key string) string {
+	return tenantID + "|" + key
+}
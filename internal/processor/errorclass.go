@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"streamlens/internal/models"
+	"strings"
+)
+
+// maxFinishReasons bounds the distinct finish reasons tracked per window;
+// further reasons are counted as "other"
+const maxFinishReasons = 16
+
+// ErrorRule maps provider error messages matching Pattern to Class
+type ErrorRule struct {
+	Class   models.ErrorClass
+	Pattern *regexp.Regexp
+}
+
+// DefaultErrorRules classify common provider error messages. They are tried
+// in order, after any configured rules.
+var DefaultErrorRules = []ErrorRule{
+	{Class: models.ErrorClassRateLimited, Pattern: regexp.MustCompile(`(?i)\b429\b|rate.?limit|too many requests|quota|throttl`)},
+	{Class: models.ErrorClassTimeout, Pattern: regexp.MustCompile(`(?i)time[ds]?.?out|deadline exceeded|\b408\b|\b504\b`)},
+	{Class: models.ErrorClassContentFilter, Pattern: regexp.MustCompile(`(?i)content.?(filter|policy|management)|safety|moderation|flagged`)},
+	{Class: models.ErrorClassInvalidRequest, Pattern: regexp.MustCompile(`(?i)\b40[0134]\b|\b413\b|\b422\b|invalid|bad request|context.?length|too long|unauthori[sz]ed|not found`)},
+	{Class: models.ErrorClassServerError, Pattern: regexp.MustCompile(`(?i)\b5\d\d\b|server error|internal error|overloaded|unavailable|bad gateway`)},
+}
+
+// ErrorClassifier maps provider error strings to normalized error classes
+type ErrorClassifier struct {
+	rules []ErrorRule
+}
+
+// NewErrorClassifier creates a classifier from a spec of semicolon-separated
+// class=regexp rules, matched case-insensitively. Configured rules take
+// precedence over DefaultErrorRules; unmatched errors are classed as other.
+//
+// Example: "rate_limited=capacity exceeded;server_error=upstream connect error"
+func NewErrorClassifier(spec string) (*ErrorClassifier, error) {
+	var rules []ErrorRule
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, pattern, ok := strings.Cut(entry, "=")
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("error rule %q: expected class=regexp", entry)
+		}
+		class, ok := models.ParseErrorClass(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("error rule %q: unknown class %q", entry, name)
+		}
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("error rule %q: %w", entry, err)
+		}
+		rules = append(rules, ErrorRule{Class: class, Pattern: re})
+	}
+
+	return &ErrorClassifier{rules: append(rules, DefaultErrorRules...)}, nil
+}
+
+// Classify returns the class of the first rule matching msg
+func (c *ErrorClassifier) Classify(msg string) models.ErrorClass {
+	for _, rule := range c.rules {
+		if rule.Pattern.MatchString(msg) {
+			return rule.Class
+		}
+	}
+	return models.ErrorClassOther
+}
+
+// normalizeFinishReason lowercases a provider finish_reason
+func normalizeFinishReason(reason string) string {
+	return strings.ToLower(strings.TrimSpace(reason))
+}
@@ -0,0 +1,68 @@
+package processor
+
+import (
+	"streamlens/internal/models"
+	"testing"
+)
+
+func TestErrorClassifier_Classify(t *testing.T) {
+	classifier, err := NewErrorClassifier("server_error=upstream connect error; rate_limited=capacity exceeded")
+	if err != nil {
+		t.Fatalf("NewErrorClassifier() error = %v", err)
+	}
+
+	tests := []struct {
+		msg  string
+		want models.ErrorClass
+	}{
+		{msg: "Error 429: Too Many Requests", want: models.ErrorClassRateLimited},
+		{msg: "Rate limit reached for gpt-4 in organization", want: models.ErrorClassRateLimited},
+		{msg: "Model capacity exceeded, retry later", want: models.ErrorClassRateLimited},
+		{msg: "context deadline exceeded", want: models.ErrorClassTimeout},
+		{msg: "Request timed out", want: models.ErrorClassTimeout},
+		{msg: "The response was filtered due to the prompt triggering content management policy", want: models.ErrorClassContentFilter},
+		{msg: "This model's maximum context length is 8192 tokens", want: models.ErrorClassInvalidRequest},
+		{msg: "400 Bad Request", want: models.ErrorClassInvalidRequest},
+		{msg: "503 Service Unavailable", want: models.ErrorClassServerError},
+		{msg: "upstream connect error or disconnect/reset before headers", want: models.ErrorClassServerError},
+		{msg: "something unexpected", want: models.ErrorClassOther},
+	}
+
+	for _, tt := range tests {
+		if got := classifier.Classify(tt.msg); got != tt.want {
+			t.Errorf("Classify(%q) = %s, want %s", tt.msg, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"timeout", "slow=timed out", "timeout=("} {
+		if _, err := NewErrorClassifier(spec); err == nil {
+			t.Errorf("NewErrorClassifier(%q) expected error", spec)
+		}
+	}
+}
+
+func TestWindowAggregate_FinishReasons(t *testing.T) {
+	def := &DefaultWindow
+	req := &models.LLMRequest{TenantID: "tenant-1", Route: "chat", Model: "gpt-4"}
+	agg := newWindowAggregate(def, req, "", windowSpan{}, 0)
+
+	errMsg := "timeout"
+	agg.add(req, &models.LLMResponse{FinishReason: "STOP"}, 0, "")
+	agg.add(req, &models.LLMResponse{FinishReason: "length"}, 0, "")
+	agg.add(req, &models.LLMResponse{Error: &errMsg}, 0, models.ErrorClassTimeout)
+
+	if agg.Errors != 1 || agg.ErrorClasses["timeout"] != 1 {
+		t.Errorf("Errors = %d, ErrorClasses = %v", agg.Errors, agg.ErrorClasses)
+	}
+	if agg.FinishReasons["stop"] != 1 || agg.FinishReasons["length"] != 1 || len(agg.FinishReasons) != 2 {
+		t.Errorf("FinishReasons = %v, want stop:1 length:1", agg.FinishReasons)
+	}
+
+	// Reasons beyond the cap are folded into other
+	for i := 0; i < maxFinishReasons+5; i++ {
+		agg.addFinishReason(string(rune('a'+i)), 1)
+	}
+	if len(agg.FinishReasons) > maxFinishReasons+1 {
+		t.Errorf("tracked %d finish reasons, want at most %d", len(agg.FinishReasons), maxFinishReasons+1)
+	}
+}
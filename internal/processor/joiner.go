@@ -0,0 +1,597 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"streamlens/internal/clock"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/spill"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Joiner is the first stage of the processor topology. It consumes
+// llm.requests and llm.responses, which are co-partitioned by request_id,
+// matches each request with its response and produces the joined event to
+// llm.joined keyed by tenant|route|model. Repartitioning by window key means
+// every window is owned by exactly one MetricsProcessor instance.
+type Joiner struct {
+	consumer Consumer
+	producer Producer
+	store    StateStore
+	dlq      DeadLetterer
+	clock    clock.Clock
+
+	// In-memory state for joining requests and responses, with the input
+	// partition each pending entry was read from
+	requestState    map[string]*models.LLMRequest
+	responseState   map[string]*models.LLMResponse
+	statePartitions map[string]int32
+	stateMu         sync.RWMutex
+
+	// Pending entries beyond maxState are spilled to disk, those of the
+	// tenants holding the most entries first
+	spill    *spill.Store
+	maxState int
+
+	// Requests still unmatched after responseTimeout are abandoned, and also
+	// published to llm.abandoned if publishAbandoned is set
+	responseTimeout  time.Duration
+	publishAbandoned bool
+}
+
+// ExpiryInterval is how often the join state is checked for abandoned
+// requests and expired responses
+const ExpiryInterval = 15 * time.Second
+
+// NewJoiner creates the join stage and registers it for rebalance callbacks.
+// A maxState of 0 keeps all pending entries in memory. A responseTimeout of
+// 0 abandons requests after StateRetentionDuration.
+func NewJoiner(consumer Consumer, producer Producer, store StateStore, dlq DeadLetterer, spill *spill.Store, maxState int, responseTimeout time.Duration, publishAbandoned bool) *Joiner {
+	if responseTimeout <= 0 {
+		responseTimeout = StateRetentionDuration
+	}
+	j := &Joiner{
+		consumer:         consumer,
+		producer:         producer,
+		store:            store,
+		dlq:              dlq,
+		clock:            clock.Real,
+		requestState:     make(map[string]*models.LLMRequest),
+		responseState:    make(map[string]*models.LLMResponse),
+		statePartitions:  make(map[string]int32),
+		spill:            spill,
+		maxState:         maxState,
+		responseTimeout:  responseTimeout,
+		publishAbandoned: publishAbandoned,
+	}
+	consumer.SetRebalanceListener(j)
+	return j
+}
+
+// SetClock replaces the system clock, which expires pending state. It must be
+// called before Run.
+func (j *Joiner) SetClock(c clock.Clock) {
+	j.clock = c
+}
+
+// Run starts the join stage
+func (j *Joiner) Run(ctx context.Context) error {
+	log.Println("Starting joiner...")
+
+	// Start background goroutine for state cleanup
+	go j.cleanupState(ctx)
+
+	// Main consume loop
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Context cancelled, stopping joiner...")
+			return ctx.Err()
+		default:
+			fetches := j.consumer.Poll(ctx)
+
+			if errs := fetches.Errors(); len(errs) > 0 {
+				for _, err := range errs {
+					log.Printf("Fetch error: %v", err.Err)
+				}
+				j.consumer.AllowRebalance()
+				continue
+			}
+
+			// Process records
+			var recordsToCommit []*kgo.Record
+			rewound := make(map[topicPartition]bool)
+			fetches.EachRecord(func(record *kgo.Record) {
+				if rewound[partitionOf(record)] {
+					return
+				}
+				if err := j.processRecord(ctx, record); err != nil {
+					// Unprocessable records go to the dead-letter topic; only
+					// commit past them once they are safely there
+					if dlqErr := j.dlq.Publish(ctx, record, err); dlqErr != nil {
+						log.Printf("Error processing record: %v (dead-letter failed, retrying: %v)", err, dlqErr)
+						j.consumer.Rewind(record)
+						rewound[partitionOf(record)] = true
+						return
+					}
+				}
+				recordsToCommit = append(recordsToCommit, record)
+			})
+
+			// Commit offsets
+			if len(recordsToCommit) > 0 {
+				if err := j.consumer.CommitRecords(ctx, recordsToCommit...); err != nil {
+					log.Printf("Failed to commit offsets: %v", err)
+				}
+			}
+
+			j.consumer.AllowRebalance()
+			if len(rewound) > 0 {
+				waitRewind(ctx, j.clock)
+			}
+		}
+	}
+}
+
+// processRecord handles a single Kafka record
+func (j *Joiner) processRecord(ctx context.Context, record *kgo.Record) error {
+	switch record.Topic {
+	case kafka.TopicLLMRequests:
+		return j.processRequest(ctx, record)
+	case kafka.TopicLLMResponses:
+		return j.processResponse(ctx, record)
+	default:
+		return fmt.Errorf("unknown topic: %s", record.Topic)
+	}
+}
+
+// processRequest joins a request with a waiting response, or stores it in state
+func (j *Joiner) processRequest(ctx context.Context, record *kgo.Record) error {
+	var req models.LLMRequest
+	if err := json.Unmarshal(record.Value, &req); err != nil {
+		return fmt.Errorf("failed to unmarshal request: %w", err)
+	}
+
+	j.stateMu.Lock()
+	resp, found := j.responseState[req.RequestID]
+	if found {
+		j.removeLocked(req.RequestID)
+	} else {
+		var spilled models.LLMResponse
+		if found = j.takeSpilledLocked(spillResponses, req.RequestID, &spilled); found {
+			resp = &spilled
+		}
+	}
+	if found {
+		joinEvents.WithLabelValues(joinJoined).Inc()
+	} else {
+		j.requestState[req.RequestID] = &req
+		j.statePartitions[req.RequestID] = record.Partition
+		j.spillLocked()
+	}
+	j.observeStateLocked()
+	j.stateMu.Unlock()
+
+	if !found {
+		return nil
+	}
+	return j.emit(ctx, &req, resp)
+}
+
+// processResponse joins a response with a waiting request, or stores it in state
+func (j *Joiner) processResponse(ctx context.Context, record *kgo.Record) error {
+	var resp models.LLMResponse
+	if err := json.Unmarshal(record.Value, &resp); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	j.stateMu.Lock()
+	req, found := j.requestState[resp.RequestID]
+	if found {
+		j.removeLocked(resp.RequestID)
+	} else {
+		var spilled models.LLMRequest
+		if found = j.takeSpilledLocked(spillRequests, resp.RequestID, &spilled); found {
+			req = &spilled
+		}
+	}
+	if found {
+		joinEvents.WithLabelValues(joinJoined).Inc()
+	} else {
+		j.responseState[resp.RequestID] = &resp
+		j.statePartitions[resp.RequestID] = record.Partition
+		j.spillLocked()
+	}
+	j.observeStateLocked()
+	j.stateMu.Unlock()
+
+	if !found {
+		return nil
+	}
+	return j.emit(ctx, req, &resp)
+}
+
+// emit produces a joined event, repartitioned by window key
+func (j *Joiner) emit(ctx context.Context, req *models.LLMRequest, resp *models.LLMResponse) error {
+	event := models.JoinedEvent{Request: *req, Response: *resp}
+	if err := j.producer.ProduceJSON(ctx, kafka.TopicLLMJoined, event.WindowKey(), &event); err != nil {
+		return fmt.Errorf("failed to produce joined event: %w", err)
+	}
+	return nil
+}
+
+// removeLocked drops all state for a request ID. Callers must hold stateMu.
+func (j *Joiner) removeLocked(requestID string) {
+	delete(j.requestState, requestID)
+	delete(j.responseState, requestID)
+	delete(j.statePartitions, requestID)
+}
+
+// cleanupState periodically abandons unmatched requests and removes old
+// responses
+func (j *Joiner) cleanupState(ctx context.Context) {
+	ticker := j.clock.NewTicker(ExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			j.expireState(ctx)
+		}
+	}
+}
+
+// expireState abandons pending requests older than the response timeout and
+// drops responses older than the state retention, which will not be joined
+// anymore
+func (j *Joiner) expireState(ctx context.Context) {
+	now := j.clock.Now()
+	requestCutoff := now.Add(-j.responseTimeout)
+	responseCutoff := now.Add(-StateRetentionDuration)
+
+	j.stateMu.Lock()
+
+	// Abandon unmatched requests
+	var abandoned []*models.LLMRequest
+	for id, req := range j.requestState {
+		if req.Timestamp.Before(requestCutoff) {
+			j.removeLocked(id)
+			abandoned = append(abandoned, req)
+		}
+	}
+
+	// Clean old responses
+	for id, resp := range j.responseState {
+		if resp.Timestamp.Before(responseCutoff) {
+			j.removeLocked(id)
+			joinEvents.WithLabelValues(joinExpiredResponse).Inc()
+		}
+	}
+
+	j.observeStateLocked()
+	j.stateMu.Unlock()
+
+	abandoned = append(abandoned, j.expireSpilled(requestCutoff, responseCutoff)...)
+	for _, req := range abandoned {
+		j.abandon(ctx, req)
+	}
+}
+
+// abandon produces a request that got no response to llm.joined, to be
+// counted in its windows, and to llm.abandoned if enabled. The request is
+// out of the join state already, so a failure only loses its count.
+func (j *Joiner) abandon(ctx context.Context, req *models.LLMRequest) {
+	joinEvents.WithLabelValues(joinAbandoned).Inc()
+
+	event := models.JoinedEvent{Request: *req, Abandoned: true}
+	if err := j.producer.ProduceJSON(ctx, kafka.TopicLLMJoined, event.WindowKey(), &event); err != nil {
+		log.Printf("Failed to produce abandoned request %s: %v", req.RequestID, err)
+	}
+	if !j.publishAbandoned {
+		return
+	}
+	abandoned := models.AbandonedRequest{Request: *req, DetectedAt: j.clock.Now()}
+	if err := j.producer.ProduceJSON(ctx, kafka.TopicLLMAbandoned, event.WindowKey(), &abandoned); err != nil {
+		log.Printf("Failed to publish abandoned request %s: %v", req.RequestID, err)
+	}
+}
+
+// OnPartitionsRevoked saves pending requests and responses of the revoked
+// partitions so the next owner can still join them
+func (j *Joiner) OnPartitionsRevoked(ctx context.Context, revoked map[string][]int32) {
+	j.stateMu.Lock()
+	defer j.stateMu.Unlock()
+
+	for topic, partitions := range revoked {
+		for _, partition := range partitions {
+			j.unspillPartitionLocked(topic, partition, false)
+
+			entries := make(map[string][]byte)
+			var ids []string
+			for id, p := range j.statePartitions {
+				if p != partition {
+					continue
+				}
+				var value interface{}
+				if topic == kafka.TopicLLMRequests {
+					if req, ok := j.requestState[id]; ok {
+						value = req
+					}
+				} else if resp, ok := j.responseState[id]; ok {
+					value = resp
+				}
+				if value == nil {
+					continue
+				}
+				data, err := json.Marshal(value)
+				if err != nil {
+					log.Printf("Failed to encode join state for %s: %v", id, err)
+					continue
+				}
+				entries[id] = data
+				ids = append(ids, id)
+			}
+
+			if err := j.store.SaveProcessorState(ctx, j.consumer.Group(), topic, partition, entries); err != nil {
+				log.Printf("Failed to hand off join state for %s[%d], dropping %d entries: %v", topic, partition, len(entries), err)
+			} else if len(entries) > 0 {
+				log.Printf("Handed off %d pending events of %s[%d]", len(entries), topic, partition)
+			}
+
+			for _, id := range ids {
+				if topic == kafka.TopicLLMRequests {
+					delete(j.requestState, id)
+				} else {
+					delete(j.responseState, id)
+				}
+				if _, ok := j.requestState[id]; !ok {
+					if _, ok := j.responseState[id]; !ok {
+						delete(j.statePartitions, id)
+					}
+				}
+			}
+		}
+	}
+	j.observeStateLocked()
+}
+
+// OnPartitionsAssigned restores pending requests and responses handed off by
+// the previous owner of the assigned partitions
+func (j *Joiner) OnPartitionsAssigned(ctx context.Context, assigned map[string][]int32) {
+	for topic, partitions := range assigned {
+		for _, partition := range partitions {
+			entries, err := j.store.TakeProcessorState(ctx, j.consumer.Group(), topic, partition)
+			if err != nil {
+				log.Printf("Failed to restore join state for %s[%d]: %v", topic, partition, err)
+				continue
+			}
+			if len(entries) == 0 {
+				continue
+			}
+
+			j.stateMu.Lock()
+			for id, data := range entries {
+				var err error
+				if topic == kafka.TopicLLMRequests {
+					var req models.LLMRequest
+					if err = json.Unmarshal(data, &req); err == nil {
+						j.requestState[id] = &req
+					}
+				} else {
+					var resp models.LLMResponse
+					if err = json.Unmarshal(data, &resp); err == nil {
+						j.responseState[id] = &resp
+					}
+				}
+				if err != nil {
+					log.Printf("Failed to decode join state for %s: %v", id, err)
+					continue
+				}
+				j.statePartitions[id] = partition
+			}
+			j.spillLocked()
+			j.observeStateLocked()
+			j.stateMu.Unlock()
+
+			log.Printf("Restored %d pending events of %s[%d]", len(entries), topic, partition)
+		}
+	}
+}
+
+// OnPartitionsLost drops pending state of partitions lost without a handoff
+func (j *Joiner) OnPartitionsLost(ctx context.Context, lost map[string][]int32) {
+	j.stateMu.Lock()
+	defer j.stateMu.Unlock()
+
+	for topic, partitions := range lost {
+		for _, partition := range partitions {
+			j.unspillPartitionLocked(topic, partition, true)
+			for id, p := range j.statePartitions {
+				if p == partition {
+					j.removeLocked(id)
+				}
+			}
+			log.Printf("Lost %s[%d], dropped its pending join state", topic, partition)
+		}
+	}
+	j.observeStateLocked()
+}
+
+// spillLocked moves the oldest pending entries to disk once the join state
+// exceeds its budget. Responses carry no tenant and are spilled as one group;
+// most arrive after their request and never wait. Callers must hold stateMu.
+func (j *Joiner) spillLocked() {
+	n := spillExcess(len(j.requestState)+len(j.responseState), j.maxState)
+	if j.spill == nil || n == 0 {
+		return
+	}
+
+	entries := make([]coldEntry, 0, len(j.requestState)+len(j.responseState))
+	for id, req := range j.requestState {
+		entries = append(entries, coldEntry{key: id, tenant: req.TenantID, age: req.Timestamp})
+	}
+	for id, resp := range j.responseState {
+		entries = append(entries, coldEntry{key: id, age: resp.Timestamp})
+	}
+	ids, tenants := selectSpill(entries, n)
+
+	batches := map[string]map[string][]byte{
+		spillRequests:  make(map[string][]byte),
+		spillResponses: make(map[string][]byte),
+	}
+	for _, id := range ids {
+		bucket, value := spillRequests, interface{}(j.requestState[id])
+		if resp, ok := j.responseState[id]; ok {
+			bucket, value = spillResponses, resp
+		}
+		data, err := encodeSpilled(j.statePartitions[id], value)
+		if err != nil {
+			log.Printf("Failed to encode join state for %s: %v", id, err)
+			continue
+		}
+		batches[bucket][id] = data
+	}
+
+	for bucket, batch := range batches {
+		if len(batch) == 0 {
+			continue
+		}
+		if err := j.spill.Put(bucket, batch); err != nil {
+			log.Printf("Failed to spill join state, keeping it in memory: %v", err)
+			continue
+		}
+		for id := range batch {
+			j.removeLocked(id)
+		}
+	}
+	log.Printf("Join state over %d entries, spilled %d to disk (by tenant: %v)", j.maxState, len(ids), tenants)
+	j.observeStateLocked()
+}
+
+// takeSpilledLocked removes a pending entry from disk, decoding it into v.
+// Callers must hold stateMu.
+func (j *Joiner) takeSpilledLocked(bucket, id string, v interface{}) bool {
+	if j.spill == nil || j.spill.Len(bucket) == 0 {
+		return false
+	}
+	data, ok, err := j.spill.Take(bucket, id)
+	if err != nil {
+		log.Printf("Failed to read spilled join state for %s: %v", id, err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	e, err := decodeSpilled(data)
+	if err == nil {
+		err = json.Unmarshal(e.Value, v)
+	}
+	if err != nil {
+		log.Printf("Failed to decode spilled join state for %s: %v", id, err)
+		return false
+	}
+	return true
+}
+
+// unspillPartitionLocked moves the spilled entries of a partition back into
+// memory, or drops them. Callers must hold stateMu.
+func (j *Joiner) unspillPartitionLocked(topic string, partition int32, drop bool) {
+	bucket := spillResponses
+	if topic == kafka.TopicLLMRequests {
+		bucket = spillRequests
+	}
+	if j.spill == nil || j.spill.Len(bucket) == 0 {
+		return
+	}
+
+	taken, err := j.spill.TakeAll(bucket, func(_ string, data []byte) bool {
+		e, err := decodeSpilled(data)
+		return err == nil && e.Partition == partition
+	})
+	if err != nil {
+		log.Printf("Failed to read spilled join state of %s[%d]: %v", topic, partition, err)
+		return
+	}
+	if drop {
+		return
+	}
+
+	for id, data := range taken {
+		e, err := decodeSpilled(data)
+		if err == nil {
+			if bucket == spillRequests {
+				var req models.LLMRequest
+				if err = json.Unmarshal(e.Value, &req); err == nil {
+					j.requestState[id] = &req
+				}
+			} else {
+				var resp models.LLMResponse
+				if err = json.Unmarshal(e.Value, &resp); err == nil {
+					j.responseState[id] = &resp
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to decode spilled join state for %s: %v", id, err)
+			continue
+		}
+		j.statePartitions[id] = partition
+	}
+}
+
+// expireSpilled removes spilled requests older than requestCutoff, returning
+// them to be abandoned, and drops spilled responses older than responseCutoff
+func (j *Joiner) expireSpilled(requestCutoff, responseCutoff time.Time) []*models.LLMRequest {
+	if j.spill == nil {
+		return nil
+	}
+	olderThan := func(cutoff time.Time) func(string, []byte) bool {
+		return func(_ string, data []byte) bool {
+			var e struct {
+				Value struct {
+					Timestamp time.Time `json:"timestamp"`
+				} `json:"value"`
+			}
+			return json.Unmarshal(data, &e) != nil || e.Value.Timestamp.Before(cutoff)
+		}
+	}
+
+	var abandoned []*models.LLMRequest
+	if j.spill.Len(spillRequests) > 0 {
+		expired, err := j.spill.TakeAll(spillRequests, olderThan(requestCutoff))
+		if err != nil {
+			log.Printf("Failed to expire spilled join state: %v", err)
+		}
+		for id, data := range expired {
+			var req models.LLMRequest
+			e, err := decodeSpilled(data)
+			if err == nil {
+				err = json.Unmarshal(e.Value, &req)
+			}
+			if err != nil {
+				log.Printf("Failed to decode spilled join state for %s: %v", id, err)
+				continue
+			}
+			abandoned = append(abandoned, &req)
+		}
+	}
+
+	if j.spill.Len(spillResponses) > 0 {
+		expired, err := j.spill.TakeAll(spillResponses, olderThan(responseCutoff))
+		if err != nil {
+			log.Printf("Failed to expire spilled join state: %v", err)
+		} else if len(expired) > 0 {
+			joinEvents.WithLabelValues(joinExpiredResponse).Add(float64(len(expired)))
+			log.Printf("Expired %d spilled responses", len(expired))
+		}
+	}
+	return abandoned
+}
@@ -0,0 +1,289 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"streamlens/internal/clock"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/processor/processortest"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// testJoiner is a join stage wired to in-memory fakes
+type testJoiner struct {
+	*Joiner
+	consumer *processortest.Consumer
+	producer *processortest.Producer
+	dlq      *processortest.DLQ
+	clock    *clock.Fake
+}
+
+func newTestJoiner(store *processortest.Store) *testJoiner {
+	tj := &testJoiner{
+		consumer: processortest.NewConsumer("test"),
+		producer: &processortest.Producer{},
+		dlq:      &processortest.DLQ{},
+		clock:    clock.NewFake(testStart),
+	}
+	tj.Joiner = NewJoiner(tj.consumer, tj.producer, store, tj.dlq, nil, 0, 2*time.Minute, true)
+	tj.SetClock(tj.clock)
+	return tj
+}
+
+// half is one side of a call, read from partition 0 at testStart+at
+type half struct {
+	request bool
+	id      string
+	at      time.Duration
+}
+
+func (h half) record(t *testing.T) *kgo.Record {
+	t.Helper()
+	ts := testStart.Add(h.at)
+	var (
+		topic string
+		value interface{}
+	)
+	if h.request {
+		topic = kafka.TopicLLMRequests
+		value = models.LLMRequest{RequestID: h.id, TenantID: "tenant-1", Route: "/chat", Model: "gpt-4o", Timestamp: ts}
+	} else {
+		topic = kafka.TopicLLMResponses
+		value = models.LLMResponse{RequestID: h.id, Timestamp: ts, LatencyMs: 100, FinishReason: "stop"}
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &kgo.Record{Topic: topic, Key: []byte(h.id), Value: data}
+}
+
+func request(id string, at time.Duration) half  { return half{request: true, id: id, at: at} }
+func response(id string, at time.Duration) half { return half{id: id, at: at} }
+
+// joinedIDs returns the request IDs of the produced joined events, and of
+// the abandoned requests among them
+func (tj *testJoiner) joinedIDs(t *testing.T) (joined, abandoned []string) {
+	t.Helper()
+	for _, m := range tj.producer.Messages(kafka.TopicLLMJoined) {
+		var ev models.JoinedEvent
+		if err := json.Unmarshal(m.Value, &ev); err != nil {
+			t.Fatal(err)
+		}
+		if m.Key != ev.WindowKey() {
+			t.Errorf("joined event %s keyed %s", ev.Request.RequestID, m.Key)
+		}
+		if ev.Abandoned {
+			abandoned = append(abandoned, ev.Request.RequestID)
+			continue
+		}
+		if ev.Request.RequestID != ev.Response.RequestID {
+			t.Errorf("request %s joined with response %s", ev.Request.RequestID, ev.Response.RequestID)
+		}
+		joined = append(joined, ev.Request.RequestID)
+	}
+	return joined, abandoned
+}
+
+func (tj *testJoiner) pending() int {
+	tj.stateMu.RLock()
+	defer tj.stateMu.RUnlock()
+	return len(tj.requestState) + len(tj.responseState)
+}
+
+func TestJoiner_Join(t *testing.T) {
+	tests := []struct {
+		name        string
+		halves      []half
+		wantJoined  []string
+		wantPending int
+	}{
+		{
+			name:       "request then response",
+			halves:     []half{request("a", 0), response("a", time.Second)},
+			wantJoined: []string{"a"},
+		},
+		{
+			name:       "response first",
+			halves:     []half{response("a", time.Second), request("a", 0)},
+			wantJoined: []string{"a"},
+		},
+		{
+			name:       "interleaved",
+			halves:     []half{request("a", 0), request("b", time.Second), response("b", 2*time.Second), response("a", 3*time.Second)},
+			wantJoined: []string{"b", "a"},
+		},
+		{
+			// A redelivered response waits for a request that never comes
+			name:        "duplicate response",
+			halves:      []half{request("a", 0), response("a", time.Second), response("a", time.Second)},
+			wantJoined:  []string{"a"},
+			wantPending: 1,
+		},
+		{
+			name:       "duplicate request before the response",
+			halves:     []half{request("a", 0), request("a", 0), response("a", time.Second)},
+			wantJoined: []string{"a"},
+		},
+		{
+			name:        "unmatched",
+			halves:      []half{request("a", 0), response("b", time.Second)},
+			wantPending: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tj := newTestJoiner(processortest.NewStore())
+			for _, h := range tt.halves {
+				if err := tj.processRecord(context.Background(), h.record(t)); err != nil {
+					t.Fatalf("processRecord() error = %v", err)
+				}
+			}
+
+			joined, _ := tj.joinedIDs(t)
+			if len(joined) != len(tt.wantJoined) {
+				t.Fatalf("joined %v, want %v", joined, tt.wantJoined)
+			}
+			for i := range joined {
+				if joined[i] != tt.wantJoined[i] {
+					t.Fatalf("joined %v, want %v", joined, tt.wantJoined)
+				}
+			}
+			if got := tj.pending(); got != tt.wantPending {
+				t.Errorf("pending = %d, want %d", got, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestJoiner_Abandoned(t *testing.T) {
+	ctx := context.Background()
+	tj := newTestJoiner(processortest.NewStore())
+	for _, h := range []half{request("lost", 0), request("slow", 90*time.Second), response("orphan", 0)} {
+		if err := tj.processRecord(ctx, h.record(t)); err != nil {
+			t.Fatalf("processRecord() error = %v", err)
+		}
+	}
+
+	// Only requests older than the response timeout are abandoned; the
+	// orphan response waits for the state retention
+	tj.clock.Advance(2*time.Minute + time.Second)
+	tj.expireState(ctx)
+	if got := tj.pending(); got != 2 {
+		t.Fatalf("pending = %d after the response timeout, want 2", got)
+	}
+	if _, abandoned := tj.joinedIDs(t); len(abandoned) != 1 || abandoned[0] != "lost" {
+		t.Fatalf("abandoned %v, want [lost]", abandoned)
+	}
+	published := tj.producer.Messages(kafka.TopicLLMAbandoned)
+	if len(published) != 1 {
+		t.Fatalf("published %d abandoned requests, want 1", len(published))
+	}
+	var ev models.AbandonedRequest
+	if err := json.Unmarshal(published[0].Value, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Request.RequestID != "lost" || !ev.DetectedAt.Equal(tj.clock.Now()) {
+		t.Errorf("published %s detected at %s", ev.Request.RequestID, ev.DetectedAt)
+	}
+
+	// A response after the timeout no longer joins; one in time still does
+	for _, h := range []half{response("lost", 150*time.Second), response("slow", 150*time.Second)} {
+		if err := tj.processRecord(ctx, h.record(t)); err != nil {
+			t.Fatalf("processRecord() error = %v", err)
+		}
+	}
+	if joined, _ := tj.joinedIDs(t); len(joined) != 1 || joined[0] != "slow" {
+		t.Errorf("joined %v, want [slow]", joined)
+	}
+
+	// Unmatched responses expire after the state retention
+	tj.clock.Advance(StateRetentionDuration + time.Minute)
+	tj.expireState(ctx)
+	if got := tj.pending(); got != 0 {
+		t.Errorf("pending = %d after the state retention, want 0", got)
+	}
+}
+
+func TestJoiner_Run(t *testing.T) {
+	tj := newTestJoiner(processortest.NewStore())
+	tj.consumer.Add(request("a", 0).record(t), response("a", time.Second).record(t))
+	tj.consumer.Add(
+		&kgo.Record{Topic: kafka.TopicLLMRequests, Value: []byte("{")},
+		&kgo.Record{Topic: "llm.unknown", Value: []byte("{}")},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- tj.Run(ctx) }()
+	<-tj.consumer.Idle()
+	cancel()
+	<-done
+
+	if joined, _ := tj.joinedIDs(t); len(joined) != 1 {
+		t.Errorf("joined %v, want [a]", joined)
+	}
+	if letters := tj.dlq.Letters(); len(letters) != 2 {
+		t.Errorf("dead-lettered %d records, want 2", len(letters))
+	}
+	if committed := tj.consumer.Committed(); len(committed) != 4 {
+		t.Errorf("committed %d records, want 4", len(committed))
+	}
+}
+
+func TestJoiner_DeadLetterFailure(t *testing.T) {
+	tj := newTestJoiner(processortest.NewStore())
+	tj.dlq.SetError(errors.New("dlq unavailable"))
+	malformed := &kgo.Record{Topic: kafka.TopicLLMRequests, Value: []byte("{")}
+	after := request("a", 0).record(t)
+	after.Offset = 1
+	tj.consumer.Add(malformed, after)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- tj.Run(ctx) }()
+	tj.consumer.IdleAdvancing(tj.clock, kafka.RewindBackoff)
+	cancel()
+	<-done
+
+	if rewound := tj.consumer.Rewound(); len(rewound) != 1 || rewound[0] != malformed {
+		t.Errorf("rewound to %v, want the malformed record", rewound)
+	}
+	if committed := tj.consumer.Committed(); len(committed) != 0 {
+		t.Errorf("committed %d records past the malformed one", len(committed))
+	}
+	if got := tj.pending(); got != 0 {
+		t.Errorf("pending = %d, want the request after the malformed one unprocessed", got)
+	}
+}
+
+func TestJoiner_Handoff(t *testing.T) {
+	ctx := context.Background()
+	store := processortest.NewStore()
+	partitions := map[string][]int32{kafka.TopicLLMRequests: {0}, kafka.TopicLLMResponses: {0}}
+
+	first := newTestJoiner(store)
+	if err := first.processRecord(ctx, request("a", 0).record(t)); err != nil {
+		t.Fatalf("processRecord() error = %v", err)
+	}
+	first.consumer.Revoke(ctx, partitions)
+	if got := first.pending(); got != 0 {
+		t.Errorf("revoked joiner kept %d pending entries", got)
+	}
+
+	// The next owner joins the handed-off request
+	next := newTestJoiner(store)
+	next.consumer.Assign(ctx, partitions)
+	if err := next.processRecord(ctx, response("a", time.Second).record(t)); err != nil {
+		t.Fatalf("processRecord() error = %v", err)
+	}
+	if joined, _ := next.joinedIDs(t); len(joined) != 1 || joined[0] != "a" {
+		t.Errorf("joined %v, want [a]", joined)
+	}
+}
@@ -0,0 +1,96 @@
+package processor
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Spill buckets, one per kind of state
+const (
+	spillRequests  = "request"
+	spillResponses = "response"
+	spillWindows   = "window"
+)
+
+// spillTarget is the share of a budget state is spilled down to once it is
+// exceeded, so that spilling happens in batches rather than per event
+const spillTarget = 0.9
+
+// spilledEntry is the on-disk form of a state entry, with the input
+// partition that owns it
+type spilledEntry struct {
+	Partition int32           `json:"partition"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// coldEntry is an in-memory state entry that may be spilled
+type coldEntry struct {
+	key    string
+	tenant string
+	age    time.Time // older entries are spilled first
+}
+
+// selectSpill picks n entries to spill. It repeatedly takes the oldest entry
+// of the tenant with the most entries left in memory, so a single tenant's
+// spike is spilled before the state of any other tenant. It returns the keys
+// and the number picked per tenant.
+func selectSpill(entries []coldEntry, n int) ([]string, map[string]int) {
+	byTenant := make(map[string][]coldEntry)
+	for _, e := range entries {
+		byTenant[e.tenant] = append(byTenant[e.tenant], e)
+	}
+	tenants := make([]string, 0, len(byTenant))
+	for tenant, es := range byTenant {
+		sort.Slice(es, func(i, j int) bool { return es[i].age.Before(es[j].age) })
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+
+	var keys []string
+	picked := make(map[string]int)
+	for len(keys) < n {
+		largest := ""
+		for _, tenant := range tenants {
+			if len(byTenant[tenant]) > len(byTenant[largest]) {
+				largest = tenant
+			}
+		}
+		es := byTenant[largest]
+		if len(es) == 0 {
+			break
+		}
+		keys = append(keys, es[0].key)
+		byTenant[largest] = es[1:]
+		picked[largest]++
+	}
+	return keys, picked
+}
+
+// spillExcess returns how many of size entries to spill under a budget of
+// max, zero when the budget is not exceeded or disabled
+func spillExcess(size, max int) int {
+	if max <= 0 || size <= max {
+		return 0
+	}
+	return size - int(float64(max)*spillTarget)
+}
+
+// encodeSpilled encodes a state value for the spill store
+func encodeSpilled(partition int32, v interface{}) ([]byte, error) {
+	value, ok := v.([]byte)
+	if !ok {
+		var err error
+		if value, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(spilledEntry{Partition: partition, Value: value})
+}
+
+// decodeSpilled decodes an entry of the spill store
+func decodeSpilled(data []byte) (spilledEntry, error) {
+	var e spilledEntry
+	err := json.Unmarshal(data, &e)
+	return e, err
+}
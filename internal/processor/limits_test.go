@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSelectSpill(t *testing.T) {
+	base := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+	entry := func(key, tenant string, minute int) coldEntry {
+		return coldEntry{key: key, tenant: tenant, age: base.Add(time.Duration(minute) * time.Minute)}
+	}
+
+	// noisy holds five entries, quiet two
+	entries := []coldEntry{
+		entry("n3", "noisy", 3),
+		entry("n1", "noisy", 1),
+		entry("q0", "quiet", 0),
+		entry("n5", "noisy", 5),
+		entry("n2", "noisy", 2),
+		entry("q1", "quiet", 1),
+		entry("n4", "noisy", 4),
+	}
+
+	tests := []struct {
+		n         int
+		wantKeys  []string
+		wantNoisy int
+	}{
+		{n: 0, wantKeys: nil},
+		{n: 2, wantKeys: []string{"n1", "n2"}, wantNoisy: 2},
+		// Once noisy is down to quiet's size they take turns
+		{n: 5, wantKeys: []string{"n1", "n2", "n3", "n4", "q0"}, wantNoisy: 4},
+		{n: 10, wantKeys: []string{"n1", "n2", "n3", "n4", "n5", "q0", "q1"}, wantNoisy: 5},
+	}
+
+	for _, tt := range tests {
+		keys, picked := selectSpill(entries, tt.n)
+		sort.Strings(keys)
+		if len(keys) != len(tt.wantKeys) {
+			t.Errorf("selectSpill(%d) = %v, want %v", tt.n, keys, tt.wantKeys)
+			continue
+		}
+		for i := range keys {
+			if keys[i] != tt.wantKeys[i] {
+				t.Errorf("selectSpill(%d) = %v, want %v", tt.n, keys, tt.wantKeys)
+				break
+			}
+		}
+		if picked["noisy"] != tt.wantNoisy {
+			t.Errorf("selectSpill(%d) picked %d of noisy, want %d", tt.n, picked["noisy"], tt.wantNoisy)
+		}
+	}
+}
+
+func TestSpillExcess(t *testing.T) {
+	tests := []struct {
+		size, max, want int
+	}{
+		{size: 100, max: 0, want: 0},
+		{size: 100, max: 100, want: 0},
+		{size: 101, max: 100, want: 11},
+	}
+	for _, tt := range tests {
+		if got := spillExcess(tt.size, tt.max); got != tt.want {
+			t.Errorf("spillExcess(%d, %d) = %d, want %d", tt.size, tt.max, got, tt.want)
+		}
+	}
+}
@@ -6,9 +6,13 @@ import (
 	"fmt"
 	"log"
 	"sort"
+	"streamlens/internal/clock"
 	"streamlens/internal/kafka"
 	"streamlens/internal/models"
-	"streamlens/internal/store"
+	"streamlens/internal/pricing"
+	"streamlens/internal/sink"
+	"streamlens/internal/sketch"
+	"streamlens/internal/spill"
 	"sync"
 	"time"
 
@@ -20,65 +24,111 @@ const (
 	WindowDuration = 1 * time.Minute
 	// StateRetentionDuration is how long to keep request/response pairs in memory
 	StateRetentionDuration = 5 * time.Minute
+	// FlushGracePeriod is how long after a window ends it stays open for late events
+	FlushGracePeriod = 1 * time.Minute
+	// MinFlushInterval bounds how often windows are checked for flushing
+	MinFlushInterval = 5 * time.Second
 )
 
-// MetricsProcessor handles stream processing of LLM events
+// MetricsProcessor is the second stage of the processor topology. It consumes
+// joined events from llm.joined, which is partitioned by tenant|route|model,
+// aggregates them into windows and flushes completed windows. Each window is
+// owned by the instance assigned its partition; on rebalance, open windows
+// are handed to the next owner through the processor_state table.
 type MetricsProcessor struct {
-	consumer *kafka.Consumer
-	producer *kafka.Producer
-	store    *store.MetricsStore
-
-	// In-memory state for joining requests and responses
-	requestState  map[string]*models.LLMRequest
-	responseState map[string]*models.LLMResponse
-	stateMu       sync.RWMutex
+	consumer   Consumer
+	sinks      WindowWriter
+	store      WindowStore
+	pricing    *pricing.Catalog
+	classifier *ErrorClassifier
+	dlq        DeadLetterer
+	budgets    SpendRecorder
+	dimensions *DimensionResolver
+	windows    []WindowDefinition
+	clock      clock.Clock
+
+	// Windowed aggregation state. Fixed windows are keyed by definition, group
+	// and start; sessions are keyed by definition and group, holding every
+	// open session for that group.
+	windowAggregates  map[string]*WindowAggregate
+	sessionAggregates map[string][]*WindowAggregate
+	windowMu          sync.RWMutex
+
+	// Fixed windows beyond maxWindows are spilled to disk, those of the
+	// tenants with the most open windows first
+	spill      *spill.Store
+	maxWindows int
+
+	// How often windows are checked for flushing. flushMu is held by a flush
+	// while its windows are out of memory, so that revoked partitions are
+	// handed off only once their windows are written or back.
+	flushInterval time.Duration
+	flushMu       sync.Mutex
+}
 
-	// Windowed aggregation state
-	windowAggregates map[string]*WindowAggregate
-	windowMu         sync.RWMutex
+// NewMetricsProcessor creates a new metrics processor and registers it for
+// rebalance callbacks. windows must include the definitions to aggregate; see
+// ParseWindowDefinitions. A maxWindows of 0 keeps all windows in memory.
+func NewMetricsProcessor(consumer Consumer, sinks WindowWriter, store WindowStore, pricing *pricing.Catalog, classifier *ErrorClassifier, dlq DeadLetterer, budgets SpendRecorder, dimensions *DimensionResolver, windows []WindowDefinition, spill *spill.Store, maxWindows int) *MetricsProcessor {
+	// Tick often enough for the finest window to close on time
+	tick := WindowDuration
+	for i := range windows {
+		if interval := windows[i].flushInterval(); interval < tick {
+			tick = interval
+		}
+	}
+	if tick < MinFlushInterval {
+		tick = MinFlushInterval
+	}
 
-	// Ticker for window processing
-	windowTicker *time.Ticker
+	p := &MetricsProcessor{
+		consumer:          consumer,
+		sinks:             sinks,
+		store:             store,
+		pricing:           pricing,
+		classifier:        classifier,
+		dlq:               dlq,
+		budgets:           budgets,
+		dimensions:        dimensions,
+		windows:           windows,
+		clock:             clock.Real,
+		windowAggregates:  make(map[string]*WindowAggregate),
+		sessionAggregates: make(map[string][]*WindowAggregate),
+		spill:             spill,
+		maxWindows:        maxWindows,
+		flushInterval:     tick,
+	}
+	consumer.SetRebalanceListener(p)
+	return p
 }
 
-// WindowAggregate holds aggregated data for a time window
-type WindowAggregate struct {
-	TenantID    string
-	Route       string
-	Model       string
-	WindowStart time.Time
-	WindowEnd   time.Time
-
-	Requests     int
-	Errors       int
-	Latencies    []int // For percentile calculation
-	PromptTokens []int
-	CompTokens   []int
+// SetClock replaces the system clock, which decides when windows are
+// complete. It must be called before Run.
+func (p *MetricsProcessor) SetClock(c clock.Clock) {
+	p.clock = c
 }
 
-// NewMetricsProcessor creates a new metrics processor
-func NewMetricsProcessor(consumer *kafka.Consumer, producer *kafka.Producer, store *store.MetricsStore) *MetricsProcessor {
-	return &MetricsProcessor{
-		consumer:         consumer,
-		producer:         producer,
-		store:            store,
-		requestState:     make(map[string]*models.LLMRequest),
-		responseState:    make(map[string]*models.LLMResponse),
-		windowAggregates: make(map[string]*WindowAggregate),
-		windowTicker:     time.NewTicker(WindowDuration),
+// EnsureTables creates the Postgres tables for non-default window definitions
+func (p *MetricsProcessor) EnsureTables(ctx context.Context) error {
+	for _, def := range p.windows {
+		if def.Name == DefaultWindowName {
+			continue
+		}
+		if err := p.store.EnsureWindowTable(ctx, def.Table); err != nil {
+			return fmt.Errorf("window %s: %w", def.Name, err)
+		}
 	}
+	return nil
 }
 
-// Run starts the metrics processor
+// Run starts the metrics processor. It stops polling once ctx is done and
+// returns after the records already fetched are processed and committed.
 func (p *MetricsProcessor) Run(ctx context.Context) error {
 	log.Println("Starting metrics processor...")
 
 	// Start background goroutine for window processing
 	go p.processWindows(ctx)
 
-	// Start background goroutine for state cleanup
-	go p.cleanupState(ctx)
-
 	// Main consume loop
 	for {
 		select {
@@ -92,168 +142,363 @@ func (p *MetricsProcessor) Run(ctx context.Context) error {
 				for _, err := range errs {
 					log.Printf("Fetch error: %v", err.Err)
 				}
+				p.consumer.AllowRebalance()
 				continue
 			}
 
+			// Records in hand are processed and committed even once ctx is
+			// done, so that Drain flushes them with the open windows
+			batchCtx := context.WithoutCancel(ctx)
+
 			// Process records
 			var recordsToCommit []*kgo.Record
+			rewound := make(map[topicPartition]bool)
 			fetches.EachRecord(func(record *kgo.Record) {
-				if err := p.processRecord(ctx, record); err != nil {
-					log.Printf("Error processing record: %v", err)
-				} else {
-					recordsToCommit = append(recordsToCommit, record)
+				if rewound[partitionOf(record)] {
+					return
+				}
+				if err := p.processRecord(batchCtx, record); err != nil {
+					// Unprocessable records go to the dead-letter topic; only
+					// commit past them once they are safely there
+					if dlqErr := p.dlq.Publish(batchCtx, record, err); dlqErr != nil {
+						log.Printf("Error processing record: %v (dead-letter failed, retrying: %v)", err, dlqErr)
+						p.consumer.Rewind(record)
+						rewound[partitionOf(record)] = true
+						return
+					}
 				}
+				recordsToCommit = append(recordsToCommit, record)
 			})
 
 			// Commit offsets
 			if len(recordsToCommit) > 0 {
-				if err := p.consumer.CommitRecords(ctx, recordsToCommit...); err != nil {
+				if err := p.consumer.CommitRecords(batchCtx, recordsToCommit...); err != nil {
 					log.Printf("Failed to commit offsets: %v", err)
 				}
 			}
+
+			p.consumer.AllowRebalance()
+			if len(rewound) > 0 {
+				waitRewind(ctx, p.clock)
+			}
 		}
 	}
 }
 
 // processRecord handles a single Kafka record
 func (p *MetricsProcessor) processRecord(ctx context.Context, record *kgo.Record) error {
-	switch record.Topic {
-	case kafka.TopicLLMRequests:
-		return p.processRequest(record)
-	case kafka.TopicLLMResponses:
-		return p.processResponse(ctx, record)
-	default:
+	if record.Topic != kafka.TopicLLMJoined {
 		return fmt.Errorf("unknown topic: %s", record.Topic)
 	}
-}
 
-// processRequest stores a request in state
-func (p *MetricsProcessor) processRequest(record *kgo.Record) error {
-	var req models.LLMRequest
-	if err := json.Unmarshal(record.Value, &req); err != nil {
-		return fmt.Errorf("failed to unmarshal request: %w", err)
+	var event models.JoinedEvent
+	if err := json.Unmarshal(record.Value, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal joined event: %w", err)
 	}
 
-	p.stateMu.Lock()
-	p.requestState[req.RequestID] = &req
-	p.stateMu.Unlock()
+	// Aggregate into windows, per promoted metadata value as well
+	resp := &event.Response
+	if event.Abandoned {
+		resp = nil
+	}
+	dims := p.dimensions.Resolve(ctx, &event.Request)
+	p.aggregateEvent(&event.Request, resp, dims, record.Partition)
 
 	return nil
 }
 
-// processResponse joins with request and aggregates into windows
-func (p *MetricsProcessor) processResponse(ctx context.Context, record *kgo.Record) error {
-	var resp models.LLMResponse
-	if err := json.Unmarshal(record.Value, &resp); err != nil {
-		return fmt.Errorf("failed to unmarshal response: %w", err)
+// aggregateEvent adds an event to the matching windows of every definition.
+// The default definition also adds it to the windows across routes and
+// models, and with non-empty dims to a dimensioned window; other definitions
+// only keep tenant totals. A nil resp adds an abandoned request, which is
+// neither priced nor classified.
+func (p *MetricsProcessor) aggregateEvent(req *models.LLMRequest, resp *models.LLMResponse, dims models.Dimensions, partition int32) {
+	var (
+		cost  float64
+		class models.ErrorClass
+	)
+	if resp != nil {
+		// Price the call once, at the price effective when it was made
+		cost = p.pricing.Cost(req.TenantID, req.Provider, req.Model, req.Timestamp, models.TokenUsage{
+			PromptTokens:       req.PromptTokens,
+			CachedPromptTokens: resp.CachedPromptTokens,
+			CompletionTokens:   resp.CompletionTokens,
+		})
+
+		// Classify failures once for every window
+		if resp.Error != nil && *resp.Error != "" {
+			class = p.classifier.Classify(*resp.Error)
+		}
 	}
 
-	// Store response
-	p.stateMu.Lock()
-	p.responseState[resp.RequestID] = &resp
-	p.stateMu.Unlock()
+	p.windowMu.Lock()
+	defer p.windowMu.Unlock()
 
-	// Try to join with request
-	p.stateMu.RLock()
-	req, found := p.requestState[resp.RequestID]
-	p.stateMu.RUnlock()
+	for i := range p.windows {
+		def := &p.windows[i]
+		if def.Type == WindowSession {
+			p.aggregateSession(def, req, resp, cost, class, partition)
+			continue
+		}
 
-	if !found {
-		// Request not found yet - response will be processed when window closes
-		return nil
+		for _, span := range def.assign(req.Timestamp) {
+			p.aggregateFixed(def, req, resp, cost, class, nil, span, partition)
+			if def.Name != DefaultWindowName {
+				continue
+			}
+			for _, grouped := range groupingSets(req) {
+				p.aggregateFixed(def, grouped, resp, cost, class, nil, span, partition)
+			}
+			if len(dims) > 0 {
+				p.aggregateFixed(def, req, resp, cost, class, dims, span, partition)
+			}
+		}
 	}
-
-	// Aggregate into window
-	p.aggregateEvent(req, &resp)
-
-	return nil
+	p.spillWindowsLocked()
 }
 
-// aggregateEvent adds an event to the appropriate window
-func (p *MetricsProcessor) aggregateEvent(req *models.LLMRequest, resp *models.LLMResponse) {
-	// Calculate window boundaries
-	windowStart := req.Timestamp.Truncate(WindowDuration)
-	windowEnd := windowStart.Add(WindowDuration)
-
-	// Create window key
-	key := fmt.Sprintf("%s|%s|%s|%d", req.TenantID, req.Route, req.Model, windowStart.Unix())
-
-	p.windowMu.Lock()
-	defer p.windowMu.Unlock()
+// aggregateFixed adds an event to one fixed window. Callers must hold
+// windowMu.
+func (p *MetricsProcessor) aggregateFixed(def *WindowDefinition, req *models.LLMRequest, resp *models.LLMResponse, cost float64, class models.ErrorClass, dims models.Dimensions, span windowSpan, partition int32) {
+	key := windowKey(def.Name, req.TenantID, req.Route, req.Model, dims.Key(), span.start)
 
 	agg, exists := p.windowAggregates[key]
 	if !exists {
-		agg = &WindowAggregate{
-			TenantID:     req.TenantID,
-			Route:        req.Route,
-			Model:        req.Model,
-			WindowStart:  windowStart,
-			WindowEnd:    windowEnd,
-			Latencies:    []int{},
-			PromptTokens: []int{},
-			CompTokens:   []int{},
-		}
+		agg, exists = p.takeSpilledWindowLocked(key)
+	}
+	if !exists {
+		agg = newWindowAggregate(def, req, "", span, partition)
+		agg.Dimensions = dims
 		p.windowAggregates[key] = agg
 	}
+	agg.add(req, resp, cost, class)
+}
 
-	// Update aggregates
-	agg.Requests++
-
-	if resp.Error != nil && *resp.Error != "" {
-		agg.Errors++
+// aggregateSession adds an event to its session, merging any sessions the
+// event bridges. Callers must hold windowMu.
+func (p *MetricsProcessor) aggregateSession(def *WindowDefinition, req *models.LLMRequest, resp *models.LLMResponse, cost float64, class models.ErrorClass, partition int32) {
+	id := sessionID(req)
+	if id == "" {
+		return
 	}
-
-	agg.Latencies = append(agg.Latencies, resp.LatencyMs)
-	agg.PromptTokens = append(agg.PromptTokens, req.PromptTokens)
-	agg.CompTokens = append(agg.CompTokens, resp.CompletionTokens)
+	key := sessionKey(def.Name, req.TenantID, req.Route, req.Model, id)
+
+	// The event alone would open the session [ts, ts+gap)
+	span := windowSpan{start: req.Timestamp, end: req.Timestamp.Add(def.Gap)}
+	session := newWindowAggregate(def, req, id, span, partition)
+	session.add(req, resp, cost, class)
+
+	var remaining []*WindowAggregate
+	for _, open := range p.sessionAggregates[key] {
+		// A session retried after a failed flush is sealed
+		if open.FlushID == "" && open.WindowStart.Before(span.end) && span.start.Before(open.WindowEnd) {
+			session.merge(open)
+		} else {
+			remaining = append(remaining, open)
+		}
+	}
+	p.sessionAggregates[key] = append(remaining, session)
 }
 
 // processWindows periodically flushes completed windows
 func (p *MetricsProcessor) processWindows(ctx context.Context) {
+	ticker := p.clock.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-p.windowTicker.C:
+		case <-ticker.C():
 			p.flushCompletedWindows(ctx)
 		}
 	}
 }
 
-// flushCompletedWindows writes completed windows to Kafka and Postgres
+// flushCompletedWindows writes completed windows to Kafka and Postgres. The
+// windows are taken out of memory to be written, so that events are
+// aggregated meanwhile: an event of a window being written opens it again, to
+// be merged into the stored window by the next flush.
 func (p *MetricsProcessor) flushCompletedWindows(ctx context.Context) {
-	now := time.Now()
-	cutoff := now.Add(-WindowDuration) // Windows older than 1 minute
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	cutoff := p.clock.Now().Add(-FlushGracePeriod)
 
 	p.windowMu.Lock()
-	defer p.windowMu.Unlock()
 
+	// Spilled windows are flushed from memory like the others
+	p.unspillWindowsLocked(func(e spilledEntry) bool {
+		var snap struct {
+			WindowEnd time.Time `json:"window_end"`
+		}
+		return json.Unmarshal(e.Value, &snap) != nil || snap.WindowEnd.Before(cutoff)
+	}, false)
+
+	var due []pendingFlush
 	for key, agg := range p.windowAggregates {
 		if agg.WindowEnd.Before(cutoff) {
-			// Compute final metrics
-			metrics := p.computeMetrics(agg)
-
-			// Produce to Kafka
-			metricsKey := fmt.Sprintf("%s|%s|%s", metrics.TenantID, metrics.Route, metrics.Model)
-			if err := p.producer.ProduceJSON(ctx, kafka.TopicLLMMetrics, metricsKey, metrics); err != nil {
-				log.Printf("Failed to produce metrics: %v", err)
-				continue
+			due = append(due, pendingFlush{key: key, agg: agg})
+			delete(p.windowAggregates, key)
+		}
+	}
+	for key, sessions := range p.sessionAggregates {
+		var open []*WindowAggregate
+		for _, agg := range sessions {
+			if agg.WindowEnd.Before(cutoff) {
+				due = append(due, pendingFlush{key: key, agg: agg})
+			} else {
+				open = append(open, agg)
 			}
+		}
+		if len(open) == 0 {
+			delete(p.sessionAggregates, key)
+		} else {
+			p.sessionAggregates[key] = open
+		}
+	}
+	p.windowMu.Unlock()
+
+	failed := p.writeWindows(ctx, due, false)
+
+	p.windowMu.Lock()
+	defer p.windowMu.Unlock()
+	for _, f := range failed {
+		p.addWindowLocked(f.agg)
+	}
+
+	// Windows that failed to flush may take the budget again
+	p.spillWindowsLocked()
+	p.observeWindowsLocked()
+}
+
+// Drain flushes every open fixed window, spilled ones included, as partial.
+// It is called after Run returned, before the consumer is closed, so that
+// a shutdown loses no window; the next owner of each partition merges the
+// rest of a window into the stored one. Windows still unflushed when ctx is
+// done, and sessions, whose continuation would start a new session, are
+// handed off with their partitions instead.
+func (p *MetricsProcessor) Drain(ctx context.Context) error {
+	p.windowMu.Lock()
+	defer p.windowMu.Unlock()
+
+	p.unspillWindowsLocked(func(spilledEntry) bool { return true }, false)
+
+	// No events are aggregated anymore, so the windows are written under
+	// windowMu
+	flushes := make([]pendingFlush, 0, len(p.windowAggregates))
+	for key, agg := range p.windowAggregates {
+		flushes = append(flushes, pendingFlush{key: key, agg: agg})
+		delete(p.windowAggregates, key)
+	}
+	failed := p.writeWindows(ctx, flushes, true)
+	for _, f := range failed {
+		p.addWindowLocked(f.agg)
+	}
+	p.observeWindowsLocked()
+
+	log.Printf("Drained %d open windows", len(flushes)-len(failed))
+	if n := len(p.windowAggregates); n > 0 {
+		return fmt.Errorf("%d windows not flushed, handing them off", n)
+	}
+	return nil
+}
 
-			// Write to Postgres
-			if err := p.store.InsertMetrics(ctx, metrics); err != nil {
-				log.Printf("Failed to insert metrics to DB: %v", err)
+// budgetsOutput marks the windows whose spend was recorded in their Delivered
+// set, next to the sinks that took them
+const budgetsOutput = "budgets"
+
+// pendingFlush is a window being written to the sinks, with its state key
+type pendingFlush struct {
+	key string
+	agg *WindowAggregate
+}
+
+// writeWindows writes windows to every sink, records the spend of those
+// counted against budgets, and returns those that some sinks or the budgets
+// failed to take. A failed window is sealed: added back to memory, it is
+// kept apart from the window later events open under its key, and retried on
+// the next tick for the failed sinks only, as it is. partial marks windows
+// flushed before they completed. The windows must be out of reach of event
+// aggregation, as writing does not hold windowMu. They are written oldest
+// first, then by key, so that consumers read each series in order.
+func (p *MetricsProcessor) writeWindows(ctx context.Context, flushes []pendingFlush, partial bool) []pendingFlush {
+	if len(flushes) == 0 {
+		return nil
+	}
+	sort.Slice(flushes, func(i, j int) bool {
+		a, b := flushes[i].agg, flushes[j].agg
+		if !a.WindowStart.Equal(b.WindowStart) {
+			return a.WindowStart.Before(b.WindowStart)
+		}
+		return flushes[i].key < flushes[j].key
+	})
+	windows := make([]*sink.Window, len(flushes))
+	delivered := make([]map[string]bool, len(flushes))
+	for i, f := range flushes {
+		agg := f.agg
+		metrics := p.computeMetrics(agg)
+		metrics.Partial = partial
+		if agg.FlushID == "" {
+			agg.FlushID = newFlushID()
+		}
+		if agg.Delivered == nil {
+			agg.Delivered = make(map[string]bool)
+		}
+
+		// Dimensioned and grouped windows go to their own topics so that
+		// consumers of the definition's topic see every event once
+		topic := agg.Definition.Topic
+		switch {
+		case len(agg.Dimensions) > 0:
+			topic = kafka.TopicLLMMetricsDimensions
+		case agg.grouped():
+			topic = kafka.TopicLLMMetricsGrouped
+		}
+		windows[i] = &sink.Window{
+			Definition: agg.Definition.Name,
+			Topic:      topic,
+			Table:      agg.Definition.Table,
+			Key:        agg.outputKey(),
+			FlushID:    agg.FlushID,
+			Metrics:    metrics,
+		}
+		delivered[i] = agg.Delivered
+	}
+
+	errs := p.sinks.Write(ctx, windows, delivered)
+
+	var failed []pendingFlush
+	for i, f := range flushes {
+		agg, metrics := f.agg, windows[i].Metrics
+		if errs[i] != nil {
+			log.Printf("Failed to flush window %s: %v", f.key, errs[i])
+			failed = append(failed, f)
+			continue
+		}
+
+		// Every event lands in exactly one default tenant-total window of its
+		// route and model, so spend is counted from those alone. Budgets are
+		// an output like the sinks: a window they missed is retried for them.
+		if agg.Definition.Name == DefaultWindowName && len(agg.Dimensions) == 0 && !agg.grouped() && !agg.Delivered[budgetsOutput] {
+			if err := p.budgets.Record(ctx, agg.FlushID, metrics); err != nil {
+				log.Printf("Failed to flush window %s: budgets: %v", f.key, err)
+				failed = append(failed, f)
 				continue
 			}
+			agg.Delivered[budgetsOutput] = true
+		}
+		flushDelay.WithLabelValues(agg.Definition.Name).Observe(p.clock.Now().Sub(agg.WindowEnd).Seconds())
 
-			log.Printf("Flushed window: %s [%s to %s] - %d requests, %d errors",
-				key, agg.WindowStart.Format(time.RFC3339), agg.WindowEnd.Format(time.RFC3339),
-				agg.Requests, agg.Errors)
-
-			// Remove from memory
-			delete(p.windowAggregates, key)
+		kind := "window"
+		if partial {
+			kind = "partial window"
 		}
+		log.Printf("Flushed %s: %s [%s to %s] - %d requests, %d errors, %d abandoned",
+			kind, f.key, agg.WindowStart.Format(time.RFC3339), agg.WindowEnd.Format(time.RFC3339),
+			agg.Requests, agg.Errors, agg.Abandoned)
 	}
+	return failed
 }
 
 // computeMetrics calculates final metrics from aggregate
@@ -262,98 +507,65 @@ func (p *MetricsProcessor) computeMetrics(agg *WindowAggregate) *models.LLMMetri
 		TenantID:    agg.TenantID,
 		Route:       agg.Route,
 		Model:       agg.Model,
+		SessionID:   agg.SessionID,
+		Dimensions:  agg.Dimensions,
 		WindowStart: agg.WindowStart,
 		WindowEnd:   agg.WindowEnd,
 		Requests:    agg.Requests,
 		Errors:      agg.Errors,
+		Abandoned:   agg.Abandoned,
 	}
 
-	// Calculate average latency
-	if len(agg.Latencies) > 0 {
-		sum := 0
-		for _, l := range agg.Latencies {
-			sum += l
-		}
-		metrics.AvgLatencyMs = float64(sum) / float64(len(agg.Latencies))
-
-		// Calculate P95 latency
-		metrics.P95LatencyMs = calculatePercentile(agg.Latencies, 0.95)
+	// Calculate averages from the additive sums
+	if agg.Requests > 0 {
+		n := float64(agg.Requests)
+		metrics.LatencySumMs = agg.LatencySumMs
+		metrics.PromptTokensSum = agg.PromptTokensSum
+		metrics.CompletionTokensSum = agg.CompletionTokensSum
+		metrics.AvgLatencyMs = float64(agg.LatencySumMs) / n
+		metrics.AvgPromptTokens = float64(agg.PromptTokensSum) / n
+		metrics.AvgCompletionTokens = float64(agg.CompletionTokensSum) / n
 	}
 
-	// Calculate average tokens
-	if len(agg.PromptTokens) > 0 {
-		sum := 0
-		for _, t := range agg.PromptTokens {
-			sum += t
-		}
-		metrics.AvgPromptTokens = float64(sum) / float64(len(agg.PromptTokens))
+	// Calculate latency percentiles
+	if err := metrics.ApplyLatencySketch(agg.LatencySketch); err != nil {
+		log.Printf("Failed to encode latency sketch: %v", err)
 	}
 
-	if len(agg.CompTokens) > 0 {
-		sum := 0
-		for _, t := range agg.CompTokens {
-			sum += t
+	// Cost is priced per event when it is aggregated
+	metrics.EstimatedCostUSD = agg.CostUSD
+
+	// Distinct users and the distribution of their calls
+	calls := sketch.NewDefault()
+	for _, n := range agg.UserCalls {
+		calls.Add(float64(n))
+		if int64(n) > metrics.MaxCallsPerUser {
+			metrics.MaxCallsPerUser = int64(n)
 		}
-		metrics.AvgCompletionTokens = float64(sum) / float64(len(agg.CompTokens))
+	}
+	if err := metrics.ApplyUserSketches(agg.Users, calls); err != nil {
+		log.Printf("Failed to encode user sketches: %v", err)
 	}
 
-	// Estimate cost (simple model: $0.01 per 1000 prompt tokens, $0.03 per 1000 completion tokens)
-	metrics.EstimatedCostUSD = (metrics.AvgPromptTokens * 0.01 / 1000.0 * float64(agg.Requests)) +
-		(metrics.AvgCompletionTokens * 0.03 / 1000.0 * float64(agg.Requests))
+	metrics.ErrorClasses = copyCounts(agg.ErrorClasses)
+	metrics.FinishReasons = copyCounts(agg.FinishReasons)
 
 	return metrics
 }
 
-// calculatePercentile calculates the nth percentile of a slice
-func calculatePercentile(values []int, percentile float64) float64 {
-	if len(values) == 0 {
-		return 0
+// copyCounts copies a non-empty count map, returning nil for an empty one
+func copyCounts(counts map[string]int) map[string]int {
+	if len(counts) == 0 {
+		return nil
 	}
-
-	sorted := make([]int, len(values))
-	copy(sorted, values)
-	sort.Ints(sorted)
-
-	index := int(float64(len(sorted)-1) * percentile)
-	return float64(sorted[index])
-}
-
-// cleanupState periodically removes old state
-func (p *MetricsProcessor) cleanupState(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			now := time.Now()
-			cutoff := now.Add(-StateRetentionDuration)
-
-			p.stateMu.Lock()
-
-			// Clean old requests
-			for id, req := range p.requestState {
-				if req.Timestamp.Before(cutoff) {
-					delete(p.requestState, id)
-				}
-			}
-
-			// Clean old responses
-			for id, resp := range p.responseState {
-				if resp.Timestamp.Before(cutoff) {
-					delete(p.responseState, id)
-				}
-			}
-
-			p.stateMu.Unlock()
-		}
+	out := make(map[string]int, len(counts))
+	for k, n := range counts {
+		out[k] = n
 	}
+	return out
 }
 
 // Close shuts down the processor
 func (p *MetricsProcessor) Close() {
-	p.windowTicker.Stop()
 	log.Println("Metrics processor closed")
 }
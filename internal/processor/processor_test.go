@@ -0,0 +1,650 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"streamlens/internal/clock"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/pricing"
+	"streamlens/internal/processor/processortest"
+	"streamlens/internal/sink"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// testStart is the start of the first window of every test
+var testStart = time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+
+// priceList serves a fixed set of prices
+type priceList []models.ModelPrice
+
+func (l priceList) ListModelPrices(ctx context.Context) ([]models.ModelPrice, error) {
+	return l, nil
+}
+
+// testProcessor is a window stage wired to in-memory fakes
+type testProcessor struct {
+	*MetricsProcessor
+	consumer *processortest.Consumer
+	store    *processortest.Store
+	sink     *processortest.Sink
+	dlq      *processortest.DLQ
+	budgets  *processortest.Budgets
+	clock    *clock.Fake
+}
+
+func newTestProcessor(t *testing.T, store *processortest.Store, prices ...models.ModelPrice) *testProcessor {
+	t.Helper()
+	catalog, err := pricing.NewCatalog(context.Background(), "", priceList(prices), time.Minute)
+	if err != nil {
+		t.Fatalf("NewCatalog() error = %v", err)
+	}
+	classifier, err := NewErrorClassifier("")
+	if err != nil {
+		t.Fatalf("NewErrorClassifier() error = %v", err)
+	}
+
+	tp := &testProcessor{
+		consumer: processortest.NewConsumer("test-windows"),
+		store:    store,
+		sink:     &processortest.Sink{},
+		dlq:      &processortest.DLQ{},
+		budgets:  &processortest.Budgets{},
+		clock:    clock.NewFake(testStart),
+	}
+	tp.MetricsProcessor = NewMetricsProcessor(tp.consumer, tp.sink, store, catalog, classifier, tp.dlq, tp.budgets,
+		NewDimensionResolver(nil, time.Minute), []WindowDefinition{DefaultWindow}, nil, 0)
+	tp.SetClock(tp.clock)
+	return tp
+}
+
+// event describes a joined call relative to testStart
+type event struct {
+	at        time.Duration
+	route     string
+	model     string
+	latencyMs int
+	prompt    int
+	cached    int
+	completed int
+	err       string
+	abandoned bool
+}
+
+func (e event) joined(id string) models.JoinedEvent {
+	route, model := e.route, e.model
+	if route == "" {
+		route = "/chat"
+	}
+	if model == "" {
+		model = "gpt-4o"
+	}
+	ev := models.JoinedEvent{
+		Request: models.LLMRequest{
+			RequestID:    id,
+			TenantID:     "tenant-1",
+			Route:        route,
+			Model:        model,
+			Provider:     "openai",
+			Timestamp:    testStart.Add(e.at),
+			PromptTokens: e.prompt,
+		},
+		Response: models.LLMResponse{
+			RequestID:          id,
+			Timestamp:          testStart.Add(e.at).Add(time.Duration(e.latencyMs) * time.Millisecond),
+			LatencyMs:          e.latencyMs,
+			CompletionTokens:   e.completed,
+			CachedPromptTokens: e.cached,
+			FinishReason:       "stop",
+		},
+	}
+	if e.err != "" {
+		ev.Response.Error = &e.err
+		ev.Response.FinishReason = "error"
+	}
+	if e.abandoned {
+		ev.Response = models.LLMResponse{}
+		ev.Abandoned = true
+	}
+	return ev
+}
+
+func joinedRecord(t *testing.T, id string, e event) *kgo.Record {
+	t.Helper()
+	ev := e.joined(id)
+	data, err := json.Marshal(&ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &kgo.Record{Topic: kafka.TopicLLMJoined, Key: []byte(ev.WindowKey()), Value: data}
+}
+
+// process feeds events to the stage in the given order
+func (tp *testProcessor) process(t *testing.T, events ...event) {
+	t.Helper()
+	for i, e := range events {
+		if err := tp.processRecord(context.Background(), joinedRecord(t, string(rune('a'+i)), e)); err != nil {
+			t.Fatalf("processRecord() error = %v", err)
+		}
+	}
+}
+
+// flushAt moves the clock to testStart+at, flushes completed windows and
+// returns the windows of single routes and models written so far
+func (tp *testProcessor) flushAt(at time.Duration) []*sink.Window {
+	tp.clock.Advance(testStart.Add(at).Sub(tp.clock.Now()))
+	tp.flushCompletedWindows(context.Background())
+	return tp.windows()
+}
+
+// windows returns the windows of single routes and models written so far
+func (tp *testProcessor) windows() []*sink.Window {
+	var windows []*sink.Window
+	for _, w := range tp.sink.Windows() {
+		if w.Metrics.Route != models.Wildcard && w.Metrics.Model != models.Wildcard {
+			windows = append(windows, w)
+		}
+	}
+	return windows
+}
+
+func TestMetricsProcessor_Windows(t *testing.T) {
+	tp := newTestProcessor(t, processortest.NewStore())
+
+	// The second minute's event arrives first, the first minute's events
+	// out of order
+	tp.process(t,
+		event{at: 65 * time.Second, latencyMs: 200},
+		event{at: 10 * time.Second, latencyMs: 100},
+		event{at: 50 * time.Second, latencyMs: 300, err: "429 Too Many Requests"},
+	)
+
+	// Windows stay open for the grace period after they end
+	if windows := tp.flushAt(2 * time.Minute); len(windows) != 0 {
+		t.Fatalf("flushed %d windows within the grace period", len(windows))
+	}
+	windows := tp.flushAt(2*time.Minute + 30*time.Second)
+	if len(windows) != 1 {
+		t.Fatalf("flushed %d windows, want 1", len(windows))
+	}
+	first := windows[0]
+	if first.Topic != kafka.TopicLLMMetrics || first.Table != "llm_metrics" || first.Key != "tenant-1|/chat|gpt-4o" {
+		t.Errorf("window output = %s, %s, %s", first.Topic, first.Table, first.Key)
+	}
+	m := first.Metrics
+	if !m.WindowStart.Equal(testStart) || !m.WindowEnd.Equal(testStart.Add(time.Minute)) {
+		t.Errorf("window = [%s, %s)", m.WindowStart, m.WindowEnd)
+	}
+	if m.Requests != 2 || m.Errors != 1 || m.LatencySumMs != 400 || m.AvgLatencyMs != 200 {
+		t.Errorf("window = %d requests, %d errors, %d ms total, %.1f ms avg; want 2, 1, 400, 200",
+			m.Requests, m.Errors, m.LatencySumMs, m.AvgLatencyMs)
+	}
+	if m.ErrorClasses[string(models.ErrorClassRateLimited)] != 1 {
+		t.Errorf("error classes = %v", m.ErrorClasses)
+	}
+
+	// A late event still lands in the open second window
+	tp.process(t, event{at: 119 * time.Second, latencyMs: 400})
+	windows = tp.flushAt(3*time.Minute + 30*time.Second)
+	if len(windows) != 2 {
+		t.Fatalf("flushed %d windows, want 2", len(windows))
+	}
+	if m := windows[1].Metrics; !m.WindowStart.Equal(testStart.Add(time.Minute)) || m.Requests != 2 || m.LatencySumMs != 600 {
+		t.Errorf("second window = %s, %d requests, %d ms; want %s, 2, 600", m.WindowStart, m.Requests, m.LatencySumMs, testStart.Add(time.Minute))
+	}
+
+	if recorded := tp.budgets.Recorded(); len(recorded) != 2 {
+		t.Errorf("recorded spend of %d windows, want 2", len(recorded))
+	}
+}
+
+func TestMetricsProcessor_Percentiles(t *testing.T) {
+	tp := newTestProcessor(t, processortest.NewStore())
+
+	// Latencies 1..100 ms, in descending order
+	for ms := 100; ms >= 1; ms-- {
+		tp.process(t, event{at: time.Duration(ms) * 100 * time.Millisecond, latencyMs: ms})
+	}
+	windows := tp.flushAt(3 * time.Minute)
+	if len(windows) != 1 {
+		t.Fatalf("flushed %d windows, want 1", len(windows))
+	}
+	m := windows[0].Metrics
+
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{name: "p50", got: m.P50LatencyMs, want: 50},
+		{name: "p90", got: m.P90LatencyMs, want: 90},
+		{name: "p95", got: m.P95LatencyMs, want: 95},
+		{name: "p99", got: m.P99LatencyMs, want: 99},
+		{name: "avg", got: m.AvgLatencyMs, want: 50.5},
+	}
+	for _, tt := range tests {
+		// The sketch guarantees 1% relative accuracy; ranks may round by one
+		if math.Abs(tt.got-tt.want) > tt.want*0.01+1 {
+			t.Errorf("%s = %.2f, want %.2f", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestMetricsProcessor_Cost(t *testing.T) {
+	effective := func(d time.Duration) time.Time { return testStart.Add(d) }
+	tp := newTestProcessor(t, processortest.NewStore(),
+		models.ModelPrice{Provider: "openai", Model: "gpt-4o", InputPerMillion: 2.5, OutputPerMillion: 10, CachedInputPerMillion: 1.25, EffectiveFrom: effective(-24 * time.Hour)},
+		models.ModelPrice{Provider: "openai", Model: "gpt-4o", InputPerMillion: 5, OutputPerMillion: 20, CachedInputPerMillion: 2.5, EffectiveFrom: effective(30 * time.Second)},
+	)
+
+	tp.process(t,
+		// (600*2.5 + 400*1.25 + 500*10) / 1e6
+		event{at: 10 * time.Second, prompt: 1000, cached: 400, completed: 500},
+		// Priced at the new rate: (1000*5 + 500*20) / 1e6
+		event{at: 40 * time.Second, prompt: 1000, completed: 500},
+		// Unknown models fall back to the default price: (1000*10 + 1000*30) / 1e6
+		event{at: 20 * time.Second, model: "unknown", prompt: 1000, completed: 1000},
+	)
+
+	want := map[string]float64{"gpt-4o": 0.022, "unknown": 0.04}
+	windows := tp.flushAt(3 * time.Minute)
+	if len(windows) != 2 {
+		t.Fatalf("flushed %d windows, want 2", len(windows))
+	}
+	for _, w := range windows {
+		if got := w.Metrics.EstimatedCostUSD; math.Abs(got-want[w.Metrics.Model]) > 1e-12 {
+			t.Errorf("%s cost = %v, want %v", w.Metrics.Model, got, want[w.Metrics.Model])
+		}
+	}
+}
+
+func TestMetricsProcessor_GroupingSets(t *testing.T) {
+	tp := newTestProcessor(t, processortest.NewStore())
+	tp.process(t,
+		event{at: 10 * time.Second, latencyMs: 100},
+		event{at: 20 * time.Second, model: "claude-3", latencyMs: 300},
+		event{at: 30 * time.Second, route: "/search", latencyMs: 500},
+	)
+	tp.flushAt(3 * time.Minute)
+
+	grouped := make(map[string]*sink.Window)
+	for _, w := range tp.sink.Windows() {
+		if w.Metrics.Route == models.Wildcard || w.Metrics.Model == models.Wildcard {
+			grouped[w.Key] = w
+		}
+	}
+	tests := []struct {
+		key      string
+		requests int
+		latency  int64
+	}{
+		{key: "tenant-1|*|*", requests: 3, latency: 900},
+		{key: "tenant-1|/chat|*", requests: 2, latency: 400},
+		{key: "tenant-1|/search|*", requests: 1, latency: 500},
+		{key: "tenant-1|*|gpt-4o", requests: 2, latency: 600},
+		{key: "tenant-1|*|claude-3", requests: 1, latency: 300},
+	}
+	if len(grouped) != len(tests) {
+		t.Fatalf("flushed %d grouped windows, want %d", len(grouped), len(tests))
+	}
+	for _, tt := range tests {
+		w, ok := grouped[tt.key]
+		if !ok {
+			t.Errorf("no window for %s", tt.key)
+			continue
+		}
+		if w.Topic != kafka.TopicLLMMetricsGrouped || w.Table != "llm_metrics" {
+			t.Errorf("%s output = %s, %s", tt.key, w.Topic, w.Table)
+		}
+		if w.Metrics.Requests != tt.requests || w.Metrics.LatencySumMs != tt.latency {
+			t.Errorf("%s = %d requests, %d ms; want %d, %d", tt.key, w.Metrics.Requests, w.Metrics.LatencySumMs, tt.requests, tt.latency)
+		}
+	}
+
+	// Percentiles come from the events of every route and model
+	if p50 := grouped["tenant-1|*|*"].Metrics.P50LatencyMs; math.Abs(p50-300) > 3 {
+		t.Errorf("tenant p50 = %.1f, want 300", p50)
+	}
+	// Spend is counted once per event
+	if recorded := tp.budgets.Recorded(); len(recorded) != 3 {
+		t.Errorf("recorded spend of %d windows, want 3", len(recorded))
+	}
+}
+
+func TestMetricsProcessor_Abandoned(t *testing.T) {
+	tp := newTestProcessor(t, processortest.NewStore(),
+		models.ModelPrice{Provider: "openai", Model: "gpt-4o", InputPerMillion: 2.5, OutputPerMillion: 10, EffectiveFrom: testStart.Add(-time.Hour)},
+	)
+	tp.process(t,
+		event{at: 10 * time.Second, latencyMs: 100, prompt: 1000},
+		event{at: 20 * time.Second, prompt: 1000, abandoned: true},
+		event{at: 30 * time.Second, prompt: 1000, abandoned: true},
+	)
+
+	windows := tp.flushAt(3 * time.Minute)
+	if len(windows) != 1 {
+		t.Fatalf("flushed %d windows, want 1", len(windows))
+	}
+	// Abandoned requests are neither requests nor errors, and are not priced
+	m := windows[0].Metrics
+	if m.Requests != 1 || m.Errors != 0 || m.Abandoned != 2 || m.AvgLatencyMs != 100 {
+		t.Errorf("window = %d requests, %d errors, %d abandoned, %.1f ms avg; want 1, 0, 2, 100",
+			m.Requests, m.Errors, m.Abandoned, m.AvgLatencyMs)
+	}
+	if math.Abs(m.EstimatedCostUSD-0.0025) > 1e-12 {
+		t.Errorf("cost = %v, want 0.0025", m.EstimatedCostUSD)
+	}
+}
+
+func TestMetricsProcessor_LateAbandoned(t *testing.T) {
+	tp := newTestProcessor(t, processortest.NewStore())
+	tp.process(t, event{at: 10 * time.Second, latencyMs: 100})
+	if windows := tp.flushAt(3 * time.Minute); len(windows) != 1 {
+		t.Fatalf("flushed %d windows, want 1", len(windows))
+	}
+
+	// The response timeout expires after the window was flushed: the window
+	// is flushed again with only the abandonment, which sinks add to the
+	// stored window rather than overwrite it with
+	tp.process(t, event{at: 20 * time.Second, abandoned: true})
+	windows := tp.flushAt(6 * time.Minute)
+	if len(windows) != 2 {
+		t.Fatalf("flushed %d windows, want 2", len(windows))
+	}
+	if m := windows[1].Metrics; m.Requests != 0 || m.Abandoned != 1 || !m.WindowStart.Equal(testStart) {
+		t.Errorf("late window = %d requests, %d abandoned at %s; want 0, 1 at %s",
+			m.Requests, m.Abandoned, m.WindowStart, testStart)
+	}
+}
+
+func TestMetricsProcessor_SinkFailure(t *testing.T) {
+	tp := newTestProcessor(t, processortest.NewStore())
+	tp.process(t, event{at: 10 * time.Second, latencyMs: 100})
+
+	tp.sink.Fail = true
+	tp.flushAt(3 * time.Minute)
+	if len(tp.budgets.Recorded()) != 0 {
+		t.Error("recorded spend of a window no sink took")
+	}
+
+	// The window stays open and is written on the next flush, once
+	tp.sink.Fail = false
+	tp.flushAt(4 * time.Minute)
+	windows := tp.flushAt(5 * time.Minute)
+	if len(windows) != 1 || windows[0].Metrics.Requests != 1 {
+		t.Fatalf("flushed %d windows after recovery, want 1", len(windows))
+	}
+	if len(tp.budgets.Recorded()) != 1 {
+		t.Errorf("recorded spend of %d windows, want 1", len(tp.budgets.Recorded()))
+	}
+}
+
+func TestMetricsProcessor_BudgetFailure(t *testing.T) {
+	tp := newTestProcessor(t, processortest.NewStore())
+	tp.process(t, event{at: 10 * time.Second, latencyMs: 100})
+
+	tp.budgets.SetError(errors.New("budgets unavailable"))
+	if windows := tp.flushAt(3 * time.Minute); len(windows) != 1 {
+		t.Fatalf("flushed %d windows, want 1", len(windows))
+	}
+	if len(tp.budgets.Recorded()) != 0 {
+		t.Fatal("recorded spend while budgets failed")
+	}
+
+	// The window is kept for the budgets alone, with the same flush ID
+	tp.budgets.SetError(nil)
+	tp.flushAt(4 * time.Minute)
+	windows := tp.flushAt(5 * time.Minute)
+	if len(windows) != 1 {
+		t.Errorf("flushed %d windows after recovery, want the first one only", len(windows))
+	}
+	recorded := tp.budgets.Recorded()
+	if len(recorded) != 1 || recorded[0].Requests != 1 {
+		t.Fatalf("recorded spend of %d windows, want 1", len(recorded))
+	}
+}
+
+func TestMetricsProcessor_SlowSink(t *testing.T) {
+	tp := newTestProcessor(t, processortest.NewStore())
+	tp.process(t, event{at: 10 * time.Second, latencyMs: 100})
+
+	tp.sink.Block = make(chan struct{})
+	tp.sink.Blocked = make(chan struct{}, 1)
+	tp.clock.Advance(3 * time.Minute)
+	flushed := make(chan struct{})
+	go func() {
+		tp.flushCompletedWindows(context.Background())
+		close(flushed)
+	}()
+	<-tp.sink.Blocked
+
+	// Events are aggregated while the sink holds the flush
+	record := joinedRecord(t, "b", event{at: 2*time.Minute + 30*time.Second, latencyMs: 200})
+	processed := make(chan error)
+	go func() { processed <- tp.processRecord(context.Background(), record) }()
+	select {
+	case err := <-processed:
+		if err != nil {
+			t.Fatalf("processRecord() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event processing blocked behind a sink write")
+	}
+
+	close(tp.sink.Block)
+	<-flushed
+	if windows := tp.windows(); len(windows) != 1 || windows[0].Metrics.Requests != 1 {
+		t.Fatalf("flushed %d windows, want the first one", len(windows))
+	}
+}
+
+func TestMetricsProcessor_FlushOrder(t *testing.T) {
+	tp := newTestProcessor(t, processortest.NewStore())
+	var events []event
+	for minute := 2; minute >= 0; minute-- {
+		for _, route := range []string{"/search", "/chat", "/embed", "/summarize"} {
+			events = append(events, event{at: time.Duration(minute)*time.Minute + 10*time.Second, route: route, latencyMs: 100})
+		}
+	}
+	tp.process(t, events...)
+
+	// Windows are written oldest first, then by key, whatever the map order
+	windows := tp.flushAt(5 * time.Minute)
+	if len(windows) != 12 {
+		t.Fatalf("flushed %d windows, want 12", len(windows))
+	}
+	for i := 1; i < len(windows); i++ {
+		prev, w := windows[i-1].Metrics, windows[i].Metrics
+		if w.WindowStart.Before(prev.WindowStart) || (w.WindowStart.Equal(prev.WindowStart) && w.Route < prev.Route) {
+			t.Errorf("window %d = %s %s after %s %s", i, w.WindowStart.Format("15:04"), w.Route, prev.WindowStart.Format("15:04"), prev.Route)
+		}
+	}
+}
+
+func TestMetricsProcessor_LateEvents(t *testing.T) {
+	tp := newTestProcessor(t, processortest.NewStore())
+	tp.process(t, event{at: 10 * time.Second, latencyMs: 100})
+	if windows := tp.flushAt(3 * time.Minute); len(windows) != 1 {
+		t.Fatalf("flushed %d windows, want 1", len(windows))
+	}
+
+	// A late event is flushed as a delta of its own, for the sinks to merge
+	tp.process(t, event{at: 20 * time.Second, latencyMs: 300})
+	windows := tp.flushAt(4 * time.Minute)
+	if len(windows) != 2 {
+		t.Fatalf("flushed %d windows, want 2", len(windows))
+	}
+	first, late := windows[0], windows[1]
+	if late.Metrics.Requests != 1 || late.Metrics.LatencySumMs != 300 || !late.Metrics.WindowStart.Equal(first.Metrics.WindowStart) {
+		t.Errorf("late window = %d requests, %d ms at %s; want the late event alone in the first window",
+			late.Metrics.Requests, late.Metrics.LatencySumMs, late.Metrics.WindowStart)
+	}
+	if late.FlushID == "" || late.FlushID == first.FlushID {
+		t.Errorf("late window flush ID %q, first %q; want a new one", late.FlushID, first.FlushID)
+	}
+}
+
+func TestMetricsProcessor_SealedRetry(t *testing.T) {
+	tp := newTestProcessor(t, processortest.NewStore())
+	tp.process(t, event{at: 10 * time.Second, latencyMs: 100})
+	tp.sink.Fail = true
+	tp.flushAt(3 * time.Minute)
+
+	// An event arriving while the window is retried does not change it
+	tp.process(t, event{at: 20 * time.Second, latencyMs: 300})
+	tp.flushAt(4 * time.Minute)
+	tp.sink.Fail = false
+	windows := tp.flushAt(5 * time.Minute)
+	if len(windows) != 2 {
+		t.Fatalf("flushed %d windows after recovery, want 2", len(windows))
+	}
+	ids := make(map[string]bool)
+	for _, w := range windows {
+		if w.Metrics.Requests != 1 {
+			t.Errorf("window %s has %d requests, want 1", w.FlushID, w.Metrics.Requests)
+		}
+		ids[w.FlushID] = true
+	}
+	if len(ids) != 2 {
+		t.Errorf("flush IDs %v, want two distinct", ids)
+	}
+}
+
+func TestMetricsProcessor_Run(t *testing.T) {
+	tp := newTestProcessor(t, processortest.NewStore())
+	tp.consumer.Add(
+		joinedRecord(t, "a", event{at: 10 * time.Second, latencyMs: 100}),
+		&kgo.Record{Topic: kafka.TopicLLMJoined, Value: []byte("not json")},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- tp.Run(ctx) }()
+	<-tp.consumer.Idle()
+	cancel()
+	<-done
+
+	// The malformed record is dead-lettered, and both are committed
+	if letters := tp.dlq.Letters(); len(letters) != 1 || string(letters[0].Record.Value) != "not json" {
+		t.Errorf("dead-lettered %d records, want the malformed one", len(letters))
+	}
+	if committed := tp.consumer.Committed(); len(committed) != 2 {
+		t.Errorf("committed %d records, want 2", len(committed))
+	}
+	if windows := tp.flushAt(3 * time.Minute); len(windows) != 1 {
+		t.Errorf("flushed %d windows, want 1", len(windows))
+	}
+}
+
+func TestMetricsProcessor_DeadLetterFailure(t *testing.T) {
+	tp := newTestProcessor(t, processortest.NewStore())
+	tp.dlq.SetError(errors.New("dlq unavailable"))
+	first := joinedRecord(t, "a", event{at: 10 * time.Second, latencyMs: 100})
+	malformed := &kgo.Record{Topic: kafka.TopicLLMJoined, Offset: 1, Value: []byte("not json")}
+	after := joinedRecord(t, "b", event{at: 20 * time.Second, latencyMs: 100})
+	after.Offset = 2
+	other := joinedRecord(t, "c", event{at: 30 * time.Second, latencyMs: 100})
+	other.Partition = 1
+	tp.consumer.Add(first, malformed, after, other)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- tp.Run(ctx) }()
+	tp.consumer.IdleAdvancing(tp.clock, kafka.RewindBackoff)
+	cancel()
+	<-done
+
+	// Nothing past the record that could not be dead-lettered is processed
+	// or committed; its partition is polled again from it
+	if rewound := tp.consumer.Rewound(); len(rewound) != 1 || rewound[0] != malformed {
+		t.Errorf("rewound to %v, want the malformed record", rewound)
+	}
+	committed := tp.consumer.Committed()
+	if len(committed) != 2 || committed[0] != first || committed[1] != other {
+		t.Errorf("committed %d records, want the first one and the other partition's", len(committed))
+	}
+	if windows := tp.flushAt(3 * time.Minute); len(windows) != 1 || windows[0].Metrics.Requests != 2 {
+		t.Errorf("flushed %d windows, want 1 of 2 requests", len(windows))
+	}
+}
+
+func TestMetricsProcessor_Handoff(t *testing.T) {
+	ctx := context.Background()
+	store := processortest.NewStore()
+	partitions := map[string][]int32{kafka.TopicLLMJoined: {3}}
+
+	// The first owner opens a window on partition 3 and is revoked
+	first := newTestProcessor(t, store)
+	record := joinedRecord(t, "a", event{at: 10 * time.Second, latencyMs: 100})
+	record.Partition = 3
+	if err := first.processRecord(ctx, record); err != nil {
+		t.Fatalf("processRecord() error = %v", err)
+	}
+	first.consumer.Revoke(ctx, partitions)
+	if windows := first.flushAt(3 * time.Minute); len(windows) != 0 {
+		t.Errorf("revoked owner flushed %d windows", len(windows))
+	}
+
+	// The next owner continues the window
+	next := newTestProcessor(t, store)
+	next.consumer.Assign(ctx, partitions)
+	next.process(t, event{at: 20 * time.Second, latencyMs: 300})
+	windows := next.flushAt(3 * time.Minute)
+	if len(windows) != 1 || windows[0].Metrics.Requests != 2 || windows[0].Metrics.LatencySumMs != 400 {
+		t.Fatalf("next owner flushed %d windows, want 1 with both events", len(windows))
+	}
+}
+
+func TestMetricsProcessor_Drain(t *testing.T) {
+	tp := newTestProcessor(t, processortest.NewStore())
+	tp.process(t,
+		event{at: 10 * time.Second, latencyMs: 100},
+		event{at: 70 * time.Second, latencyMs: 200},
+	)
+
+	// Both the completed and the running window are flushed early
+	tp.clock.Advance(80 * time.Second)
+	if err := tp.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	windows := tp.windows()
+	if len(windows) != 2 {
+		t.Fatalf("drained %d windows, want 2", len(windows))
+	}
+	if !windows[0].Metrics.WindowStart.Before(windows[1].Metrics.WindowStart) {
+		t.Errorf("drained %s before %s, want oldest first", windows[0].Metrics.WindowStart, windows[1].Metrics.WindowStart)
+	}
+	for _, w := range windows {
+		if !w.Metrics.Partial || w.Metrics.Requests != 1 {
+			t.Errorf("drained window %s = partial %v, %d requests; want partial, 1", w.Metrics.WindowStart, w.Metrics.Partial, w.Metrics.Requests)
+		}
+	}
+	if windows := tp.flushAt(5 * time.Minute); len(windows) != 2 {
+		t.Errorf("flushed %d windows after the drain, want none more", len(windows)-2)
+	}
+}
+
+func TestMetricsProcessor_DrainFailure(t *testing.T) {
+	store := processortest.NewStore()
+	tp := newTestProcessor(t, store)
+	tp.process(t, event{at: 10 * time.Second, latencyMs: 100})
+
+	// A window no sink took is handed off with its partition instead
+	tp.sink.Fail = true
+	if err := tp.Drain(context.Background()); err == nil {
+		t.Fatal("Drain() succeeded with every sink down")
+	}
+	partitions := map[string][]int32{kafka.TopicLLMJoined: {0}}
+	tp.consumer.Revoke(context.Background(), partitions)
+
+	next := newTestProcessor(t, store)
+	next.consumer.Assign(context.Background(), partitions)
+	if windows := next.flushAt(3 * time.Minute); len(windows) != 1 || windows[0].Metrics.Partial {
+		t.Fatalf("next owner flushed %d windows, want the handed-off one", len(windows))
+	}
+}
@@ -0,0 +1,388 @@
+// Package processortest provides in-memory fakes of the dependencies of the
+// processor stages, for deterministic tests.
+package processortest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"streamlens/internal/clock"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/sink"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Consumer serves queued records to Poll, one Add per fetch
+type Consumer struct {
+	group    string
+	listener kafka.RebalanceListener
+
+	fetches   []kgo.Fetches
+	committed []*kgo.Record
+	rewound   []*kgo.Record
+	added     chan struct{}
+	idle      chan struct{}
+	mu        sync.Mutex
+}
+
+// NewConsumer creates a consumer of the given group
+func NewConsumer(group string) *Consumer {
+	return &Consumer{
+		group: group,
+		added: make(chan struct{}, 1),
+		idle:  make(chan struct{}, 1),
+	}
+}
+
+// Add queues records to be returned together by one Poll
+func (c *Consumer) Add(records ...*kgo.Record) {
+	var fetch kgo.Fetch
+	for _, r := range records {
+		fetch.Topics = append(fetch.Topics, kgo.FetchTopic{
+			Topic:      r.Topic,
+			Partitions: []kgo.FetchPartition{{Partition: r.Partition, Records: []*kgo.Record{r}}},
+		})
+	}
+
+	c.mu.Lock()
+	c.fetches = append(c.fetches, kgo.Fetches{fetch})
+	// An idle signal from before the records were added is stale
+	select {
+	case <-c.idle:
+	default:
+	}
+	c.mu.Unlock()
+
+	select {
+	case c.added <- struct{}{}:
+	default:
+	}
+}
+
+// Idle is signalled when Poll finds no queued records, that is once every
+// record added before was processed
+func (c *Consumer) Idle() <-chan struct{} {
+	return c.idle
+}
+
+// IdleAdvancing waits for Idle while advancing clk by step, for a stage that
+// pauses on clk between polls
+func (c *Consumer) IdleAdvancing(clk *clock.Fake, step time.Duration) {
+	for {
+		select {
+		case <-c.idle:
+			return
+		case <-time.After(10 * time.Millisecond):
+			clk.Advance(step)
+		}
+	}
+}
+
+// Committed returns the records committed so far
+func (c *Consumer) Committed() []*kgo.Record {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*kgo.Record(nil), c.committed...)
+}
+
+// Group returns the consumer group name
+func (c *Consumer) Group() string {
+	return c.group
+}
+
+// SetRebalanceListener registers the listener called by Assign, Revoke and Lose
+func (c *Consumer) SetRebalanceListener(l kafka.RebalanceListener) {
+	c.listener = l
+}
+
+// Poll returns the next queued fetch, waiting for one until ctx is done
+func (c *Consumer) Poll(ctx context.Context) kgo.Fetches {
+	for {
+		c.mu.Lock()
+		if len(c.fetches) > 0 {
+			f := c.fetches[0]
+			c.fetches = c.fetches[1:]
+			c.mu.Unlock()
+			return f
+		}
+		select {
+		case c.idle <- struct{}{}:
+		default:
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return kgo.Fetches{}
+		case <-c.added:
+		}
+	}
+}
+
+// AllowRebalance does nothing; rebalances happen when the test calls them
+func (c *Consumer) AllowRebalance() {}
+
+// CommitRecords records the committed records
+func (c *Consumer) CommitRecords(ctx context.Context, records ...*kgo.Record) error {
+	c.mu.Lock()
+	c.committed = append(c.committed, records...)
+	c.mu.Unlock()
+	return nil
+}
+
+// Rewind records the rewound record; it is not polled again
+func (c *Consumer) Rewind(record *kgo.Record) {
+	c.mu.Lock()
+	c.rewound = append(c.rewound, record)
+	c.mu.Unlock()
+}
+
+// Rewound returns the records rewound to so far
+func (c *Consumer) Rewound() []*kgo.Record {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*kgo.Record(nil), c.rewound...)
+}
+
+// Assign calls the listener's OnPartitionsAssigned
+func (c *Consumer) Assign(ctx context.Context, partitions map[string][]int32) {
+	c.listener.OnPartitionsAssigned(ctx, partitions)
+}
+
+// Revoke calls the listener's OnPartitionsRevoked
+func (c *Consumer) Revoke(ctx context.Context, partitions map[string][]int32) {
+	c.listener.OnPartitionsRevoked(ctx, partitions)
+}
+
+// Lose calls the listener's OnPartitionsLost
+func (c *Consumer) Lose(ctx context.Context, partitions map[string][]int32) {
+	c.listener.OnPartitionsLost(ctx, partitions)
+}
+
+// Message is a produced record
+type Message struct {
+	Topic string
+	Key   string
+	Value []byte
+}
+
+// Producer keeps produced messages in memory
+type Producer struct {
+	messages []Message
+	mu       sync.Mutex
+}
+
+// ProduceJSON encodes value and keeps the message
+func (p *Producer) ProduceJSON(ctx context.Context, topic, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.messages = append(p.messages, Message{Topic: topic, Key: key, Value: data})
+	p.mu.Unlock()
+	return nil
+}
+
+// Messages returns the messages produced to topic
+func (p *Producer) Messages(topic string) []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []Message
+	for _, m := range p.messages {
+		if m.Topic == topic {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// stateKey identifies the handoff state of one input partition
+type stateKey struct {
+	group     string
+	topic     string
+	partition int32
+}
+
+// Store keeps handed-off state and created window tables in memory
+type Store struct {
+	state  map[stateKey]map[string][]byte
+	tables map[string]bool
+	mu     sync.Mutex
+}
+
+// NewStore creates an empty store
+func NewStore() *Store {
+	return &Store{
+		state:  make(map[stateKey]map[string][]byte),
+		tables: make(map[string]bool),
+	}
+}
+
+// SaveProcessorState replaces the handoff state of a partition with entries
+func (s *Store) SaveProcessorState(ctx context.Context, group, topic string, partition int32, entries map[string][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := stateKey{group, topic, partition}
+	s.state[key] = make(map[string][]byte, len(entries))
+	for k, v := range entries {
+		s.state[key][k] = v
+	}
+	return nil
+}
+
+// TakeProcessorState removes and returns the handoff state of a partition
+func (s *Store) TakeProcessorState(ctx context.Context, group, topic string, partition int32) (map[string][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := stateKey{group, topic, partition}
+	entries := s.state[key]
+	delete(s.state, key)
+	return entries, nil
+}
+
+// EnsureWindowTable records the table as created
+func (s *Store) EnsureWindowTable(ctx context.Context, table string) error {
+	s.mu.Lock()
+	s.tables[table] = true
+	s.mu.Unlock()
+	return nil
+}
+
+// Table reports whether a window table was created
+func (s *Store) Table(table string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tables[table]
+}
+
+// Sink keeps written windows in memory. While Fail is set every write fails.
+type Sink struct {
+	Fail bool
+	// Block, if set, holds every Write until it is closed; Blocked, if set,
+	// is signalled when a Write starts waiting
+	Block   chan struct{}
+	Blocked chan struct{}
+
+	windows []*sink.Window
+	mu      sync.Mutex
+}
+
+// ErrSinkDown is returned by a failing Sink
+var ErrSinkDown = errors.New("sink down")
+
+// Write keeps the windows not delivered to it yet, or fails each while Fail
+// is set
+func (s *Sink) Write(ctx context.Context, windows []*sink.Window, delivered []map[string]bool) []error {
+	if s.Block != nil {
+		if s.Blocked != nil {
+			s.Blocked <- struct{}{}
+		}
+		<-s.Block
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errs := make([]error, len(windows))
+	for i, w := range windows {
+		if delivered[i]["test"] {
+			continue
+		}
+		if s.Fail {
+			errs[i] = ErrSinkDown
+			continue
+		}
+		s.windows = append(s.windows, w)
+		delivered[i]["test"] = true
+	}
+	return errs
+}
+
+// Windows returns the windows written so far
+func (s *Sink) Windows() []*sink.Window {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*sink.Window(nil), s.windows...)
+}
+
+// DeadLetter is a record handed to the DLQ with the reason it failed
+type DeadLetter struct {
+	Record *kgo.Record
+	Cause  error
+}
+
+// DLQ keeps dead-lettered records in memory
+type DLQ struct {
+	letters []DeadLetter
+	err     error
+	mu      sync.Mutex
+}
+
+// Publish keeps the record, or returns the error set by SetError
+func (d *DLQ) Publish(ctx context.Context, record *kgo.Record, cause error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		return d.err
+	}
+	d.letters = append(d.letters, DeadLetter{Record: record, Cause: cause})
+	return nil
+}
+
+// SetError makes Publish fail with err, or succeed again for nil
+func (d *DLQ) SetError(err error) {
+	d.mu.Lock()
+	d.err = err
+	d.mu.Unlock()
+}
+
+// Letters returns the records dead-lettered so far
+func (d *DLQ) Letters() []DeadLetter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]DeadLetter(nil), d.letters...)
+}
+
+// Budgets keeps the windows whose spend was recorded, once per flush ID
+type Budgets struct {
+	recorded []*models.LLMMetrics
+	flushes  map[string]bool
+	err      error
+	mu       sync.Mutex
+}
+
+// Record keeps the window unless its flush was recorded already
+func (b *Budgets) Record(ctx context.Context, flushID string, m *models.LLMMetrics) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	if b.flushes[flushID] {
+		return nil
+	}
+	if b.flushes == nil {
+		b.flushes = make(map[string]bool)
+	}
+	b.flushes[flushID] = true
+	b.recorded = append(b.recorded, m)
+	return nil
+}
+
+// SetError makes Record fail with err, or succeed again if err is nil
+func (b *Budgets) SetError(err error) {
+	b.mu.Lock()
+	b.err = err
+	b.mu.Unlock()
+}
+
+// Recorded returns the windows recorded so far
+func (b *Budgets) Recorded() []*models.LLMMetrics {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*models.LLMMetrics(nil), b.recorded...)
+}
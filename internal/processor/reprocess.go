@@ -0,0 +1,168 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"streamlens/internal/clock"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/pricing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Reprocessor recomputes the default one-minute windows of a past range from
+// llm.requests and llm.responses, in event time, or from the joined events of
+// the archive. It joins the records itself instead of reading llm.joined and
+// writes to no sink, so that its windows can be swapped into llm_metrics in
+// one step. All windows of the range are held in memory until the run ends.
+type Reprocessor struct {
+	proc            *MetricsProcessor
+	from, to        time.Time
+	responseTimeout time.Duration
+
+	requests  map[string]*models.LLMRequest
+	responses map[string]*models.LLMResponse
+	// seen holds the request IDs of the archived events added
+	seen map[string]bool
+
+	// Joined counts the pairs aggregated, Abandoned the requests aggregated
+	// without a response, and OutOfRange those whose request falls outside
+	// [from, to). Duplicates counts the archived events added more than once.
+	Joined     int
+	Abandoned  int
+	OutOfRange int
+	Duplicates int
+}
+
+// NewReprocessor creates a reprocessor for windows starting in [from, to),
+// which must be aligned to WindowDuration. Requests are abandoned after
+// responseTimeout like in the live joiner.
+func NewReprocessor(pricing *pricing.Catalog, classifier *ErrorClassifier, dimensions *DimensionResolver, from, to time.Time, responseTimeout time.Duration) *Reprocessor {
+	if responseTimeout <= 0 {
+		responseTimeout = StateRetentionDuration
+	}
+	return &Reprocessor{
+		proc: &MetricsProcessor{
+			pricing:          pricing,
+			classifier:       classifier,
+			dimensions:       dimensions,
+			windows:          []WindowDefinition{DefaultWindow},
+			clock:            clock.Real,
+			windowAggregates: make(map[string]*WindowAggregate),
+		},
+		from:            from,
+		to:              to,
+		responseTimeout: responseTimeout,
+		requests:        make(map[string]*models.LLMRequest),
+		responses:       make(map[string]*models.LLMResponse),
+		seen:            make(map[string]bool),
+	}
+}
+
+// Add joins a request or response record with its other half, aggregating
+// the pair once both were seen
+func (r *Reprocessor) Add(ctx context.Context, record *kgo.Record) error {
+	switch record.Topic {
+	case kafka.TopicLLMRequests:
+		var req models.LLMRequest
+		if err := json.Unmarshal(record.Value, &req); err != nil {
+			return fmt.Errorf("failed to unmarshal request: %w", err)
+		}
+		if resp, found := r.responses[req.RequestID]; found {
+			delete(r.responses, req.RequestID)
+			r.aggregate(ctx, &req, resp)
+		} else {
+			r.requests[req.RequestID] = &req
+		}
+	case kafka.TopicLLMResponses:
+		var resp models.LLMResponse
+		if err := json.Unmarshal(record.Value, &resp); err != nil {
+			return fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+		if req, found := r.requests[resp.RequestID]; found {
+			delete(r.requests, resp.RequestID)
+			r.aggregate(ctx, req, &resp)
+		} else {
+			r.responses[resp.RequestID] = &resp
+		}
+	default:
+		return fmt.Errorf("unknown topic: %s", record.Topic)
+	}
+	return nil
+}
+
+// AddJoined aggregates an archived joined event. The archive holds every
+// event at least once, so events already added are skipped.
+func (r *Reprocessor) AddJoined(ctx context.Context, event *models.JoinedEvent) {
+	if r.seen[event.Request.RequestID] {
+		r.Duplicates++
+		return
+	}
+	r.seen[event.Request.RequestID] = true
+
+	var resp *models.LLMResponse
+	if !event.Abandoned {
+		resp = &event.Response
+	}
+	r.aggregate(ctx, &event.Request, resp)
+}
+
+// aggregate adds a joined pair to its window if its request is in range. A
+// response later than the response timeout does not count, the live joiner
+// had abandoned the request by then.
+func (r *Reprocessor) aggregate(ctx context.Context, req *models.LLMRequest, resp *models.LLMResponse) {
+	if req.Timestamp.Before(r.from) || !req.Timestamp.Before(r.to) {
+		r.OutOfRange++
+		return
+	}
+	if resp != nil && resp.Timestamp.Sub(req.Timestamp) > r.responseTimeout {
+		resp = nil
+	}
+	if resp == nil {
+		r.Abandoned++
+	} else {
+		r.Joined++
+	}
+	r.proc.aggregateEvent(req, resp, r.proc.dimensions.Resolve(ctx, req), 0)
+}
+
+// Abandon aggregates the requests still without a response as abandoned. It
+// is called once every record of the range was added.
+func (r *Reprocessor) Abandon(ctx context.Context) {
+	for id, req := range r.requests {
+		delete(r.requests, id)
+		r.aggregate(ctx, req, nil)
+	}
+}
+
+// Unmatched returns the number of requests and responses whose other half
+// was not read. Like expired join state, responses are left out of every
+// window; requests are counted by Abandon.
+func (r *Reprocessor) Unmatched() int {
+	return len(r.requests) + len(r.responses)
+}
+
+// Windows computes the metrics of every window, ordered by start
+func (r *Reprocessor) Windows() []*models.LLMMetrics {
+	keys := make([]string, 0, len(r.proc.windowAggregates))
+	for key := range r.proc.windowAggregates {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := r.proc.windowAggregates[keys[i]], r.proc.windowAggregates[keys[j]]
+		if !a.WindowStart.Equal(b.WindowStart) {
+			return a.WindowStart.Before(b.WindowStart)
+		}
+		return keys[i] < keys[j]
+	})
+
+	windows := make([]*models.LLMMetrics, 0, len(keys))
+	for _, key := range keys {
+		windows = append(windows, r.proc.computeMetrics(r.proc.windowAggregates[key]))
+	}
+	return windows
+}
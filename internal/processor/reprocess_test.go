@@ -0,0 +1,145 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/pricing"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestReprocessor(t *testing.T) {
+	ctx := context.Background()
+	catalog, err := pricing.NewCatalog(ctx, "", nil, time.Minute)
+	if err != nil {
+		t.Fatalf("NewCatalog() error = %v", err)
+	}
+	classifier, err := NewErrorClassifier("")
+	if err != nil {
+		t.Fatalf("NewErrorClassifier() error = %v", err)
+	}
+
+	from := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+	to := from.Add(2 * time.Minute)
+	r := NewReprocessor(catalog, classifier, NewDimensionResolver(nil, time.Minute), from, to, 2*time.Minute)
+
+	record := func(topic string, v interface{}) *kgo.Record {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &kgo.Record{Topic: topic, Value: data}
+	}
+	request := func(id string, ts time.Time) *kgo.Record {
+		return record(kafka.TopicLLMRequests, models.LLMRequest{RequestID: id, TenantID: "t1", Route: "/chat", Model: "gpt-4", Timestamp: ts})
+	}
+	response := func(id string, latency int) *kgo.Record {
+		return record(kafka.TopicLLMResponses, models.LLMResponse{RequestID: id, LatencyMs: latency, FinishReason: "stop"})
+	}
+
+	records := []*kgo.Record{
+		request("a", from.Add(10*time.Second)),
+		response("a", 100),
+		response("b", 300), // response read before its request
+		request("b", from.Add(70*time.Second)),
+		request("c", from.Add(-30*time.Second)), // before the range
+		response("c", 100),
+		request("d", to), // after the range
+		response("d", 100),
+		request("e", from.Add(20*time.Second)), // never answered
+		request("f", from.Add(30*time.Second)), // answered after the timeout
+		record(kafka.TopicLLMResponses, models.LLMResponse{RequestID: "f", Timestamp: from.Add(3 * time.Minute), LatencyMs: 150000}),
+	}
+	for _, rec := range records {
+		if err := r.Add(ctx, rec); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if err := r.Add(ctx, &kgo.Record{Topic: kafka.TopicLLMRequests, Value: []byte("{")}); err == nil {
+		t.Error("Add() of malformed record expected error")
+	}
+
+	r.Abandon(ctx)
+	if r.Joined != 2 || r.Abandoned != 2 || r.OutOfRange != 2 || r.Unmatched() != 0 {
+		t.Errorf("joined %d, abandoned %d, out of range %d, unmatched %d; want 2, 2, 2, 0",
+			r.Joined, r.Abandoned, r.OutOfRange, r.Unmatched())
+	}
+
+	// Each minute has a window of its route and model, and three across them
+	all := r.Windows()
+	if len(all) != 8 {
+		t.Fatalf("got %d windows, want 8", len(all))
+	}
+	var windows []*models.LLMMetrics
+	for _, w := range all {
+		if w.Route != models.Wildcard && w.Model != models.Wildcard {
+			windows = append(windows, w)
+		}
+	}
+	if len(windows) != 2 {
+		t.Fatalf("got %d windows of the route and model, want 2", len(windows))
+	}
+	for i, want := range []struct {
+		start     time.Time
+		latency   int64
+		abandoned int
+	}{
+		{from, 100, 2},
+		{from.Add(time.Minute), 300, 0},
+	} {
+		w := windows[i]
+		if !w.WindowStart.Equal(want.start) || w.Requests != 1 || w.LatencySumMs != want.latency || w.Abandoned != want.abandoned {
+			t.Errorf("window %d = %s, %d requests, %d ms, %d abandoned; want %s, 1, %d, %d",
+				i, w.WindowStart, w.Requests, w.LatencySumMs, w.Abandoned, want.start, want.latency, want.abandoned)
+		}
+	}
+}
+
+func TestReprocessor_AddJoined(t *testing.T) {
+	ctx := context.Background()
+	catalog, err := pricing.NewCatalog(ctx, "", nil, time.Minute)
+	if err != nil {
+		t.Fatalf("NewCatalog() error = %v", err)
+	}
+	classifier, err := NewErrorClassifier("")
+	if err != nil {
+		t.Fatalf("NewErrorClassifier() error = %v", err)
+	}
+
+	from := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+	to := from.Add(time.Minute)
+	r := NewReprocessor(catalog, classifier, NewDimensionResolver(nil, time.Minute), from, to, 2*time.Minute)
+
+	joined := func(id string, ts time.Time, abandoned bool) *models.JoinedEvent {
+		return &models.JoinedEvent{
+			Request:   models.LLMRequest{RequestID: id, TenantID: "t1", Route: "/chat", Model: "gpt-4", Timestamp: ts},
+			Response:  models.LLMResponse{RequestID: id, Timestamp: ts.Add(time.Second), LatencyMs: 200, FinishReason: "stop"},
+			Abandoned: abandoned,
+		}
+	}
+	for _, ev := range []*models.JoinedEvent{
+		joined("a", from.Add(10*time.Second), false),
+		joined("a", from.Add(10*time.Second), false), // archived twice
+		joined("b", from.Add(20*time.Second), true),
+		joined("c", to, false), // after the range
+	} {
+		r.AddJoined(ctx, ev)
+	}
+	if r.Joined != 1 || r.Abandoned != 1 || r.OutOfRange != 1 || r.Duplicates != 1 {
+		t.Errorf("joined %d, abandoned %d, out of range %d, duplicates %d; want 1, 1, 1, 1",
+			r.Joined, r.Abandoned, r.OutOfRange, r.Duplicates)
+	}
+
+	for _, w := range r.Windows() {
+		if w.Route == models.Wildcard || w.Model == models.Wildcard {
+			continue
+		}
+		if w.Requests != 1 || w.LatencySumMs != 200 || w.Abandoned != 1 {
+			t.Errorf("window = %d requests, %d ms, %d abandoned; want 1, 200, 1", w.Requests, w.LatencySumMs, w.Abandoned)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/sketch"
+	"time"
+)
+
+// windowSnapshot is the serialized form of an open WindowAggregate, used to
+// hand windows over to another instance on rebalance
+type windowSnapshot struct {
+	Window              string            `json:"window"`
+	TenantID            string            `json:"tenant_id"`
+	Route               string            `json:"route"`
+	Model               string            `json:"model"`
+	SessionID           string            `json:"session_id,omitempty"`
+	Dimensions          models.Dimensions `json:"dimensions,omitempty"`
+	WindowStart         time.Time         `json:"window_start"`
+	WindowEnd           time.Time         `json:"window_end"`
+	Requests            int               `json:"requests"`
+	Errors              int               `json:"errors"`
+	Abandoned           int               `json:"abandoned,omitempty"`
+	LatencySumMs        int64             `json:"latency_sum_ms"`
+	PromptTokensSum     int64             `json:"prompt_tokens_sum"`
+	CompletionTokensSum int64             `json:"completion_tokens_sum"`
+	CostUSD             float64           `json:"cost_usd"`
+	LatencySketch       []byte            `json:"latency_sketch"`
+
+	ErrorClasses  map[string]int `json:"error_classes,omitempty"`
+	FinishReasons map[string]int `json:"finish_reasons,omitempty"`
+
+	Users     []byte         `json:"users,omitempty"`
+	UserCalls map[string]int `json:"user_calls,omitempty"`
+
+	FlushID   string          `json:"flush_id,omitempty"`
+	Delivered map[string]bool `json:"delivered,omitempty"`
+}
+
+// snapshot serializes the aggregate
+func (a *WindowAggregate) snapshot() ([]byte, error) {
+	latencies, err := a.LatencySketch.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	users, err := a.Users.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(windowSnapshot{
+		Window:              a.Definition.Name,
+		TenantID:            a.TenantID,
+		Route:               a.Route,
+		Model:               a.Model,
+		SessionID:           a.SessionID,
+		Dimensions:          a.Dimensions,
+		WindowStart:         a.WindowStart,
+		WindowEnd:           a.WindowEnd,
+		Requests:            a.Requests,
+		Errors:              a.Errors,
+		Abandoned:           a.Abandoned,
+		LatencySumMs:        a.LatencySumMs,
+		PromptTokensSum:     a.PromptTokensSum,
+		CompletionTokensSum: a.CompletionTokensSum,
+		CostUSD:             a.CostUSD,
+		LatencySketch:       latencies,
+		ErrorClasses:        a.ErrorClasses,
+		FinishReasons:       a.FinishReasons,
+		Users:               users,
+		UserCalls:           a.UserCalls,
+		FlushID:             a.FlushID,
+		Delivered:           a.Delivered,
+	})
+}
+
+// restoreWindow decodes a snapshot into an aggregate of a configured definition
+func (p *MetricsProcessor) restoreWindow(data []byte, partition int32) (*WindowAggregate, error) {
+	var snap windowSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+
+	def := p.windowDefinition(snap.Window)
+	if def == nil {
+		return nil, fmt.Errorf("window definition %q is not configured", snap.Window)
+	}
+
+	latencies, err := sketch.Decode(snap.LatencySketch)
+	if err != nil {
+		return nil, err
+	}
+	users, err := sketch.DecodeHLL(snap.Users)
+	if err != nil {
+		return nil, err
+	}
+
+	agg := &WindowAggregate{
+		Definition:          def,
+		Partition:           partition,
+		TenantID:            snap.TenantID,
+		Route:               snap.Route,
+		Model:               snap.Model,
+		SessionID:           snap.SessionID,
+		Dimensions:          snap.Dimensions,
+		WindowStart:         snap.WindowStart,
+		WindowEnd:           snap.WindowEnd,
+		Requests:            snap.Requests,
+		Errors:              snap.Errors,
+		Abandoned:           snap.Abandoned,
+		LatencySumMs:        snap.LatencySumMs,
+		PromptTokensSum:     snap.PromptTokensSum,
+		CompletionTokensSum: snap.CompletionTokensSum,
+		CostUSD:             snap.CostUSD,
+		LatencySketch:       latencies,
+		ErrorClasses:        snap.ErrorClasses,
+		FinishReasons:       snap.FinishReasons,
+		Users:               users,
+		UserCalls:           snap.UserCalls,
+		FlushID:             snap.FlushID,
+		Delivered:           snap.Delivered,
+	}
+	if agg.ErrorClasses == nil {
+		agg.ErrorClasses = make(map[string]int)
+	}
+	if agg.FinishReasons == nil {
+		agg.FinishReasons = make(map[string]int)
+	}
+	if agg.UserCalls == nil {
+		agg.UserCalls = make(map[string]int)
+	}
+	return agg, nil
+}
+
+// windowDefinition returns the configured definition with the given name
+func (p *MetricsProcessor) windowDefinition(name string) *WindowDefinition {
+	for i := range p.windows {
+		if p.windows[i].Name == name {
+			return &p.windows[i]
+		}
+	}
+	return nil
+}
+
+// OnPartitionsRevoked hands the open windows of revoked partitions to the
+// next owner. If the handoff cannot be saved, the windows are flushed as they
+// are instead of being dropped.
+func (p *MetricsProcessor) OnPartitionsRevoked(ctx context.Context, revoked map[string][]int32) {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+	p.windowMu.Lock()
+	defer p.windowMu.Unlock()
+
+	for _, partition := range revoked[kafka.TopicLLMJoined] {
+		p.unspillWindowsLocked(func(e spilledEntry) bool { return e.Partition == partition }, false)
+		owned := p.windowsOfPartitionLocked(partition)
+
+		entries := make(map[string][]byte, len(owned))
+		for key, agg := range owned {
+			data, err := agg.snapshot()
+			if err != nil {
+				log.Printf("Failed to encode window %s: %v", key, err)
+				continue
+			}
+			entries[key] = data
+		}
+
+		if err := p.store.SaveProcessorState(ctx, p.consumer.Group(), kafka.TopicLLMJoined, partition, entries); err != nil {
+			log.Printf("Failed to hand off windows of partition %d, flushing them instead: %v", partition, err)
+			flushes := make([]pendingFlush, 0, len(owned))
+			for key, agg := range owned {
+				flushes = append(flushes, pendingFlush{key: key, agg: agg})
+			}
+			p.writeWindows(ctx, flushes, true)
+		} else if len(owned) > 0 {
+			log.Printf("Handed off %d open windows of partition %d", len(owned), partition)
+		}
+
+		p.removeWindowsLocked(owned)
+	}
+}
+
+// OnPartitionsAssigned restores windows handed off by the previous owner
+func (p *MetricsProcessor) OnPartitionsAssigned(ctx context.Context, assigned map[string][]int32) {
+	for _, partition := range assigned[kafka.TopicLLMJoined] {
+		entries, err := p.store.TakeProcessorState(ctx, p.consumer.Group(), kafka.TopicLLMJoined, partition)
+		if err != nil {
+			log.Printf("Failed to restore windows of partition %d: %v", partition, err)
+			continue
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		p.windowMu.Lock()
+		restored := 0
+		for key, data := range entries {
+			agg, err := p.restoreWindow(data, partition)
+			if err != nil {
+				log.Printf("Failed to restore window %s: %v", key, err)
+				continue
+			}
+			p.addWindowLocked(agg)
+			restored++
+		}
+		p.spillWindowsLocked()
+		p.windowMu.Unlock()
+
+		log.Printf("Restored %d open windows of partition %d", restored, partition)
+	}
+}
+
+// OnPartitionsLost drops the open windows of partitions lost without a handoff
+func (p *MetricsProcessor) OnPartitionsLost(ctx context.Context, lost map[string][]int32) {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+	p.windowMu.Lock()
+	defer p.windowMu.Unlock()
+
+	for _, partition := range lost[kafka.TopicLLMJoined] {
+		p.unspillWindowsLocked(func(e spilledEntry) bool { return e.Partition == partition }, true)
+		owned := p.windowsOfPartitionLocked(partition)
+		p.removeWindowsLocked(owned)
+		log.Printf("Lost partition %d, dropped %d open windows", partition, len(owned))
+	}
+}
+
+// windowsOfPartitionLocked returns the open windows owned by a partition,
+// keyed by a unique state key. Callers must hold windowMu.
+func (p *MetricsProcessor) windowsOfPartitionLocked(partition int32) map[string]*WindowAggregate {
+	owned := make(map[string]*WindowAggregate)
+	for key, agg := range p.windowAggregates {
+		if agg.Partition == partition {
+			owned[key] = agg
+		}
+	}
+	for key, sessions := range p.sessionAggregates {
+		for _, agg := range sessions {
+			if agg.Partition == partition {
+				owned[fmt.Sprintf("%s|%d", key, agg.WindowStart.UnixNano())] = agg
+			}
+		}
+	}
+	return owned
+}
+
+// removeWindowsLocked drops the given windows from memory. Callers must hold windowMu.
+func (p *MetricsProcessor) removeWindowsLocked(windows map[string]*WindowAggregate) {
+	drop := make(map[*WindowAggregate]bool, len(windows))
+	for _, agg := range windows {
+		drop[agg] = true
+	}
+
+	for key, agg := range p.windowAggregates {
+		if drop[agg] {
+			delete(p.windowAggregates, key)
+		}
+	}
+	for key, sessions := range p.sessionAggregates {
+		var open []*WindowAggregate
+		for _, agg := range sessions {
+			if !drop[agg] {
+				open = append(open, agg)
+			}
+		}
+		if len(open) == 0 {
+			delete(p.sessionAggregates, key)
+		} else {
+			p.sessionAggregates[key] = open
+		}
+	}
+}
+
+// addWindowLocked inserts a restored window, merging it with any window
+// still open for the same key. Callers must hold windowMu.
+func (p *MetricsProcessor) addWindowLocked(agg *WindowAggregate) {
+	if agg.Definition.Type == WindowSession {
+		key := sessionKey(agg.Definition.Name, agg.TenantID, agg.Route, agg.Model, agg.SessionID)
+		p.sessionAggregates[key] = append(p.sessionAggregates[key], agg)
+		return
+	}
+
+	// A sealed window is retried as it is
+	if agg.FlushID != "" {
+		p.windowAggregates[agg.sealedKey()] = agg
+		return
+	}
+
+	key := windowKey(agg.Definition.Name, agg.TenantID, agg.Route, agg.Model, agg.Dimensions.Key(), agg.WindowStart)
+	if existing, ok := p.windowAggregates[key]; ok {
+		existing.merge(agg)
+		return
+	}
+	p.windowAggregates[key] = agg
+}
+
+// spillWindowsLocked moves the oldest fixed windows to disk once more are
+// open than the budget allows; they mostly wait for their flush and see only
+// late events. Sessions are never spilled. Callers must hold windowMu.
+func (p *MetricsProcessor) spillWindowsLocked() {
+	n := spillExcess(len(p.windowAggregates), p.maxWindows)
+	if p.spill == nil || n == 0 {
+		return
+	}
+
+	entries := make([]coldEntry, 0, len(p.windowAggregates))
+	for key, agg := range p.windowAggregates {
+		entries = append(entries, coldEntry{key: key, tenant: agg.TenantID, age: agg.WindowStart})
+	}
+	keys, tenants := selectSpill(entries, n)
+
+	batch := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		agg := p.windowAggregates[key]
+		snap, err := agg.snapshot()
+		if err != nil {
+			log.Printf("Failed to encode window %s: %v", key, err)
+			continue
+		}
+		data, err := encodeSpilled(agg.Partition, snap)
+		if err != nil {
+			log.Printf("Failed to encode window %s: %v", key, err)
+			continue
+		}
+		batch[key] = data
+	}
+	if err := p.spill.Put(spillWindows, batch); err != nil {
+		log.Printf("Failed to spill windows, keeping them in memory: %v", err)
+		return
+	}
+	for key := range batch {
+		delete(p.windowAggregates, key)
+	}
+	log.Printf("Open windows over %d, spilled %d to disk (by tenant: %v)", p.maxWindows, len(batch), tenants)
+}
+
+// takeSpilledWindowLocked removes a window from disk. Callers must hold
+// windowMu.
+func (p *MetricsProcessor) takeSpilledWindowLocked(key string) (*WindowAggregate, bool) {
+	if p.spill == nil || p.spill.Len(spillWindows) == 0 {
+		return nil, false
+	}
+	data, ok, err := p.spill.Take(spillWindows, key)
+	if err != nil {
+		log.Printf("Failed to read spilled window %s: %v", key, err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	e, err := decodeSpilled(data)
+	if err != nil {
+		log.Printf("Failed to decode spilled window %s: %v", key, err)
+		return nil, false
+	}
+	agg, err := p.restoreWindow(e.Value, e.Partition)
+	if err != nil {
+		log.Printf("Failed to restore spilled window %s: %v", key, err)
+		return nil, false
+	}
+	return agg, true
+}
+
+// unspillWindowsLocked moves the spilled windows for which match reports
+// true back into memory, or drops them. Callers must hold windowMu.
+func (p *MetricsProcessor) unspillWindowsLocked(match func(spilledEntry) bool, drop bool) {
+	if p.spill == nil || p.spill.Len(spillWindows) == 0 {
+		return
+	}
+
+	taken, err := p.spill.TakeAll(spillWindows, func(_ string, data []byte) bool {
+		e, err := decodeSpilled(data)
+		return err == nil && match(e)
+	})
+	if err != nil {
+		log.Printf("Failed to read spilled windows: %v", err)
+		return
+	}
+	if drop {
+		return
+	}
+
+	for key, data := range taken {
+		e, err := decodeSpilled(data)
+		if err != nil {
+			log.Printf("Failed to decode spilled window %s: %v", key, err)
+			continue
+		}
+		agg, err := p.restoreWindow(e.Value, e.Partition)
+		if err != nil {
+			log.Printf("Failed to restore spilled window %s: %v", key, err)
+			continue
+		}
+		p.addWindowLocked(agg)
+	}
+}
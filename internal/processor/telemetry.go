@@ -0,0 +1,73 @@
+package processor
+
+import (
+	"streamlens/internal/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Join results counted by joinEvents
+const (
+	joinJoined          = "joined"
+	joinAbandoned       = "abandoned"
+	joinExpiredResponse = "expired_response"
+)
+
+var (
+	joinEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "joiner",
+		Name:      "events_total",
+		Help:      "Requests and responses by join result: joined pairs, requests abandoned without a response, and responses expired without their request.",
+	}, []string{"result"})
+
+	joinPending = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "joiner",
+		Name:      "pending",
+		Help:      "Requests and responses held in join state, waiting for their counterpart.",
+	}, []string{"state"})
+
+	openWindows = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "processor",
+		Name:      "open_windows",
+		Help:      "Windows held in memory, by window definition.",
+	}, []string{"window"})
+
+	flushDelay = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "processor",
+		Name:      "flush_delay_seconds",
+		Help:      "Time from the end of a window until it was written to every sink, by window definition.",
+		Buckets:   []float64{30, 60, 75, 90, 120, 180, 300, 600, 1800},
+	}, []string{"window"})
+)
+
+// observeStateLocked records the size of the join state. Callers must hold
+// stateMu.
+func (j *Joiner) observeStateLocked() {
+	joinPending.WithLabelValues("request").Set(float64(len(j.requestState)))
+	joinPending.WithLabelValues("response").Set(float64(len(j.responseState)))
+}
+
+// observeWindowsLocked records the number of open windows per definition.
+// Callers must hold windowMu.
+func (p *MetricsProcessor) observeWindowsLocked() {
+	counts := make(map[string]int, len(p.windows))
+	for i := range p.windows {
+		counts[p.windows[i].Name] = 0
+	}
+	for _, agg := range p.windowAggregates {
+		counts[agg.Definition.Name]++
+	}
+	for _, sessions := range p.sessionAggregates {
+		for _, agg := range sessions {
+			counts[agg.Definition.Name]++
+		}
+	}
+	for name, n := range counts {
+		openWindows.WithLabelValues(name).Set(float64(n))
+	}
+}
@@ -0,0 +1,385 @@
+package processor
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/sketch"
+	"strings"
+	"time"
+)
+
+// WindowType is the kind of window a definition produces
+type WindowType string
+
+const (
+	// WindowTumbling windows are fixed-size and non-overlapping
+	WindowTumbling WindowType = "tumbling"
+	// WindowHopping windows are fixed-size and start every Advance, so they overlap
+	WindowHopping WindowType = "hopping"
+	// WindowSession windows group events of one session_id until a gap of inactivity
+	WindowSession WindowType = "session"
+)
+
+// DefaultWindowName is the name of the built-in one-minute tumbling window
+const DefaultWindowName = "default"
+
+// SessionIDMetadataKey is the LLMRequest metadata key session windows group by
+const SessionIDMetadataKey = "session_id"
+
+// maxHopsPerWindow bounds the number of overlapping windows an event is added to
+const maxHopsPerWindow = 120
+
+var windowNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,47}$`)
+
+// reservedOutputs are the topics and tables written other than by window
+// definitions, which a definition must not share
+var reservedOutputs = map[string]bool{
+	kafka.TopicLLMMetricsDimensions: true,
+	kafka.TopicLLMMetricsGrouped:    true,
+	"llm_metrics_5m":                true,
+	"llm_metrics_1h":                true,
+	"llm_metrics_1d":                true,
+	"llm_metrics_breakdown":         true,
+}
+
+// WindowDefinition describes one windowed output stream
+type WindowDefinition struct {
+	Name    string
+	Type    WindowType
+	Size    time.Duration // window length (tumbling, hopping)
+	Advance time.Duration // hop between window starts (hopping)
+	Gap     time.Duration // inactivity gap that closes a session (session)
+	Topic   string        // Kafka topic the windows are produced to
+	Table   string        // Postgres table the windows are written to
+}
+
+// DefaultWindow is the one-minute tumbling window that feeds llm.metrics and llm_metrics
+var DefaultWindow = WindowDefinition{
+	Name:  DefaultWindowName,
+	Type:  WindowTumbling,
+	Size:  WindowDuration,
+	Topic: kafka.TopicLLMMetrics,
+	Table: "llm_metrics",
+}
+
+// WindowTable returns the Postgres table for a named window definition
+func WindowTable(name string) string {
+	if name == DefaultWindowName {
+		return DefaultWindow.Table
+	}
+	return "llm_window_" + name
+}
+
+// WindowTopic returns the Kafka topic for a named window definition
+func WindowTopic(name string) string {
+	if name == DefaultWindowName {
+		return DefaultWindow.Topic
+	}
+	return kafka.TopicLLMMetrics + "." + name
+}
+
+// ValidWindowName reports whether name can be used for a window definition
+func ValidWindowName(name string) bool {
+	return windowNamePattern.MatchString(name)
+}
+
+// ParseWindowDefinitions parses a spec of semicolon-separated definitions, each
+// of the form name:type:size[:advance]. For session windows size is the
+// inactivity gap. The default window is always included first.
+//
+// Example: "errors_5m:hopping:5m:30s;chat_sessions:session:30m"
+func ParseWindowDefinitions(spec string) ([]WindowDefinition, error) {
+	defs := []WindowDefinition{DefaultWindow}
+	seen := map[string]bool{DefaultWindowName: true}
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("window %q: expected name:type:size[:advance]", entry)
+		}
+
+		def := WindowDefinition{Name: parts[0], Type: WindowType(parts[1])}
+		if !ValidWindowName(def.Name) {
+			return nil, fmt.Errorf("window %q: name must match %s", def.Name, windowNamePattern)
+		}
+		if seen[def.Name] {
+			return nil, fmt.Errorf("window %q: defined more than once", def.Name)
+		}
+		seen[def.Name] = true
+
+		size, err := time.ParseDuration(parts[2])
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("window %q: invalid size %q", def.Name, parts[2])
+		}
+
+		switch def.Type {
+		case WindowTumbling:
+			if len(parts) != 3 {
+				return nil, fmt.Errorf("window %q: tumbling windows take no advance", def.Name)
+			}
+			def.Size = size
+		case WindowHopping:
+			if len(parts) != 4 {
+				return nil, fmt.Errorf("window %q: hopping windows require an advance", def.Name)
+			}
+			advance, err := time.ParseDuration(parts[3])
+			if err != nil || advance <= 0 || advance > size {
+				return nil, fmt.Errorf("window %q: invalid advance %q", def.Name, parts[3])
+			}
+			if size/advance > maxHopsPerWindow {
+				return nil, fmt.Errorf("window %q: size/advance must be at most %d", def.Name, maxHopsPerWindow)
+			}
+			def.Size = size
+			def.Advance = advance
+		case WindowSession:
+			if len(parts) != 3 {
+				return nil, fmt.Errorf("window %q: session windows take only a gap", def.Name)
+			}
+			def.Gap = size
+		default:
+			return nil, fmt.Errorf("window %q: unknown type %q", def.Name, parts[1])
+		}
+
+		def.Topic = WindowTopic(def.Name)
+		def.Table = WindowTable(def.Name)
+		for _, output := range []string{def.Topic, def.Table} {
+			if reservedOutputs[output] {
+				return nil, fmt.Errorf("window %q: %s is reserved", def.Name, output)
+			}
+		}
+		defs = append(defs, def)
+	}
+
+	return defs, nil
+}
+
+// windowSpan is a [start, end) interval an event was assigned to
+type windowSpan struct {
+	start time.Time
+	end   time.Time
+}
+
+// assign returns the fixed windows containing ts. Session windows are assigned
+// by the processor since they depend on the other events in the session.
+func (d *WindowDefinition) assign(ts time.Time) []windowSpan {
+	switch d.Type {
+	case WindowHopping:
+		// Latest window containing ts starts at the last advance boundary; walk
+		// back while the window still covers ts
+		var spans []windowSpan
+		for start := ts.Truncate(d.Advance); ts.Before(start.Add(d.Size)); start = start.Add(-d.Advance) {
+			spans = append(spans, windowSpan{start: start, end: start.Add(d.Size)})
+		}
+		sort.Slice(spans, func(i, j int) bool { return spans[i].start.Before(spans[j].start) })
+		return spans
+	default:
+		start := ts.Truncate(d.Size)
+		return []windowSpan{{start: start, end: start.Add(d.Size)}}
+	}
+}
+
+// flushInterval is how often windows of this definition can close
+func (d *WindowDefinition) flushInterval() time.Duration {
+	switch d.Type {
+	case WindowHopping:
+		return d.Advance
+	case WindowSession:
+		return d.Gap
+	default:
+		return d.Size
+	}
+}
+
+// WindowAggregate holds aggregated data for a time window
+type WindowAggregate struct {
+	Definition  *WindowDefinition
+	Partition   int32 // llm.joined partition that owns the window
+	TenantID    string
+	Route       string
+	Model       string
+	SessionID   string
+	Dimensions  models.Dimensions // promoted metadata values, nil for tenant totals
+	WindowStart time.Time
+	WindowEnd   time.Time
+
+	Requests            int
+	Errors              int
+	Abandoned           int // requests without a response, not in Requests
+	LatencySumMs        int64
+	PromptTokensSum     int64
+	CompletionTokensSum int64
+	CostUSD             float64
+	LatencySketch       *sketch.DDSketch // For percentile calculation
+
+	ErrorClasses  map[string]int // errors by models.ErrorClass
+	FinishReasons map[string]int // calls by normalized finish_reason
+
+	Users     *sketch.HLL    // distinct user_id_hash values
+	UserCalls map[string]int // calls by user_id_hash, kept until the window is flushed
+
+	// FlushID is set when the window is first flushed. From then on it is
+	// sealed: retries write the same content, and later events open a new
+	// window that is flushed, and merged by the sinks, on its own.
+	FlushID   string
+	Delivered map[string]bool // sinks that took the window when a flush partly failed
+}
+
+// newWindowAggregate creates an empty aggregate for a window of def
+func newWindowAggregate(def *WindowDefinition, req *models.LLMRequest, sessionID string, span windowSpan, partition int32) *WindowAggregate {
+	return &WindowAggregate{
+		Definition:    def,
+		Partition:     partition,
+		TenantID:      req.TenantID,
+		Route:         req.Route,
+		Model:         req.Model,
+		SessionID:     sessionID,
+		WindowStart:   span.start,
+		WindowEnd:     span.end,
+		LatencySketch: sketch.NewDefault(),
+		ErrorClasses:  make(map[string]int),
+		FinishReasons: make(map[string]int),
+		Users:         sketch.NewDefaultHLL(),
+		UserCalls:     make(map[string]int),
+	}
+}
+
+// add folds a joined request/response pair, priced at cost, into the
+// aggregate. class is the error class of a failed call and empty otherwise.
+// A nil resp counts an abandoned request.
+func (a *WindowAggregate) add(req *models.LLMRequest, resp *models.LLMResponse, cost float64, class models.ErrorClass) {
+	if resp == nil {
+		a.Abandoned++
+		return
+	}
+	a.Requests++
+
+	if class != "" {
+		a.Errors++
+		a.ErrorClasses[string(class)]++
+	}
+	if reason := normalizeFinishReason(resp.FinishReason); reason != "" {
+		a.addFinishReason(reason, 1)
+	}
+
+	a.LatencySumMs += int64(resp.LatencyMs)
+	a.PromptTokensSum += int64(req.PromptTokens)
+	a.CompletionTokensSum += int64(resp.CompletionTokens)
+	a.CostUSD += cost
+	a.LatencySketch.Add(float64(resp.LatencyMs))
+
+	if req.UserIDHash != nil && *req.UserIDHash != "" {
+		a.Users.AddString(*req.UserIDHash)
+		a.UserCalls[*req.UserIDHash]++
+	}
+}
+
+// merge folds other into a, widening a's bounds to cover both
+func (a *WindowAggregate) merge(other *WindowAggregate) {
+	if other.WindowStart.Before(a.WindowStart) {
+		a.WindowStart = other.WindowStart
+	}
+	if other.WindowEnd.After(a.WindowEnd) {
+		a.WindowEnd = other.WindowEnd
+	}
+
+	a.Requests += other.Requests
+	a.Errors += other.Errors
+	a.Abandoned += other.Abandoned
+	a.LatencySumMs += other.LatencySumMs
+	a.PromptTokensSum += other.PromptTokensSum
+	a.CompletionTokensSum += other.CompletionTokensSum
+	a.CostUSD += other.CostUSD
+	// Both sketches are created with the default accuracy, so merging cannot fail
+	_ = a.LatencySketch.Merge(other.LatencySketch)
+
+	for class, n := range other.ErrorClasses {
+		a.ErrorClasses[class] += n
+	}
+	for reason, n := range other.FinishReasons {
+		a.addFinishReason(reason, n)
+	}
+
+	// Both HyperLogLogs have the default precision
+	_ = a.Users.Merge(other.Users)
+	for user, n := range other.UserCalls {
+		a.UserCalls[user] += n
+	}
+}
+
+// addFinishReason counts n calls with the given finish reason, folding new
+// reasons into "other" once maxFinishReasons are tracked
+func (a *WindowAggregate) addFinishReason(reason string, n int) {
+	if _, tracked := a.FinishReasons[reason]; !tracked && len(a.FinishReasons) >= maxFinishReasons {
+		reason = string(models.ErrorClassOther)
+	}
+	a.FinishReasons[reason] += n
+}
+
+// windowKey identifies a fixed window of a definition. dimensions is the
+// Key of the window's dimensions.
+func windowKey(def, tenantID, route, model, dimensions string, start time.Time) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%d", def, tenantID, route, model, dimensions, start.Unix())
+}
+
+// sealedKey identifies a sealed fixed window, apart from the open window of
+// the same definition, group and start
+func (a *WindowAggregate) sealedKey() string {
+	return windowKey(a.Definition.Name, a.TenantID, a.Route, a.Model, a.Dimensions.Key(), a.WindowStart) + "|" + a.FlushID
+}
+
+// newFlushID returns a random flush identifier
+func newFlushID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// sessionKey identifies the open sessions of one session_id within a definition
+func sessionKey(def, tenantID, route, model, sessionID string) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s", def, tenantID, route, model, sessionID)
+}
+
+// outputKey is the Kafka key for the aggregate's metrics
+func (a *WindowAggregate) outputKey() string {
+	if a.SessionID != "" {
+		return fmt.Sprintf("%s|%s|%s|%s", a.TenantID, a.Route, a.Model, a.SessionID)
+	}
+	return fmt.Sprintf("%s|%s|%s", a.TenantID, a.Route, a.Model)
+}
+
+// grouped reports whether the window is aggregated across routes or models
+func (a *WindowAggregate) grouped() bool {
+	return a.Route == models.Wildcard || a.Model == models.Wildcard
+}
+
+// groupingSets returns copies of req attributed to the (tenant, route),
+// (tenant, model) and (tenant) windows
+func groupingSets(req *models.LLMRequest) []*models.LLMRequest {
+	byRoute, byModel, byTenant := *req, *req, *req
+	byRoute.Model = models.Wildcard
+	byModel.Route = models.Wildcard
+	byTenant.Route, byTenant.Model = models.Wildcard, models.Wildcard
+	return []*models.LLMRequest{&byRoute, &byModel, &byTenant}
+}
+
+// sessionID extracts the session identifier from request metadata
+func sessionID(req *models.LLMRequest) string {
+	if req.Metadata == nil {
+		return ""
+	}
+	id, _ := req.Metadata[SessionIDMetadataKey].(string)
+	return id
+}
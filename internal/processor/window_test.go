@@ -0,0 +1,69 @@
+package processor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindowDefinitions(t *testing.T) {
+	defs, err := ParseWindowDefinitions("errors_5m:hopping:5m:30s; chat_sessions:session:30m")
+	if err != nil {
+		t.Fatalf("ParseWindowDefinitions() error = %v", err)
+	}
+	if len(defs) != 3 {
+		t.Fatalf("got %d definitions, want 3", len(defs))
+	}
+	if defs[0].Name != DefaultWindowName || defs[0].Size != WindowDuration {
+		t.Errorf("first definition = %+v, want default window", defs[0])
+	}
+	if defs[1].Topic != "llm.metrics.errors_5m" || defs[1].Table != "llm_window_errors_5m" {
+		t.Errorf("hopping outputs = %s, %s", defs[1].Topic, defs[1].Table)
+	}
+	if defs[2].Type != WindowSession || defs[2].Gap != 30*time.Minute {
+		t.Errorf("session definition = %+v", defs[2])
+	}
+
+	invalid := []string{
+		"bad",
+		"Upper:tumbling:1m",
+		"x:tumbling:1m;x:tumbling:5m",
+		"default:tumbling:1m",
+		"dimensions:tumbling:5m",
+		"grouped:session:30m",
+		"h:hopping:5m",
+		"h:hopping:1m:5m",
+		"h:hopping:1h:1s",
+		"s:session:10m:1m",
+		"w:sliding:1m",
+	}
+	for _, spec := range invalid {
+		if _, err := ParseWindowDefinitions(spec); err == nil {
+			t.Errorf("ParseWindowDefinitions(%q) expected error", spec)
+		}
+	}
+}
+
+func TestWindowDefinition_Assign(t *testing.T) {
+	ts := time.Date(2025, 11, 20, 10, 2, 45, 0, time.UTC)
+
+	hopping := WindowDefinition{Type: WindowHopping, Size: 2 * time.Minute, Advance: 30 * time.Second}
+	spans := hopping.assign(ts)
+	if len(spans) != 4 {
+		t.Fatalf("hopping assign returned %d windows, want 4", len(spans))
+	}
+	wantFirst := time.Date(2025, 11, 20, 10, 1, 0, 0, time.UTC)
+	if !spans[0].start.Equal(wantFirst) || !spans[3].start.Equal(ts.Truncate(30*time.Second)) {
+		t.Errorf("hopping windows start at %s..%s", spans[0].start, spans[3].start)
+	}
+	for _, s := range spans {
+		if ts.Before(s.start) || !ts.Before(s.end) {
+			t.Errorf("window [%s, %s) does not contain %s", s.start, s.end, ts)
+		}
+	}
+
+	tumbling := DefaultWindow
+	spans = tumbling.assign(ts)
+	if len(spans) != 1 || !spans[0].start.Equal(ts.Truncate(time.Minute)) {
+		t.Errorf("tumbling assign = %+v", spans)
+	}
+}
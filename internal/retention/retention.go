@@ -0,0 +1,237 @@
+// Package retention keeps the metrics tables within their retention: it
+// creates upcoming partitions, drops expired ones and deletes the expired
+// windows of tenants with shorter retention than their partitions.
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"streamlens/internal/models"
+	"streamlens/internal/store"
+	"time"
+)
+
+// maintainerLockKey is the Postgres advisory lock held during maintenance, so
+// that one processor instance creates and drops partitions at a time
+const maintainerLockKey int64 = 0x726574656e74
+
+// Policy is how many days windows of each resolution are kept, by default
+// and for tenants overriding the default. 0 days keeps windows forever.
+type Policy struct {
+	Defaults map[models.Resolution]int
+	Tenants  map[string]map[models.Resolution]int
+}
+
+// LoadPolicy combines the default retention with the stored tenant overrides
+func LoadPolicy(ctx context.Context, s *store.MetricsStore, defaults map[models.Resolution]int) (Policy, error) {
+	overrides, err := s.ListTenantRetention(ctx, nil)
+	if err != nil {
+		return Policy{}, fmt.Errorf("failed to list retention overrides: %w", err)
+	}
+	p := Policy{Defaults: defaults, Tenants: make(map[string]map[models.Resolution]int)}
+	for _, o := range overrides {
+		if p.Tenants[o.TenantID] == nil {
+			p.Tenants[o.TenantID] = make(map[models.Resolution]int)
+		}
+		p.Tenants[o.TenantID][o.Resolution] = o.Days
+	}
+	return p, nil
+}
+
+// Days returns how many days a tenant's windows of res are kept
+func (p Policy) Days(tenantID string, res models.Resolution) int {
+	if days, ok := p.Tenants[tenantID][res]; ok {
+		return days
+	}
+	return p.Defaults[res]
+}
+
+// KeptSince returns the earliest time from which every tenant keeps its
+// windows of res at now, or the zero time if every tenant keeps them forever
+func (p Policy) KeptSince(res models.Resolution, now time.Time) time.Time {
+	var since time.Time
+	for _, days := range p.days(res) {
+		if c := cutoff(now, days); c.After(since) {
+			since = c
+		}
+	}
+	return since
+}
+
+// days returns the retentions of res, the default and every override
+func (p Policy) days(res models.Resolution) []int {
+	all := []int{p.Defaults[res]}
+	for _, overrides := range p.Tenants {
+		if days, ok := overrides[res]; ok {
+			all = append(all, days)
+		}
+	}
+	return all
+}
+
+// cutoff returns the start of the earliest day kept by a retention of days at
+// now, or the zero time for a retention of 0 days
+func cutoff(now time.Time, days int) time.Time {
+	if days <= 0 {
+		return time.Time{}
+	}
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -days)
+}
+
+// deletion is a deletion of windows starting before Before, of one tenant,
+// or with an empty TenantID of every tenant not in Except
+type deletion struct {
+	TenantID string
+	Except   []string
+	Before   time.Time
+}
+
+// plan is the retention work on the windows of one resolution
+type plan struct {
+	Drop      []store.Partition
+	Deletions []deletion
+}
+
+// plan returns the partitions of res to drop at now, those holding only
+// windows no tenant keeps, and the windows to delete from the partitions kept
+func (p Policy) plan(res models.Resolution, now time.Time, partitions []store.Partition) plan {
+	var pl plan
+
+	// A partition is dropped once the longest retention is past its end
+	longest, forever := 0, false
+	for _, days := range p.days(res) {
+		if days == 0 {
+			forever = true
+		} else if days > longest {
+			longest = days
+		}
+	}
+	if !forever {
+		before := cutoff(now, longest)
+		for _, part := range partitions {
+			if !part.End.After(before) {
+				pl.Drop = append(pl.Drop, part)
+			}
+		}
+	}
+
+	var overridden []string
+	for tenantID, overrides := range p.Tenants {
+		days, ok := overrides[res]
+		if !ok {
+			continue
+		}
+		overridden = append(overridden, tenantID)
+		if days > 0 {
+			pl.Deletions = append(pl.Deletions, deletion{TenantID: tenantID, Before: cutoff(now, days)})
+		}
+	}
+	sort.Strings(overridden)
+	sort.Slice(pl.Deletions, func(i, j int) bool { return pl.Deletions[i].TenantID < pl.Deletions[j].TenantID })
+
+	if days := p.Defaults[res]; days > 0 {
+		pl.Deletions = append(pl.Deletions, deletion{Except: overridden, Before: cutoff(now, days)})
+	}
+	return pl
+}
+
+// Maintainer periodically creates the partitions of the coming days and
+// enforces the retention policy on every resolution
+type Maintainer struct {
+	store       *store.MetricsStore
+	defaults    map[models.Resolution]int
+	premakeDays int
+	interval    time.Duration
+}
+
+// NewMaintainer creates a new Maintainer. Partitions are created premakeDays
+// ahead, so that writes rarely have to create one.
+func NewMaintainer(store *store.MetricsStore, defaults map[models.Resolution]int, premakeDays int, interval time.Duration) *Maintainer {
+	return &Maintainer{
+		store:       store,
+		defaults:    defaults,
+		premakeDays: premakeDays,
+		interval:    interval,
+	}
+}
+
+// Run starts the maintenance loop, maintaining the tables once right away
+func (m *Maintainer) Run(ctx context.Context) {
+	log.Printf("Starting retention maintenance (interval %s, %d days of partitions ahead)", m.interval, m.premakeDays)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Maintain(ctx, time.Now().UTC()); err != nil {
+			log.Printf("Retention maintenance failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Maintain creates upcoming partitions and removes expired windows of every
+// resolution, unless another instance is already doing so
+func (m *Maintainer) Maintain(ctx context.Context, now time.Time) error {
+	unlock, ok, err := m.store.TryAdvisoryLock(ctx, maintainerLockKey)
+	if err != nil {
+		return fmt.Errorf("failed to take maintenance lock: %w", err)
+	}
+	if !ok {
+		return nil
+	}
+	defer unlock()
+
+	policy, err := LoadPolicy(ctx, m.store, m.defaults)
+	if err != nil {
+		return err
+	}
+	for _, res := range models.Resolutions {
+		// One failing resolution must not hold back the others
+		if err := m.maintain(ctx, policy, res, now); err != nil {
+			log.Printf("Failed to maintain %s windows: %v", res, err)
+		}
+	}
+	return nil
+}
+
+// maintain creates upcoming partitions of res and removes its expired windows
+func (m *Maintainer) maintain(ctx context.Context, policy Policy, res models.Resolution, now time.Time) error {
+	if err := m.store.EnsurePartitions(ctx, res, now, now.AddDate(0, 0, m.premakeDays+1)); err != nil {
+		return err
+	}
+
+	partitions, err := m.store.ListPartitions(ctx, res)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions: %w", err)
+	}
+	pl := policy.plan(res, now, partitions)
+
+	for _, part := range pl.Drop {
+		if err := m.store.DropPartition(ctx, res, part); err != nil {
+			return fmt.Errorf("failed to drop partition %s: %w", part.Name, err)
+		}
+		log.Printf("Dropped expired partition %s", part.Name)
+	}
+	for _, d := range pl.Deletions {
+		deleted, err := m.store.DeleteWindowsBefore(ctx, res, d.Before, d.TenantID, d.Except)
+		if err != nil {
+			return err
+		}
+		if deleted > 0 {
+			scope := "tenant " + d.TenantID
+			if d.TenantID == "" {
+				scope = "default retention"
+			}
+			log.Printf("Deleted %d expired %s windows before %s (%s)", deleted, res, d.Before.Format("2006-01-02"), scope)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package retention
+
+import (
+	"reflect"
+	"streamlens/internal/models"
+	"streamlens/internal/store"
+	"testing"
+	"time"
+)
+
+func TestPolicy_Plan(t *testing.T) {
+	now := time.Date(2025, 11, 20, 10, 30, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2025, 11, d, 0, 0, 0, 0, time.UTC) }
+	partition := func(d int) store.Partition {
+		return store.Partition{Name: day(d).Format("p20060102"), Start: day(d), End: day(d + 1)}
+	}
+	partitions := []store.Partition{partition(1), partition(5), partition(6), partition(19), partition(20)}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		want   plan
+	}{
+		{
+			name:   "defaults only",
+			policy: Policy{Defaults: map[models.Resolution]int{models.Resolution1m: 14}},
+			want: plan{
+				Drop:      []store.Partition{partition(1), partition(5)},
+				Deletions: []deletion{{Before: day(6)}},
+			},
+		},
+		{
+			name:   "kept forever",
+			policy: Policy{Defaults: map[models.Resolution]int{models.Resolution1m: 0}},
+			want:   plan{},
+		},
+		{
+			name: "longer override keeps partitions",
+			policy: Policy{
+				Defaults: map[models.Resolution]int{models.Resolution1m: 14},
+				Tenants:  map[string]map[models.Resolution]int{"acme": {models.Resolution1m: 15}},
+			},
+			want: plan{
+				Drop: []store.Partition{partition(1)},
+				Deletions: []deletion{
+					{TenantID: "acme", Before: day(5)},
+					{Except: []string{"acme"}, Before: day(6)},
+				},
+			},
+		},
+		{
+			name: "override kept forever",
+			policy: Policy{
+				Defaults: map[models.Resolution]int{models.Resolution1m: 14},
+				Tenants: map[string]map[models.Resolution]int{
+					"acme":   {models.Resolution1m: 0},
+					"globex": {models.Resolution1m: 1},
+					"other":  {models.Resolution1h: 30},
+				},
+			},
+			want: plan{
+				Deletions: []deletion{
+					{TenantID: "globex", Before: day(19)},
+					{Except: []string{"acme", "globex"}, Before: day(6)},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.plan(models.Resolution1m, now, partitions); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("plan() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_KeptSince(t *testing.T) {
+	now := time.Date(2025, 11, 20, 10, 30, 0, 0, time.UTC)
+	policy := Policy{
+		Defaults: map[models.Resolution]int{models.Resolution1m: 14, models.Resolution1d: 0},
+		Tenants: map[string]map[models.Resolution]int{
+			"acme": {models.Resolution1m: 7, models.Resolution1d: 30},
+		},
+	}
+
+	if got, want := policy.KeptSince(models.Resolution1m, now), time.Date(2025, 11, 13, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("KeptSince(1m) = %v, want %v", got, want)
+	}
+	if got, want := policy.KeptSince(models.Resolution1d, now), time.Date(2025, 10, 21, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("KeptSince(1d) = %v, want %v", got, want)
+	}
+	if got := policy.KeptSince(models.Resolution5m, now); !got.IsZero() {
+		t.Errorf("KeptSince(5m) = %v, want zero", got)
+	}
+	if got := policy.Days("acme", models.Resolution1m); got != 7 {
+		t.Errorf("Days(acme, 1m) = %d, want 7", got)
+	}
+	if got := policy.Days("globex", models.Resolution1m); got != 14 {
+		t.Errorf("Days(globex, 1m) = %d, want 14", got)
+	}
+}
@@ -0,0 +1,358 @@
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"streamlens/internal/models"
+	"streamlens/internal/sketch"
+	"streamlens/internal/store"
+	"time"
+)
+
+// MaxPointsPerSeries is the most windows per series a query should need; the
+// finest resolution that stays under it is chosen for a time range
+const MaxPointsPerSeries = 1440
+
+// level describes one step of the rollup cascade
+type level struct {
+	source models.Resolution
+	target models.Resolution
+}
+
+// cascade builds each resolution from the next finer one, so every level reads
+// a bounded number of rows (5 minute rows, 12 five-minute rows, 24 hourly rows)
+var cascade = []level{
+	{source: models.Resolution1m, target: models.Resolution5m},
+	{source: models.Resolution5m, target: models.Resolution1h},
+	{source: models.Resolution1h, target: models.Resolution1d},
+}
+
+// Roller periodically rebuilds coarse-resolution windows from finer ones
+type Roller struct {
+	store    *store.MetricsStore
+	interval time.Duration
+	lookback time.Duration
+}
+
+// NewRoller creates a new Roller. Every interval it recomputes all rollup
+// buckets that overlap the last lookback period, so late-arriving minute
+// windows are picked up as long as they land within that period, and the
+// buckets of older minute windows that late events were merged into.
+func NewRoller(store *store.MetricsStore, interval, lookback time.Duration) *Roller {
+	return &Roller{
+		store:    store,
+		interval: interval,
+		lookback: lookback,
+	}
+}
+
+// Run starts the rollup loop
+func (r *Roller) Run(ctx context.Context) {
+	log.Printf("Starting rollups (interval %s, lookback %s)", r.interval, r.lookback)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.RollupAll(ctx, time.Now().UTC()); err != nil {
+				log.Printf("Rollup failed: %v", err)
+			}
+		}
+	}
+}
+
+// maxPendingRollups bounds the merged minute windows re-rolled per run
+const maxPendingRollups = 1000
+
+// RollupAll runs every level of the cascade, finest first. Besides the
+// buckets within the lookback, it rebuilds those of minute windows merged into
+// after their first flush, however old.
+func (r *Roller) RollupAll(ctx context.Context, now time.Time) error {
+	marks, err := r.store.PendingRollups(ctx, maxPendingRollups)
+	if err != nil {
+		return fmt.Errorf("failed to list pending rollups: %w", err)
+	}
+	merged := make([]time.Time, len(marks))
+	for i, m := range marks {
+		merged[i] = m.WindowStart
+	}
+
+	for _, lvl := range cascade {
+		from := now.Add(-r.lookback).Truncate(lvl.target.Duration())
+		if err := r.rollup(ctx, lvl, from, now); err != nil {
+			return fmt.Errorf("rollup %s -> %s: %w", lvl.source, lvl.target, err)
+		}
+		for _, bucket := range buckets(merged, lvl.target, from) {
+			if err := r.rollup(ctx, lvl, bucket, bucket.Add(lvl.target.Duration())); err != nil {
+				return fmt.Errorf("rollup %s -> %s: %w", lvl.source, lvl.target, err)
+			}
+		}
+	}
+
+	if err := r.store.ClearRollups(ctx, marks); err != nil {
+		return fmt.Errorf("failed to clear pending rollups: %w", err)
+	}
+	return nil
+}
+
+// buckets returns the distinct target buckets holding the given window
+// starts that begin before the given time, in order
+func buckets(starts []time.Time, target models.Resolution, before time.Time) []time.Time {
+	seen := make(map[int64]bool)
+	var result []time.Time
+	for _, start := range starts {
+		bucket := start.Truncate(target.Duration())
+		if !bucket.Before(before) || seen[bucket.Unix()] {
+			continue
+		}
+		seen[bucket.Unix()] = true
+		result = append(result, bucket)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Before(result[j]) })
+	return result
+}
+
+// RollupRange rebuilds every rollup bucket overlapping [from, to), finest
+// first, after the minute windows of that range were replaced. Unlike
+// RollupAll it also drops buckets whose source windows are gone.
+func (r *Roller) RollupRange(ctx context.Context, from, to time.Time) error {
+	for _, lvl := range cascade {
+		size := lvl.target.Duration()
+		start := from.Truncate(size)
+		end := to.Truncate(size)
+		if end.Before(to) {
+			end = end.Add(size)
+		}
+
+		windows, err := r.store.ListWindows(ctx, lvl.source, start, end)
+		if err != nil {
+			return fmt.Errorf("rollup %s -> %s: %w", lvl.source, lvl.target, err)
+		}
+		if err := r.store.ReplaceWindows(ctx, lvl.target, start, end, Merge(windows, lvl.target)); err != nil {
+			return fmt.Errorf("rollup %s -> %s: %w", lvl.source, lvl.target, err)
+		}
+	}
+	return nil
+}
+
+// rollup recomputes every target bucket starting in [from, to)
+func (r *Roller) rollup(ctx context.Context, lvl level, from, to time.Time) error {
+	windows, err := r.store.ListWindows(ctx, lvl.source, from, to)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range Merge(windows, lvl.target) {
+		if err := r.store.UpsertMetrics(ctx, lvl.target, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merge groups windows by tenant/route/model/dimensions and target bucket,
+// combining each group from its additive components
+func Merge(windows []models.LLMMetrics, target models.Resolution) []*models.LLMMetrics {
+	size := target.Duration()
+	return combine(windows, func(w *models.LLMMetrics) (models.Dimensions, time.Time, time.Time) {
+		bucket := w.WindowStart.Truncate(size)
+		return w.Dimensions, bucket, bucket.Add(size)
+	})
+}
+
+// Group merges dimensioned windows of the same tenant/route/model and window
+// that agree on the groupBy dimensions, dropping all other dimensions. With
+// no groupBy keys every window is reduced to its totals.
+func Group(windows []models.LLMMetrics, groupBy []string) []*models.LLMMetrics {
+	return combine(windows, func(w *models.LLMMetrics) (models.Dimensions, time.Time, time.Time) {
+		return w.Dimensions.Project(groupBy), w.WindowStart, w.WindowEnd
+	})
+}
+
+// combine merges windows that share a tenant/route/model and the dimensions
+// and bounds assigned by target
+func combine(windows []models.LLMMetrics, target func(*models.LLMMetrics) (models.Dimensions, time.Time, time.Time)) []*models.LLMMetrics {
+	merged := make(map[string]*models.LLMMetrics)
+	sketches := make(map[string]*bucketSketches)
+	var order []string
+
+	for i := range windows {
+		w := &windows[i]
+		dims, start, end := target(w)
+		key := fmt.Sprintf("%s|%s|%s|%s|%d", w.TenantID, w.Route, w.Model, dims.Key(), start.Unix())
+
+		m, exists := merged[key]
+		if !exists {
+			m = &models.LLMMetrics{
+				TenantID:    w.TenantID,
+				Route:       w.Route,
+				Model:       w.Model,
+				Dimensions:  dims,
+				WindowStart: start,
+				WindowEnd:   end,
+			}
+			merged[key] = m
+			sketches[key] = newBucketSketches()
+			order = append(order, key)
+		}
+
+		m.Requests += w.Requests
+		m.Errors += w.Errors
+		m.Abandoned += w.Abandoned
+		m.LatencySumMs += w.LatencySumMs
+		m.PromptTokensSum += w.PromptTokensSum
+		m.CompletionTokensSum += w.CompletionTokensSum
+		m.EstimatedCostUSD += w.EstimatedCostUSD
+		m.ErrorClasses = addCounts(m.ErrorClasses, w.ErrorClasses)
+		m.FinishReasons = addCounts(m.FinishReasons, w.FinishReasons)
+
+		if w.MaxCallsPerUser > m.MaxCallsPerUser {
+			m.MaxCallsPerUser = w.MaxCallsPerUser
+		}
+		sketches[key].add(w)
+	}
+
+	results := make([]*models.LLMMetrics, 0, len(order))
+	for _, key := range order {
+		m := merged[key]
+		if m.Requests > 0 {
+			n := float64(m.Requests)
+			m.AvgLatencyMs = float64(m.LatencySumMs) / n
+			m.AvgPromptTokens = float64(m.PromptTokensSum) / n
+			m.AvgCompletionTokens = float64(m.CompletionTokensSum) / n
+		}
+		if err := m.ApplyLatencySketch(sketches[key].latencies); err != nil {
+			log.Printf("Failed to encode latency sketch: %v", err)
+		}
+		if err := m.ApplyUserSketches(sketches[key].users, sketches[key].calls); err != nil {
+			log.Printf("Failed to encode user sketches: %v", err)
+		}
+		results = append(results, m)
+	}
+	return results
+}
+
+// bucketSketches are the merged sketches of one rollup bucket
+type bucketSketches struct {
+	latencies *sketch.DDSketch
+	users     *sketch.HLL
+	calls     *sketch.DDSketch
+}
+
+func newBucketSketches() *bucketSketches {
+	return &bucketSketches{
+		latencies: sketch.NewDefault(),
+		users:     sketch.NewDefaultHLL(),
+		calls:     sketch.NewDefault(),
+	}
+}
+
+// add merges the sketches of a window, skipping any that cannot be decoded
+func (b *bucketSketches) add(w *models.LLMMetrics) {
+	skip := func(name string, err error) {
+		log.Printf("Skipping %s sketch of %s window %s: %v", name, w.TenantID, w.WindowStart.Format(time.RFC3339), err)
+	}
+
+	latencies, err := sketch.Decode(w.LatencySketch)
+	if err == nil {
+		err = b.latencies.Merge(latencies)
+	}
+	if err != nil {
+		skip("latency", err)
+	}
+
+	users, err := sketch.DecodeHLL(w.UsersSketch)
+	if err == nil {
+		err = b.users.Merge(users)
+	}
+	if err != nil {
+		skip("users", err)
+	}
+
+	calls, err := sketch.Decode(w.CallsPerUserSketch)
+	if err == nil {
+		err = b.calls.Merge(calls)
+	}
+	if err != nil {
+		skip("calls per user", err)
+	}
+}
+
+// UserStats are the distinct users of a set of windows and their calls
+type UserStats struct {
+	UniqueUsers int64 `json:"unique_users"`
+	Requests    int   `json:"requests"`
+	// Percentiles and maximum of the calls each user made within one source
+	// window (one minute for stored windows)
+	P50CallsPerUser float64 `json:"p50_calls_per_user"`
+	P90CallsPerUser float64 `json:"p90_calls_per_user"`
+	P99CallsPerUser float64 `json:"p99_calls_per_user"`
+	MaxCallsPerUser int64   `json:"max_calls_per_user"`
+}
+
+// Users merges the user sketches of windows of any resolution. Requests
+// without a user_id_hash are counted in Requests but not per user.
+func Users(windows []models.LLMMetrics) UserStats {
+	merged := newBucketSketches()
+	var stats UserStats
+	for i := range windows {
+		w := &windows[i]
+		merged.add(w)
+		stats.Requests += w.Requests
+		if w.MaxCallsPerUser > stats.MaxCallsPerUser {
+			stats.MaxCallsPerUser = w.MaxCallsPerUser
+		}
+	}
+
+	stats.UniqueUsers = int64(merged.users.Estimate())
+	stats.P50CallsPerUser = merged.calls.Quantile(0.50)
+	stats.P90CallsPerUser = merged.calls.Quantile(0.90)
+	stats.P99CallsPerUser = merged.calls.Quantile(0.99)
+	return stats
+}
+
+// addCounts adds src into dst, allocating dst on first use
+func addCounts(dst, src map[string]int) map[string]int {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]int, len(src))
+	}
+	for k, n := range src {
+		dst[k] += n
+	}
+	return dst
+}
+
+// SelectResolution picks the finest resolution that covers [from, to) in at
+// most MaxPointsPerSeries windows
+func SelectResolution(from, to time.Time) models.Resolution {
+	span := to.Sub(from)
+	for _, res := range models.Resolutions {
+		if span <= res.Duration()*MaxPointsPerSeries {
+			return res
+		}
+	}
+	return models.Resolution1d
+}
+
+// Points returns how many windows of res cover [from, to), at most
+// MaxPointsPerSeries
+func Points(from, to time.Time, res models.Resolution) int {
+	first := from.Truncate(res.Duration())
+	n := int((to.Sub(first) + res.Duration() - 1) / res.Duration())
+	if n < 1 {
+		return 1
+	}
+	if n > MaxPointsPerSeries {
+		return MaxPointsPerSeries
+	}
+	return n
+}
@@ -0,0 +1,272 @@
+package rollup
+
+import (
+	"streamlens/internal/models"
+	"streamlens/internal/sketch"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	base := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+
+	window := func(offset time.Duration, requests int, latencies ...int) models.LLMMetrics {
+		s := sketch.NewDefault()
+		var sum int64
+		for _, l := range latencies {
+			s.Add(float64(l))
+			sum += int64(l)
+		}
+		data, _ := s.MarshalBinary()
+		return models.LLMMetrics{
+			TenantID:         "tenant-1",
+			Route:            "chat",
+			Model:            "gpt-4",
+			WindowStart:      base.Add(offset),
+			WindowEnd:        base.Add(offset + time.Minute),
+			Requests:         requests,
+			LatencySumMs:     sum,
+			PromptTokensSum:  int64(100 * requests),
+			EstimatedCostUSD: 0.5,
+			LatencySketch:    data,
+		}
+	}
+
+	windows := []models.LLMMetrics{
+		window(0, 1, 100),
+		window(1*time.Minute, 3, 1000, 1000, 1000),
+		window(5*time.Minute, 1, 50),
+	}
+	windows[0].ErrorClasses = map[string]int{"timeout": 1}
+	windows[0].FinishReasons = map[string]int{"stop": 1}
+	windows[1].ErrorClasses = map[string]int{"timeout": 1, "rate_limited": 2}
+	windows[1].FinishReasons = map[string]int{"stop": 2, "length": 1}
+
+	merged := Merge(windows, models.Resolution5m)
+	if len(merged) != 2 {
+		t.Fatalf("Merge() returned %d windows, want 2", len(merged))
+	}
+
+	first := merged[0]
+	if !first.WindowStart.Equal(base) || !first.WindowEnd.Equal(base.Add(5*time.Minute)) {
+		t.Errorf("window bounds = [%s, %s)", first.WindowStart, first.WindowEnd)
+	}
+	if first.Requests != 4 {
+		t.Errorf("Requests = %d, want 4", first.Requests)
+	}
+	// Weighted mean, not the mean of the per-window averages (550)
+	if first.AvgLatencyMs != 775 {
+		t.Errorf("AvgLatencyMs = %v, want 775", first.AvgLatencyMs)
+	}
+	if first.AvgPromptTokens != 100 {
+		t.Errorf("AvgPromptTokens = %v, want 100", first.AvgPromptTokens)
+	}
+	if first.EstimatedCostUSD != 1.0 {
+		t.Errorf("EstimatedCostUSD = %v, want 1.0", first.EstimatedCostUSD)
+	}
+	if p95 := first.P95LatencyMs; p95 < 990 || p95 > 1010 {
+		t.Errorf("P95LatencyMs = %v, want within 1%% of 1000", p95)
+	}
+	if first.ErrorClasses["timeout"] != 2 || first.ErrorClasses["rate_limited"] != 2 {
+		t.Errorf("ErrorClasses = %v, want timeout:2 rate_limited:2", first.ErrorClasses)
+	}
+	if first.FinishReasons["stop"] != 3 || first.FinishReasons["length"] != 1 {
+		t.Errorf("FinishReasons = %v, want stop:3 length:1", first.FinishReasons)
+	}
+	if merged[1].ErrorClasses != nil {
+		t.Errorf("second window ErrorClasses = %v, want nil", merged[1].ErrorClasses)
+	}
+	if p50 := first.P50LatencyMs; p50 < 990 || p50 > 1010 {
+		t.Errorf("P50LatencyMs = %v, want within 1%% of 1000", p50)
+	}
+}
+
+func TestMerge_Users(t *testing.T) {
+	base := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+
+	// window records calls per user, as the processor does on flush
+	window := func(offset time.Duration, calls map[string]int) models.LLMMetrics {
+		users, perUser := sketch.NewDefaultHLL(), sketch.NewDefault()
+		m := models.LLMMetrics{TenantID: "tenant-1", Route: "chat", Model: "gpt-4", WindowStart: base.Add(offset)}
+		for user, n := range calls {
+			users.AddString(user)
+			perUser.Add(float64(n))
+			m.Requests += n
+			if int64(n) > m.MaxCallsPerUser {
+				m.MaxCallsPerUser = int64(n)
+			}
+		}
+		if err := m.ApplyUserSketches(users, perUser); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	windows := []models.LLMMetrics{
+		window(0, map[string]int{"alice": 1, "bob": 2}),
+		window(time.Minute, map[string]int{"bob": 1, "carol": 40}),
+		window(2*time.Minute, map[string]int{"alice": 3}),
+	}
+
+	merged := Merge(windows, models.Resolution5m)
+	if len(merged) != 1 {
+		t.Fatalf("Merge() returned %d windows, want 1", len(merged))
+	}
+	// Users seen in several windows are counted once
+	if merged[0].UniqueUsers != 3 {
+		t.Errorf("UniqueUsers = %d, want 3", merged[0].UniqueUsers)
+	}
+	if merged[0].MaxCallsPerUser != 40 {
+		t.Errorf("MaxCallsPerUser = %d, want 40", merged[0].MaxCallsPerUser)
+	}
+
+	stats := Users(windows)
+	if stats.UniqueUsers != 3 || stats.Requests != 47 || stats.MaxCallsPerUser != 40 {
+		t.Errorf("Users() = %+v", stats)
+	}
+	// Five per-window counts: 1, 2, 1, 40, 3
+	if stats.P50CallsPerUser < 1.9 || stats.P50CallsPerUser > 2.1 {
+		t.Errorf("P50CallsPerUser = %v, want ~2", stats.P50CallsPerUser)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	start := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+	window := func(env, feature string, requests int) models.LLMMetrics {
+		return models.LLMMetrics{
+			TenantID:    "tenant-1",
+			Route:       "chat",
+			Model:       "gpt-4",
+			Dimensions:  models.Dimensions{"environment": env, "feature": feature},
+			WindowStart: start,
+			WindowEnd:   start.Add(time.Minute),
+			Requests:    requests,
+		}
+	}
+	windows := []models.LLMMetrics{
+		window("prod", "search", 10),
+		window("prod", "summarize", 5),
+		window("staging", "search", 2),
+	}
+
+	byEnv := Group(windows, []string{"environment"})
+	if len(byEnv) != 2 {
+		t.Fatalf("Group(environment) returned %d windows, want 2", len(byEnv))
+	}
+	if byEnv[0].Dimensions.Key() != "environment=prod" || byEnv[0].Requests != 15 {
+		t.Errorf("prod group = %v with %d requests, want 15", byEnv[0].Dimensions, byEnv[0].Requests)
+	}
+	if !byEnv[0].WindowEnd.Equal(start.Add(time.Minute)) {
+		t.Errorf("WindowEnd = %v, want the source window's end", byEnv[0].WindowEnd)
+	}
+
+	totals := Group(windows, nil)
+	if len(totals) != 1 || totals[0].Requests != 17 || totals[0].Dimensions != nil {
+		t.Errorf("Group(nil) = %+v, want one total of 17 requests", totals)
+	}
+
+	// Rollups keep each dimension combination apart
+	if merged := Merge(windows, models.Resolution5m); len(merged) != 3 {
+		t.Errorf("Merge() returned %d windows, want 3", len(merged))
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	base := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+	merged := []time.Time{
+		base.Add(62 * time.Minute),
+		base.Add(3 * time.Minute),
+		base.Add(61 * time.Minute),
+		base.Add(-26 * time.Hour),
+		base.Add(3 * time.Minute),
+	}
+
+	tests := []struct {
+		name   string
+		target models.Resolution
+		before time.Time
+		want   []time.Time
+	}{
+		{
+			name:   "5m",
+			target: models.Resolution5m,
+			before: base.Add(2 * time.Hour),
+			want:   []time.Time{base.Add(-26 * time.Hour), base, base.Add(time.Hour)},
+		},
+		{
+			name:   "1h within lookback",
+			target: models.Resolution1h,
+			before: base.Add(time.Hour),
+			want:   []time.Time{base.Add(-26 * time.Hour), base},
+		},
+		{
+			name:   "1d",
+			target: models.Resolution1d,
+			before: base.Truncate(24 * time.Hour),
+			want:   []time.Time{base.Add(-26 * time.Hour).Truncate(24 * time.Hour)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buckets(merged, tt.target, tt.before)
+			if len(got) != len(tt.want) {
+				t.Fatalf("buckets() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("buckets()[%d] = %s, want %s", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSelectResolution(t *testing.T) {
+	now := time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		span time.Duration
+		want models.Resolution
+	}{
+		{name: "one hour", span: time.Hour, want: models.Resolution1m},
+		{name: "one day", span: 24 * time.Hour, want: models.Resolution1m},
+		{name: "three days", span: 72 * time.Hour, want: models.Resolution5m},
+		{name: "thirty days", span: 30 * 24 * time.Hour, want: models.Resolution1h},
+		{name: "one year", span: 365 * 24 * time.Hour, want: models.Resolution1d},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SelectResolution(now.Add(-tt.span), now); got != tt.want {
+				t.Errorf("SelectResolution() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoints(t *testing.T) {
+	from := time.Date(2025, 11, 20, 10, 2, 30, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		span time.Duration
+		res  models.Resolution
+		want int
+	}{
+		{name: "partial first window", span: 3 * time.Minute, res: models.Resolution1m, want: 4},
+		{name: "one day of minutes", span: 24*time.Hour - 30*time.Second, res: models.Resolution1m, want: 1440},
+		{name: "capped", span: 48 * time.Hour, res: models.Resolution1m, want: MaxPointsPerSeries},
+		{name: "thirty days of hours", span: 30 * 24 * time.Hour, res: models.Resolution1h, want: 721},
+		{name: "empty", span: 0, res: models.Resolution1d, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Points(from, from.Add(tt.span), tt.res); got != tt.want {
+				t.Errorf("Points() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package sink
+
+import (
+	"context"
+	"streamlens/internal/kafka"
+	"streamlens/internal/store"
+)
+
+// kafkaSink produces windows as JSON to their definition's topic
+type kafkaSink struct {
+	producer *kafka.Producer
+}
+
+// NewKafka creates a sink producing to the window's topic. The producer is
+// owned by the caller and not closed by the sink.
+func NewKafka(producer *kafka.Producer) Sink {
+	return &kafkaSink{producer: producer}
+}
+
+func (s *kafkaSink) Name() string { return NameKafka }
+
+func (s *kafkaSink) Write(ctx context.Context, w *Window) error {
+	return s.producer.ProduceJSON(ctx, w.Topic, w.Key, w.Metrics)
+}
+
+func (s *kafkaSink) Close() error { return nil }
+
+// postgresSink merges windows into their definition's table
+type postgresSink struct {
+	store *store.MetricsStore
+}
+
+// NewPostgres creates a sink merging each flush into the window's stored
+// row, see store.MergeMetricsTable. The store is owned by the caller and not
+// closed by the sink.
+func NewPostgres(store *store.MetricsStore) Sink {
+	return &postgresSink{store: store}
+}
+
+func (s *postgresSink) Name() string { return NamePostgres }
+
+func (s *postgresSink) Write(ctx context.Context, w *Window) error {
+	return s.store.MergeMetricsTable(ctx, w.Table, w.FlushID, w.Metrics)
+}
+
+func (s *postgresSink) Close() error { return nil }
@@ -0,0 +1,64 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"streamlens/internal/models"
+	"sync"
+)
+
+// record is the JSON-lines form of a window: its metrics with the name of
+// the window definition
+type record struct {
+	Window string `json:"window"`
+	*models.LLMMetrics
+}
+
+// jsonLinesSink writes one JSON object per window to w
+type jsonLinesSink struct {
+	name   string
+	out    io.Writer
+	closer io.Closer
+
+	mu sync.Mutex
+}
+
+// NewFile creates a sink appending windows as JSON lines to the file at path,
+// creating it if needed. A retried window may be written twice.
+func NewFile(path string) (Sink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonLinesSink{name: NameFile, out: f, closer: f}, nil
+}
+
+// NewStdout creates a sink printing windows as JSON lines, for debugging
+func NewStdout() Sink {
+	return &jsonLinesSink{name: NameStdout, out: os.Stdout}
+}
+
+func (s *jsonLinesSink) Name() string { return s.name }
+
+func (s *jsonLinesSink) Write(ctx context.Context, w *Window) error {
+	line, err := json.Marshal(record{Window: w.Definition, LLMMetrics: w.Metrics})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	// One write per line keeps lines whole when appending
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.out.Write(line)
+	return err
+}
+
+func (s *jsonLinesSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
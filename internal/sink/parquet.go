@@ -0,0 +1,248 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetRow is the Parquet schema of a window. Sketches are kept in their
+// serialized form so that windows can still be merged downstream.
+type parquetRow struct {
+	Window              string            `parquet:"window"`
+	TenantID            string            `parquet:"tenant_id"`
+	Route               string            `parquet:"route"`
+	Model               string            `parquet:"model"`
+	SessionID           string            `parquet:"session_id"`
+	Dimensions          map[string]string `parquet:"dimensions"`
+	WindowStart         time.Time         `parquet:"window_start,timestamp(millisecond)"`
+	WindowEnd           time.Time         `parquet:"window_end,timestamp(millisecond)"`
+	Requests            int64             `parquet:"requests"`
+	Errors              int64             `parquet:"errors"`
+	Abandoned           int64             `parquet:"abandoned"`
+	AvgLatencyMs        float64           `parquet:"avg_latency_ms"`
+	P50LatencyMs        float64           `parquet:"p50_latency_ms"`
+	P90LatencyMs        float64           `parquet:"p90_latency_ms"`
+	P95LatencyMs        float64           `parquet:"p95_latency_ms"`
+	P99LatencyMs        float64           `parquet:"p99_latency_ms"`
+	P999LatencyMs       float64           `parquet:"p999_latency_ms"`
+	AvgPromptTokens     float64           `parquet:"avg_prompt_tokens"`
+	AvgCompletionTokens float64           `parquet:"avg_completion_tokens"`
+	EstimatedCostUSD    float64           `parquet:"estimated_cost_usd"`
+	LatencySumMs        int64             `parquet:"latency_sum_ms"`
+	PromptTokensSum     int64             `parquet:"prompt_tokens_sum"`
+	CompletionTokensSum int64             `parquet:"completion_tokens_sum"`
+	ErrorClasses        map[string]int64  `parquet:"error_classes"`
+	FinishReasons       map[string]int64  `parquet:"finish_reasons"`
+	UniqueUsers         int64             `parquet:"unique_users"`
+	P99CallsPerUser     float64           `parquet:"p99_calls_per_user"`
+	MaxCallsPerUser     int64             `parquet:"max_calls_per_user"`
+	LatencySketch       []byte            `parquet:"latency_sketch"`
+	UsersSketch         []byte            `parquet:"users_sketch"`
+	CallsPerUserSketch  []byte            `parquet:"calls_per_user_sketch"`
+	Partial             bool              `parquet:"partial"`
+}
+
+func newParquetRow(w *Window) parquetRow {
+	m := w.Metrics
+	return parquetRow{
+		Window:              w.Definition,
+		TenantID:            m.TenantID,
+		Route:               m.Route,
+		Model:               m.Model,
+		SessionID:           m.SessionID,
+		Dimensions:          m.Dimensions,
+		WindowStart:         m.WindowStart,
+		WindowEnd:           m.WindowEnd,
+		Requests:            int64(m.Requests),
+		Errors:              int64(m.Errors),
+		Abandoned:           int64(m.Abandoned),
+		AvgLatencyMs:        m.AvgLatencyMs,
+		P50LatencyMs:        m.P50LatencyMs,
+		P90LatencyMs:        m.P90LatencyMs,
+		P95LatencyMs:        m.P95LatencyMs,
+		P99LatencyMs:        m.P99LatencyMs,
+		P999LatencyMs:       m.P999LatencyMs,
+		AvgPromptTokens:     m.AvgPromptTokens,
+		AvgCompletionTokens: m.AvgCompletionTokens,
+		EstimatedCostUSD:    m.EstimatedCostUSD,
+		LatencySumMs:        m.LatencySumMs,
+		PromptTokensSum:     m.PromptTokensSum,
+		CompletionTokensSum: m.CompletionTokensSum,
+		ErrorClasses:        int64Counts(m.ErrorClasses),
+		FinishReasons:       int64Counts(m.FinishReasons),
+		UniqueUsers:         m.UniqueUsers,
+		P99CallsPerUser:     m.P99CallsPerUser,
+		MaxCallsPerUser:     m.MaxCallsPerUser,
+		LatencySketch:       m.LatencySketch,
+		UsersSketch:         m.UsersSketch,
+		CallsPerUserSketch:  m.CallsPerUserSketch,
+		Partial:             m.Partial,
+	}
+}
+
+func int64Counts(counts map[string]int) map[string]int64 {
+	if len(counts) == 0 {
+		return nil
+	}
+	out := make(map[string]int64, len(counts))
+	for k, n := range counts {
+		out[k] = int64(n)
+	}
+	return out
+}
+
+// parquetFile is the open file of one hourly partition. It is written under
+// a hidden name and renamed once complete, so readers never see a file
+// without its footer.
+type parquetFile struct {
+	file   *os.File
+	writer *parquet.GenericWriter[parquetRow]
+	path   string
+}
+
+// parquetSink writes windows to Parquet files partitioned by the UTC hour of
+// their start, as <dir>/date=YYYY-MM-DD/hour=HH/metrics-<n>.parquet. Each
+// partition's file stays open until closeDelay after its hour ends, when
+// late windows are unlikely; windows arriving later start a new file in the
+// same partition. Windows of files still open are lost on a crash.
+type parquetSink struct {
+	dir        string
+	closeDelay time.Duration
+
+	files map[time.Time]*parquetFile // by hour
+	mu    sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewParquet creates a sink writing hourly-partitioned Parquet files under dir
+func NewParquet(dir string, closeDelay time.Duration) (Sink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &parquetSink{
+		dir:        dir,
+		closeDelay: closeDelay,
+		files:      make(map[time.Time]*parquetFile),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *parquetSink) Name() string { return NameParquet }
+
+func (s *parquetSink) Write(ctx context.Context, w *Window) error {
+	hour := w.Metrics.WindowStart.UTC().Truncate(time.Hour)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[hour]
+	if !ok {
+		var err error
+		if f, err = s.create(hour); err != nil {
+			return err
+		}
+		s.files[hour] = f
+	}
+	_, err := f.writer.Write([]parquetRow{newParquetRow(w)})
+	return err
+}
+
+// create opens a new file in the partition of hour
+func (s *parquetSink) create(hour time.Time) (*parquetFile, error) {
+	dir := filepath.Join(s.dir, "date="+hour.Format("2006-01-02"), fmt.Sprintf("hour=%02d", hour.Hour()))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("metrics-%d.parquet", time.Now().UnixNano()))
+	file, err := os.Create(hiddenPath(path))
+	if err != nil {
+		return nil, err
+	}
+	return &parquetFile{
+		file:   file,
+		writer: parquet.NewGenericWriter[parquetRow](file),
+		path:   path,
+	}, nil
+}
+
+// run closes the files of expired partitions every minute
+func (s *parquetSink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.closeFiles(func(hour time.Time) bool {
+				return now.After(hour.Add(time.Hour + s.closeDelay))
+			})
+		}
+	}
+}
+
+// closeFiles completes the files of every partition for which expired
+// reports true, returning the errors of those that fail
+func (s *parquetSink) closeFiles(expired func(hour time.Time) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for hour, f := range s.files {
+		if !expired(hour) {
+			continue
+		}
+		delete(s.files, hour)
+		if err := f.close(); err != nil {
+			log.Printf("Failed to complete Parquet file %s: %v", f.path, err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close completes every open file
+func (s *parquetSink) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.closeFiles(func(time.Time) bool { return true })
+}
+
+// close writes the footer and publishes the file under its final name
+func (f *parquetFile) close() error {
+	if err := f.writer.Close(); err != nil {
+		f.file.Close()
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		f.file.Close()
+		return err
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(hiddenPath(f.path), f.path)
+}
+
+// hiddenPath is the name a file is written under; query engines skip files
+// starting with a dot
+func hiddenPath(path string) string {
+	dir, name := filepath.Split(path)
+	return filepath.Join(dir, "."+name+".tmp")
+}
@@ -0,0 +1,210 @@
+// Package sink delivers flushed metric windows to their outputs.
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"streamlens/internal/kafka"
+	"streamlens/internal/models"
+	"streamlens/internal/store"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the built-in sinks
+const (
+	NameKafka    = "kafka"
+	NamePostgres = "postgres"
+	NameFile     = "file"
+	NameStdout   = "stdout"
+	NameParquet  = "parquet"
+)
+
+// Window is a flushed window together with where its definition is routed
+type Window struct {
+	Definition string // window definition name
+	Topic      string // Kafka topic of the definition
+	Table      string // Postgres table of the definition
+	Key        string // Kafka key
+	FlushID    string // identifies the flush; every attempt to write it has the same ID
+	Metrics    *models.LLMMetrics
+}
+
+// Sink is one output of flushed windows. Write must be safe to call again
+// with the same window after a failure.
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, w *Window) error
+	Close() error
+}
+
+// Fanout writes windows to every sink. Each sink is written by its own
+// goroutine and retried independently, so a failing or slow sink does not
+// hold back the others.
+type Fanout struct {
+	sinks    []Sink
+	attempts int
+	backoff  time.Duration
+}
+
+// NewFanout creates a Fanout that tries each sink up to attempts times per
+// window and Write, doubling backoff between attempts
+func NewFanout(sinks []Sink, attempts int, backoff time.Duration) *Fanout {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return &Fanout{sinks: sinks, attempts: attempts, backoff: backoff}
+}
+
+// Write delivers each window to every sink not yet in its delivered set,
+// adds the sinks that succeed to the set, and returns the error of each
+// window. Every sink takes the windows in order, at its own pace; once a
+// sink runs out of attempts for a window, its remaining windows fail with the
+// same error rather than wait for its retries too. Callers keep delivered
+// across calls so that a retried window is only written to the sinks that
+// failed.
+func (f *Fanout) Write(ctx context.Context, windows []*Window, delivered []map[string]bool) []error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = make([][]error, len(windows))
+	)
+	for _, s := range f.sinks {
+		wg.Add(1)
+		go func(s Sink) {
+			defer wg.Done()
+			var err error
+			for i, w := range windows {
+				mu.Lock()
+				done := delivered[i][s.Name()]
+				mu.Unlock()
+				if done {
+					continue
+				}
+				if err == nil {
+					err = f.write(ctx, s, w)
+				}
+
+				mu.Lock()
+				if err != nil {
+					errs[i] = append(errs[i], fmt.Errorf("%s sink: %w", s.Name(), err))
+				} else {
+					delivered[i][s.Name()] = true
+				}
+				mu.Unlock()
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	joined := make([]error, len(windows))
+	for i := range errs {
+		joined[i] = errors.Join(errs[i]...)
+	}
+	return joined
+}
+
+// write tries one sink until it succeeds, attempts run out or ctx is done
+func (f *Fanout) write(ctx context.Context, s Sink, w *Window) error {
+	start := time.Now()
+	defer func() { writeDuration.WithLabelValues(s.Name()).Observe(time.Since(start).Seconds()) }()
+
+	backoff := f.backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = s.Write(ctx, w); err == nil {
+			return nil
+		}
+		writeErrors.WithLabelValues(s.Name()).Inc()
+		if attempt == f.attempts {
+			return err
+		}
+		log.Printf("%s sink failed (attempt %d/%d), retrying in %s: %v", s.Name(), attempt, f.attempts, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Close closes every sink
+func (f *Fanout) Close() error {
+	var errs []error
+	for _, s := range f.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s sink: %w", s.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ParseNames parses a comma-separated list of sink names
+func ParseNames(spec string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		switch name {
+		case NameKafka, NamePostgres, NameFile, NameStdout, NameParquet:
+		default:
+			return nil, fmt.Errorf("unknown sink %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("sink %q listed more than once", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no sinks configured")
+	}
+	return names, nil
+}
+
+// Settings configure the optional sinks
+type Settings struct {
+	FilePath          string        // JSON-lines file of the file sink
+	ParquetDir        string        // root directory of the parquet sink
+	ParquetCloseDelay time.Duration // how long after its hour a Parquet partition stays open
+}
+
+// Open creates the named sinks. Kafka and Postgres sinks use the given
+// producer and store.
+func Open(names []string, producer *kafka.Producer, store *store.MetricsStore, settings Settings) ([]Sink, error) {
+	var sinks []Sink
+	for _, name := range names {
+		var s Sink
+		var err error
+		switch name {
+		case NameKafka:
+			s = NewKafka(producer)
+		case NamePostgres:
+			s = NewPostgres(store)
+		case NameFile:
+			s, err = NewFile(settings.FilePath)
+		case NameStdout:
+			s = NewStdout()
+		case NameParquet:
+			s, err = NewParquet(settings.ParquetDir, settings.ParquetCloseDelay)
+		default:
+			err = fmt.Errorf("unknown sink %q", name)
+		}
+		if err != nil {
+			for _, opened := range sinks {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("%s sink: %w", name, err)
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
@@ -0,0 +1,256 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"streamlens/internal/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// fakeSink fails its first failures writes
+type fakeSink struct {
+	name     string
+	failures int
+	writes   int
+}
+
+func (s *fakeSink) Name() string { return s.name }
+
+func (s *fakeSink) Write(ctx context.Context, w *Window) error {
+	s.writes++
+	if s.writes <= s.failures {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func (s *fakeSink) Close() error { return nil }
+
+func TestFanout_Write(t *testing.T) {
+	w := &Window{Definition: "default", Metrics: &models.LLMMetrics{TenantID: "tenant-1"}}
+
+	tests := []struct {
+		name          string
+		failures      int
+		wantErr       bool
+		wantDelivered bool
+		wantWrites    int // writes of the flaky sink over two Write calls
+	}{
+		{name: "healthy", failures: 0, wantDelivered: true, wantWrites: 1},
+		{name: "recovers within attempts", failures: 2, wantDelivered: true, wantWrites: 3},
+		{name: "retried on the next call", failures: 3, wantErr: true, wantDelivered: true, wantWrites: 4},
+		{name: "down", failures: 100, wantErr: true, wantDelivered: false, wantWrites: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			healthy := &fakeSink{name: "healthy"}
+			flaky := &fakeSink{name: "flaky", failures: tt.failures}
+			f := NewFanout([]Sink{healthy, flaky}, 3, time.Millisecond)
+
+			delivered := make(map[string]bool)
+			err := f.Write(context.Background(), []*Window{w}, []map[string]bool{delivered})[0]
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// A failing sink does not hold back the others
+			if !delivered["healthy"] {
+				t.Error("healthy sink not delivered")
+			}
+
+			_ = f.Write(context.Background(), []*Window{w}, []map[string]bool{delivered})
+			if delivered["flaky"] != tt.wantDelivered {
+				t.Errorf("flaky delivered = %v, want %v", delivered["flaky"], tt.wantDelivered)
+			}
+			if flaky.writes != tt.wantWrites {
+				t.Errorf("flaky writes = %d, want %d", flaky.writes, tt.wantWrites)
+			}
+			// Delivered sinks are not written again
+			if healthy.writes != 1 {
+				t.Errorf("healthy writes = %d, want 1", healthy.writes)
+			}
+		})
+	}
+}
+
+// blockingSink holds every write until release is closed
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (s *blockingSink) Name() string { return "blocking" }
+
+func (s *blockingSink) Write(ctx context.Context, w *Window) error {
+	select {
+	case <-s.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *blockingSink) Close() error { return nil }
+
+// recordingSink reports every window it takes
+type recordingSink struct {
+	written chan *Window
+}
+
+func (s *recordingSink) Name() string { return "recording" }
+
+func (s *recordingSink) Write(ctx context.Context, w *Window) error {
+	s.written <- w
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+func TestFanout_SlowSink(t *testing.T) {
+	slow := &blockingSink{release: make(chan struct{})}
+	healthy := &recordingSink{written: make(chan *Window, 3)}
+	f := NewFanout([]Sink{slow, healthy}, 1, time.Millisecond)
+
+	windows := []*Window{{Key: "a"}, {Key: "b"}, {Key: "c"}}
+	delivered := []map[string]bool{{}, {}, {}}
+	done := make(chan []error)
+	go func() { done <- f.Write(context.Background(), windows, delivered) }()
+
+	// The healthy sink takes every window while the slow one is stuck on the
+	// first
+	for _, want := range windows {
+		select {
+		case w := <-healthy.written:
+			if w != want {
+				t.Errorf("healthy sink wrote %q, want %q", w.Key, want.Key)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("healthy sink did not write %q while the slow sink was blocked", want.Key)
+		}
+	}
+
+	close(slow.release)
+	for i, err := range <-done {
+		if err != nil {
+			t.Errorf("window %d: %v", i, err)
+		}
+		if !delivered[i]["blocking"] || !delivered[i]["recording"] {
+			t.Errorf("window %d delivered = %v, want both sinks", i, delivered[i])
+		}
+	}
+}
+
+func TestParseNames(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    int
+		wantErr bool
+	}{
+		{spec: "kafka,postgres", want: 2},
+		{spec: " kafka , parquet ,stdout", want: 3},
+		{spec: "kafka,s3", wantErr: true},
+		{spec: "kafka,kafka", wantErr: true},
+		{spec: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		names, err := ParseNames(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseNames(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if len(names) != tt.want {
+			t.Errorf("ParseNames(%q) = %v, want %d names", tt.spec, names, tt.want)
+		}
+	}
+}
+
+func TestParquet_HourlyPartitions(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewParquet(dir, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2025, 11, 20, 10, 58, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		ws := start.Add(time.Duration(i) * time.Minute)
+		if err := s.Write(context.Background(), &Window{
+			Definition: "default",
+			Metrics: &models.LLMMetrics{
+				TenantID:     "tenant-1",
+				Route:        "chat",
+				Model:        "gpt-4",
+				Dimensions:   models.Dimensions{"environment": "prod"},
+				WindowStart:  ws,
+				WindowEnd:    ws.Add(time.Minute),
+				Requests:     10 + i,
+				ErrorClasses: map[string]int{"timeout": 1},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 10:58 and 10:59 land in hour 10, 11:00 in hour 11
+	for hour, want := range map[string]int{"hour=10": 2, "hour=11": 1} {
+		files, err := filepath.Glob(filepath.Join(dir, "date=2025-11-20", hour, "*.parquet"))
+		if err != nil || len(files) != 1 {
+			t.Fatalf("%s files = %v (%v), want one", hour, files, err)
+		}
+		rows, err := parquet.ReadFile[parquetRow](files[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != want {
+			t.Fatalf("%s rows = %d, want %d", hour, len(rows), want)
+		}
+		if rows[0].Dimensions["environment"] != "prod" || rows[0].ErrorClasses["timeout"] != 1 || rows[0].Window != "default" {
+			t.Errorf("%s row = %+v", hour, rows[0])
+		}
+	}
+
+	// No temporary files are left behind
+	hidden, _ := filepath.Glob(filepath.Join(dir, "*", "*", ".*"))
+	if len(hidden) != 0 {
+		t.Errorf("temporary files left: %v", hidden)
+	}
+}
+
+func TestFile_AppendsLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.jsonl")
+	for i := 0; i < 2; i++ {
+		s, err := NewFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Write(context.Background(), &Window{Definition: "default", Metrics: &models.LLMMetrics{TenantID: "tenant-1"}}); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"window":"default","tenant_id":"tenant-1",`
+	lines := 0
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		lines++
+		if len(line) < len(want) || line[:len(want)] != want {
+			t.Errorf("line = %s, want prefix %s", line, want)
+		}
+	}
+	if lines != 2 {
+		t.Errorf("lines = %d, want 2", lines)
+	}
+}
@@ -0,0 +1,25 @@
+package sink
+
+import (
+	"streamlens/internal/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	writeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "sink",
+		Name:      "write_duration_seconds",
+		Help:      "Duration of window writes by sink, including retries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"sink"})
+
+	writeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "sink",
+		Name:      "write_errors_total",
+		Help:      "Failed window write attempts by sink.",
+	}, []string{"sink"})
+)
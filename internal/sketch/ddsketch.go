@@ -0,0 +1,292 @@
+// Package sketch implements DDSketch, a mergeable quantile sketch with bounded
+// relative error (Masson, Rim and Lee, VLDB 2019).
+//
+// Values are mapped to logarithmically sized buckets so that any quantile is
+// returned within a relative error of alpha of the true value. Sketches with
+// the same alpha merge by adding bucket counts, which makes them suitable for
+// combining windows into rollups.
+package sketch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	// DefaultRelativeAccuracy is the relative error guaranteed by NewDefault
+	DefaultRelativeAccuracy = 0.01
+	// DefaultMaxBins bounds memory per sketch. With 1% accuracy it covers more
+	// than eight orders of magnitude before the lowest buckets are collapsed.
+	DefaultMaxBins = 2048
+
+	encodingVersion = 1
+)
+
+// ErrIncompatible is returned when merging sketches with different accuracies
+var ErrIncompatible = errors.New("sketch: incompatible relative accuracy")
+
+// DDSketch is a quantile sketch over non-negative values
+type DDSketch struct {
+	alpha      float64
+	gamma      float64
+	logGamma   float64
+	maxBins    int
+	bins       map[int32]uint64
+	zeroCount  uint64
+	count      uint64
+	minIndexed int32 // lowest index kept once the sketch has collapsed
+	collapsed  bool
+}
+
+// New creates an empty sketch with the given relative accuracy and bin limit
+func New(alpha float64, maxBins int) *DDSketch {
+	gamma := (1 + alpha) / (1 - alpha)
+	return &DDSketch{
+		alpha:    alpha,
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		maxBins:  maxBins,
+		bins:     make(map[int32]uint64),
+	}
+}
+
+// NewDefault creates an empty sketch with DefaultRelativeAccuracy and DefaultMaxBins
+func NewDefault() *DDSketch {
+	return New(DefaultRelativeAccuracy, DefaultMaxBins)
+}
+
+// Add records a value. Values <= 0 are counted in a dedicated zero bucket.
+func (s *DDSketch) Add(v float64) {
+	s.AddN(v, 1)
+}
+
+// AddN records a value n times
+func (s *DDSketch) AddN(v float64, n uint64) {
+	if n == 0 {
+		return
+	}
+	s.count += n
+	if v <= 0 {
+		s.zeroCount += n
+		return
+	}
+	s.addToBin(s.index(v), n)
+}
+
+// Merge adds the contents of other into s
+func (s *DDSketch) Merge(other *DDSketch) error {
+	if other == nil {
+		return nil
+	}
+	if other.alpha != s.alpha {
+		return ErrIncompatible
+	}
+	s.count += other.count
+	s.zeroCount += other.zeroCount
+	for i, c := range other.bins {
+		s.addToBin(i, c)
+	}
+	return nil
+}
+
+// Count returns the number of recorded values
+func (s *DDSketch) Count() uint64 {
+	return s.count
+}
+
+// Quantile returns the q-th quantile (0..1), or 0 for an empty sketch
+func (s *DDSketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	if q < 0 {
+		q = 0
+	}
+	if q > 1 {
+		q = 1
+	}
+
+	rank := uint64(q * float64(s.count-1))
+	if rank < s.zeroCount {
+		return 0
+	}
+
+	seen := s.zeroCount
+	for _, i := range s.sortedIndexes() {
+		seen += s.bins[i]
+		if seen > rank {
+			return s.value(i)
+		}
+	}
+	return 0
+}
+
+// CountAtOrBelow returns the number of recorded values at or below v. Values in
+// the bucket of v are all counted, so the boundary is accurate to alpha.
+func (s *DDSketch) CountAtOrBelow(v float64) uint64 {
+	if v < 0 {
+		return 0
+	}
+	n := s.zeroCount
+	if v == 0 {
+		return n
+	}
+	limit := s.index(v)
+	for i, c := range s.bins {
+		if i <= limit {
+			n += c
+		}
+	}
+	return n
+}
+
+// index maps a positive value to its bucket
+func (s *DDSketch) index(v float64) int32 {
+	return int32(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+// value returns the representative value of a bucket, which is within alpha
+// of every value mapped to it
+func (s *DDSketch) value(i int32) float64 {
+	return 2 * math.Pow(s.gamma, float64(i)) / (s.gamma + 1)
+}
+
+func (s *DDSketch) addToBin(i int32, n uint64) {
+	if s.collapsed && i < s.minIndexed {
+		i = s.minIndexed
+	}
+	s.bins[i] += n
+	if len(s.bins) > s.maxBins {
+		s.collapseLowest()
+	}
+}
+
+// collapseLowest folds the lowest buckets together until the bin limit holds.
+// Accuracy is kept for the upper quantiles, which are the ones that matter
+// for latency.
+func (s *DDSketch) collapseLowest() {
+	indexes := s.sortedIndexes()
+	excess := len(indexes) - s.maxBins
+	target := indexes[excess]
+	for _, i := range indexes[:excess] {
+		s.bins[target] += s.bins[i]
+		delete(s.bins, i)
+	}
+	s.minIndexed = target
+	s.collapsed = true
+}
+
+func (s *DDSketch) sortedIndexes() []int32 {
+	indexes := make([]int32, 0, len(s.bins))
+	for i := range s.bins {
+		indexes = append(indexes, i)
+	}
+	sort.Slice(indexes, func(a, b int) bool { return indexes[a] < indexes[b] })
+	return indexes
+}
+
+// MarshalBinary encodes the sketch as: version, alpha, max bins, zero count,
+// collapse floor, then delta-encoded (index, count) pairs in index order
+func (s *DDSketch) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, 32+len(s.bins)*4)
+	buf = append(buf, encodingVersion)
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(s.alpha))
+	buf = binary.AppendUvarint(buf, uint64(s.maxBins))
+	buf = binary.AppendUvarint(buf, s.zeroCount)
+	collapsed := uint64(0)
+	if s.collapsed {
+		collapsed = 1
+	}
+	buf = binary.AppendUvarint(buf, collapsed)
+	buf = binary.AppendVarint(buf, int64(s.minIndexed))
+
+	indexes := s.sortedIndexes()
+	buf = binary.AppendUvarint(buf, uint64(len(indexes)))
+	prev := int32(0)
+	for _, i := range indexes {
+		buf = binary.AppendVarint(buf, int64(i-prev))
+		buf = binary.AppendUvarint(buf, s.bins[i])
+		prev = i
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a sketch produced by MarshalBinary
+func (s *DDSketch) UnmarshalBinary(data []byte) error {
+	if len(data) < 9 || data[0] != encodingVersion {
+		return fmt.Errorf("sketch: unsupported encoding")
+	}
+	r := reader{buf: data[9:]}
+
+	maxBins := int(r.uvarint())
+	if maxBins <= 0 {
+		maxBins = DefaultMaxBins
+	}
+	*s = *New(math.Float64frombits(binary.LittleEndian.Uint64(data[1:9])), maxBins)
+	s.zeroCount = r.uvarint()
+	s.count = s.zeroCount
+	s.collapsed = r.uvarint() == 1
+	s.minIndexed = int32(r.varint())
+
+	n := r.uvarint()
+	prev := int32(0)
+	for j := uint64(0); j < n && r.err == nil; j++ {
+		i := prev + int32(r.varint())
+		c := r.uvarint()
+		s.bins[i] = c
+		s.count += c
+		prev = i
+	}
+	if r.err != nil {
+		return fmt.Errorf("sketch: %w", r.err)
+	}
+	return nil
+}
+
+// Decode returns the sketch encoded in data, or an empty default sketch when
+// data is empty
+func Decode(data []byte) (*DDSketch, error) {
+	s := NewDefault()
+	if len(data) == 0 {
+		return s, nil
+	}
+	if err := s.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reader decodes varints, remembering the first error
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errors.New("truncated data")
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *reader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = errors.New("truncated data")
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
@@ -0,0 +1,106 @@
+package sketch
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestDDSketch_QuantileAccuracy(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	s := NewDefault()
+
+	values := make([]float64, 10000)
+	for i := range values {
+		values[i] = math.Exp(rng.NormFloat64()*1.5 + 6) // log-normal latencies
+		s.Add(values[i])
+	}
+	sort.Float64s(values)
+
+	for _, q := range []float64{0.5, 0.9, 0.95, 0.99, 0.999} {
+		want := values[int(q*float64(len(values)-1))]
+		got := s.Quantile(q)
+		if rel := math.Abs(got-want) / want; rel > DefaultRelativeAccuracy+1e-9 {
+			t.Errorf("Quantile(%v) = %v, want %v (relative error %.4f)", q, got, want, rel)
+		}
+	}
+}
+
+func TestDDSketch_MergeAndEncode(t *testing.T) {
+	a, b, all := NewDefault(), NewDefault(), NewDefault()
+	for i := 1; i <= 1000; i++ {
+		all.Add(float64(i))
+		if i%2 == 0 {
+			a.Add(float64(i))
+		} else {
+			b.Add(float64(i))
+		}
+	}
+	a.Add(0)
+	all.Add(0)
+
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if a.Count() != all.Count() {
+		t.Fatalf("Count() = %d, want %d", a.Count(), all.Count())
+	}
+
+	data, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error = %v", err)
+	}
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	for _, q := range []float64{0, 0.5, 0.95, 0.999, 1} {
+		if got, want := decoded.Quantile(q), all.Quantile(q); got != want {
+			t.Errorf("Quantile(%v) after merge+decode = %v, want %v", q, got, want)
+		}
+	}
+
+	if err := a.Merge(New(0.05, DefaultMaxBins)); err != ErrIncompatible {
+		t.Errorf("Merge() with different accuracy error = %v, want ErrIncompatible", err)
+	}
+}
+
+func TestDDSketch_BoundedBins(t *testing.T) {
+	s := New(DefaultRelativeAccuracy, 64)
+	for v := 1.0; v < 1e9; v *= 1.01 {
+		s.Add(v)
+	}
+	if len(s.bins) > 64 {
+		t.Errorf("sketch holds %d bins, want at most 64", len(s.bins))
+	}
+	if got := s.Quantile(1); math.Abs(got-1e9)/1e9 > 0.02 {
+		t.Errorf("Quantile(1) = %v after collapsing, want ~1e9", got)
+	}
+}
+
+func TestDDSketch_CountAtOrBelow(t *testing.T) {
+	s := NewDefault()
+	s.Add(0)
+	for v := 1; v <= 1000; v++ {
+		s.Add(float64(v))
+	}
+
+	tests := []struct {
+		v    float64
+		want uint64
+	}{
+		{v: -1, want: 0},
+		{v: 0, want: 1},
+		{v: 500, want: 501},
+		{v: 2000, want: 1001},
+	}
+	for _, tt := range tests {
+		got := s.CountAtOrBelow(tt.v)
+		// The bucket of v may hold values up to 2*alpha above it
+		if diff := math.Abs(float64(got) - float64(tt.want)); diff > 0.02*float64(tt.want)+1 {
+			t.Errorf("CountAtOrBelow(%v) = %d, want ~%d", tt.v, got, tt.want)
+		}
+	}
+}
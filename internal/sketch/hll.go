@@ -0,0 +1,184 @@
+package sketch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	// DefaultHLLPrecision gives 4096 registers and a standard error of 1.6%
+	DefaultHLLPrecision = 12
+
+	hllEncodingVersion = 1
+	hllSparse          = 0
+	hllDense           = 1
+)
+
+// ErrHLLIncompatible is returned when merging HyperLogLogs of different precision
+var ErrHLLIncompatible = errors.New("sketch: incompatible hyperloglog precision")
+
+// HLL is a HyperLogLog distinct-value counter (Flajolet et al., 2007) with
+// linear counting for small cardinalities. HyperLogLogs of the same precision
+// merge by taking the maximum of each register.
+type HLL struct {
+	precision uint8
+	registers []uint8
+}
+
+// NewHLL creates an empty HyperLogLog with 2^precision registers
+func NewHLL(precision uint8) *HLL {
+	return &HLL{precision: precision, registers: make([]uint8, 1<<precision)}
+}
+
+// NewDefaultHLL creates an empty HyperLogLog with DefaultHLLPrecision
+func NewDefaultHLL() *HLL {
+	return NewHLL(DefaultHLLPrecision)
+}
+
+// AddString records a value
+func (h *HLL) AddString(v string) {
+	f := fnv.New64a()
+	_, _ = f.Write([]byte(v))
+	h.AddHash(mix64(f.Sum64()))
+}
+
+// AddHash records a value by its uniformly distributed 64-bit hash
+func (h *HLL) AddHash(x uint64) {
+	idx := x >> (64 - h.precision)
+	// The remaining bits, with a sentinel so rho is bounded
+	w := x<<h.precision | 1<<(h.precision-1)
+	rho := uint8(bits.LeadingZeros64(w)) + 1
+	if rho > h.registers[idx] {
+		h.registers[idx] = rho
+	}
+}
+
+// Merge adds the values of other into h
+func (h *HLL) Merge(other *HLL) error {
+	if other == nil {
+		return nil
+	}
+	if other.precision != h.precision {
+		return ErrHLLIncompatible
+	}
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+	return nil
+}
+
+// Estimate returns the approximate number of distinct values recorded
+func (h *HLL) Estimate() uint64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Linear counting is more accurate while many registers are empty
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// MarshalBinary encodes the HyperLogLog as: version, precision, then either
+// the non-zero registers as delta-encoded (index, value) pairs or, once that
+// would be larger, every register
+func (h *HLL) MarshalBinary() ([]byte, error) {
+	nonZero := 0
+	for _, r := range h.registers {
+		if r != 0 {
+			nonZero++
+		}
+	}
+
+	if nonZero*3 >= len(h.registers) {
+		buf := make([]byte, 0, 3+len(h.registers))
+		buf = append(buf, hllEncodingVersion, h.precision, hllDense)
+		return append(buf, h.registers...), nil
+	}
+
+	buf := make([]byte, 0, 8+nonZero*3)
+	buf = append(buf, hllEncodingVersion, h.precision, hllSparse)
+	buf = binary.AppendUvarint(buf, uint64(nonZero))
+	prev := 0
+	for i, r := range h.registers {
+		if r != 0 {
+			buf = binary.AppendUvarint(buf, uint64(i-prev))
+			buf = append(buf, r)
+			prev = i
+		}
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a HyperLogLog produced by MarshalBinary
+func (h *HLL) UnmarshalBinary(data []byte) error {
+	if len(data) < 3 || data[0] != hllEncodingVersion || data[1] < 4 || data[1] > 18 {
+		return fmt.Errorf("sketch: unsupported hyperloglog encoding")
+	}
+	*h = *NewHLL(data[1])
+
+	switch data[2] {
+	case hllDense:
+		if len(data)-3 != len(h.registers) {
+			return errors.New("sketch: truncated hyperloglog")
+		}
+		copy(h.registers, data[3:])
+	case hllSparse:
+		r := reader{buf: data[3:]}
+		n := r.uvarint()
+		i := uint64(0)
+		for j := uint64(0); j < n && r.err == nil; j++ {
+			i += r.uvarint()
+			if r.err != nil || len(r.buf) == 0 || i >= uint64(len(h.registers)) {
+				return errors.New("sketch: truncated hyperloglog")
+			}
+			h.registers[i] = r.buf[0]
+			r.buf = r.buf[1:]
+		}
+		if r.err != nil {
+			return fmt.Errorf("sketch: %w", r.err)
+		}
+	default:
+		return fmt.Errorf("sketch: unsupported hyperloglog encoding")
+	}
+	return nil
+}
+
+// DecodeHLL returns the HyperLogLog encoded in data, or an empty default one
+// when data is empty
+func DecodeHLL(data []byte) (*HLL, error) {
+	h := NewDefaultHLL()
+	if len(data) == 0 {
+		return h, nil
+	}
+	if err := h.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// mix64 is the splitmix64 finalizer, spreading FNV's weak low-entropy bits
+// over the whole word
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,69 @@
+package sketch
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestHLL_Estimate(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 100, 1000, 10000, 100000, 1000000} {
+		h := NewDefaultHLL()
+		for i := 0; i < n; i++ {
+			h.AddString(fmt.Sprintf("user-%d", i))
+			// Duplicates must not be counted
+			h.AddString(fmt.Sprintf("user-%d", i))
+		}
+		got := float64(h.Estimate())
+		// 4 standard errors
+		if math.Abs(got-float64(n)) > 0.065*float64(n)+0.5 {
+			t.Errorf("Estimate with %d users = %v", n, got)
+		}
+	}
+}
+
+func TestHLL_MergeAndEncode(t *testing.T) {
+	a, b, all := NewDefaultHLL(), NewDefaultHLL(), NewDefaultHLL()
+	for i := 0; i < 5000; i++ {
+		v := fmt.Sprintf("user-%d", i)
+		all.AddString(v)
+		// Overlapping halves
+		if i < 3000 {
+			a.AddString(v)
+		}
+		if i >= 2000 {
+			b.AddString(v)
+		}
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if a.Estimate() != all.Estimate() {
+		t.Errorf("merged estimate = %d, want %d", a.Estimate(), all.Estimate())
+	}
+	if err := a.Merge(NewHLL(10)); err != ErrHLLIncompatible {
+		t.Errorf("Merge with other precision = %v, want ErrHLLIncompatible", err)
+	}
+
+	// Both the sparse and the dense encoding round-trip
+	small := NewDefaultHLL()
+	for i := 0; i < 50; i++ {
+		small.AddString(fmt.Sprintf("user-%d", i))
+	}
+	for _, h := range []*HLL{NewDefaultHLL(), small, all} {
+		data, err := h.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodeHLL(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Estimate() != h.Estimate() {
+			t.Errorf("decoded estimate = %d, want %d", decoded.Estimate(), h.Estimate())
+		}
+	}
+	if data, _ := small.MarshalBinary(); len(data) > 200 {
+		t.Errorf("sparse encoding of 50 users is %d bytes", len(data))
+	}
+}
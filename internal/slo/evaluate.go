@@ -0,0 +1,53 @@
+package slo
+
+import (
+	"streamlens/internal/models"
+	"time"
+)
+
+// advance moves the burn-rate alert of a window forward. a is the window's
+// current alert, or nil if it has none; the returned alert is nil while the
+// window is not burning.
+func advance(sloID int64, a *models.SLOAlert, br BurnRateStatus, now time.Time) *models.SLOAlert {
+	// Burning again after a resolution starts a new alert
+	if a != nil && a.Status == models.AlertResolved && br.Firing {
+		a = nil
+	}
+
+	if a == nil {
+		if !br.Firing {
+			return nil
+		}
+		a = &models.SLOAlert{
+			SLOID:    sloID,
+			Window:   br.Window,
+			Status:   models.AlertFiring,
+			StartsAt: now,
+		}
+	}
+
+	a.Severity = br.Severity
+	a.Threshold = br.Threshold
+	a.LongBurnRate = br.LongBurnRate
+	a.ShortBurnRate = br.ShortBurnRate
+
+	if !br.Firing && a.Status == models.AlertFiring {
+		a.Status = models.AlertResolved
+		endsAt := now
+		a.EndsAt = &endsAt
+	}
+	return a
+}
+
+// needsNotification reports whether an alert's state must be delivered.
+// Firing alerts are sent once, then again every repeat interval; resolutions
+// are sent only for alerts whose firing was delivered.
+func needsNotification(a *models.SLOAlert, now time.Time, repeat time.Duration) bool {
+	switch a.Status {
+	case models.AlertFiring:
+		return a.NotifiedStatus != models.AlertFiring || a.LastNotifiedAt == nil || now.Sub(*a.LastNotifiedAt) >= repeat
+	case models.AlertResolved:
+		return a.NotifiedStatus == models.AlertFiring
+	}
+	return false
+}
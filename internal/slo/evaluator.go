@@ -0,0 +1,145 @@
+package slo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"streamlens/internal/alert"
+	"streamlens/internal/models"
+	"streamlens/internal/store"
+	"time"
+)
+
+// evaluatorLockKey is the Postgres advisory lock held while SLOs are
+// evaluated, so that one processor instance evaluates and notifies at a time
+const evaluatorLockKey int64 = 0x736c6f
+
+// Evaluator periodically computes every enabled SLO and notifies SLO webhooks
+// of firing and resolved burn-rate alerts
+type Evaluator struct {
+	store    *store.MetricsStore
+	notifier *alert.Notifier
+	interval time.Duration
+	repeat   time.Duration
+}
+
+// NewEvaluator creates a new Evaluator. Firing alerts are notified again
+// every repeat interval.
+func NewEvaluator(store *store.MetricsStore, notifier *alert.Notifier, interval, repeat time.Duration) *Evaluator {
+	return &Evaluator{
+		store:    store,
+		notifier: notifier,
+		interval: interval,
+		repeat:   repeat,
+	}
+}
+
+// Run starts the evaluation loop
+func (e *Evaluator) Run(ctx context.Context) {
+	log.Printf("Starting SLO evaluation (interval %s)", e.interval)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.EvaluateAll(ctx, time.Now().UTC()); err != nil {
+				log.Printf("SLO evaluation failed: %v", err)
+			}
+		}
+	}
+}
+
+// EvaluateAll evaluates every enabled SLO, unless another instance is
+// already doing so
+func (e *Evaluator) EvaluateAll(ctx context.Context, now time.Time) error {
+	unlock, ok, err := e.store.TryAdvisoryLock(ctx, evaluatorLockKey)
+	if err != nil {
+		return fmt.Errorf("failed to take evaluation lock: %w", err)
+	}
+	if !ok {
+		return nil
+	}
+	defer unlock()
+
+	slos, err := e.store.ListSLOs(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to list SLOs: %w", err)
+	}
+	for i := range slos {
+		if !slos[i].Enabled {
+			continue
+		}
+		// One failing SLO must not hold back the others
+		if err := e.evaluate(ctx, &slos[i], now); err != nil {
+			log.Printf("Failed to evaluate SLO %d: %v", slos[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// evaluate advances the burn-rate alerts of one SLO and delivers their changes
+func (e *Evaluator) evaluate(ctx context.Context, s *models.SLO, now time.Time) error {
+	status, err := Compute(ctx, e.store, s, now)
+	if err != nil {
+		return err
+	}
+
+	stored, err := e.store.ListSLOAlerts(ctx, s.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load alerts: %w", err)
+	}
+	current := make(map[string]*models.SLOAlert, len(stored))
+	for i := range stored {
+		current[stored[i].Window] = &stored[i]
+	}
+
+	var alerts, pending []*models.SLOAlert
+	for _, br := range status.BurnRates {
+		a := advance(s.ID, current[br.Window], br, now)
+		if a == nil {
+			continue
+		}
+		alerts = append(alerts, a)
+		if needsNotification(a, now, e.repeat) {
+			pending = append(pending, a)
+		}
+	}
+
+	if len(pending) > 0 && s.WebhookURL != "" {
+		if err := e.notifier.Post(ctx, s.WebhookURL, payload(s, status, pending)); err != nil {
+			// Undelivered alerts are retried on the next evaluation
+			log.Printf("Failed to notify SLO %d webhook: %v", s.ID, err)
+		} else {
+			for _, a := range pending {
+				a.NotifiedStatus = a.Status
+				notifiedAt := now
+				a.LastNotifiedAt = &notifiedAt
+			}
+		}
+	}
+
+	kept := make(map[string]bool, len(alerts))
+	for _, a := range alerts {
+		// Resolutions are kept only until they are delivered; without a
+		// webhook there is nothing to deliver
+		if a.Status == models.AlertResolved && (s.WebhookURL == "" || !needsNotification(a, now, e.repeat)) {
+			continue
+		}
+		if err := e.store.SaveSLOAlert(ctx, a); err != nil {
+			return fmt.Errorf("failed to save alert %s: %w", a.Window, err)
+		}
+		kept[a.Window] = true
+	}
+	for window := range current {
+		if !kept[window] {
+			if err := e.store.DeleteSLOAlert(ctx, s.ID, window); err != nil {
+				return fmt.Errorf("failed to delete alert %s: %w", window, err)
+			}
+		}
+	}
+	return nil
+}
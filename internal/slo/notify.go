@@ -0,0 +1,125 @@
+package slo
+
+import (
+	"fmt"
+	"strconv"
+	"streamlens/internal/alert"
+	"streamlens/internal/models"
+	"strings"
+	"time"
+)
+
+// payload builds the webhook body for the alerts of an SLO in its webhook format
+func payload(s *models.SLO, status *Status, alerts []*models.SLOAlert) interface{} {
+	switch s.WebhookFormat {
+	case models.WebhookSlack:
+		return slackPayload(s, status, alerts)
+	case models.WebhookAlertmanager:
+		return alertmanagerPayload(s, status, alerts)
+	default:
+		return genericPayload(s, status, alerts)
+	}
+}
+
+// groupKey identifies the notification group of an SLO
+func groupKey(s *models.SLO) string {
+	return "slo/" + strconv.FormatInt(s.ID, 10)
+}
+
+// groupStatus is firing if any alert of the group is firing
+func groupStatus(alerts []*models.SLOAlert) models.AlertStatus {
+	for _, a := range alerts {
+		if a.Status == models.AlertFiring {
+			return models.AlertFiring
+		}
+	}
+	return models.AlertResolved
+}
+
+// summary describes one burn-rate alert in a sentence
+func summary(s *models.SLO, status *Status, a *models.SLOAlert) string {
+	return fmt.Sprintf("%s burn rate %.3g over %s (threshold %.3g), %.1f%% of error budget left",
+		s.Name, a.LongBurnRate, a.Window, a.Threshold, status.BudgetRemaining*100)
+}
+
+// genericWebhook is the default payload
+type genericWebhook struct {
+	GroupKey        string             `json:"group_key"`
+	Status          models.AlertStatus `json:"status"`
+	SLO             *models.SLO        `json:"slo"`
+	BudgetRemaining float64            `json:"budget_remaining"`
+	Alerts          []*models.SLOAlert `json:"alerts"`
+}
+
+func genericPayload(s *models.SLO, status *Status, alerts []*models.SLOAlert) genericWebhook {
+	// The webhook URL may carry credentials; receivers do not need it
+	redacted := *s
+	redacted.WebhookURL = ""
+	return genericWebhook{
+		GroupKey:        groupKey(s),
+		Status:          groupStatus(alerts),
+		SLO:             &redacted,
+		BudgetRemaining: status.BudgetRemaining,
+		Alerts:          alerts,
+	}
+}
+
+func slackPayload(s *models.SLO, status *Status, alerts []*models.SLOAlert) alert.SlackWebhook {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*[%s] SLO %s* (%s, objective %g)", strings.ToUpper(string(groupStatus(alerts))), s.Name, s.TenantID, s.Objective)
+	for _, a := range alerts {
+		icon := ":red_circle:"
+		if a.Status == models.AlertResolved {
+			icon = ":large_green_circle:"
+		}
+		fmt.Fprintf(&b, "\n%s %s: %s", icon, a.Severity, summary(s, status, a))
+	}
+	return alert.SlackWebhook{Text: b.String()}
+}
+
+func alertmanagerPayload(s *models.SLO, status *Status, alerts []*models.SLOAlert) alert.AlertmanagerWebhook {
+	common := map[string]string{
+		"alertname": s.Name,
+		"tenant_id": s.TenantID,
+		"route":     s.Route,
+		"model":     s.Model,
+		"sli":       string(s.SLI),
+	}
+
+	payload := alert.AlertmanagerWebhook{
+		Version:           "4",
+		GroupKey:          groupKey(s),
+		Status:            groupStatus(alerts),
+		Receiver:          "streamlens",
+		GroupLabels:       map[string]string{"alertname": s.Name},
+		CommonLabels:      common,
+		CommonAnnotations: map[string]string{},
+	}
+
+	for _, a := range alerts {
+		labels := make(map[string]string, len(common)+2)
+		for k, v := range common {
+			labels[k] = v
+		}
+		labels["severity"] = a.Severity
+		labels["burn_window"] = a.Window
+
+		var endsAt time.Time
+		if a.EndsAt != nil {
+			endsAt = *a.EndsAt
+		}
+		payload.Alerts = append(payload.Alerts, alert.AlertmanagerAlert{
+			Status: a.Status,
+			Labels: labels,
+			Annotations: map[string]string{
+				"summary":          summary(s, status, a),
+				"burn_rate":        strconv.FormatFloat(a.LongBurnRate, 'g', -1, 64),
+				"budget_remaining": strconv.FormatFloat(status.BudgetRemaining, 'g', -1, 64),
+			},
+			StartsAt:    a.StartsAt,
+			EndsAt:      endsAt,
+			Fingerprint: fmt.Sprintf("slo|%d|%s", s.ID, a.Window),
+		})
+	}
+	return payload
+}
@@ -0,0 +1,233 @@
+// Package slo computes service level indicators, error budgets and
+// multi-window burn rates from stored metrics windows.
+package slo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"streamlens/internal/models"
+	"streamlens/internal/sketch"
+	"time"
+)
+
+// BurnWindow is a multi-window burn-rate alert: it fires when both the long
+// and the short window consume the error budget faster than the threshold.
+// Thresholds follow the SRE workbook, derived from the share of the budget
+// the long window may consume.
+type BurnWindow struct {
+	Name           string
+	Long           time.Duration
+	Short          time.Duration
+	BudgetFraction float64
+	Severity       string
+}
+
+// BurnWindows are the alert windows evaluated for every SLO. For a 30 day
+// period their thresholds are 14.4, 6, 3 and 1.
+var BurnWindows = []BurnWindow{
+	{Name: "1h/5m", Long: time.Hour, Short: 5 * time.Minute, BudgetFraction: 0.02, Severity: "page"},
+	{Name: "6h/30m", Long: 6 * time.Hour, Short: 30 * time.Minute, BudgetFraction: 0.05, Severity: "page"},
+	{Name: "1d/2h", Long: 24 * time.Hour, Short: 2 * time.Hour, BudgetFraction: 0.10, Severity: "ticket"},
+	{Name: "3d/6h", Long: 72 * time.Hour, Short: 6 * time.Hour, BudgetFraction: 0.10, Severity: "ticket"},
+}
+
+// Threshold returns the burn rate at which the window fires for a period
+func (w BurnWindow) Threshold(period time.Duration) float64 {
+	return w.BudgetFraction * float64(period) / float64(w.Long)
+}
+
+// minuteWindowsUpTo is the longest range read from minute windows; longer
+// ranges are read from 5 minute rollups
+const minuteWindowsUpTo = 6 * time.Hour
+
+// WindowLister reads stored windows of a tenant, optionally of one route and model
+type WindowLister interface {
+	ListSeriesWindows(ctx context.Context, res models.Resolution, tenantID, route, model string, from, to time.Time) ([]models.LLMMetrics, error)
+}
+
+// Counts are the good and total events of an SLI over a range
+type Counts struct {
+	Good  float64 `json:"good"`
+	Total float64 `json:"total"`
+}
+
+// add folds another range into c
+func (c *Counts) add(o Counts) {
+	c.Good += o.Good
+	c.Total += o.Total
+}
+
+// ErrorRate returns the fraction of bad events, or 0 without events
+func (c Counts) ErrorRate() float64 {
+	if c.Total == 0 {
+		return 0
+	}
+	return 1 - c.Good/c.Total
+}
+
+// BurnRate returns how many times faster than sustainable the budget burns
+func (c Counts) BurnRate(objective float64) float64 {
+	return c.ErrorRate() / (1 - objective)
+}
+
+// windowCounts returns the good and total events of one window
+func windowCounts(s *models.SLO, m *models.LLMMetrics) Counts {
+	switch s.SLI {
+	case models.SLILatency:
+		latencies, err := sketch.Decode(m.LatencySketch)
+		if err != nil {
+			log.Printf("Skipping latency sketch of %s window %s: %v", m.TenantID, m.WindowStart.Format(time.RFC3339), err)
+			return Counts{}
+		}
+		return Counts{
+			Good:  float64(latencies.CountAtOrBelow(s.LatencyThresholdMs)),
+			Total: float64(latencies.Count()),
+		}
+	default:
+		return Counts{Good: float64(m.Requests - m.Errors), Total: float64(m.Requests)}
+	}
+}
+
+// countSince sums the windows starting at or after from
+func countSince(s *models.SLO, windows []models.LLMMetrics, from time.Time) Counts {
+	var c Counts
+	for i := range windows {
+		if !windows[i].WindowStart.Before(from) {
+			c.add(windowCounts(s, &windows[i]))
+		}
+	}
+	return c
+}
+
+// BurnRateStatus is the state of one burn-rate alert window
+type BurnRateStatus struct {
+	Window        string  `json:"window"`
+	Severity      string  `json:"severity"`
+	Threshold     float64 `json:"threshold"`
+	LongBurnRate  float64 `json:"long_burn_rate"`
+	ShortBurnRate float64 `json:"short_burn_rate"`
+	Firing        bool    `json:"firing"`
+}
+
+// HistoryPoint is the SLI of one hour of the period
+type HistoryPoint struct {
+	WindowStart time.Time `json:"window_start"`
+	Counts
+	BurnRate float64 `json:"burn_rate"`
+	// BudgetRemaining is the fraction of the error budget left at the end of
+	// the hour, counting from the start of the period
+	BudgetRemaining float64 `json:"budget_remaining"`
+}
+
+// Status is the current state of an SLO over its rolling period
+type Status struct {
+	EvaluatedAt time.Time `json:"evaluated_at"`
+	PeriodStart time.Time `json:"period_start"`
+	Counts
+	SLI float64 `json:"sli"`
+	// Error budget in events: allowed bad events over the period so far,
+	// bad events seen, and the fraction of the budget left
+	BudgetEvents    float64          `json:"budget_events"`
+	BadEvents       float64          `json:"bad_events"`
+	BudgetRemaining float64          `json:"budget_remaining"`
+	BurnRates       []BurnRateStatus `json:"burn_rates"`
+	History         []HistoryPoint   `json:"history"`
+}
+
+// budgetRemaining is the fraction of the budget left after c
+func budgetRemaining(c Counts, objective float64) float64 {
+	allowed := (1 - objective) * c.Total
+	if allowed == 0 {
+		return 1
+	}
+	return 1 - (c.Total-c.Good)/allowed
+}
+
+// Compute evaluates an SLO at now
+func Compute(ctx context.Context, lister WindowLister, s *models.SLO, now time.Time) (*Status, error) {
+	periodStart := now.Add(-s.Period()).Truncate(time.Hour)
+
+	hourly, err := lister.ListSeriesWindows(ctx, models.Resolution1h, s.TenantID, s.Route, s.Model, periodStart, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list hourly windows: %w", err)
+	}
+
+	status := &Status{EvaluatedAt: now, PeriodStart: periodStart}
+
+	// Hourly history and period totals. Rollups hold one row per route and
+	// model, so rows are combined by hour.
+	var hours []*HistoryPoint
+	byHour := make(map[int64]*HistoryPoint)
+	for i := range hourly {
+		w := &hourly[i]
+		p, ok := byHour[w.WindowStart.Unix()]
+		if !ok {
+			p = &HistoryPoint{WindowStart: w.WindowStart}
+			byHour[w.WindowStart.Unix()] = p
+			hours = append(hours, p)
+		}
+		p.add(windowCounts(s, w))
+	}
+	for _, p := range hours {
+		status.add(p.Counts)
+		p.BurnRate = p.Counts.BurnRate(s.Objective)
+		p.BudgetRemaining = budgetRemaining(status.Counts, s.Objective)
+		status.History = append(status.History, *p)
+	}
+
+	status.SLI = 1 - status.ErrorRate()
+	status.BudgetEvents = (1 - s.Objective) * status.Total
+	status.BadEvents = status.Total - status.Good
+	status.BudgetRemaining = budgetRemaining(status.Counts, s.Objective)
+
+	burnRates, err := computeBurnRates(ctx, lister, s, now)
+	if err != nil {
+		return nil, err
+	}
+	status.BurnRates = burnRates
+	return status, nil
+}
+
+// computeBurnRates evaluates every burn window from minute windows for short
+// ranges and 5 minute rollups for long ones
+func computeBurnRates(ctx context.Context, lister WindowLister, s *models.SLO, now time.Time) ([]BurnRateStatus, error) {
+	var longest time.Duration
+	for _, w := range BurnWindows {
+		if w.Long > longest {
+			longest = w.Long
+		}
+	}
+
+	minutes, err := lister.ListSeriesWindows(ctx, models.Resolution1m, s.TenantID, s.Route, s.Model, now.Add(-minuteWindowsUpTo), now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list minute windows: %w", err)
+	}
+	fiveMinutes, err := lister.ListSeriesWindows(ctx, models.Resolution5m, s.TenantID, s.Route, s.Model, now.Add(-longest).Truncate(5*time.Minute), now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list 5 minute windows: %w", err)
+	}
+
+	counts := func(d time.Duration) Counts {
+		if d <= minuteWindowsUpTo {
+			return countSince(s, minutes, now.Add(-d))
+		}
+		return countSince(s, fiveMinutes, now.Add(-d).Truncate(5*time.Minute))
+	}
+
+	statuses := make([]BurnRateStatus, 0, len(BurnWindows))
+	for _, w := range BurnWindows {
+		threshold := w.Threshold(s.Period())
+		long := counts(w.Long).BurnRate(s.Objective)
+		short := counts(w.Short).BurnRate(s.Objective)
+		statuses = append(statuses, BurnRateStatus{
+			Window:        w.Name,
+			Severity:      w.Severity,
+			Threshold:     threshold,
+			LongBurnRate:  long,
+			ShortBurnRate: short,
+			Firing:        long >= threshold && short >= threshold,
+		})
+	}
+	return statuses, nil
+}
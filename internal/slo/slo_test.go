@@ -0,0 +1,156 @@
+package slo
+
+import (
+	"context"
+	"math"
+	"streamlens/internal/models"
+	"streamlens/internal/sketch"
+	"testing"
+	"time"
+)
+
+// fakeLister serves windows per resolution, filtered by range
+type fakeLister map[models.Resolution][]models.LLMMetrics
+
+func (f fakeLister) ListSeriesWindows(_ context.Context, res models.Resolution, _, _, _ string, from, to time.Time) ([]models.LLMMetrics, error) {
+	var out []models.LLMMetrics
+	for _, w := range f[res] {
+		if !w.WindowStart.Before(from) && w.WindowStart.Before(to) {
+			out = append(out, w)
+		}
+	}
+	return out, nil
+}
+
+func TestBurnWindow_Threshold(t *testing.T) {
+	period := 30 * 24 * time.Hour
+	want := []float64{14.4, 6, 3, 1}
+	for i, w := range BurnWindows {
+		if got := w.Threshold(period); math.Abs(got-want[i]) > 1e-9 {
+			t.Errorf("%s threshold = %v, want %v", w.Name, got, want[i])
+		}
+	}
+}
+
+func TestWindowCounts(t *testing.T) {
+	latencies := sketch.NewDefault()
+	for _, v := range []float64{100, 200, 900, 1500, 3000} {
+		latencies.Add(v)
+	}
+	m := &models.LLMMetrics{Requests: 5, Errors: 1}
+	if err := m.ApplyLatencySketch(latencies); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		slo  models.SLO
+		want Counts
+	}{
+		{"availability", models.SLO{SLI: models.SLIAvailability}, Counts{Good: 4, Total: 5}},
+		{"latency", models.SLO{SLI: models.SLILatency, LatencyThresholdMs: 1000}, Counts{Good: 3, Total: 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := windowCounts(&tt.slo, m); got != tt.want {
+				t.Errorf("windowCounts = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompute(t *testing.T) {
+	now := time.Date(2025, 11, 20, 12, 0, 0, 0, time.UTC)
+	s := &models.SLO{ID: 1, TenantID: "tenant-1", SLI: models.SLIAvailability, Objective: 0.99, PeriodDays: 30}
+
+	lister := fakeLister{}
+	// 48 hours of 1000 requests with 2 errors, then the last hour at 20% errors
+	for i := 48; i >= 1; i-- {
+		errors := 2
+		if i == 1 {
+			errors = 200
+		}
+		lister[models.Resolution1h] = append(lister[models.Resolution1h], models.LLMMetrics{
+			WindowStart: now.Add(-time.Duration(i) * time.Hour), Requests: 1000, Errors: errors,
+		})
+	}
+	// Minute windows: five healthy hours, then the last hour at 20% errors
+	for i := 360; i >= 1; i-- {
+		w := models.LLMMetrics{WindowStart: now.Add(-time.Duration(i) * time.Minute), Requests: 1000, Errors: 2}
+		if i <= 60 {
+			w.Requests, w.Errors = 100, 20
+		}
+		lister[models.Resolution1m] = append(lister[models.Resolution1m], w)
+	}
+
+	status, err := Compute(context.Background(), lister, s, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Total != 48000 || status.BadEvents != 47*2+200 {
+		t.Errorf("Total = %v, BadEvents = %v", status.Total, status.BadEvents)
+	}
+	// 294 bad events of a 480 event budget
+	if want := 1 - 294.0/480; math.Abs(status.BudgetRemaining-want) > 1e-9 {
+		t.Errorf("BudgetRemaining = %v, want %v", status.BudgetRemaining, want)
+	}
+	if len(status.History) != 48 {
+		t.Fatalf("History has %d points, want 48", len(status.History))
+	}
+	if last := status.History[47]; math.Abs(last.BurnRate-20) > 1e-9 || math.Abs(last.BudgetRemaining-status.BudgetRemaining) > 1e-9 {
+		t.Errorf("last history point = %+v", last)
+	}
+
+	fast := status.BurnRates[0]
+	if !fast.Firing || math.Abs(fast.LongBurnRate-20) > 1e-9 || math.Abs(fast.ShortBurnRate-20) > 1e-9 {
+		t.Errorf("1h/5m = %+v, want firing at burn rate 20", fast)
+	}
+	// 6h/30m: the short window burns at 20, but the long window is diluted by
+	// the healthy hours
+	if slow := status.BurnRates[1]; slow.Firing {
+		t.Errorf("6h/30m = %+v, want not firing", slow)
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	now := time.Date(2025, 11, 20, 12, 0, 0, 0, time.UTC)
+	firing := BurnRateStatus{Window: "1h/5m", Severity: "page", Threshold: 14.4, LongBurnRate: 20, ShortBurnRate: 30, Firing: true}
+	normal := BurnRateStatus{Window: "1h/5m", Severity: "page", Threshold: 14.4, LongBurnRate: 2, ShortBurnRate: 1}
+
+	if a := advance(1, nil, normal, now); a != nil {
+		t.Fatalf("advance(nil, normal) = %+v, want nil", a)
+	}
+
+	a := advance(1, nil, firing, now)
+	if a == nil || a.Status != models.AlertFiring || !a.StartsAt.Equal(now) {
+		t.Fatalf("advance(nil, firing) = %+v", a)
+	}
+	if !needsNotification(a, now, time.Hour) {
+		t.Error("new firing alert should be notified")
+	}
+	notifiedAt := now
+	a.NotifiedStatus, a.LastNotifiedAt = models.AlertFiring, &notifiedAt
+
+	later := now.Add(30 * time.Minute)
+	a = advance(1, a, firing, later)
+	if needsNotification(a, later, time.Hour) {
+		t.Error("firing alert should not be repeated before the repeat interval")
+	}
+	if !needsNotification(a, now.Add(time.Hour), time.Hour) {
+		t.Error("firing alert should be repeated after the repeat interval")
+	}
+
+	a = advance(1, a, normal, later)
+	if a.Status != models.AlertResolved || a.EndsAt == nil || !a.EndsAt.Equal(later) {
+		t.Fatalf("advance(firing, normal) = %+v", a)
+	}
+	if !needsNotification(a, later, time.Hour) {
+		t.Error("resolution of a notified alert should be notified")
+	}
+
+	again := advance(1, a, firing, later.Add(time.Minute))
+	if again.Status != models.AlertFiring || again.EndsAt != nil || again.NotifiedStatus != "" {
+		t.Errorf("burning after resolution = %+v, want a new alert", again)
+	}
+}
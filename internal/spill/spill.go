@@ -0,0 +1,150 @@
+// Package spill keeps processor state that exceeds its memory budget in an
+// embedded on-disk key-value store.
+package spill
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store holds spilled entries in named buckets. Spilled state belongs to the
+// running process, like the in-memory state it extends, so the file is
+// recreated by Open and nothing is synced to disk explicitly.
+type Store struct {
+	db *bolt.DB
+
+	counts map[string]int // entries by bucket
+	mu     sync.Mutex
+}
+
+// Open creates an empty store at path
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{
+		Timeout:        time.Second,
+		NoSync:         true,
+		NoFreelistSync: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spill store: %w", err)
+	}
+	return &Store{db: db, counts: make(map[string]int)}, nil
+}
+
+// Len returns the number of entries in a bucket
+func (s *Store) Len(bucket string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[bucket]
+}
+
+// Put writes entries to a bucket, replacing those with the same keys
+func (s *Store) Put(bucket string, entries map[string][]byte) error {
+	added := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		added = 0
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		for key, value := range entries {
+			if b.Get([]byte(key)) == nil {
+				added++
+			}
+			if err := b.Put([]byte(key), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.adjust(bucket, added)
+	spilled.WithLabelValues(bucket).Add(float64(len(entries)))
+	return nil
+}
+
+// Take removes an entry from a bucket and returns it
+func (s *Store) Take(bucket, key string) ([]byte, bool, error) {
+	var value []byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		value = nil
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		if v := b.Get([]byte(key)); v != nil {
+			value = append([]byte(nil), v...)
+			return b.Delete([]byte(key))
+		}
+		return nil
+	})
+	if err != nil || value == nil {
+		return nil, false, err
+	}
+	s.adjust(bucket, -1)
+	restored.WithLabelValues(bucket).Inc()
+	return value, true, nil
+}
+
+// TakeAll removes every entry of a bucket for which match reports true and
+// returns them
+func (s *Store) TakeAll(bucket string, match func(key string, value []byte) bool) (map[string][]byte, error) {
+	var taken map[string][]byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		taken = make(map[string][]byte)
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		if err := b.ForEach(func(k, v []byte) error {
+			if match(string(k), v) {
+				taken[string(k)] = append([]byte(nil), v...)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		// Deleting while iterating would skip entries
+		for key := range taken {
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.adjust(bucket, -len(taken))
+	restored.WithLabelValues(bucket).Add(float64(len(taken)))
+	return taken, nil
+}
+
+func (s *Store) adjust(bucket string, n int) {
+	s.mu.Lock()
+	s.counts[bucket] += n
+	entries.WithLabelValues(bucket).Set(float64(s.counts[bucket]))
+	s.mu.Unlock()
+}
+
+// Close closes and removes the store file
+func (s *Store) Close() error {
+	path := s.db.Path()
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
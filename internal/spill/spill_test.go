@@ -0,0 +1,55 @@
+package spill
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	if err := s.Put("request", map[string][]byte{"a": []byte("1"), "b": []byte("2"), "c": []byte("3")}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := s.Put("request", map[string][]byte{"a": []byte("4")}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if n := s.Len("request"); n != 3 {
+		t.Errorf("Len() = %d, want 3", n)
+	}
+
+	value, ok, err := s.Take("request", "a")
+	if err != nil || !ok || string(value) != "4" {
+		t.Errorf("Take(a) = %q, %v, %v; want 4", value, ok, err)
+	}
+	if _, ok, _ := s.Take("request", "a"); ok {
+		t.Error("Take(a) again found the entry")
+	}
+	if _, ok, _ := s.Take("window", "a"); ok {
+		t.Error("Take() from an empty bucket found an entry")
+	}
+
+	taken, err := s.TakeAll("request", func(key string, _ []byte) bool { return key == "c" })
+	if err != nil || len(taken) != 1 || string(taken["c"]) != "3" {
+		t.Errorf("TakeAll() = %v, %v; want c", taken, err)
+	}
+	if n := s.Len("request"); n != 1 {
+		t.Errorf("Len() = %d, want 1", n)
+	}
+
+	// State does not survive the process
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if s, err = Open(path); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer s.Close()
+	if _, ok, _ := s.Take("request", "b"); ok {
+		t.Error("reopened store kept entries")
+	}
+}
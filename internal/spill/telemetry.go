@@ -0,0 +1,31 @@
+package spill
+
+import (
+	"streamlens/internal/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	entries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "spill",
+		Name:      "entries",
+		Help:      "State entries held on disk, by kind of state.",
+	}, []string{"state"})
+
+	spilled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "spill",
+		Name:      "spilled_total",
+		Help:      "State entries moved from memory to disk, by kind of state.",
+	}, []string{"state"})
+
+	restored = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "spill",
+		Name:      "restored_total",
+		Help:      "State entries taken back from disk, to be used, flushed, handed off or expired, by kind of state.",
+	}, []string{"state"})
+)
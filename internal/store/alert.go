@@ -0,0 +1,212 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"streamlens/internal/models"
+)
+
+// ErrAlertRuleNotFound is returned when an alert rule does not exist
+var ErrAlertRuleNotFound = errors.New("alert rule not found")
+
+const alertRuleColumns = `id, name, tenant_id, route, model, metric, operator, threshold,
+		for_windows, severity, webhook_url, webhook_format, enabled, created_at, updated_at`
+
+func scanAlertRule(row rowScanner) (*models.AlertRule, error) {
+	var r models.AlertRule
+	err := row.Scan(
+		&r.ID, &r.Name, &r.TenantID, &r.Route, &r.Model, &r.Metric, &r.Operator, &r.Threshold,
+		&r.ForWindows, &r.Severity, &r.WebhookURL, &r.WebhookFormat, &r.Enabled, &r.CreatedAt, &r.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ListAlertRules returns alert rules, of one tenant when tenantID is set
+func (s *MetricsStore) ListAlertRules(ctx context.Context, tenantID *string) ([]models.AlertRule, error) {
+	query := `SELECT ` + alertRuleColumns + ` FROM alert_rules`
+	var args []interface{}
+	if tenantID != nil {
+		query += ` WHERE tenant_id = $1`
+		args = append(args, *tenantID)
+	}
+	query += ` ORDER BY id`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []models.AlertRule
+	for rows.Next() {
+		r, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *r)
+	}
+	return rules, rows.Err()
+}
+
+// GetAlertRule returns a single alert rule
+func (s *MetricsStore) GetAlertRule(ctx context.Context, id int64) (*models.AlertRule, error) {
+	r, err := scanAlertRule(s.db.QueryRowContext(ctx, `SELECT `+alertRuleColumns+` FROM alert_rules WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAlertRuleNotFound
+	}
+	return r, err
+}
+
+// CreateAlertRule inserts a rule, filling in its ID and timestamps
+func (s *MetricsStore) CreateAlertRule(ctx context.Context, r *models.AlertRule) error {
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO alert_rules (
+			name, tenant_id, route, model, metric, operator, threshold,
+			for_windows, severity, webhook_url, webhook_format, enabled
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id, created_at, updated_at
+	`,
+		r.Name, r.TenantID, r.Route, r.Model, r.Metric, r.Operator, r.Threshold,
+		r.ForWindows, r.Severity, r.WebhookURL, r.WebhookFormat, r.Enabled,
+	).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt)
+}
+
+// UpdateAlertRule replaces a rule. Alerts of the rule are reset, since they
+// were evaluated against the old definition.
+func (s *MetricsStore) UpdateAlertRule(ctx context.Context, r *models.AlertRule) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE alert_rules SET
+			name = $2, tenant_id = $3, route = $4, model = $5, metric = $6, operator = $7,
+			threshold = $8, for_windows = $9, severity = $10, webhook_url = $11,
+			webhook_format = $12, enabled = $13, updated_at = NOW()
+		WHERE id = $1
+		RETURNING created_at, updated_at
+	`,
+		r.ID, r.Name, r.TenantID, r.Route, r.Model, r.Metric, r.Operator,
+		r.Threshold, r.ForWindows, r.Severity, r.WebhookURL, r.WebhookFormat, r.Enabled,
+	).Scan(&r.CreatedAt, &r.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrAlertRuleNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM alert_states WHERE rule_id = $1`, r.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteAlertRule deletes a rule and its alerts
+func (s *MetricsStore) DeleteAlertRule(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM alert_rules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrAlertRuleNotFound
+	}
+	return nil
+}
+
+const alertColumns = `rule_id, tenant_id, route, model, status, value, consecutive_windows,
+		starts_at, ends_at, last_window_start, last_window_end, notified_status, last_notified_at`
+
+func scanAlert(row rowScanner) (*models.Alert, error) {
+	var a models.Alert
+	var endsAt, lastNotifiedAt sql.NullTime
+	if err := row.Scan(
+		&a.RuleID, &a.TenantID, &a.Route, &a.Model, &a.Status, &a.Value, &a.Consecutive,
+		&a.StartsAt, &endsAt, &a.LastWindowStart, &a.LastWindowEnd, &a.NotifiedStatus, &lastNotifiedAt,
+	); err != nil {
+		return nil, err
+	}
+	if endsAt.Valid {
+		a.EndsAt = &endsAt.Time
+	}
+	if lastNotifiedAt.Valid {
+		a.LastNotifiedAt = &lastNotifiedAt.Time
+	}
+	return &a, nil
+}
+
+// LoadAlerts returns the alerts of one tenant/route/model series
+func (s *MetricsStore) LoadAlerts(ctx context.Context, tenantID, route, model string) ([]models.Alert, error) {
+	return s.queryAlerts(ctx, `SELECT `+alertColumns+` FROM alert_states
+		WHERE tenant_id = $1 AND route = $2 AND model = $3`, tenantID, route, model)
+}
+
+// ListAlerts returns the alerts of a tenant, optionally with one status
+func (s *MetricsStore) ListAlerts(ctx context.Context, tenantID string, status *models.AlertStatus) ([]models.Alert, error) {
+	query := `SELECT ` + alertColumns + ` FROM alert_states WHERE tenant_id = $1`
+	args := []interface{}{tenantID}
+	if status != nil {
+		query += ` AND status = $2`
+		args = append(args, *status)
+	}
+	query += ` ORDER BY starts_at DESC`
+	return s.queryAlerts(ctx, query, args...)
+}
+
+func (s *MetricsStore) queryAlerts(ctx context.Context, query string, args ...interface{}) ([]models.Alert, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []models.Alert
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, *a)
+	}
+	return alerts, rows.Err()
+}
+
+// SaveAlert upserts the state of an alert. Alerts of deleted rules are ignored.
+func (s *MetricsStore) SaveAlert(ctx context.Context, a *models.Alert) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO alert_states (`+alertColumns+`, updated_at)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NOW()
+		WHERE EXISTS (SELECT 1 FROM alert_rules WHERE id = $1)
+		ON CONFLICT (rule_id, tenant_id, route, model)
+		DO UPDATE SET
+			status = EXCLUDED.status,
+			value = EXCLUDED.value,
+			consecutive_windows = EXCLUDED.consecutive_windows,
+			starts_at = EXCLUDED.starts_at,
+			ends_at = EXCLUDED.ends_at,
+			last_window_start = EXCLUDED.last_window_start,
+			last_window_end = EXCLUDED.last_window_end,
+			notified_status = EXCLUDED.notified_status,
+			last_notified_at = EXCLUDED.last_notified_at,
+			updated_at = NOW()
+	`,
+		a.RuleID, a.TenantID, a.Route, a.Model, a.Status, a.Value, a.Consecutive,
+		a.StartsAt, a.EndsAt, a.LastWindowStart, a.LastWindowEnd, a.NotifiedStatus, a.LastNotifiedAt,
+	)
+	return err
+}
+
+// DeleteAlert removes the state of an alert that is back to normal
+func (s *MetricsStore) DeleteAlert(ctx context.Context, ruleID int64, tenantID, route, model string) error {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM alert_states
+		WHERE rule_id = $1 AND tenant_id = $2 AND route = $3 AND model = $4
+	`, ruleID, tenantID, route, model)
+	return err
+}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"streamlens/internal/models"
+	"strings"
+	"time"
+)
+
+// AnomalyQuery filters stored anomalies
+type AnomalyQuery struct {
+	TenantID string
+	Route    *string
+	Model    *string
+	Metric   *string
+	From     *time.Time
+	To       *time.Time
+	Limit    int
+}
+
+// LoadAnomalySeries returns the saved baselines of a series, or nil if it has none
+func (s *MetricsStore) LoadAnomalySeries(ctx context.Context, tenantID, route, model string) (*models.AnomalySeries, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT metric, slot, mean, variance, count, last_window_start
+		FROM anomaly_baselines
+		WHERE tenant_id = $1 AND route = $2 AND model = $3
+	`, tenantID, route, model)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	series := &models.AnomalySeries{TenantID: tenantID, Route: route, Model: model}
+	for rows.Next() {
+		var b models.AnomalyBaseline
+		var lastWindowStart time.Time
+		if err := rows.Scan(&b.Metric, &b.Slot, &b.Mean, &b.Variance, &b.Count, &lastWindowStart); err != nil {
+			return nil, err
+		}
+		if lastWindowStart.After(series.LastWindowStart) {
+			series.LastWindowStart = lastWindowStart
+		}
+		series.Baselines = append(series.Baselines, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(series.Baselines) == 0 {
+		return nil, nil
+	}
+	return series, nil
+}
+
+// SaveAnomalySeries upserts every baseline of a series
+func (s *MetricsStore) SaveAnomalySeries(ctx context.Context, series *models.AnomalySeries) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, b := range series.Baselines {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO anomaly_baselines
+				(tenant_id, route, model, metric, slot, mean, variance, count, last_window_start, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+			ON CONFLICT (tenant_id, route, model, metric, slot)
+			DO UPDATE SET
+				mean = EXCLUDED.mean,
+				variance = EXCLUDED.variance,
+				count = EXCLUDED.count,
+				last_window_start = EXCLUDED.last_window_start,
+				updated_at = NOW()
+		`, series.TenantID, series.Route, series.Model, b.Metric, b.Slot, b.Mean, b.Variance, b.Count, series.LastWindowStart); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// InsertAnomaly stores an anomaly; a window already flagged for a metric is kept
+func (s *MetricsStore) InsertAnomaly(ctx context.Context, a *models.Anomaly) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO llm_anomalies (
+			tenant_id, route, model, metric, window_start, window_end,
+			observed, expected, stddev, z_score, seasonal, detected_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (tenant_id, route, model, metric, window_start) DO NOTHING
+	`,
+		a.TenantID, a.Route, a.Model, a.Metric, a.WindowStart, a.WindowEnd,
+		a.Observed, a.Expected, a.StdDev, a.ZScore, a.Seasonal, a.DetectedAt,
+	)
+	return err
+}
+
+// QueryAnomalies returns anomalies of a tenant, newest window first
+func (s *MetricsStore) QueryAnomalies(ctx context.Context, q AnomalyQuery) ([]models.Anomaly, error) {
+	conditions := []string{"tenant_id = $1"}
+	args := []interface{}{q.TenantID}
+
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if q.Route != nil {
+		add("route = $%d", *q.Route)
+	}
+	if q.Model != nil {
+		add("model = $%d", *q.Model)
+	}
+	if q.Metric != nil {
+		add("metric = $%d", *q.Metric)
+	}
+	if q.From != nil {
+		add("window_start >= $%d", *q.From)
+	}
+	if q.To != nil {
+		add("window_start < $%d", *q.To)
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	args = append(args, limit)
+
+	query := fmt.Sprintf(`
+		SELECT tenant_id, route, model, metric, window_start, window_end,
+			observed, expected, stddev, z_score, seasonal, detected_at
+		FROM llm_anomalies
+		WHERE %s
+		ORDER BY window_start DESC, metric
+		LIMIT $%d
+	`, strings.Join(conditions, " AND "), len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var anomalies []models.Anomaly
+	for rows.Next() {
+		var a models.Anomaly
+		if err := rows.Scan(
+			&a.TenantID, &a.Route, &a.Model, &a.Metric, &a.WindowStart, &a.WindowEnd,
+			&a.Observed, &a.Expected, &a.StdDev, &a.ZScore, &a.Seasonal, &a.DetectedAt,
+		); err != nil {
+			return nil, err
+		}
+		anomalies = append(anomalies, a)
+	}
+	return anomalies, rows.Err()
+}
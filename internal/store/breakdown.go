@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"streamlens/internal/models"
+	"time"
+)
+
+// breakdownKey identifies the window a breakdown row belongs to
+type breakdownKey struct {
+	tenantID    string
+	route       string
+	model       string
+	sessionID   string
+	dimensions  string
+	windowStart int64
+}
+
+// replaceBreakdowns rewrites the error class and finish reason counts of one
+// window in llm_metrics_breakdown
+func replaceBreakdowns(ctx context.Context, tx *sql.Tx, table string, m *models.LLMMetrics) error {
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM llm_metrics_breakdown
+		WHERE metrics_table = $1 AND tenant_id = $2 AND route = $3 AND model = $4
+			AND session_id = $5 AND dimensions_key = $6 AND window_start = $7
+	`, table, m.TenantID, m.Route, m.Model, m.SessionID, m.Dimensions.Key(), m.WindowStart); err != nil {
+		return err
+	}
+
+	dimensions := []struct {
+		name   string
+		counts map[string]int
+	}{
+		{name: models.BreakdownErrorClass, counts: m.ErrorClasses},
+		{name: models.BreakdownFinishReason, counts: m.FinishReasons},
+	}
+
+	for _, dim := range dimensions {
+		for value, count := range dim.counts {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO llm_metrics_breakdown
+					(metrics_table, tenant_id, route, model, session_id, dimensions_key, window_start, dimension, value, count)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			`, table, m.TenantID, m.Route, m.Model, m.SessionID, m.Dimensions.Key(), m.WindowStart, dim.name, value, count); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadBreakdowns attaches the breakdowns of table to every window in results,
+// optionally restricted to one tenant
+func loadBreakdowns(ctx context.Context, q queryer, table string, tenantID *string, results []models.LLMMetrics) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	from, to := results[0].WindowStart, results[0].WindowStart
+	index := make(map[breakdownKey]*models.LLMMetrics, len(results))
+	for i := range results {
+		m := &results[i]
+		if m.WindowStart.Before(from) {
+			from = m.WindowStart
+		}
+		if m.WindowStart.After(to) {
+			to = m.WindowStart
+		}
+		index[breakdownKey{m.TenantID, m.Route, m.Model, m.SessionID, m.Dimensions.Key(), m.WindowStart.Unix()}] = m
+	}
+
+	query := `
+		SELECT tenant_id, route, model, session_id, dimensions_key, window_start, dimension, value, count
+		FROM llm_metrics_breakdown
+		WHERE metrics_table = $1 AND window_start >= $2 AND window_start <= $3`
+	args := []interface{}{table, from, to}
+	if tenantID != nil {
+		query += ` AND tenant_id = $4`
+		args = append(args, *tenantID)
+	}
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key breakdownKey
+		var windowStart time.Time
+		var dimension, value string
+		var count int
+		if err := rows.Scan(&key.tenantID, &key.route, &key.model, &key.sessionID, &key.dimensions, &windowStart, &dimension, &value, &count); err != nil {
+			return err
+		}
+		key.windowStart = windowStart.Unix()
+
+		m, ok := index[key]
+		if !ok {
+			continue
+		}
+		switch dimension {
+		case models.BreakdownErrorClass:
+			if m.ErrorClasses == nil {
+				m.ErrorClasses = make(map[string]int)
+			}
+			m.ErrorClasses[value] = count
+		case models.BreakdownFinishReason:
+			if m.FinishReasons == nil {
+				m.FinishReasons = make(map[string]int)
+			}
+			m.FinishReasons[value] = count
+		default:
+			return fmt.Errorf("unknown breakdown dimension %q", dimension)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,184 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"streamlens/internal/models"
+	"time"
+)
+
+// ErrBudgetNotFound is returned when a budget does not exist
+var ErrBudgetNotFound = errors.New("budget not found")
+
+const budgetColumns = `id, tenant_id, route, period, amount_usd, created_at, updated_at`
+
+func scanBudget(row rowScanner) (*models.Budget, error) {
+	var b models.Budget
+	if err := row.Scan(&b.ID, &b.TenantID, &b.Route, &b.Period, &b.AmountUSD, &b.CreatedAt, &b.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// ListBudgets returns budgets, of one tenant when tenantID is set
+func (s *MetricsStore) ListBudgets(ctx context.Context, tenantID *string) ([]models.Budget, error) {
+	query := `SELECT ` + budgetColumns + ` FROM budgets`
+	var args []interface{}
+	if tenantID != nil {
+		query += ` WHERE tenant_id = $1`
+		args = append(args, *tenantID)
+	}
+	query += ` ORDER BY id`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var budgets []models.Budget
+	for rows.Next() {
+		b, err := scanBudget(rows)
+		if err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, *b)
+	}
+	return budgets, rows.Err()
+}
+
+// GetBudget returns a single budget
+func (s *MetricsStore) GetBudget(ctx context.Context, id int64) (*models.Budget, error) {
+	b, err := scanBudget(s.db.QueryRowContext(ctx, `SELECT `+budgetColumns+` FROM budgets WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrBudgetNotFound
+	}
+	return b, err
+}
+
+// CreateBudget inserts a budget, filling in its ID and timestamps
+func (s *MetricsStore) CreateBudget(ctx context.Context, b *models.Budget) error {
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO budgets (tenant_id, route, period, amount_usd)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at
+	`, b.TenantID, b.Route, b.Period, b.AmountUSD).Scan(&b.ID, &b.CreatedAt, &b.UpdatedAt)
+}
+
+// UpdateBudget replaces a budget. Spend of earlier periods is kept.
+func (s *MetricsStore) UpdateBudget(ctx context.Context, b *models.Budget) error {
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE budgets SET tenant_id = $2, route = $3, period = $4, amount_usd = $5, updated_at = NOW()
+		WHERE id = $1
+		RETURNING created_at, updated_at
+	`, b.ID, b.TenantID, b.Route, b.Period, b.AmountUSD).Scan(&b.CreatedAt, &b.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrBudgetNotFound
+	}
+	return err
+}
+
+// DeleteBudget deletes a budget and its spend
+func (s *MetricsStore) DeleteBudget(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM budgets WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrBudgetNotFound
+	}
+	return nil
+}
+
+// AddBudgetSpend adds the spend of a flush to a budget period, returning the
+// period's total spend and the highest threshold notified so far. The spend
+// of a flush added before is not added again.
+func (s *MetricsStore) AddBudgetSpend(ctx context.Context, budgetID int64, periodStart time.Time, flushID string, amountUSD float64) (spendUSD float64, notifiedPercent int, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO budget_spend (budget_id, period_start)
+		SELECT $1, $2
+		WHERE EXISTS (SELECT 1 FROM budgets WHERE id = $1)
+		ON CONFLICT (budget_id, period_start) DO NOTHING
+	`, budgetID, periodStart); err != nil {
+		return 0, 0, err
+	}
+	err = tx.QueryRowContext(ctx, `
+		SELECT spend_usd, notified_percent FROM budget_spend
+		WHERE budget_id = $1 AND period_start = $2
+		FOR UPDATE
+	`, budgetID, periodStart).Scan(&spendUSD, &notifiedPercent)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, ErrBudgetNotFound
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO budget_spend_flushes (budget_id, period_start, flush_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, budgetID, periodStart, flushID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to record flush: %w", err)
+	}
+	if added, err := res.RowsAffected(); err != nil || added == 0 {
+		return spendUSD, notifiedPercent, err
+	}
+
+	if err := tx.QueryRowContext(ctx, `
+		UPDATE budget_spend SET spend_usd = spend_usd + $3, updated_at = NOW()
+		WHERE budget_id = $1 AND period_start = $2
+		RETURNING spend_usd
+	`, budgetID, periodStart, amountUSD).Scan(&spendUSD); err != nil {
+		return 0, 0, err
+	}
+	return spendUSD, notifiedPercent, tx.Commit()
+}
+
+// PruneBudgetFlushes forgets the flushes added to budgets before the given
+// time, returning how many were removed. A flush retried after that would be
+// counted again.
+func (s *MetricsStore) PruneBudgetFlushes(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM budget_spend_flushes WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetBudgetSpend returns the spend and highest notified threshold of a budget
+// period, or zeros for a period without spend
+func (s *MetricsStore) GetBudgetSpend(ctx context.Context, budgetID int64, periodStart time.Time) (spendUSD float64, notifiedPercent int, err error) {
+	err = s.db.QueryRowContext(ctx, `
+		SELECT spend_usd, notified_percent FROM budget_spend
+		WHERE budget_id = $1 AND period_start = $2
+	`, budgetID, periodStart).Scan(&spendUSD, &notifiedPercent)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, nil
+	}
+	return spendUSD, notifiedPercent, err
+}
+
+// SetBudgetNotified moves the notified threshold of a budget period from one
+// value to another, reporting whether it still had the expected value. Claiming
+// a threshold this way notifies it once even with several processors.
+func (s *MetricsStore) SetBudgetNotified(ctx context.Context, budgetID int64, periodStart time.Time, from, to int) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE budget_spend SET notified_percent = $4, updated_at = NOW()
+		WHERE budget_id = $1 AND period_start = $2 AND notified_percent = $3
+	`, budgetID, periodStart, from, to)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
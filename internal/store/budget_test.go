@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"streamlens/internal/models"
+	"testing"
+	"time"
+)
+
+func TestAddBudgetSpend(t *testing.T) {
+	ctx := context.Background()
+	s := testSchemaStore(t)
+	if _, err := s.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	b := &models.Budget{TenantID: "tenant-1", Period: models.BudgetDaily, AmountUSD: 10}
+	if err := s.CreateBudget(ctx, b); err != nil {
+		t.Fatalf("CreateBudget() error = %v", err)
+	}
+	period := time.Date(2025, 11, 20, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		flushID string
+		amount  float64
+		want    float64
+	}{
+		{name: "first flush", flushID: "flush-1", amount: 1.5, want: 1.5},
+		{name: "second flush", flushID: "flush-2", amount: 2, want: 3.5},
+		{name: "retried flush", flushID: "flush-1", amount: 1.5, want: 3.5},
+	}
+	for _, tt := range tests {
+		spend, _, err := s.AddBudgetSpend(ctx, b.ID, period, tt.flushID, tt.amount)
+		if err != nil || spend != tt.want {
+			t.Errorf("%s: AddBudgetSpend() = %v, %v; want %v", tt.name, spend, err, tt.want)
+		}
+	}
+
+	if _, _, err := s.AddBudgetSpend(ctx, b.ID+1, period, "flush-3", 1); !errors.Is(err, ErrBudgetNotFound) {
+		t.Errorf("AddBudgetSpend() of a missing budget error = %v, want ErrBudgetNotFound", err)
+	}
+
+	// Forgotten flushes are counted again
+	if pruned, err := s.PruneBudgetFlushes(ctx, time.Now().UTC().Add(time.Hour)); err != nil || pruned != 2 {
+		t.Errorf("PruneBudgetFlushes() = %d, %v; want 2", pruned, err)
+	}
+	if spend, _, err := s.AddBudgetSpend(ctx, b.ID, period, "flush-1", 1.5); err != nil || spend != 5 {
+		t.Errorf("AddBudgetSpend() after pruning = %v, %v; want 5", spend, err)
+	}
+}
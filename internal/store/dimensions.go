@@ -0,0 +1,112 @@
+package store
+
+import (
+	"context"
+	"streamlens/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// ListTenantDimensions returns promoted metadata keys, of one tenant when
+// tenantID is set
+func (s *MetricsStore) ListTenantDimensions(ctx context.Context, tenantID *string) ([]models.TenantDimension, error) {
+	query := `SELECT tenant_id, key, max_values, created_at FROM tenant_dimensions`
+	var args []interface{}
+	if tenantID != nil {
+		query += ` WHERE tenant_id = $1`
+		args = append(args, *tenantID)
+	}
+	query += ` ORDER BY tenant_id, key`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dims []models.TenantDimension
+	for rows.Next() {
+		var d models.TenantDimension
+		if err := rows.Scan(&d.TenantID, &d.Key, &d.MaxValues, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		dims = append(dims, d)
+	}
+	return dims, rows.Err()
+}
+
+// ReplaceTenantDimensions replaces the promoted metadata keys of a tenant.
+// Admitted values of keys that stay promoted are kept.
+func (s *MetricsStore) ReplaceTenantDimensions(ctx context.Context, tenantID string, dims []models.TenantDimension) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tenant_dimensions WHERE tenant_id = $1`, tenantID); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(dims))
+	for i := range dims {
+		d := &dims[i]
+		d.TenantID = tenantID
+		if err := tx.QueryRowContext(ctx, `
+			INSERT INTO tenant_dimensions (tenant_id, key, max_values)
+			VALUES ($1, $2, $3)
+			RETURNING created_at
+		`, tenantID, d.Key, d.MaxValues).Scan(&d.CreatedAt); err != nil {
+			return err
+		}
+		keys = append(keys, d.Key)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM dimension_values WHERE tenant_id = $1 AND NOT (key = ANY($2))
+	`, tenantID, pq.Array(keys)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListDimensionValues returns the admitted values of every promoted key, by
+// tenant and key
+func (s *MetricsStore) ListDimensionValues(ctx context.Context) (map[string]map[string][]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT tenant_id, key, value FROM dimension_values`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[string]map[string][]string)
+	for rows.Next() {
+		var tenantID, key, value string
+		if err := rows.Scan(&tenantID, &key, &value); err != nil {
+			return nil, err
+		}
+		if values[tenantID] == nil {
+			values[tenantID] = make(map[string][]string)
+		}
+		values[tenantID][key] = append(values[tenantID][key], value)
+	}
+	return values, rows.Err()
+}
+
+// AdmitDimensionValue records a new value of a promoted key unless the key
+// already has maxValues values, reporting whether the value is admitted.
+// Concurrent admissions may exceed the cap by a few values.
+func (s *MetricsStore) AdmitDimensionValue(ctx context.Context, tenantID, key, value string, maxValues int) (bool, error) {
+	var admitted bool
+	err := s.db.QueryRowContext(ctx, `
+		WITH inserted AS (
+			INSERT INTO dimension_values (tenant_id, key, value)
+			SELECT $1, $2, $3
+			WHERE (SELECT COUNT(*) FROM dimension_values WHERE tenant_id = $1 AND key = $2) < $4
+			ON CONFLICT DO NOTHING
+			RETURNING 1
+		)
+		SELECT EXISTS (SELECT 1 FROM inserted)
+			OR EXISTS (SELECT 1 FROM dimension_values WHERE tenant_id = $1 AND key = $2 AND value = $3)
+	`, tenantID, key, value, maxValues).Scan(&admitted)
+	return admitted, err
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"streamlens/internal/models"
+	"strings"
+	"time"
+)
+
+// ErrDLQEntryNotFound is returned when a dead-letter entry does not exist
+var ErrDLQEntryNotFound = errors.New("dead-letter entry not found")
+
+// DLQQuery filters dead-letter entries
+type DLQQuery struct {
+	SourceTopic     *string
+	From            *time.Time
+	To              *time.Time
+	IncludeRedriven bool
+	Limit           int
+}
+
+// InsertDLQEntry records a dead-lettered record. A record dead-lettered again
+// after a restart keeps its original entry.
+func (s *MetricsStore) InsertDLQEntry(ctx context.Context, entry *models.DLQEntry) error {
+	headers, err := json.Marshal(entry.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to encode headers: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO llm_dlq (
+			consumer_group, source_topic, source_partition, source_offset,
+			record_key, record_value, record_headers, error_reason, failed_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (consumer_group, source_topic, source_partition, source_offset) DO NOTHING
+	`,
+		entry.ConsumerGroup, entry.SourceTopic, entry.SourcePartition, entry.SourceOffset,
+		[]byte(entry.Key), []byte(entry.Value), headers, entry.ErrorReason, entry.FailedAt,
+	)
+	return err
+}
+
+// ListDLQEntries returns dead-letter entries, newest first
+func (s *MetricsStore) ListDLQEntries(ctx context.Context, q DLQQuery) ([]models.DLQEntry, error) {
+	var conditions []string
+	var args []interface{}
+
+	if q.SourceTopic != nil {
+		args = append(args, *q.SourceTopic)
+		conditions = append(conditions, fmt.Sprintf("source_topic = $%d", len(args)))
+	}
+	if q.From != nil {
+		args = append(args, *q.From)
+		conditions = append(conditions, fmt.Sprintf("failed_at >= $%d", len(args)))
+	}
+	if q.To != nil {
+		args = append(args, *q.To)
+		conditions = append(conditions, fmt.Sprintf("failed_at < $%d", len(args)))
+	}
+	if !q.IncludeRedriven {
+		conditions = append(conditions, "redriven_at IS NULL")
+	}
+
+	query := `SELECT ` + dlqColumns + ` FROM llm_dlq`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY failed_at DESC, id DESC"
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" LIMIT $%d", len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.DLQEntry
+	for rows.Next() {
+		entry, err := scanDLQEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, rows.Err()
+}
+
+// GetDLQEntry returns a single dead-letter entry
+func (s *MetricsStore) GetDLQEntry(ctx context.Context, id int64) (*models.DLQEntry, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+dlqColumns+` FROM llm_dlq WHERE id = $1`, id)
+	entry, err := scanDLQEntry(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDLQEntryNotFound
+	}
+	return entry, err
+}
+
+// MarkDLQRedriven records that an entry was produced back to its source topic
+func (s *MetricsStore) MarkDLQRedriven(ctx context.Context, id int64, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE llm_dlq SET redriven_at = $2 WHERE id = $1`, id, at)
+	return err
+}
+
+const dlqColumns = `id, consumer_group, source_topic, source_partition, source_offset,
+		record_key, record_value, record_headers, error_reason, failed_at, redriven_at`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDLQEntry(row rowScanner) (*models.DLQEntry, error) {
+	var entry models.DLQEntry
+	var key, value, headers []byte
+	var redrivenAt sql.NullTime
+
+	if err := row.Scan(
+		&entry.ID, &entry.ConsumerGroup, &entry.SourceTopic, &entry.SourcePartition, &entry.SourceOffset,
+		&key, &value, &headers, &entry.ErrorReason, &entry.FailedAt, &redrivenAt,
+	); err != nil {
+		return nil, err
+	}
+
+	entry.Key = string(key)
+	entry.Value = string(value)
+	if len(headers) > 0 {
+		if err := json.Unmarshal(headers, &entry.Headers); err != nil {
+			return nil, fmt.Errorf("failed to decode headers: %w", err)
+		}
+	}
+	if redrivenAt.Valid {
+		entry.RedrivenAt = &redrivenAt.Time
+	}
+	return &entry, nil
+}
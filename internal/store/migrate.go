@@ -0,0 +1,291 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrationFiles holds the schema as numbered migrations, each an
+// NNNN_name.up.sql file and the NNNN_name.down.sql file reverting it
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrateLockKey is the Postgres advisory lock held while migrating, so that
+// services starting together apply each migration once
+const migrateLockKey int64 = 0x6d696772617465
+
+var migrationName = regexp.MustCompile(`^(\d{4})_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one version of the schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration and when it was applied, nil if it was not
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrations returns the embedded migrations, ordered by version
+func Migrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles)
+}
+
+func loadMigrations(files fs.FS) ([]Migration, error) {
+	paths, err := fs.Glob(files, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, p := range paths {
+		name := p[len("migrations/"):]
+		m := migrationName.FindStringSubmatch(name)
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+		version, _ := strconv.Atoi(m[1])
+		data, err := fs.ReadFile(files, p)
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %04d is named both %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(data)
+		} else {
+			mig.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, mig := range migrations {
+		if mig.Version != i+1 {
+			return nil, fmt.Errorf("migration %04d is missing", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// withMigrateLock runs fn on a dedicated connection holding the migrate lock,
+// waiting for any other migration run to finish first
+func (s *MetricsStore) withMigrateLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrateLockKey); err != nil {
+		return fmt.Errorf("failed to take migrate lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrateLockKey); err != nil {
+			log.Printf("Failed to release migrate lock: %v", err)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return fn(conn)
+}
+
+// appliedMigrations returns when each applied version was applied
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// runMigration runs one direction of a migration and records it, in one
+// transaction
+func runMigration(ctx context.Context, conn *sql.Conn, mig Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	script, record := mig.Down, `DELETE FROM schema_migrations WHERE version = $1`
+	args := []interface{}{mig.Version}
+	if up {
+		script, record = mig.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`
+		args = append(args, mig.Name)
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// adoptBaseline records the first migration as applied to a database created
+// from deploy/init.sql before migrations existed: it holds the tables of
+// 0001_init, which cannot run again, but no record of it. The later
+// migrations only add what is missing. It returns whether the database was
+// adopted.
+func adoptBaseline(ctx context.Context, conn *sql.Conn, first Migration) (bool, error) {
+	var exists bool
+	if err := conn.QueryRowContext(ctx, `SELECT to_regclass('llm_metrics') IS NOT NULL`).Scan(&exists); err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, nil
+	}
+	if _, err := conn.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`,
+		first.Version, first.Name); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Migrate applies every migration not applied yet, in order, and returns
+// how many were applied
+func (s *MetricsStore) Migrate(ctx context.Context) (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = s.withMigrateLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			adopted, err := adoptBaseline(ctx, conn, migrations[0])
+			if err != nil {
+				return fmt.Errorf("failed to adopt existing schema: %w", err)
+			}
+			if adopted {
+				log.Printf("Adopted existing schema as migration %04d_%s", migrations[0].Version, migrations[0].Name)
+				applied[migrations[0].Version] = time.Now()
+			}
+		}
+		for version := range applied {
+			if version > len(migrations) {
+				log.Printf("Database schema is at version %d, newer than this build (%d)", version, len(migrations))
+				break
+			}
+		}
+		for _, mig := range migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, mig, true); err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", mig.Version, mig.Name, err)
+			}
+			log.Printf("Applied migration %04d_%s", mig.Version, mig.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// MigrateDown reverts the latest steps applied migrations, newest first, and
+// returns the migrations reverted
+func (s *MetricsStore) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	err = s.withMigrateLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for version := range applied {
+			if version > len(migrations) {
+				return fmt.Errorf("database schema is at version %d, newer than this build (%d)", version, len(migrations))
+			}
+		}
+		for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			mig := migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, mig, false); err != nil {
+				return fmt.Errorf("reverting migration %04d_%s failed: %w", mig.Version, mig.Name, err)
+			}
+			log.Printf("Reverted migration %04d_%s", mig.Version, mig.Name)
+			reverted = append(reverted, mig)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatuses returns every embedded migration with when it was applied
+func (s *MetricsStore) MigrationStatuses(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	err = s.withMigrateLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range migrations {
+			status := MigrationStatus{Migration: mig}
+			if at, ok := applied[mig.Version]; ok {
+				status.AppliedAt = &at
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
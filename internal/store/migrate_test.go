@@ -0,0 +1,214 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations() error = %v", err)
+	}
+	if len(migrations) == 0 || migrations[0].Version != 1 || migrations[0].Name != "init" {
+		t.Fatalf("Migrations() = %v, want 0001_init first", migrations)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(sql string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(sql)} }
+
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		wantErr  bool
+		versions []int
+	}{
+		{
+			name: "ordered",
+			files: fstest.MapFS{
+				"migrations/0002_add_col.up.sql":   file("ALTER TABLE t ADD COLUMN c INT;"),
+				"migrations/0002_add_col.down.sql": file("ALTER TABLE t DROP COLUMN c;"),
+				"migrations/0001_init.up.sql":      file("CREATE TABLE t ();"),
+				"migrations/0001_init.down.sql":    file("DROP TABLE t;"),
+			},
+			versions: []int{1, 2},
+		},
+		{
+			name: "missing down",
+			files: fstest.MapFS{
+				"migrations/0001_init.up.sql": file("CREATE TABLE t ();"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "gap",
+			files: fstest.MapFS{
+				"migrations/0001_init.up.sql":      file("CREATE TABLE t ();"),
+				"migrations/0001_init.down.sql":    file("DROP TABLE t;"),
+				"migrations/0003_add_col.up.sql":   file("ALTER TABLE t ADD COLUMN c INT;"),
+				"migrations/0003_add_col.down.sql": file("ALTER TABLE t DROP COLUMN c;"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "conflicting names",
+			files: fstest.MapFS{
+				"migrations/0001_init.up.sql":    file("CREATE TABLE t ();"),
+				"migrations/0001_other.down.sql": file("DROP TABLE t;"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid name",
+			files: fstest.MapFS{
+				"migrations/init.sql": file("CREATE TABLE t ();"),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.files)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadMigrations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(migrations) != len(tt.versions) {
+				t.Fatalf("got %d migrations, want %d", len(migrations), len(tt.versions))
+			}
+			for i, v := range tt.versions {
+				if migrations[i].Version != v || migrations[i].Up == "" || migrations[i].Down == "" {
+					t.Errorf("migration %d = %+v, want version %d with up and down", i, migrations[i], v)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrations_Columns(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations() error = %v", err)
+	}
+
+	// Every metrics column is created by 0001_init or added by a later
+	// migration, so that databases created from deploy/init.sql get it
+	for _, col := range strings.Split(metricsColumns, ",") {
+		col = strings.TrimSpace(col)
+		created := regexp.MustCompile(`(?m)^\s+` + col + `\s`).MatchString(migrations[0].Up)
+		for _, mig := range migrations[1:] {
+			if strings.Contains(mig.Up, "ADD COLUMN IF NOT EXISTS "+col+" ") {
+				created = true
+			}
+		}
+		if !created {
+			t.Errorf("no migration adds column %s", col)
+		}
+	}
+}
+
+// testSchemaStore returns a store using a new, empty schema of the database
+// at TEST_POSTGRES_DSN, dropped at the end of the test
+func testSchemaStore(t *testing.T) *MetricsStore {
+	t.Helper()
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("failed to drop schema: %v", err)
+		}
+	})
+
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	s, err := NewMetricsStore(dsn + sep + "search_path=" + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		// baseline creates the schema from deploy/init.sql before migrating
+		baseline bool
+		want     int
+	}{
+		{name: "empty database", want: len(migrations)},
+		{name: "baseline database", baseline: true, want: len(migrations) - 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testSchemaStore(t)
+			if tt.baseline {
+				if _, err := s.db.ExecContext(ctx, migrations[0].Up); err != nil {
+					t.Fatalf("failed to create baseline schema: %v", err)
+				}
+				if _, err := s.db.ExecContext(ctx, `
+					INSERT INTO llm_metrics (tenant_id, route, model, window_start, window_end, requests)
+					VALUES ('t1', '/chat', 'gpt-4o', NOW(), NOW(), 3)`); err != nil {
+					t.Fatalf("failed to insert baseline row: %v", err)
+				}
+			}
+
+			applied, err := s.Migrate(ctx)
+			if err != nil {
+				t.Fatalf("Migrate() error = %v", err)
+			}
+			if applied != tt.want {
+				t.Errorf("Migrate() applied %d migrations, want %d", applied, tt.want)
+			}
+			if applied, err := s.Migrate(ctx); err != nil || applied != 0 {
+				t.Errorf("Migrate() again = %d, %v; want nothing to apply", applied, err)
+			}
+
+			// The migrated tables serve the current column list
+			for _, table := range []string{"llm_metrics", "llm_metrics_5m", "llm_metrics_1h", "llm_metrics_1d"} {
+				if _, err := s.db.ExecContext(ctx, fmt.Sprintf(`SELECT %s, flush_ids FROM %s`, metricsColumns, table)); err != nil {
+					t.Errorf("%s: %v", table, err)
+				}
+			}
+			var requests int
+			err = s.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(requests), 0) FROM llm_metrics`).Scan(&requests)
+			if err != nil || tt.baseline && requests != 3 {
+				t.Errorf("llm_metrics holds %d requests (%v) after migrating", requests, err)
+			}
+
+			reverted, err := s.MigrateDown(ctx, len(migrations))
+			if err != nil || len(reverted) != len(migrations) {
+				t.Fatalf("MigrateDown() = %d migrations, %v; want %d", len(reverted), err, len(migrations))
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+-- Drops the initial schema. Window definition and reprocess tables are
+-- created by the services from llm_metrics and share its id sequence, so
+-- they go first.
+DO $$
+DECLARE
+    t TEXT;
+BEGIN
+    FOR t IN SELECT tablename FROM pg_tables WHERE schemaname = current_schema() AND tablename LIKE 'llm\_window\_%' LOOP
+        EXECUTE format('DROP TABLE %I', t);
+    END LOOP;
+END $$;
+
+DROP TABLE IF EXISTS llm_metrics_reprocess;
+DROP TABLE llm_metrics;
@@ -0,0 +1,10 @@
+DROP TABLE llm_metrics_1d;
+DROP TABLE llm_metrics_1h;
+DROP TABLE llm_metrics_5m;
+
+DROP INDEX IF EXISTS idx_llm_metrics_window_start;
+
+ALTER TABLE llm_metrics
+    DROP COLUMN IF EXISTS latency_sum_ms,
+    DROP COLUMN IF EXISTS prompt_tokens_sum,
+    DROP COLUMN IF EXISTS completion_tokens_sum;
@@ -0,0 +1,33 @@
+-- Additive components of the averages, so that minute windows can be rolled
+-- up, and the 5-minute, hourly and daily rollup tables built from them
+ALTER TABLE llm_metrics
+    ADD COLUMN IF NOT EXISTS latency_sum_ms BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS prompt_tokens_sum BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS completion_tokens_sum BIGINT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_llm_metrics_window_start ON llm_metrics(window_start);
+
+-- Rollup tables share the minute table's layout
+CREATE TABLE IF NOT EXISTS llm_metrics_5m (
+    LIKE llm_metrics INCLUDING DEFAULTS INCLUDING CONSTRAINTS,
+    PRIMARY KEY (id),
+    UNIQUE (tenant_id, route, model, window_start)
+);
+CREATE INDEX IF NOT EXISTS idx_llm_metrics_5m_tenant_time ON llm_metrics_5m(tenant_id, window_start DESC);
+CREATE INDEX IF NOT EXISTS idx_llm_metrics_5m_window_start ON llm_metrics_5m(window_start);
+
+CREATE TABLE IF NOT EXISTS llm_metrics_1h (
+    LIKE llm_metrics INCLUDING DEFAULTS INCLUDING CONSTRAINTS,
+    PRIMARY KEY (id),
+    UNIQUE (tenant_id, route, model, window_start)
+);
+CREATE INDEX IF NOT EXISTS idx_llm_metrics_1h_tenant_time ON llm_metrics_1h(tenant_id, window_start DESC);
+CREATE INDEX IF NOT EXISTS idx_llm_metrics_1h_window_start ON llm_metrics_1h(window_start);
+
+CREATE TABLE IF NOT EXISTS llm_metrics_1d (
+    LIKE llm_metrics INCLUDING DEFAULTS INCLUDING CONSTRAINTS,
+    PRIMARY KEY (id),
+    UNIQUE (tenant_id, route, model, window_start)
+);
+CREATE INDEX IF NOT EXISTS idx_llm_metrics_1d_tenant_time ON llm_metrics_1d(tenant_id, window_start DESC);
+CREATE INDEX IF NOT EXISTS idx_llm_metrics_1d_window_start ON llm_metrics_1d(window_start);
@@ -0,0 +1,21 @@
+DO $$
+DECLARE
+    t TEXT;
+    con TEXT;
+BEGIN
+    FOREACH t IN ARRAY ARRAY['llm_metrics', 'llm_metrics_5m', 'llm_metrics_1h', 'llm_metrics_1d'] LOOP
+        FOR con IN SELECT conname FROM pg_constraint WHERE conrelid = t::REGCLASS AND contype = 'u' LOOP
+            EXECUTE format('ALTER TABLE %I DROP CONSTRAINT %I', t, con);
+        END LOOP;
+        EXECUTE format('ALTER TABLE %I ADD UNIQUE (tenant_id, route, model, window_start)', t);
+    END LOOP;
+END $$;
+
+ALTER TABLE llm_metrics
+    DROP COLUMN IF EXISTS session_id;
+ALTER TABLE llm_metrics_5m
+    DROP COLUMN IF EXISTS session_id;
+ALTER TABLE llm_metrics_1h
+    DROP COLUMN IF EXISTS session_id;
+ALTER TABLE llm_metrics_1d
+    DROP COLUMN IF EXISTS session_id;
@@ -0,0 +1,24 @@
+-- Session windows are keyed by session_id as well, '' for fixed windows.
+-- Tables for additional window definitions (WINDOW_DEFINITIONS) are created by
+-- the metrics processor at startup as llm_window_<name>, with the same layout.
+ALTER TABLE llm_metrics
+    ADD COLUMN IF NOT EXISTS session_id VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE llm_metrics_5m
+    ADD COLUMN IF NOT EXISTS session_id VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE llm_metrics_1h
+    ADD COLUMN IF NOT EXISTS session_id VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE llm_metrics_1d
+    ADD COLUMN IF NOT EXISTS session_id VARCHAR(255) NOT NULL DEFAULT '';
+
+DO $$
+DECLARE
+    t TEXT;
+    con TEXT;
+BEGIN
+    FOREACH t IN ARRAY ARRAY['llm_metrics', 'llm_metrics_5m', 'llm_metrics_1h', 'llm_metrics_1d'] LOOP
+        FOR con IN SELECT conname FROM pg_constraint WHERE conrelid = t::REGCLASS AND contype = 'u' LOOP
+            EXECUTE format('ALTER TABLE %I DROP CONSTRAINT %I', t, con);
+        END LOOP;
+        EXECUTE format('ALTER TABLE %I ADD UNIQUE (tenant_id, route, model, session_id, window_start)', t);
+    END LOOP;
+END $$;
@@ -0,0 +1,24 @@
+ALTER TABLE llm_metrics
+    DROP COLUMN IF EXISTS p50_latency_ms,
+    DROP COLUMN IF EXISTS p90_latency_ms,
+    DROP COLUMN IF EXISTS p99_latency_ms,
+    DROP COLUMN IF EXISTS p999_latency_ms,
+    DROP COLUMN IF EXISTS latency_sketch;
+ALTER TABLE llm_metrics_5m
+    DROP COLUMN IF EXISTS p50_latency_ms,
+    DROP COLUMN IF EXISTS p90_latency_ms,
+    DROP COLUMN IF EXISTS p99_latency_ms,
+    DROP COLUMN IF EXISTS p999_latency_ms,
+    DROP COLUMN IF EXISTS latency_sketch;
+ALTER TABLE llm_metrics_1h
+    DROP COLUMN IF EXISTS p50_latency_ms,
+    DROP COLUMN IF EXISTS p90_latency_ms,
+    DROP COLUMN IF EXISTS p99_latency_ms,
+    DROP COLUMN IF EXISTS p999_latency_ms,
+    DROP COLUMN IF EXISTS latency_sketch;
+ALTER TABLE llm_metrics_1d
+    DROP COLUMN IF EXISTS p50_latency_ms,
+    DROP COLUMN IF EXISTS p90_latency_ms,
+    DROP COLUMN IF EXISTS p99_latency_ms,
+    DROP COLUMN IF EXISTS p999_latency_ms,
+    DROP COLUMN IF EXISTS latency_sketch;
@@ -0,0 +1,25 @@
+-- Latency percentiles and the mergeable sketch they are computed from
+ALTER TABLE llm_metrics
+    ADD COLUMN IF NOT EXISTS p50_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS p90_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS p99_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS p999_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS latency_sketch BYTEA;
+ALTER TABLE llm_metrics_5m
+    ADD COLUMN IF NOT EXISTS p50_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS p90_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS p99_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS p999_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS latency_sketch BYTEA;
+ALTER TABLE llm_metrics_1h
+    ADD COLUMN IF NOT EXISTS p50_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS p90_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS p99_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS p999_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS latency_sketch BYTEA;
+ALTER TABLE llm_metrics_1d
+    ADD COLUMN IF NOT EXISTS p50_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS p90_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS p99_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS p999_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS latency_sketch BYTEA;
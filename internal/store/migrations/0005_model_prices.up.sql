@@ -0,0 +1,15 @@
+-- Model prices (USD per million tokens). An empty tenant_id is the list price;
+-- a tenant_id makes the row a negotiated override. The row with the latest
+-- effective_from at or before an event's timestamp applies.
+CREATE TABLE IF NOT EXISTS model_prices (
+    id BIGSERIAL PRIMARY KEY,
+    provider VARCHAR(255) NOT NULL DEFAULT '',
+    model VARCHAR(255) NOT NULL,
+    tenant_id VARCHAR(255) NOT NULL DEFAULT '',
+    input_per_million DOUBLE PRECISION NOT NULL,
+    output_per_million DOUBLE PRECISION NOT NULL,
+    cached_input_per_million DOUBLE PRECISION NOT NULL DEFAULT 0,
+    effective_from TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE(provider, model, tenant_id, effective_from)
+);
@@ -0,0 +1 @@
+DROP TABLE processor_state;
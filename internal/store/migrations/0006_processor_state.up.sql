@@ -0,0 +1,11 @@
+-- In-memory processor state handed over between instances when a partition
+-- is revoked from one consumer and assigned to another
+CREATE TABLE IF NOT EXISTS processor_state (
+    consumer_group VARCHAR(255) NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    partition INTEGER NOT NULL,
+    state_key VARCHAR(1024) NOT NULL,
+    payload BYTEA NOT NULL,
+    updated_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (consumer_group, topic, partition, state_key)
+);
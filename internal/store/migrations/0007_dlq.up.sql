@@ -0,0 +1,19 @@
+-- Records the processor could not handle. llm.dlq holds every dead-lettered
+-- record; this table indexes them for inspection and re-drive.
+CREATE TABLE IF NOT EXISTS llm_dlq (
+    id BIGSERIAL PRIMARY KEY,
+    consumer_group VARCHAR(255) NOT NULL,
+    source_topic VARCHAR(255) NOT NULL,
+    source_partition INTEGER NOT NULL,
+    source_offset BIGINT NOT NULL,
+    record_key BYTEA,
+    record_value BYTEA,
+    record_headers JSONB NOT NULL DEFAULT '{}',
+    error_reason TEXT NOT NULL,
+    failed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    redriven_at TIMESTAMP,
+    UNIQUE(consumer_group, source_topic, source_partition, source_offset)
+);
+
+CREATE INDEX IF NOT EXISTS idx_llm_dlq_failed_at ON llm_dlq (failed_at DESC);
+CREATE INDEX IF NOT EXISTS idx_llm_dlq_pending ON llm_dlq (source_topic, failed_at DESC) WHERE redriven_at IS NULL;
@@ -0,0 +1 @@
+DROP TABLE llm_metrics_breakdown;
@@ -0,0 +1,16 @@
+-- Per-window counts of errors by class and calls by finish_reason, for every
+-- metrics table (metrics_table names llm_metrics, a rollup or a window table)
+CREATE TABLE IF NOT EXISTS llm_metrics_breakdown (
+    metrics_table VARCHAR(63) NOT NULL,
+    tenant_id VARCHAR(255) NOT NULL,
+    route VARCHAR(255) NOT NULL,
+    model VARCHAR(255) NOT NULL,
+    session_id VARCHAR(255) NOT NULL DEFAULT '',
+    window_start TIMESTAMP NOT NULL,
+    dimension VARCHAR(32) NOT NULL,
+    value VARCHAR(255) NOT NULL,
+    count INTEGER NOT NULL,
+    PRIMARY KEY (metrics_table, tenant_id, route, model, session_id, window_start, dimension, value)
+);
+
+CREATE INDEX IF NOT EXISTS idx_llm_metrics_breakdown_window ON llm_metrics_breakdown(metrics_table, window_start);
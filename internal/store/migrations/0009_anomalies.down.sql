@@ -0,0 +1,2 @@
+DROP TABLE anomaly_baselines;
+DROP TABLE llm_anomalies;
@@ -0,0 +1,35 @@
+-- Windows whose value deviated from the series baseline (llm.anomalies)
+CREATE TABLE IF NOT EXISTS llm_anomalies (
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id VARCHAR(255) NOT NULL,
+    route VARCHAR(255) NOT NULL,
+    model VARCHAR(255) NOT NULL,
+    metric VARCHAR(64) NOT NULL,
+    window_start TIMESTAMP NOT NULL,
+    window_end TIMESTAMP NOT NULL,
+    observed DOUBLE PRECISION NOT NULL,
+    expected DOUBLE PRECISION NOT NULL,
+    stddev DOUBLE PRECISION NOT NULL,
+    z_score DOUBLE PRECISION NOT NULL,
+    seasonal BOOLEAN NOT NULL DEFAULT FALSE,
+    detected_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE(tenant_id, route, model, metric, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_llm_anomalies_tenant_time ON llm_anomalies(tenant_id, window_start DESC);
+
+-- EWMA baselines of the anomaly detector. slot is the UTC hour of day, or -1
+-- for the all-day baseline.
+CREATE TABLE IF NOT EXISTS anomaly_baselines (
+    tenant_id VARCHAR(255) NOT NULL,
+    route VARCHAR(255) NOT NULL,
+    model VARCHAR(255) NOT NULL,
+    metric VARCHAR(64) NOT NULL,
+    slot SMALLINT NOT NULL,
+    mean DOUBLE PRECISION NOT NULL,
+    variance DOUBLE PRECISION NOT NULL,
+    count BIGINT NOT NULL,
+    last_window_start TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (tenant_id, route, model, metric, slot)
+);
@@ -0,0 +1,40 @@
+-- Alert rules, managed through /v1/alerts/rules. Empty route/model match any.
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    tenant_id VARCHAR(255) NOT NULL,
+    route VARCHAR(255) NOT NULL DEFAULT '',
+    model VARCHAR(255) NOT NULL DEFAULT '',
+    metric VARCHAR(64) NOT NULL,
+    operator VARCHAR(2) NOT NULL,
+    threshold DOUBLE PRECISION NOT NULL,
+    for_windows INTEGER NOT NULL DEFAULT 1,
+    severity VARCHAR(32) NOT NULL DEFAULT 'warning',
+    webhook_url TEXT NOT NULL,
+    webhook_format VARCHAR(32) NOT NULL DEFAULT 'generic',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_rules_tenant ON alert_rules(tenant_id);
+
+-- Pending, firing and not yet notified resolved alerts, one per rule and series
+CREATE TABLE IF NOT EXISTS alert_states (
+    rule_id BIGINT NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+    tenant_id VARCHAR(255) NOT NULL,
+    route VARCHAR(255) NOT NULL,
+    model VARCHAR(255) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    value DOUBLE PRECISION NOT NULL,
+    consecutive_windows INTEGER NOT NULL,
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP,
+    last_window_start TIMESTAMP NOT NULL,
+    notified_status VARCHAR(16) NOT NULL DEFAULT '',
+    last_notified_at TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (rule_id, tenant_id, route, model)
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_states_tenant ON alert_states(tenant_id, status);
@@ -0,0 +1,2 @@
+DROP TABLE slo_alert_states;
+DROP TABLE slos;
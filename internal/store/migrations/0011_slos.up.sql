@@ -0,0 +1,37 @@
+-- Service level objectives, managed through /v1/slos. Empty route/model cover
+-- every route or model of the tenant.
+CREATE TABLE IF NOT EXISTS slos (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    tenant_id VARCHAR(255) NOT NULL,
+    route VARCHAR(255) NOT NULL DEFAULT '',
+    model VARCHAR(255) NOT NULL DEFAULT '',
+    sli VARCHAR(32) NOT NULL,
+    objective DOUBLE PRECISION NOT NULL,
+    latency_threshold_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    period_days INTEGER NOT NULL DEFAULT 28,
+    webhook_url TEXT NOT NULL DEFAULT '',
+    webhook_format VARCHAR(32) NOT NULL DEFAULT 'generic',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_slos_tenant ON slos(tenant_id);
+
+-- Firing and not yet notified resolved burn-rate alerts, one per SLO and window
+CREATE TABLE IF NOT EXISTS slo_alert_states (
+    slo_id BIGINT NOT NULL REFERENCES slos(id) ON DELETE CASCADE,
+    burn_window VARCHAR(16) NOT NULL,
+    severity VARCHAR(32) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    threshold DOUBLE PRECISION NOT NULL,
+    long_burn_rate DOUBLE PRECISION NOT NULL,
+    short_burn_rate DOUBLE PRECISION NOT NULL,
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP,
+    notified_status VARCHAR(16) NOT NULL DEFAULT '',
+    last_notified_at TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (slo_id, burn_window)
+);
@@ -0,0 +1,2 @@
+DROP TABLE budget_spend;
+DROP TABLE budgets;
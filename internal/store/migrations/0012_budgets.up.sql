@@ -0,0 +1,22 @@
+-- Spend budgets, managed through /v1/budgets. An empty route covers the whole tenant.
+CREATE TABLE IF NOT EXISTS budgets (
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id VARCHAR(255) NOT NULL,
+    route VARCHAR(255) NOT NULL DEFAULT '',
+    period VARCHAR(16) NOT NULL,
+    amount_usd DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_budgets_tenant ON budgets(tenant_id);
+
+-- Spend accumulated per budget period, and the highest threshold notified
+CREATE TABLE IF NOT EXISTS budget_spend (
+    budget_id BIGINT NOT NULL REFERENCES budgets(id) ON DELETE CASCADE,
+    period_start TIMESTAMP NOT NULL,
+    spend_usd DOUBLE PRECISION NOT NULL DEFAULT 0,
+    notified_percent INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (budget_id, period_start)
+);
@@ -0,0 +1,24 @@
+ALTER TABLE llm_metrics
+    DROP COLUMN IF EXISTS unique_users,
+    DROP COLUMN IF EXISTS p99_calls_per_user,
+    DROP COLUMN IF EXISTS max_calls_per_user,
+    DROP COLUMN IF EXISTS users_sketch,
+    DROP COLUMN IF EXISTS calls_per_user_sketch;
+ALTER TABLE llm_metrics_5m
+    DROP COLUMN IF EXISTS unique_users,
+    DROP COLUMN IF EXISTS p99_calls_per_user,
+    DROP COLUMN IF EXISTS max_calls_per_user,
+    DROP COLUMN IF EXISTS users_sketch,
+    DROP COLUMN IF EXISTS calls_per_user_sketch;
+ALTER TABLE llm_metrics_1h
+    DROP COLUMN IF EXISTS unique_users,
+    DROP COLUMN IF EXISTS p99_calls_per_user,
+    DROP COLUMN IF EXISTS max_calls_per_user,
+    DROP COLUMN IF EXISTS users_sketch,
+    DROP COLUMN IF EXISTS calls_per_user_sketch;
+ALTER TABLE llm_metrics_1d
+    DROP COLUMN IF EXISTS unique_users,
+    DROP COLUMN IF EXISTS p99_calls_per_user,
+    DROP COLUMN IF EXISTS max_calls_per_user,
+    DROP COLUMN IF EXISTS users_sketch,
+    DROP COLUMN IF EXISTS calls_per_user_sketch;
@@ -0,0 +1,25 @@
+-- Unique users per window and the mergeable sketches they are computed from
+ALTER TABLE llm_metrics
+    ADD COLUMN IF NOT EXISTS unique_users BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS p99_calls_per_user DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS max_calls_per_user BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS users_sketch BYTEA,
+    ADD COLUMN IF NOT EXISTS calls_per_user_sketch BYTEA;
+ALTER TABLE llm_metrics_5m
+    ADD COLUMN IF NOT EXISTS unique_users BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS p99_calls_per_user DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS max_calls_per_user BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS users_sketch BYTEA,
+    ADD COLUMN IF NOT EXISTS calls_per_user_sketch BYTEA;
+ALTER TABLE llm_metrics_1h
+    ADD COLUMN IF NOT EXISTS unique_users BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS p99_calls_per_user DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS max_calls_per_user BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS users_sketch BYTEA,
+    ADD COLUMN IF NOT EXISTS calls_per_user_sketch BYTEA;
+ALTER TABLE llm_metrics_1d
+    ADD COLUMN IF NOT EXISTS unique_users BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS p99_calls_per_user DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS max_calls_per_user BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS users_sketch BYTEA,
+    ADD COLUMN IF NOT EXISTS calls_per_user_sketch BYTEA;
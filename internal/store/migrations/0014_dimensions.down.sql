@@ -0,0 +1,34 @@
+DROP TABLE dimension_values;
+DROP TABLE tenant_dimensions;
+
+ALTER TABLE llm_metrics_breakdown DROP CONSTRAINT llm_metrics_breakdown_pkey;
+ALTER TABLE llm_metrics_breakdown DROP COLUMN dimensions_key;
+ALTER TABLE llm_metrics_breakdown ADD PRIMARY KEY (metrics_table, tenant_id, route, model, session_id, window_start, dimension, value);
+
+DROP INDEX IF EXISTS idx_llm_metrics_dimensions;
+
+DO $$
+DECLARE
+    t TEXT;
+    con TEXT;
+BEGIN
+    FOREACH t IN ARRAY ARRAY['llm_metrics', 'llm_metrics_5m', 'llm_metrics_1h', 'llm_metrics_1d'] LOOP
+        FOR con IN SELECT conname FROM pg_constraint WHERE conrelid = t::REGCLASS AND contype = 'u' LOOP
+            EXECUTE format('ALTER TABLE %I DROP CONSTRAINT %I', t, con);
+        END LOOP;
+        EXECUTE format('ALTER TABLE %I ADD UNIQUE (tenant_id, route, model, session_id, window_start)', t);
+    END LOOP;
+END $$;
+
+ALTER TABLE llm_metrics
+    DROP COLUMN IF EXISTS dimensions,
+    DROP COLUMN IF EXISTS dimensions_key;
+ALTER TABLE llm_metrics_5m
+    DROP COLUMN IF EXISTS dimensions,
+    DROP COLUMN IF EXISTS dimensions_key;
+ALTER TABLE llm_metrics_1h
+    DROP COLUMN IF EXISTS dimensions,
+    DROP COLUMN IF EXISTS dimensions_key;
+ALTER TABLE llm_metrics_1d
+    DROP COLUMN IF EXISTS dimensions,
+    DROP COLUMN IF EXISTS dimensions_key;
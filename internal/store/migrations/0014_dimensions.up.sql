@@ -0,0 +1,51 @@
+-- Promoted metadata values of a window, {} and '' for tenant totals. Windows
+-- are keyed by dimensions_key as well.
+ALTER TABLE llm_metrics
+    ADD COLUMN IF NOT EXISTS dimensions JSONB NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS dimensions_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE llm_metrics_5m
+    ADD COLUMN IF NOT EXISTS dimensions JSONB NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS dimensions_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE llm_metrics_1h
+    ADD COLUMN IF NOT EXISTS dimensions JSONB NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS dimensions_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE llm_metrics_1d
+    ADD COLUMN IF NOT EXISTS dimensions JSONB NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS dimensions_key TEXT NOT NULL DEFAULT '';
+
+DO $$
+DECLARE
+    t TEXT;
+    con TEXT;
+BEGIN
+    FOREACH t IN ARRAY ARRAY['llm_metrics', 'llm_metrics_5m', 'llm_metrics_1h', 'llm_metrics_1d'] LOOP
+        FOR con IN SELECT conname FROM pg_constraint WHERE conrelid = t::REGCLASS AND contype = 'u' LOOP
+            EXECUTE format('ALTER TABLE %I DROP CONSTRAINT %I', t, con);
+        END LOOP;
+        EXECUTE format('ALTER TABLE %I ADD UNIQUE (tenant_id, route, model, session_id, dimensions_key, window_start)', t);
+    END LOOP;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_llm_metrics_dimensions ON llm_metrics USING GIN (dimensions);
+
+ALTER TABLE llm_metrics_breakdown ADD COLUMN IF NOT EXISTS dimensions_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE llm_metrics_breakdown DROP CONSTRAINT llm_metrics_breakdown_pkey;
+ALTER TABLE llm_metrics_breakdown ADD PRIMARY KEY (metrics_table, tenant_id, route, model, session_id, dimensions_key, window_start, dimension, value);
+
+-- Metadata keys a tenant promoted to aggregation dimensions, managed through
+-- /v1/dimensions, and the values admitted under each key's cardinality cap
+CREATE TABLE IF NOT EXISTS tenant_dimensions (
+    tenant_id VARCHAR(255) NOT NULL,
+    key VARCHAR(63) NOT NULL,
+    max_values INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (tenant_id, key)
+);
+
+CREATE TABLE IF NOT EXISTS dimension_values (
+    tenant_id VARCHAR(255) NOT NULL,
+    key VARCHAR(63) NOT NULL,
+    value VARCHAR(128) NOT NULL,
+    first_seen TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (tenant_id, key, value)
+);
@@ -0,0 +1,8 @@
+ALTER TABLE llm_metrics
+    DROP COLUMN IF EXISTS partial;
+ALTER TABLE llm_metrics_5m
+    DROP COLUMN IF EXISTS partial;
+ALTER TABLE llm_metrics_1h
+    DROP COLUMN IF EXISTS partial;
+ALTER TABLE llm_metrics_1d
+    DROP COLUMN IF EXISTS partial;
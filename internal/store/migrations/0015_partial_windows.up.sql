@@ -0,0 +1,10 @@
+-- Set on windows flushed early by a stopping processor, until the rest of the
+-- window is merged in
+ALTER TABLE llm_metrics
+    ADD COLUMN IF NOT EXISTS partial BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE llm_metrics_5m
+    ADD COLUMN IF NOT EXISTS partial BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE llm_metrics_1h
+    ADD COLUMN IF NOT EXISTS partial BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE llm_metrics_1d
+    ADD COLUMN IF NOT EXISTS partial BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,9 @@
+-- Requests that got no response within RESPONSE_TIMEOUT, not in requests
+ALTER TABLE llm_metrics
+    ADD COLUMN IF NOT EXISTS abandoned INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE llm_metrics_5m
+    ADD COLUMN IF NOT EXISTS abandoned INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE llm_metrics_1h
+    ADD COLUMN IF NOT EXISTS abandoned INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE llm_metrics_1d
+    ADD COLUMN IF NOT EXISTS abandoned INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,9 @@
+-- Flushes merged into a window, so that a retried flush is not added twice
+ALTER TABLE llm_metrics
+    ADD COLUMN IF NOT EXISTS flush_ids TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE llm_metrics_5m
+    ADD COLUMN IF NOT EXISTS flush_ids TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE llm_metrics_1h
+    ADD COLUMN IF NOT EXISTS flush_ids TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE llm_metrics_1d
+    ADD COLUMN IF NOT EXISTS flush_ids TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,44 @@
+-- Turns llm_metrics and its rollup tables back into plain tables, keeping
+-- the rows of every partition
+DROP TABLE tenant_retention;
+
+DO $$
+DECLARE
+    spec RECORD;
+    con RECORD;
+    old_name TEXT;
+    seq TEXT;
+BEGIN
+    FOR spec IN SELECT * FROM (VALUES ('llm_metrics'), ('llm_metrics_5m'), ('llm_metrics_1h'), ('llm_metrics_1d')) AS s(name) LOOP
+        old_name := spec.name || '_partitioned';
+
+        EXECUTE format('ALTER TABLE %I RENAME TO %I', spec.name, old_name);
+        FOR con IN SELECT conname, contype FROM pg_constraint
+                WHERE conrelid = old_name::REGCLASS AND contype IN ('p', 'u') LOOP
+            EXECUTE format('ALTER TABLE %I RENAME CONSTRAINT %I TO %I', old_name, con.conname, old_name || '_' || con.contype);
+        END LOOP;
+        FOR con IN SELECT indexname FROM pg_indexes
+                WHERE schemaname = current_schema() AND tablename = old_name AND indexname LIKE 'idx\_%' LOOP
+            EXECUTE format('DROP INDEX %I', con.indexname);
+        END LOOP;
+
+        EXECUTE format('CREATE TABLE %I (
+                LIKE %I INCLUDING DEFAULTS,
+                PRIMARY KEY (id),
+                UNIQUE (tenant_id, route, model, session_id, dimensions_key, window_start)
+            )', spec.name, old_name);
+        EXECUTE format('INSERT INTO %I SELECT * FROM %I', spec.name, old_name);
+
+        seq := pg_get_serial_sequence(old_name, 'id');
+        IF seq IS NOT NULL THEN
+            EXECUTE format('ALTER SEQUENCE %s OWNED BY %I.id', seq, spec.name);
+        END IF;
+        EXECUTE format('DROP TABLE %I', old_name);
+
+        EXECUTE format('CREATE INDEX %I ON %I (tenant_id, window_start DESC)', 'idx_' || spec.name || '_tenant_time', spec.name);
+        EXECUTE format('CREATE INDEX %I ON %I (window_start)', 'idx_' || spec.name || '_window_start', spec.name);
+    END LOOP;
+END $$;
+
+CREATE INDEX idx_llm_metrics_composite ON llm_metrics (tenant_id, route, model, window_start DESC);
+CREATE INDEX idx_llm_metrics_dimensions ON llm_metrics USING GIN (dimensions);
@@ -0,0 +1,82 @@
+-- Range-partitions llm_metrics and its rollup tables by window_start, so that
+-- expired windows are dropped a partition at a time: daily partitions for
+-- minute and 5-minute windows, monthly ones for hourly and daily windows.
+-- Partitions are named <table>_pYYYYMMDD or <table>_pYYYYMM. The metrics
+-- processor creates upcoming ones and drops expired ones; this migration
+-- creates those holding the existing rows, up to the current one.
+DO $$
+DECLARE
+    spec RECORD;
+    con RECORD;
+    old_name TEXT;
+    seq TEXT;
+    first_start TIMESTAMP;
+    last_start TIMESTAMP;
+    part_start TIMESTAMP;
+    step INTERVAL;
+    suffix TEXT;
+BEGIN
+    FOR spec IN SELECT * FROM (VALUES
+        ('llm_metrics', 'day'),
+        ('llm_metrics_5m', 'day'),
+        ('llm_metrics_1h', 'month'),
+        ('llm_metrics_1d', 'month')
+    ) AS s(name, period) LOOP
+        old_name := spec.name || '_unpartitioned';
+        step := ('1 ' || spec.period)::INTERVAL;
+        suffix := CASE spec.period WHEN 'day' THEN 'YYYYMMDD' ELSE 'YYYYMM' END;
+
+        -- Move the table and the names of its constraints and indexes aside
+        EXECUTE format('ALTER TABLE %I RENAME TO %I', spec.name, old_name);
+        FOR con IN SELECT conname, contype FROM pg_constraint
+                WHERE conrelid = old_name::REGCLASS AND contype IN ('p', 'u') LOOP
+            EXECUTE format('ALTER TABLE %I RENAME CONSTRAINT %I TO %I', old_name, con.conname, old_name || '_' || con.contype);
+        END LOOP;
+        FOR con IN SELECT indexname FROM pg_indexes
+                WHERE schemaname = current_schema() AND tablename = old_name AND indexname LIKE 'idx\_%' LOOP
+            EXECUTE format('DROP INDEX %I', con.indexname);
+        END LOOP;
+
+        -- Unique constraints of a partitioned table must include window_start
+        EXECUTE format('CREATE TABLE %I (
+                LIKE %I INCLUDING DEFAULTS,
+                PRIMARY KEY (id, window_start),
+                UNIQUE (tenant_id, route, model, session_id, dimensions_key, window_start)
+            ) PARTITION BY RANGE (window_start)', spec.name, old_name);
+
+        EXECUTE format('SELECT min(window_start), max(window_start) FROM %I', old_name) INTO first_start, last_start;
+        part_start := date_trunc(spec.period, LEAST(first_start, now() AT TIME ZONE 'UTC'));
+        WHILE part_start <= GREATEST(last_start, now() AT TIME ZONE 'UTC') LOOP
+            EXECUTE format('CREATE TABLE %I PARTITION OF %I FOR VALUES FROM (%L) TO (%L)',
+                spec.name || '_p' || to_char(part_start, suffix), spec.name,
+                to_char(part_start, 'YYYY-MM-DD HH24:MI:SS'), to_char(part_start + step, 'YYYY-MM-DD HH24:MI:SS'));
+            part_start := part_start + step;
+        END LOOP;
+
+        EXECUTE format('INSERT INTO %I SELECT * FROM %I', spec.name, old_name);
+
+        -- The id sequence of llm_metrics is shared by the rollup tables and
+        -- must outlive the old table
+        seq := pg_get_serial_sequence(old_name, 'id');
+        IF seq IS NOT NULL THEN
+            EXECUTE format('ALTER SEQUENCE %s OWNED BY %I.id', seq, spec.name);
+        END IF;
+        EXECUTE format('DROP TABLE %I', old_name);
+
+        EXECUTE format('CREATE INDEX %I ON %I (tenant_id, window_start DESC)', 'idx_' || spec.name || '_tenant_time', spec.name);
+        EXECUTE format('CREATE INDEX %I ON %I (window_start)', 'idx_' || spec.name || '_window_start', spec.name);
+    END LOOP;
+END $$;
+
+CREATE INDEX idx_llm_metrics_composite ON llm_metrics (tenant_id, route, model, window_start DESC);
+CREATE INDEX idx_llm_metrics_dimensions ON llm_metrics USING GIN (dimensions);
+
+-- How many days a tenant's windows of a resolution are kept, overriding the
+-- RETENTION_DAYS_* defaults (0 keeps them forever); managed through /v1/retention
+CREATE TABLE tenant_retention (
+    tenant_id VARCHAR(255) NOT NULL,
+    resolution VARCHAR(8) NOT NULL,
+    days INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (tenant_id, resolution)
+);
@@ -0,0 +1,6 @@
+-- Minute window starts merged into after their first flush, whose rollup
+-- buckets are rebuilt even when they lie before the rollup lookback
+CREATE TABLE IF NOT EXISTS llm_rollup_pending (
+    window_start TIMESTAMP PRIMARY KEY,
+    marked_at TIMESTAMP NOT NULL DEFAULT clock_timestamp()
+);
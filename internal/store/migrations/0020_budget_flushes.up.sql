@@ -0,0 +1,13 @@
+-- Flushes whose spend was added to a budget period, so that a retried flush
+-- is not counted twice
+CREATE TABLE IF NOT EXISTS budget_spend_flushes (
+    budget_id BIGINT NOT NULL,
+    period_start TIMESTAMP NOT NULL,
+    flush_id VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (budget_id, period_start, flush_id),
+    FOREIGN KEY (budget_id, period_start)
+        REFERENCES budget_spend(budget_id, period_start) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_budget_spend_flushes_created ON budget_spend_flushes(created_at);
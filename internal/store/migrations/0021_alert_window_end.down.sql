@@ -0,0 +1 @@
+ALTER TABLE alert_states DROP COLUMN IF EXISTS last_window_end;
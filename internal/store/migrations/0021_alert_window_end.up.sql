@@ -0,0 +1,6 @@
+-- End of the last breaching window of an alert, so that only adjacent
+-- breaching windows count as consecutive. Existing alerts are taken to end
+-- one minute after their last window started.
+ALTER TABLE alert_states ADD COLUMN IF NOT EXISTS last_window_end TIMESTAMP;
+UPDATE alert_states SET last_window_end = last_window_start + INTERVAL '1 minute' WHERE last_window_end IS NULL;
+ALTER TABLE alert_states ALTER COLUMN last_window_end SET NOT NULL;
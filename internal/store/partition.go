@@ -0,0 +1,214 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"streamlens/internal/models"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// partitionSpan is the time range each partition of a metrics table covers
+type partitionSpan string
+
+const (
+	spanDay   partitionSpan = "day"
+	spanMonth partitionSpan = "month"
+)
+
+// partitionSpans lists the metrics tables range-partitioned by window_start,
+// see migration 0018_partition_metrics
+var partitionSpans = map[string]partitionSpan{
+	"llm_metrics":    spanDay,
+	"llm_metrics_5m": spanDay,
+	"llm_metrics_1h": spanMonth,
+	"llm_metrics_1d": spanMonth,
+}
+
+// start returns the start of the partition holding t
+func (p partitionSpan) start(t time.Time) time.Time {
+	t = t.UTC()
+	if p == spanMonth {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// next returns the start of the partition after the one starting at start
+func (p partitionSpan) next(start time.Time) time.Time {
+	if p == spanMonth {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// layout is the time layout of the partitions' name suffixes
+func (p partitionSpan) layout() string {
+	if p == spanMonth {
+		return "200601"
+	}
+	return "20060102"
+}
+
+// Partition is one partition of a metrics table, holding the windows that
+// start in [Start, End)
+type Partition struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// EnsurePartitions creates the missing partitions of the metrics table of res
+// for windows starting in [from, to)
+func (s *MetricsStore) EnsurePartitions(ctx context.Context, res models.Resolution, from, to time.Time) error {
+	return s.ensurePartitions(ctx, metricsTable(res), from, to)
+}
+
+func (s *MetricsStore) ensurePartitions(ctx context.Context, table string, from, to time.Time) error {
+	span, ok := partitionSpans[table]
+	if !ok {
+		return nil
+	}
+	for start := span.start(from); start.Before(to); start = span.next(start) {
+		end := span.next(start)
+		name := table + "_p" + start.Format(span.layout())
+		_, err := s.db.ExecContext(ctx, fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM (%s) TO (%s)
+		`, pq.QuoteIdentifier(name), pq.QuoteIdentifier(table),
+			pq.QuoteLiteral(start.Format("2006-01-02 15:04:05")), pq.QuoteLiteral(end.Format("2006-01-02 15:04:05"))))
+		// Another instance may have created it since IF NOT EXISTS looked
+		if err != nil && !isDuplicateTable(err) {
+			return fmt.Errorf("failed to create partition %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ListPartitions returns the partitions of the metrics table of res, oldest
+// first. Partitions not named by EnsurePartitions are left out.
+func (s *MetricsStore) ListPartitions(ctx context.Context, res models.Resolution) ([]Partition, error) {
+	table := metricsTable(res)
+	span, ok := partitionSpans[table]
+	if !ok {
+		return nil, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = $1::regclass
+	`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partitions []Partition
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		suffix, ok := strings.CutPrefix(name, table+"_p")
+		if !ok {
+			continue
+		}
+		start, err := time.Parse(span.layout(), suffix)
+		if err != nil {
+			continue
+		}
+		partitions = append(partitions, Partition{Name: name, Start: start, End: span.next(start)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].Start.Before(partitions[j].Start) })
+	return partitions, nil
+}
+
+// DropPartition drops a partition of the metrics table of res, with the
+// breakdowns of its windows
+func (s *MetricsStore) DropPartition(ctx context.Context, res models.Resolution, p Partition) error {
+	table := metricsTable(res)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM llm_metrics_breakdown
+		WHERE metrics_table = $1 AND window_start >= $2 AND window_start < $3
+	`, table, p.Start, p.End); err != nil {
+		return fmt.Errorf("failed to delete breakdowns: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DROP TABLE %s`, pq.QuoteIdentifier(p.Name))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteWindowsBefore deletes the windows of res starting before before, and
+// their breakdowns, of one tenant, or with an empty tenantID of every tenant
+// not in except. It returns the number of windows deleted.
+func (s *MetricsStore) DeleteWindowsBefore(ctx context.Context, res models.Resolution, before time.Time, tenantID string, except []string) (int64, error) {
+	table := metricsTable(res)
+	args := []interface{}{before}
+	where := `window_start < $1`
+	if tenantID != "" {
+		where += ` AND tenant_id = $2`
+		args = append(args, tenantID)
+	} else if len(except) > 0 {
+		where += ` AND NOT (tenant_id = ANY($2))`
+		args = append(args, pq.Array(except))
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE %s`, pq.QuoteIdentifier(table), where), args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete windows: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM llm_metrics_breakdown WHERE metrics_table = %s AND %s`,
+		pq.QuoteLiteral(table), where), args...); err != nil {
+		return 0, fmt.Errorf("failed to delete breakdowns: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// withPartition runs write, and once more after creating the partition of
+// the window if it found none, e.g. for a window older than every partition
+func (s *MetricsStore) withPartition(ctx context.Context, table string, m *models.LLMMetrics, write func() error) error {
+	err := write()
+	if !isMissingPartition(err) {
+		return err
+	}
+	if err := s.ensurePartitions(ctx, table, m.WindowStart, m.WindowEnd); err != nil {
+		return err
+	}
+	return write()
+}
+
+// isMissingPartition reports whether err is Postgres' error for a row outside
+// every partition of a table
+func isMissingPartition(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23514" && strings.HasPrefix(pqErr.Message, "no partition of relation")
+}
+
+// isDuplicateTable reports whether err is Postgres' duplicate_table error
+func isDuplicateTable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "42P07"
+}
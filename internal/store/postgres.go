@@ -3,15 +3,20 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"streamlens/internal/models"
 	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+// ErrUnknownTable is returned when querying a metrics table that does not exist
+var ErrUnknownTable = errors.New("unknown metrics table")
+
 // MetricsStore provides database operations for metrics
 type MetricsStore struct {
 	db *sql.DB
@@ -41,55 +46,299 @@ func NewMetricsStore(dsn string) (*MetricsStore, error) {
 	return &MetricsStore{db: db}, nil
 }
 
-// InsertMetrics inserts an aggregated metrics record into the database
+// metricsColumns is the column list shared by all metrics tables
+const metricsColumns = `tenant_id, route, model, session_id, window_start, window_end,
+		requests, errors, abandoned, avg_latency_ms,
+		p50_latency_ms, p90_latency_ms, p95_latency_ms, p99_latency_ms, p999_latency_ms,
+		avg_prompt_tokens, avg_completion_tokens, estimated_cost_usd,
+		latency_sum_ms, prompt_tokens_sum, completion_tokens_sum, latency_sketch,
+		unique_users, p99_calls_per_user, max_calls_per_user, users_sketch, calls_per_user_sketch,
+		dimensions, dimensions_key, partial`
+
+// windowKeyColumns identify a window in every metrics table
+const windowKeyColumns = `tenant_id, route, model, session_id, dimensions_key, window_start`
+
+// metricsTable returns the table holding windows of the given resolution
+func metricsTable(res models.Resolution) string {
+	switch res {
+	case models.Resolution5m:
+		return "llm_metrics_5m"
+	case models.Resolution1h:
+		return "llm_metrics_1h"
+	case models.Resolution1d:
+		return "llm_metrics_1d"
+	default:
+		return "llm_metrics"
+	}
+}
+
+// InsertMetrics inserts an aggregated one-minute metrics record into the database
 func (s *MetricsStore) InsertMetrics(ctx context.Context, metrics *models.LLMMetrics) error {
-	query := `
-		INSERT INTO llm_metrics (
-			tenant_id, route, model, window_start, window_end,
-			requests, errors, avg_latency_ms, p95_latency_ms,
-			avg_prompt_tokens, avg_completion_tokens, estimated_cost_usd
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-		ON CONFLICT (tenant_id, route, model, window_start)
+	return s.UpsertMetrics(ctx, models.Resolution1m, metrics)
+}
+
+// UpsertMetrics writes a metrics record into the table for the given resolution,
+// replacing any existing row for the same window
+func (s *MetricsStore) UpsertMetrics(ctx context.Context, res models.Resolution, metrics *models.LLMMetrics) error {
+	return s.UpsertMetricsTable(ctx, metricsTable(res), metrics)
+}
+
+// UpsertMetricsTable writes a metrics record and its breakdowns into the named
+// metrics table, replacing any existing row for the same window
+func (s *MetricsStore) UpsertMetricsTable(ctx context.Context, table string, metrics *models.LLMMetrics) error {
+	return s.withPartition(ctx, table, metrics, func() error {
+		return s.upsertMetricsTable(ctx, table, metrics)
+	})
+}
+
+func (s *MetricsStore) upsertMetricsTable(ctx context.Context, table string, metrics *models.LLMMetrics) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := upsertMetrics(ctx, tx, table, metrics); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MergeMetricsTable adds a flushed window to the named metrics table. If the
+// window is stored already, from an earlier flush or another instance, the
+// additive components and sketches of both are summed and the averages and
+// percentiles derived again, so that late events and repeated flushes
+// converge on the window's totals, and a merged minute window is marked for
+// its rollups to be rebuilt. flushID identifies the flush: a flush retried
+// after it was stored is not added twice.
+func (s *MetricsStore) MergeMetricsTable(ctx context.Context, table, flushID string, metrics *models.LLMMetrics) error {
+	return s.withPartition(ctx, table, metrics, func() error {
+		return s.mergeMetricsTable(ctx, table, flushID, metrics)
+	})
+}
+
+func (s *MetricsStore) mergeMetricsTable(ctx context.Context, table, flushID string, metrics *models.LLMMetrics) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Serialize writers of the window, including the first insert
+	key := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%d", table, metrics.TenantID, metrics.Route, metrics.Model,
+		metrics.SessionID, metrics.Dimensions.Key(), metrics.WindowStart.Unix())
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, key); err != nil {
+		return fmt.Errorf("failed to lock window: %w", err)
+	}
+
+	existing, applied, err := storedWindow(ctx, tx, table, flushID, metrics)
+	if err != nil {
+		return fmt.Errorf("failed to read stored window: %w", err)
+	}
+	if applied {
+		return nil
+	}
+	if existing != nil {
+		if err := existing.Merge(metrics); err != nil {
+			return fmt.Errorf("failed to merge window: %w", err)
+		}
+		existing.Partial = metrics.Partial
+		metrics = existing
+
+		// The rollups of the window may have been built already, possibly
+		// before the lookback of the next rollup
+		if table == metricsTable(models.Resolution1m) {
+			if err := markRollup(ctx, tx, metrics.WindowStart); err != nil {
+				return fmt.Errorf("failed to mark rollup: %w", err)
+			}
+		}
+	}
+
+	if err := upsertMetrics(ctx, tx, table, metrics); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s SET flush_ids = array_append(flush_ids, $7)
+		WHERE tenant_id = $1 AND route = $2 AND model = $3 AND session_id = $4
+			AND dimensions_key = $5 AND window_start = $6
+	`, pq.QuoteIdentifier(table)), metrics.TenantID, metrics.Route, metrics.Model, metrics.SessionID,
+		metrics.Dimensions.Key(), metrics.WindowStart, flushID); err != nil {
+		return fmt.Errorf("failed to record flush: %w", err)
+	}
+	return tx.Commit()
+}
+
+// storedWindow returns the stored row of a window with its breakdowns, nil
+// if there is none, and whether the flush was applied to it already
+func storedWindow(ctx context.Context, tx *sql.Tx, table, flushID string, m *models.LLMMetrics) (*models.LLMMetrics, bool, error) {
+	args := []interface{}{m.TenantID, m.Route, m.Model, m.SessionID, m.Dimensions.Key(), m.WindowStart}
+	where := `WHERE tenant_id = $1 AND route = $2 AND model = $3 AND session_id = $4
+			AND dimensions_key = $5 AND window_start = $6`
+
+	var applied bool
+	err := tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT $7 = ANY(flush_ids) FROM %s %s`,
+		pq.QuoteIdentifier(table), where), append(args, flushID)...).Scan(&applied)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil || applied {
+		return nil, applied, err
+	}
+
+	rows, err := queryMetricsRows(ctx, tx, fmt.Sprintf(`SELECT %s FROM %s %s`,
+		metricsColumns, pq.QuoteIdentifier(table), where), args...)
+	if err != nil || len(rows) == 0 {
+		return nil, false, err
+	}
+	if err := loadBreakdowns(ctx, tx, table, &m.TenantID, rows); err != nil {
+		return nil, false, err
+	}
+	return &rows[0], false, nil
+}
+
+// upsertMetrics writes a metrics record and its breakdowns within tx
+func upsertMetrics(ctx context.Context, tx *sql.Tx, table string, metrics *models.LLMMetrics) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %s (%s)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30)
+		ON CONFLICT (%s)
 		DO UPDATE SET
 			window_end = EXCLUDED.window_end,
 			requests = EXCLUDED.requests,
 			errors = EXCLUDED.errors,
+			abandoned = EXCLUDED.abandoned,
 			avg_latency_ms = EXCLUDED.avg_latency_ms,
+			p50_latency_ms = EXCLUDED.p50_latency_ms,
+			p90_latency_ms = EXCLUDED.p90_latency_ms,
 			p95_latency_ms = EXCLUDED.p95_latency_ms,
+			p99_latency_ms = EXCLUDED.p99_latency_ms,
+			p999_latency_ms = EXCLUDED.p999_latency_ms,
 			avg_prompt_tokens = EXCLUDED.avg_prompt_tokens,
 			avg_completion_tokens = EXCLUDED.avg_completion_tokens,
-			estimated_cost_usd = EXCLUDED.estimated_cost_usd
-	`
+			estimated_cost_usd = EXCLUDED.estimated_cost_usd,
+			latency_sum_ms = EXCLUDED.latency_sum_ms,
+			prompt_tokens_sum = EXCLUDED.prompt_tokens_sum,
+			completion_tokens_sum = EXCLUDED.completion_tokens_sum,
+			latency_sketch = EXCLUDED.latency_sketch,
+			unique_users = EXCLUDED.unique_users,
+			p99_calls_per_user = EXCLUDED.p99_calls_per_user,
+			max_calls_per_user = EXCLUDED.max_calls_per_user,
+			users_sketch = EXCLUDED.users_sketch,
+			calls_per_user_sketch = EXCLUDED.calls_per_user_sketch,
+			dimensions = EXCLUDED.dimensions,
+			partial = EXCLUDED.partial
+	`, pq.QuoteIdentifier(table), metricsColumns, windowKeyColumns)
+
+	dimensions, err := encodeDimensions(metrics.Dimensions)
+	if err != nil {
+		return err
+	}
 
-	_, err := s.db.ExecContext(ctx, query,
+	if _, err := tx.ExecContext(ctx, query,
 		metrics.TenantID,
 		metrics.Route,
 		metrics.Model,
+		metrics.SessionID,
 		metrics.WindowStart,
 		metrics.WindowEnd,
 		metrics.Requests,
 		metrics.Errors,
+		metrics.Abandoned,
 		metrics.AvgLatencyMs,
+		metrics.P50LatencyMs,
+		metrics.P90LatencyMs,
 		metrics.P95LatencyMs,
+		metrics.P99LatencyMs,
+		metrics.P999LatencyMs,
 		metrics.AvgPromptTokens,
 		metrics.AvgCompletionTokens,
 		metrics.EstimatedCostUSD,
-	)
+		metrics.LatencySumMs,
+		metrics.PromptTokensSum,
+		metrics.CompletionTokensSum,
+		metrics.LatencySketch,
+		metrics.UniqueUsers,
+		metrics.P99CallsPerUser,
+		metrics.MaxCallsPerUser,
+		metrics.UsersSketch,
+		metrics.CallsPerUserSketch,
+		dimensions,
+		metrics.Dimensions.Key(),
+		metrics.Partial,
+	); err != nil {
+		return err
+	}
 
+	if err := replaceBreakdowns(ctx, tx, table, metrics); err != nil {
+		return fmt.Errorf("failed to write breakdowns: %w", err)
+	}
+	return nil
+}
+
+// EnsureWindowTable creates a metrics table for a window definition, with the
+// same layout as llm_metrics
+func (s *MetricsStore) EnsureWindowTable(ctx context.Context, table string) error {
+	name := pq.QuoteIdentifier(table)
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (LIKE llm_metrics INCLUDING DEFAULTS INCLUDING CONSTRAINTS);
+		CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s);
+		CREATE INDEX IF NOT EXISTS %s ON %s (tenant_id, window_start DESC);
+	`, name,
+		pq.QuoteIdentifier(table+"_window_key"), name, windowKeyColumns,
+		pq.QuoteIdentifier(table+"_tenant_time"), name)
+
+	_, err := s.db.ExecContext(ctx, query)
 	return err
 }
 
+// isUndefinedTable reports whether err is Postgres' undefined_table error
+func isUndefinedTable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "42P01"
+}
+
+// MetricsQuery describes a metrics lookup
+type MetricsQuery struct {
+	TenantID string
+	// Route and Model select one route or model. Left nil, tenant totals of
+	// the default window read the windows across all routes or models,
+	// while dimensioned windows and window definitions are not restricted.
+	Route      *string
+	Model      *string
+	From       *time.Time
+	To         *time.Time
+	Resolution models.Resolution
+	// Table overrides Resolution to read a window definition's table
+	Table string
+	Limit int
+	// Dimensional reads the windows of each dimension combination instead of
+	// tenant totals, restricted to those matching every Dimensions value
+	Dimensional bool
+	Dimensions  models.Dimensions
+}
+
 // QueryMetrics retrieves metrics based on filters
-func (s *MetricsStore) QueryMetrics(ctx context.Context, tenantID string, route, model *string, limit int) ([]models.LLMMetrics, error) {
+func (s *MetricsStore) QueryMetrics(ctx context.Context, q MetricsQuery) ([]models.LLMMetrics, error) {
 	// Build query dynamically based on filters
 	var conditions []string
 	var args []interface{}
 	argIndex := 1
 
 	conditions = append(conditions, fmt.Sprintf("tenant_id = $%d", argIndex))
-	args = append(args, tenantID)
+	args = append(args, q.TenantID)
 	argIndex++
 
+	route, model := q.Route, q.Model
+	if !q.Dimensional && q.Table == "" {
+		wildcard := models.Wildcard
+		if route == nil || *route == "" {
+			route = &wildcard
+		}
+		if model == nil || *model == "" {
+			model = &wildcard
+		}
+	}
+
 	if route != nil && *route != "" {
 		conditions = append(conditions, fmt.Sprintf("route = $%d", argIndex))
 		args = append(args, *route)
@@ -102,26 +351,99 @@ func (s *MetricsStore) QueryMetrics(ctx context.Context, tenantID string, route,
 		argIndex++
 	}
 
+	if q.Dimensional {
+		conditions = append(conditions, "dimensions_key <> ''")
+		if len(q.Dimensions) > 0 {
+			filter, err := encodeDimensions(q.Dimensions)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, fmt.Sprintf("dimensions @> $%d::jsonb", argIndex))
+			args = append(args, filter)
+			argIndex++
+		}
+	} else {
+		conditions = append(conditions, "dimensions_key = ''")
+	}
+
+	if q.From != nil {
+		conditions = append(conditions, fmt.Sprintf("window_start >= $%d", argIndex))
+		args = append(args, *q.From)
+		argIndex++
+	}
+
+	if q.To != nil {
+		conditions = append(conditions, fmt.Sprintf("window_start < $%d", argIndex))
+		args = append(args, *q.To)
+		argIndex++
+	}
+
 	whereClause := strings.Join(conditions, " AND ")
 
+	table := metricsTable(q.Resolution)
+	if q.Table != "" {
+		table = q.Table
+	}
+
 	// Default limit if not specified
+	limit := q.Limit
 	if limit <= 0 {
 		limit = 60
 	}
 
 	query := fmt.Sprintf(`
-		SELECT tenant_id, route, model, window_start, window_end,
-		       requests, errors, avg_latency_ms, p95_latency_ms,
-		       avg_prompt_tokens, avg_completion_tokens, estimated_cost_usd
-		FROM llm_metrics
+		SELECT %s
+		FROM %s
 		WHERE %s
 		ORDER BY window_start DESC
 		LIMIT $%d
-	`, whereClause, argIndex)
+	`, metricsColumns, pq.QuoteIdentifier(table), whereClause, argIndex)
 
 	args = append(args, limit)
 
-	rows, err := s.db.QueryContext(ctx, query, args...)
+	results, err := queryMetricsRows(ctx, s.db, query, args...)
+	if isUndefinedTable(err) {
+		return nil, ErrUnknownTable
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loadBreakdowns(ctx, s.db, table, &q.TenantID, results); err != nil {
+		return nil, fmt.Errorf("failed to load breakdowns: %w", err)
+	}
+	return results, nil
+}
+
+// ListWindows returns every window of the given resolution starting in [from, to),
+// across all tenants. It is used as the source for rollups.
+func (s *MetricsStore) ListWindows(ctx context.Context, res models.Resolution, from, to time.Time) ([]models.LLMMetrics, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE window_start >= $1 AND window_start < $2
+		ORDER BY window_start
+	`, metricsColumns, metricsTable(res))
+
+	results, err := queryMetricsRows(ctx, s.db, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loadBreakdowns(ctx, s.db, metricsTable(res), nil, results); err != nil {
+		return nil, fmt.Errorf("failed to load breakdowns: %w", err)
+	}
+	return results, nil
+}
+
+// queryer runs queries on the database or within a transaction
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryMetricsRows runs a query selecting metricsColumns and scans the results
+func queryMetricsRows(ctx context.Context, q queryer, query string, args ...interface{}) ([]models.LLMMetrics, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -130,23 +452,53 @@ func (s *MetricsStore) QueryMetrics(ctx context.Context, tenantID string, route,
 	var results []models.LLMMetrics
 	for rows.Next() {
 		var m models.LLMMetrics
+		var dimensions []byte
+		var dimensionsKey string
 		err := rows.Scan(
 			&m.TenantID,
 			&m.Route,
 			&m.Model,
+			&m.SessionID,
 			&m.WindowStart,
 			&m.WindowEnd,
 			&m.Requests,
 			&m.Errors,
+			&m.Abandoned,
 			&m.AvgLatencyMs,
+			&m.P50LatencyMs,
+			&m.P90LatencyMs,
 			&m.P95LatencyMs,
+			&m.P99LatencyMs,
+			&m.P999LatencyMs,
 			&m.AvgPromptTokens,
 			&m.AvgCompletionTokens,
 			&m.EstimatedCostUSD,
+			&m.LatencySumMs,
+			&m.PromptTokensSum,
+			&m.CompletionTokensSum,
+			&m.LatencySketch,
+			&m.UniqueUsers,
+			&m.P99CallsPerUser,
+			&m.MaxCallsPerUser,
+			&m.UsersSketch,
+			&m.CallsPerUserSketch,
+			&dimensions,
+			&dimensionsKey,
+			&m.Partial,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if dimensionsKey != "" {
+			if err := json.Unmarshal(dimensions, &m.Dimensions); err != nil {
+				return nil, fmt.Errorf("invalid dimensions %s: %w", dimensions, err)
+			}
+		}
+		// Averages and percentiles follow from the stored components; a
+		// window with a corrupt sketch keeps its stored values
+		if err := m.Derive(); err != nil {
+			log.Printf("Failed to derive %s window %s: %v", m.TenantID, m.WindowStart.Format(time.RFC3339), err)
+		}
 		results = append(results, m)
 	}
 
@@ -157,6 +509,16 @@ func (s *MetricsStore) QueryMetrics(ctx context.Context, tenantID string, route,
 	return results, nil
 }
 
+// encodeDimensions encodes dimensions as a JSON object, {} when there are none.
+// It returns a string since lib/pq sends []byte as bytea.
+func encodeDimensions(d models.Dimensions) (string, error) {
+	if len(d) == 0 {
+		return "{}", nil
+	}
+	data, err := json.Marshal(d)
+	return string(data), err
+}
+
 // Close closes the database connection
 func (s *MetricsStore) Close() error {
 	log.Println("Closing Postgres connection")
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"streamlens/internal/models"
+)
+
+// ListModelPrices returns every price entry in the model_prices table
+func (s *MetricsStore) ListModelPrices(ctx context.Context) ([]models.ModelPrice, error) {
+	query := `
+		SELECT provider, model, tenant_id,
+		       input_per_million, output_per_million, cached_input_per_million,
+		       effective_from
+		FROM model_prices
+		ORDER BY effective_from
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prices []models.ModelPrice
+	for rows.Next() {
+		var p models.ModelPrice
+		if err := rows.Scan(
+			&p.Provider,
+			&p.Model,
+			&p.TenantID,
+			&p.InputPerMillion,
+			&p.OutputPerMillion,
+			&p.CachedInputPerMillion,
+			&p.EffectiveFrom,
+		); err != nil {
+			return nil, err
+		}
+		prices = append(prices, p)
+	}
+
+	return prices, rows.Err()
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"streamlens/internal/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ShadowMetricsTable holds one-minute windows recomputed by a reprocess run
+// until they are swapped into llm_metrics
+const ShadowMetricsTable = "llm_metrics_reprocess"
+
+// CreateShadowTable (re)creates an empty shadow table with the layout of
+// llm_metrics, dropping what an earlier, interrupted run left behind. Unlike
+// llm_metrics it is not partitioned.
+func (s *MetricsStore) CreateShadowTable(ctx context.Context, table string) error {
+	name := pq.QuoteIdentifier(table)
+	query := fmt.Sprintf(`
+		DROP TABLE IF EXISTS %s;
+		CREATE TABLE %s (LIKE llm_metrics INCLUDING DEFAULTS INCLUDING CONSTRAINTS);
+		CREATE UNIQUE INDEX %s ON %s (%s);
+	`, name, name, pq.QuoteIdentifier(table+"_window_key"), name, windowKeyColumns)
+
+	if _, err := s.db.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `DELETE FROM llm_metrics_breakdown WHERE metrics_table = $1`, table)
+	return err
+}
+
+// SwapMetricsRange replaces the llm_metrics windows starting in [from, to)
+// with the contents of the shadow table, and drops the shadow table. Readers
+// see either the old or the new windows of the range, never a mix.
+func (s *MetricsStore) SwapMetricsRange(ctx context.Context, shadow string, from, to time.Time) error {
+	target := metricsTable(models.Resolution1m)
+	if err := s.ensurePartitions(ctx, target, from, to); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		DELETE FROM %s WHERE window_start >= $1 AND window_start < $2
+	`, target), from, to); err != nil {
+		return fmt.Errorf("failed to delete windows: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM llm_metrics_breakdown
+		WHERE metrics_table = $1 AND window_start >= $2 AND window_start < $3
+	`, target, from, to); err != nil {
+		return fmt.Errorf("failed to delete breakdowns: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s (%s)
+		SELECT %s FROM %s
+		WHERE window_start >= $1 AND window_start < $2
+	`, target, metricsColumns, metricsColumns, pq.QuoteIdentifier(shadow)), from, to); err != nil {
+		return fmt.Errorf("failed to copy windows: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE llm_metrics_breakdown SET metrics_table = $1
+		WHERE metrics_table = $2 AND window_start >= $3 AND window_start < $4
+	`, target, shadow, from, to); err != nil {
+		return fmt.Errorf("failed to move breakdowns: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM llm_metrics_breakdown WHERE metrics_table = $1`, shadow); err != nil {
+		return fmt.Errorf("failed to delete shadow breakdowns: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DROP TABLE %s`, pq.QuoteIdentifier(shadow))); err != nil {
+		return fmt.Errorf("failed to drop shadow table: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceWindows replaces every window of the given resolution starting in
+// [from, to) with windows, in one transaction
+func (s *MetricsStore) ReplaceWindows(ctx context.Context, res models.Resolution, from, to time.Time, windows []*models.LLMMetrics) error {
+	table := metricsTable(res)
+	if err := s.ensurePartitions(ctx, table, from, to); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		DELETE FROM %s WHERE window_start >= $1 AND window_start < $2
+	`, table), from, to); err != nil {
+		return fmt.Errorf("failed to delete windows: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM llm_metrics_breakdown
+		WHERE metrics_table = $1 AND window_start >= $2 AND window_start < $3
+	`, table, from, to); err != nil {
+		return fmt.Errorf("failed to delete breakdowns: %w", err)
+	}
+
+	for _, m := range windows {
+		if err := upsertMetrics(ctx, tx, table, m); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
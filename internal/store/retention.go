@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"streamlens/internal/models"
+)
+
+// ListTenantRetention returns retention overrides, of one tenant when
+// tenantID is set
+func (s *MetricsStore) ListTenantRetention(ctx context.Context, tenantID *string) ([]models.TenantRetention, error) {
+	query := `SELECT tenant_id, resolution, days, created_at FROM tenant_retention`
+	var args []interface{}
+	if tenantID != nil {
+		query += ` WHERE tenant_id = $1`
+		args = append(args, *tenantID)
+	}
+	query += ` ORDER BY tenant_id, resolution`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var overrides []models.TenantRetention
+	for rows.Next() {
+		var o models.TenantRetention
+		if err := rows.Scan(&o.TenantID, &o.Resolution, &o.Days, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, rows.Err()
+}
+
+// ReplaceTenantRetention replaces the retention overrides of a tenant
+func (s *MetricsStore) ReplaceTenantRetention(ctx context.Context, tenantID string, overrides []models.TenantRetention) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tenant_retention WHERE tenant_id = $1`, tenantID); err != nil {
+		return err
+	}
+	for i := range overrides {
+		o := &overrides[i]
+		o.TenantID = tenantID
+		if err := tx.QueryRowContext(ctx, `
+			INSERT INTO tenant_retention (tenant_id, resolution, days)
+			VALUES ($1, $2, $3)
+			RETURNING created_at
+		`, tenantID, o.Resolution, o.Days).Scan(&o.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RollupMark is a minute window start whose stored windows were merged into
+// after their first flush, so that its rollup buckets may be stale
+type RollupMark struct {
+	WindowStart time.Time
+	MarkedAt    time.Time
+}
+
+// markRollup records within tx that the minute windows starting at
+// windowStart changed
+func markRollup(ctx context.Context, tx *sql.Tx, windowStart time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO llm_rollup_pending (window_start)
+		VALUES ($1)
+		ON CONFLICT (window_start) DO UPDATE SET marked_at = clock_timestamp()
+	`, windowStart)
+	return err
+}
+
+// PendingRollups returns up to limit marks, oldest window first
+func (s *MetricsStore) PendingRollups(ctx context.Context, limit int) ([]RollupMark, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT window_start, marked_at FROM llm_rollup_pending
+		ORDER BY window_start
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var marks []RollupMark
+	for rows.Next() {
+		var m RollupMark
+		if err := rows.Scan(&m.WindowStart, &m.MarkedAt); err != nil {
+			return nil, err
+		}
+		marks = append(marks, m)
+	}
+	return marks, rows.Err()
+}
+
+// ClearRollups removes marks whose buckets were rebuilt. A mark set again
+// since it was read is kept, for the merge that set it to be rolled up too.
+func (s *MetricsStore) ClearRollups(ctx context.Context, marks []RollupMark) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, m := range marks {
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM llm_rollup_pending WHERE window_start = $1 AND marked_at = $2
+		`, m.WindowStart, m.MarkedAt); err != nil {
+			return fmt.Errorf("failed to clear rollup mark: %w", err)
+		}
+	}
+	return tx.Commit()
+}
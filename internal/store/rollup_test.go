@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"streamlens/internal/models"
+	"testing"
+	"time"
+)
+
+func TestMergeMetricsTable_MarksRollup(t *testing.T) {
+	ctx := context.Background()
+	s := testSchemaStore(t)
+	if _, err := s.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	start := time.Date(2025, 11, 20, 10, 3, 0, 0, time.UTC)
+	window := func(requests int) *models.LLMMetrics {
+		return &models.LLMMetrics{
+			TenantID:    "tenant-1",
+			Route:       "/chat",
+			Model:       "gpt-4o",
+			WindowStart: start,
+			WindowEnd:   start.Add(time.Minute),
+			Requests:    requests,
+		}
+	}
+	pending := func() []RollupMark {
+		t.Helper()
+		marks, err := s.PendingRollups(ctx, 10)
+		if err != nil {
+			t.Fatalf("PendingRollups() error = %v", err)
+		}
+		return marks
+	}
+
+	// A first flush is rolled up within the lookback
+	if err := s.MergeMetricsTable(ctx, "llm_metrics", "flush-1", window(2)); err != nil {
+		t.Fatalf("MergeMetricsTable() error = %v", err)
+	}
+	if marks := pending(); len(marks) != 0 {
+		t.Fatalf("pending rollups after the first flush = %v, want none", marks)
+	}
+
+	// A late flush merged into it marks its minute
+	if err := s.MergeMetricsTable(ctx, "llm_metrics", "flush-2", window(1)); err != nil {
+		t.Fatalf("MergeMetricsTable() error = %v", err)
+	}
+	marks := pending()
+	if len(marks) != 1 || !marks[0].WindowStart.Equal(start) {
+		t.Fatalf("pending rollups = %v, want %s", marks, start)
+	}
+
+	// A mark set again after it was read survives clearing
+	if err := s.MergeMetricsTable(ctx, "llm_metrics", "flush-3", window(1)); err != nil {
+		t.Fatalf("MergeMetricsTable() error = %v", err)
+	}
+	if err := s.ClearRollups(ctx, marks); err != nil {
+		t.Fatalf("ClearRollups() error = %v", err)
+	}
+	marks = pending()
+	if len(marks) != 1 {
+		t.Fatalf("pending rollups after a new merge = %v, want 1", marks)
+	}
+	if err := s.ClearRollups(ctx, marks); err != nil {
+		t.Fatalf("ClearRollups() error = %v", err)
+	}
+	if marks := pending(); len(marks) != 0 {
+		t.Errorf("pending rollups after clearing = %v, want none", marks)
+	}
+}
@@ -0,0 +1,240 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"streamlens/internal/models"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrSLONotFound is returned when an SLO does not exist
+var ErrSLONotFound = errors.New("slo not found")
+
+const sloColumns = `id, name, tenant_id, route, model, sli, objective, latency_threshold_ms,
+		period_days, webhook_url, webhook_format, enabled, created_at, updated_at`
+
+func scanSLO(row rowScanner) (*models.SLO, error) {
+	var s models.SLO
+	err := row.Scan(
+		&s.ID, &s.Name, &s.TenantID, &s.Route, &s.Model, &s.SLI, &s.Objective, &s.LatencyThresholdMs,
+		&s.PeriodDays, &s.WebhookURL, &s.WebhookFormat, &s.Enabled, &s.CreatedAt, &s.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ListSLOs returns SLOs, of one tenant when tenantID is set
+func (s *MetricsStore) ListSLOs(ctx context.Context, tenantID *string) ([]models.SLO, error) {
+	query := `SELECT ` + sloColumns + ` FROM slos`
+	var args []interface{}
+	if tenantID != nil {
+		query += ` WHERE tenant_id = $1`
+		args = append(args, *tenantID)
+	}
+	query += ` ORDER BY id`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slos []models.SLO
+	for rows.Next() {
+		o, err := scanSLO(rows)
+		if err != nil {
+			return nil, err
+		}
+		slos = append(slos, *o)
+	}
+	return slos, rows.Err()
+}
+
+// GetSLO returns a single SLO
+func (s *MetricsStore) GetSLO(ctx context.Context, id int64) (*models.SLO, error) {
+	o, err := scanSLO(s.db.QueryRowContext(ctx, `SELECT `+sloColumns+` FROM slos WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSLONotFound
+	}
+	return o, err
+}
+
+// CreateSLO inserts an SLO, filling in its ID and timestamps
+func (s *MetricsStore) CreateSLO(ctx context.Context, o *models.SLO) error {
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO slos (
+			name, tenant_id, route, model, sli, objective, latency_threshold_ms,
+			period_days, webhook_url, webhook_format, enabled
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at, updated_at
+	`,
+		o.Name, o.TenantID, o.Route, o.Model, o.SLI, o.Objective, o.LatencyThresholdMs,
+		o.PeriodDays, o.WebhookURL, o.WebhookFormat, o.Enabled,
+	).Scan(&o.ID, &o.CreatedAt, &o.UpdatedAt)
+}
+
+// UpdateSLO replaces an SLO. Its burn-rate alerts are reset, since they were
+// evaluated against the old definition.
+func (s *MetricsStore) UpdateSLO(ctx context.Context, o *models.SLO) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE slos SET
+			name = $2, tenant_id = $3, route = $4, model = $5, sli = $6, objective = $7,
+			latency_threshold_ms = $8, period_days = $9, webhook_url = $10,
+			webhook_format = $11, enabled = $12, updated_at = NOW()
+		WHERE id = $1
+		RETURNING created_at, updated_at
+	`,
+		o.ID, o.Name, o.TenantID, o.Route, o.Model, o.SLI, o.Objective,
+		o.LatencyThresholdMs, o.PeriodDays, o.WebhookURL, o.WebhookFormat, o.Enabled,
+	).Scan(&o.CreatedAt, &o.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSLONotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM slo_alert_states WHERE slo_id = $1`, o.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteSLO deletes an SLO and its alerts
+func (s *MetricsStore) DeleteSLO(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM slos WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrSLONotFound
+	}
+	return nil
+}
+
+const sloAlertColumns = `slo_id, burn_window, severity, status, threshold, long_burn_rate,
+		short_burn_rate, starts_at, ends_at, notified_status, last_notified_at`
+
+// ListSLOAlerts returns the burn-rate alerts of an SLO
+func (s *MetricsStore) ListSLOAlerts(ctx context.Context, sloID int64) ([]models.SLOAlert, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sloAlertColumns+` FROM slo_alert_states
+		WHERE slo_id = $1 ORDER BY starts_at DESC`, sloID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []models.SLOAlert
+	for rows.Next() {
+		var a models.SLOAlert
+		var endsAt, lastNotifiedAt sql.NullTime
+		if err := rows.Scan(
+			&a.SLOID, &a.Window, &a.Severity, &a.Status, &a.Threshold, &a.LongBurnRate,
+			&a.ShortBurnRate, &a.StartsAt, &endsAt, &a.NotifiedStatus, &lastNotifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		if endsAt.Valid {
+			a.EndsAt = &endsAt.Time
+		}
+		if lastNotifiedAt.Valid {
+			a.LastNotifiedAt = &lastNotifiedAt.Time
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// SaveSLOAlert upserts the state of a burn-rate alert. Alerts of deleted SLOs
+// are ignored.
+func (s *MetricsStore) SaveSLOAlert(ctx context.Context, a *models.SLOAlert) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO slo_alert_states (`+sloAlertColumns+`, updated_at)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW()
+		WHERE EXISTS (SELECT 1 FROM slos WHERE id = $1)
+		ON CONFLICT (slo_id, burn_window)
+		DO UPDATE SET
+			severity = EXCLUDED.severity,
+			status = EXCLUDED.status,
+			threshold = EXCLUDED.threshold,
+			long_burn_rate = EXCLUDED.long_burn_rate,
+			short_burn_rate = EXCLUDED.short_burn_rate,
+			starts_at = EXCLUDED.starts_at,
+			ends_at = EXCLUDED.ends_at,
+			notified_status = EXCLUDED.notified_status,
+			last_notified_at = EXCLUDED.last_notified_at,
+			updated_at = NOW()
+	`,
+		a.SLOID, a.Window, a.Severity, a.Status, a.Threshold, a.LongBurnRate,
+		a.ShortBurnRate, a.StartsAt, a.EndsAt, a.NotifiedStatus, a.LastNotifiedAt,
+	)
+	return err
+}
+
+// DeleteSLOAlert removes the state of a burn-rate alert that is back to normal
+func (s *MetricsStore) DeleteSLOAlert(ctx context.Context, sloID int64, window string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM slo_alert_states WHERE slo_id = $1 AND burn_window = $2`, sloID, window)
+	return err
+}
+
+// ListSeriesWindows returns the windows of a tenant starting in [from, to),
+// limited to one route and model when they are not empty. Only tenant totals
+// of single routes and models are read, and breakdowns are not loaded.
+func (s *MetricsStore) ListSeriesWindows(ctx context.Context, res models.Resolution, tenantID, route, model string, from, to time.Time) ([]models.LLMMetrics, error) {
+	conditions := []string{"tenant_id = $1", "window_start >= $2", "window_start < $3", "session_id = ''", "dimensions_key = ''",
+		"route <> '" + models.Wildcard + "'", "model <> '" + models.Wildcard + "'"}
+	args := []interface{}{tenantID, from, to}
+	if route != "" {
+		args = append(args, route)
+		conditions = append(conditions, fmt.Sprintf("route = $%d", len(args)))
+	}
+	if model != "" {
+		args = append(args, model)
+		conditions = append(conditions, fmt.Sprintf("model = $%d", len(args)))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE %s
+		ORDER BY window_start
+	`, metricsColumns, pq.QuoteIdentifier(metricsTable(res)), strings.Join(conditions, " AND "))
+
+	return queryMetricsRows(ctx, s.db, query, args...)
+}
+
+// TryAdvisoryLock takes a session-level Postgres advisory lock on a dedicated
+// connection. ok is false when another session holds the lock; otherwise
+// unlock must be called to release the lock and the connection.
+func (s *MetricsStore) TryAdvisoryLock(ctx context.Context, key int64) (unlock func(), ok bool, err error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&ok); err != nil || !ok {
+		conn.Close()
+		return nil, false, err
+	}
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, key); err != nil {
+			// Discard the connection instead of pooling it; the session
+			// ending releases the lock
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, true, nil
+}
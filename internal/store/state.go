@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// SaveProcessorState replaces the saved state of one consumer group partition.
+// It is used to hand in-memory state over to the next owner of a partition.
+func (s *MetricsStore) SaveProcessorState(ctx context.Context, group, topic string, partition int32, entries map[string][]byte) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM processor_state WHERE consumer_group = $1 AND topic = $2 AND partition = $3`,
+		group, topic, partition,
+	); err != nil {
+		return err
+	}
+
+	for key, payload := range entries {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO processor_state (consumer_group, topic, partition, state_key, payload)
+			VALUES ($1, $2, $3, $4, $5)
+		`, group, topic, partition, key, payload); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// TakeProcessorState returns and removes the saved state of one consumer group partition
+func (s *MetricsStore) TakeProcessorState(ctx context.Context, group, topic string, partition int32) (map[string][]byte, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		DELETE FROM processor_state
+		WHERE consumer_group = $1 AND topic = $2 AND partition = $3
+		RETURNING state_key, payload
+	`, group, topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make(map[string][]byte)
+	for rows.Next() {
+		var key string
+		var payload []byte
+		if err := rows.Scan(&key, &payload); err != nil {
+			return nil, err
+		}
+		entries[key] = payload
+	}
+
+	return entries, rows.Err()
+}
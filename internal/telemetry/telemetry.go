@@ -0,0 +1,81 @@
+// Package telemetry exposes Prometheus metrics about the services
+// themselves, as opposed to the LLM metrics they compute.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes every metric name
+const Namespace = "streamlens"
+
+var httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: Namespace,
+	Subsystem: "http",
+	Name:      "request_duration_seconds",
+	Help:      "Duration of HTTP requests by route pattern, method and status code.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"route", "method", "code"})
+
+// Handler serves the metrics of the default registry
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware records the duration of every request, labelled by its chi
+// route pattern so that path parameters do not create new series
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpRequestDuration.WithLabelValues(route, r.Method, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Serve runs an HTTP listener serving /metrics on addr until ctx is
+// cancelled, for services without an HTTP API of their own
+func Serve(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Metrics listener shutdown failed: %v", err)
+		}
+	}()
+
+	log.Printf("Serving Prometheus metrics on %s/metrics", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Metrics listener failed: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package telemetry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMiddleware_LabelsByRoutePattern(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/v1/slos/{id}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "SLO not found", http.StatusNotFound)
+	})
+	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	for _, path := range []string{"/v1/slos/1", "/v1/slos/2", "/health", "/nope"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	tests := []struct {
+		route, code string
+		want        int
+	}{
+		{route: "/v1/slos/{id}", code: "404", want: 2},
+		{route: "/health", code: "200", want: 1},
+		{route: "unmatched", code: "404", want: 1},
+	}
+	for _, tt := range tests {
+		if got := sampleCount(t, tt.route, tt.code); got != tt.want {
+			t.Errorf("%s %s: %d requests, want %d", tt.route, tt.code, got, tt.want)
+		}
+	}
+}
+
+// sampleCount returns how many requests the histogram observed for a route
+func sampleCount(t *testing.T, route, code string) int {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != Namespace+"_http_request_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["route"] == route && labels["code"] == code {
+				return int(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return 0
+}